@@ -0,0 +1,60 @@
+// Package cmd Convert top level command
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"philosopher/lib/cnv"
+	"philosopher/lib/met"
+	"philosopher/lib/msg"
+	"philosopher/lib/sys"
+
+	"github.com/spf13/cobra"
+)
+
+// convertCmd represents the convert command
+var convertCmd = &cobra.Command{
+	Use:   "convert",
+	Short: "Convert Thermo raw files to mzML and subset mzML files",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		m.FunctionInitCheckUp()
+
+		if len(args) < 1 {
+			msg.InputNotFound(errors.New("provide at least one raw or mzML file to convert"), "fatal")
+		}
+
+		msg.Executing("Convert", Version)
+		m = cnv.Run(m, args)
+
+		// store parameters on meta data
+		m.Serialize()
+
+		// clean tmp
+		met.CleanTemp(m.Temp)
+
+		msg.Done()
+	},
+}
+
+func init() {
+
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+
+		m.Restore(sys.Meta())
+
+		convertCmd.Flags().StringVarP(&m.Msconvert.Output, "output", "", ".", "output folder for the converted files")
+		convertCmd.Flags().StringVarP(&m.Msconvert.Format, "format", "", "mzML", "output format")
+		convertCmd.Flags().StringVarP(&m.Msconvert.MZBinaryEncoding, "mz", "", "64", "m/z binary encoding precision (32 or 64)")
+		convertCmd.Flags().StringVarP(&m.Msconvert.IntensityBinaryEncoding, "intensity", "", "32", "intensity binary encoding precision (32 or 64)")
+		convertCmd.Flags().StringVarP(&m.Msconvert.MSLevel, "mslevel", "", "", "comma separated list of MS levels to keep (e.g. 1,2)")
+		convertCmd.Flags().StringVarP(&m.Msconvert.Scans, "scans", "", "", "comma separated list of scans and scan ranges to keep (e.g. 1-100,250)")
+		convertCmd.Flags().Float64VarP(&m.Msconvert.MinRT, "rtmin", "", 0, "minimum retention time in minutes")
+		convertCmd.Flags().Float64VarP(&m.Msconvert.MaxRT, "rtmax", "", 0, "maximum retention time in minutes (0 for no limit)")
+		convertCmd.Flags().BoolVarP(&m.Msconvert.NoIndex, "noindex", "", false, "write mzML files without the offset index")
+		convertCmd.Flags().BoolVarP(&m.Msconvert.Zlib, "zlib", "", false, "compress the binary arrays with zlib")
+	}
+
+	RootCmd.AddCommand(convertCmd)
+}
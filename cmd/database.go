@@ -41,6 +41,9 @@ func init() {
 		databaseCmd.Flags().StringVarP(&m.Database.Tag, "prefix", "", "rev_", "define a decoy prefix")
 		databaseCmd.Flags().StringVarP(&m.Database.Add, "add", "", "", "add custom sequences (UniProt FASTA format only)")
 		databaseCmd.Flags().StringVarP(&m.Database.Custom, "custom", "", "", "use a pre-formatted custom database")
+		databaseCmd.Flags().StringVarP(&m.Database.Decoy, "decoy", "", "reverse", "decoy generation method (reverse, pseudo, shuffle, debruijn)")
+		databaseCmd.Flags().StringVarP(&m.Database.Entrapment, "entrapment", "", "", "add an entrapment set from a foreign proteome FASTA file, or shuffled from the targets with shuffle")
+		databaseCmd.Flags().StringVarP(&m.Database.EntrapmentTag, "entrapmentprefix", "", "entrapment_", "define an entrapment prefix")
 		databaseCmd.Flags().BoolVarP(&m.Database.Crap, "contam", "", false, "add common contaminants")
 		databaseCmd.Flags().BoolVarP(&m.Database.CrapTag, "contamprefix", "", false, "mark the contaminant sequences with a prefix tag")
 		databaseCmd.Flags().BoolVarP(&m.Database.Rev, "reviewed", "", false, "use only reviwed sequences from Swiss-Prot")
@@ -31,7 +31,7 @@ var filterCmd = &cobra.Command{
 		os.RemoveAll(sys.PepxmlBin())
 
 		// check file existence
-		if len(m.Filter.Pex) < 1 {
+		if len(m.Filter.Pex) < 1 && !m.Filter.Percolator && !m.Filter.Rescore {
 			msg.InputNotFound(errors.New("you must provide a pepXML file or a folder with one or more files, Run 'philosopher filter --help' for more information"), "fatal")
 		}
 
@@ -57,11 +57,12 @@ func init() {
 
 		m.Restore(sys.Meta())
 
-		filterCmd.Flags().StringVarP(&m.Filter.Pex, "pepxml", "", "", "pepXML file or directory containing a set of pepXML files")
+		filterCmd.Flags().StringVarP(&m.Filter.Pex, "pepxml", "", "", "pepXML, MSFragger tsv or mzIdentML file, or directory containing a set of them")
 		filterCmd.Flags().StringVarP(&m.Filter.Pox, "protxml", "", "", "protXML file path")
 		filterCmd.Flags().StringVarP(&m.Filter.Tag, "tag", "", "rev_", "decoy tag")
 		filterCmd.Flags().StringVarP(&m.Filter.Mods, "mods", "", "", "list of modifications for a stratified FDR filtering")
 		filterCmd.Flags().StringVarP(&m.Filter.RazorBin, "razorbin", "", "", "use a custom razor assignment for the filtering")
+		filterCmd.Flags().StringVarP(&m.Filter.SearchScore, "searchscore", "", "", "name or accession of the search engine score kept for each PSM (default expectation value)")
 		filterCmd.Flags().Float64VarP(&m.Filter.IonFDR, "ion", "", 0.01, "peptide ion FDR level")
 		filterCmd.Flags().Float64VarP(&m.Filter.PepFDR, "pep", "", 0.01, "peptide FDR level")
 		filterCmd.Flags().Float64VarP(&m.Filter.PsmFDR, "psm", "", 0.01, "psm FDR level")
@@ -74,8 +75,18 @@ func init() {
 		filterCmd.Flags().BoolVarP(&m.Filter.TwoD, "2d", "", false, "two-dimensional FDR filtering")
 		filterCmd.Flags().BoolVarP(&m.Filter.Model, "models", "", false, "print model distribution")
 		filterCmd.Flags().BoolVarP(&m.Filter.Razor, "razor", "", false, "use razor peptides for protein FDR scoring")
+		filterCmd.Flags().StringVarP(&m.Filter.RazorStrategy, "razorstrategy", "", "", "assign the razor peptides to the protein with the most peptides, spectra, the highest probability, the longest or the reviewed one (peptides, spectra, probability, length or reviewed)")
 		filterCmd.Flags().BoolVarP(&m.Filter.Picked, "picked", "", false, "apply the picked FDR algorithm before the protein scoring")
+		filterCmd.Flags().BoolVarP(&m.Filter.Gene, "gene", "", false, "estimate the protein FDR at the gene level with the picked FDR algorithm, and report the genes")
+		filterCmd.Flags().BoolVarP(&m.Filter.Fido, "fido", "", false, "score the proteins of the native inference with a Bayesian model of their peptides")
 		filterCmd.Flags().BoolVarP(&m.Filter.Mapmods, "mapmods", "", false, "map modifications")
+		filterCmd.Flags().StringVarP(&m.Filter.Score, "score", "", "", "rank the PSMs by a search engine score instead of the probability (expect, hyperscore, xcorr or search_score)")
+		filterCmd.Flags().StringVarP(&m.Filter.FDRGroup, "fdrgroup", "", "", "estimate the PSM, ion and peptide FDR for each run or experiment (run or experiment)")
+		filterCmd.Flags().StringVarP(&m.Filter.SampleSheet, "samplesheet", "", "", "two column file assigning each run to an experiment, used by the experiment FDR")
+		filterCmd.Flags().StringVarP(&m.Filter.Stratify, "stratify", "", "", "estimate the PSM FDR for each stratum of a comma-separated list of charge, ntt, nmc, mods, massdiff, cv or length")
+		filterCmd.Flags().StringVarP(&m.Filter.Entrapment, "entrapment", "", "", "prefix tag of the entrapment sequences, reports the entrapment FDP against the estimated FDR")
+		filterCmd.Flags().BoolVarP(&m.Filter.Percolator, "percolator", "", false, "use the PSMs rescored by the percolator command instead of the pepXML files")
+		filterCmd.Flags().BoolVarP(&m.Filter.Rescore, "rescore", "", false, "use the PSMs rescored by the rescore command instead of the pepXML files")
 		filterCmd.Flags().BoolVarP(&m.Filter.Inference, "inference", "", false, "extremely fast and efficient protein inference compatible with 2D and Sequential filters")
 		filterCmd.Flags().MarkHidden("mods")
 		filterCmd.Flags().MarkHidden("delta")
@@ -22,7 +22,6 @@ var freequant = &cobra.Command{
 
 		m.FunctionInitCheckUp()
 
-		m.Quantify.Format = "mzML"
 		if len(m.Quantify.Dir) < 1 {
 			msg.InputNotFound(errors.New("you need to provide the path to the mz files and the correct extension"), "fatal")
 		}
@@ -32,14 +31,13 @@ var freequant = &cobra.Command{
 		if strings.EqualFold(m.Quantify.Format, "mzml") {
 			m.Quantify.Format = "mzML"
 		} else if strings.EqualFold(m.Quantify.Format, "mzxml") {
-			msg.InputNotFound(errors.New("only the mzML format is supported"), "fatal")
 			m.Quantify.Format = "mzXML"
+		} else if strings.EqualFold(m.Quantify.Format, "mgf") {
+			msg.Custom(errors.New("MGF files have no MS1 spectra to trace the precursors, use mzML, mzXML or d"), "error")
+		} else if strings.EqualFold(m.Quantify.Format, "d") {
+			m.Quantify.Format = "d"
 		} else {
-			msg.InputNotFound(errors.New("unknown file format"), "fatal")
-		}
-
-		if m.Quantify.Raw {
-			msg.Custom(errors.New("support for Thermo raw files was temporarily removed, please convert your files to mzML"), "fatal")
+			msg.InputNotFound(errors.New("unknown file format, use mzML, mzXML or d"), "error")
 		}
 
 		//forcing the larger time window to be the same as the smaller one
@@ -66,6 +64,7 @@ func init() {
 		m.Restore(sys.Meta())
 
 		freequant.Flags().StringVarP(&m.Quantify.Dir, "dir", "", "", "folder path containing the raw files")
+		freequant.Flags().StringVarP(&m.Quantify.Format, "format", "", "mzML", "spectra file format (mzML, mzXML, d for Bruker timsTOF)")
 		freequant.Flags().Float64VarP(&m.Quantify.Tol, "tol", "", 10, "m/z tolerance in ppm")
 		freequant.Flags().Float64VarP(&m.Quantify.PTWin, "ptw", "", 0.4, "specify the time windows for the peak (minute)")
 		freequant.Flags().BoolVarP(&m.Quantify.Raw, "raw", "", false, "read raw files instead of converted XML")
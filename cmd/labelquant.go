@@ -22,8 +22,6 @@ var labelquantCmd = &cobra.Command{
 
 		m.FunctionInitCheckUp()
 
-		m.Quantify.Format = "mzML"
-
 		if len(m.Quantify.Format) < 1 || len(m.Quantify.Dir) < 1 {
 			msg.InputNotFound(errors.New("you need to provide the path to the mz files and the correct extension"), "fatal")
 		}
@@ -34,17 +32,16 @@ var labelquantCmd = &cobra.Command{
 
 		msg.Executing("Isobaric-label quantification ", Version)
 
-		if strings.EqualFold(strings.ToLower(m.Quantify.Format), "mzml") {
+		if strings.EqualFold(m.Quantify.Format, "mzml") {
 			m.Quantify.Format = "mzML"
 		} else if strings.EqualFold(m.Quantify.Format, "mzxml") {
-			msg.InputNotFound(errors.New("only the mzML format is supported"), "fatal")
 			m.Quantify.Format = "mzXML"
+		} else if strings.EqualFold(m.Quantify.Format, "mgf") {
+			m.Quantify.Format = "mgf"
+		} else if strings.EqualFold(m.Quantify.Format, "d") {
+			m.Quantify.Format = "d"
 		} else {
-			msg.InputNotFound(errors.New("unknown file format"), "fatal")
-		}
-
-		if m.Quantify.Raw {
-			msg.Custom(errors.New("support for Thermo raw files was temporarily removed, please convert your files to mzML"), "fatal")
+			msg.InputNotFound(errors.New("unknown file format, use mzML, mzXML, mgf or d"), "error")
 		}
 
 		m.Quantify = qua.RunIsobaricLabelQuantification(m.Quantify, m.Filter.Mapmods)
@@ -68,6 +65,7 @@ func init() {
 		labelquantCmd.Flags().StringVarP(&m.Quantify.Annot, "annot", "", "", "annotation file with custom names for the TMT channels")
 		labelquantCmd.Flags().StringVarP(&m.Quantify.Plex, "plex", "", "", "number of reporter ion channels")
 		labelquantCmd.Flags().StringVarP(&m.Quantify.Dir, "dir", "", "", "folder path containing the raw files")
+		labelquantCmd.Flags().StringVarP(&m.Quantify.Format, "format", "", "mzML", "spectra file format (mzML, mzXML, mgf, d for Bruker timsTOF)")
 		labelquantCmd.Flags().StringVarP(&m.Quantify.Brand, "brand", "", "", "isobaric labeling brand (tmt, itraq)")
 		labelquantCmd.Flags().Float64VarP(&m.Quantify.Tol, "tol", "", 20, "m/z tolerance in ppm")
 		labelquantCmd.Flags().IntVarP(&m.Quantify.Level, "level", "", 2, "ms level for the quantification")
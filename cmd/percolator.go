@@ -0,0 +1,53 @@
+// Package cmd Percolator top level command
+package cmd
+
+import (
+	"os"
+
+	"philosopher/lib/ext/percolator"
+	"philosopher/lib/met"
+	"philosopher/lib/msg"
+	"philosopher/lib/sys"
+
+	"github.com/spf13/cobra"
+)
+
+// percolatorCmd represents the percolator command
+var percolatorCmd = &cobra.Command{
+	Use:   "percolator",
+	Short: "Semi-supervised PSM validation with Percolator",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		m.FunctionInitCheckUp()
+
+		msg.Executing("Percolator ", Version)
+
+		m = percolator.Run(m, args)
+
+		m.Serialize()
+
+		// clean tmp
+		met.CleanTemp(m.Temp)
+
+		msg.Done()
+	},
+}
+
+func init() {
+
+	if len(os.Args) > 1 && os.Args[1] == "percolator" {
+
+		m.Restore(sys.Meta())
+
+		percolatorCmd.Flags().StringVarP(&m.Percolator.Bin, "path", "", "percolator", "path to the Percolator binary")
+		percolatorCmd.Flags().StringVarP(&m.Percolator.Output, "output", "", "percolator", "prefix for the pin and result files")
+		percolatorCmd.Flags().StringVarP(&m.Percolator.Tag, "tag", "", "", "decoy tag (default from the database command)")
+		percolatorCmd.Flags().StringVarP(&m.Percolator.SearchScore, "searchscore", "", "", "name or accession of a search engine score to add as a feature")
+		percolatorCmd.Flags().Float64VarP(&m.Percolator.TrainFDR, "trainfdr", "", 0.01, "FDR threshold to define positive examples in training")
+		percolatorCmd.Flags().Float64VarP(&m.Percolator.TestFDR, "testfdr", "", 0.01, "FDR threshold for evaluating the best cross validation result")
+		percolatorCmd.Flags().IntVarP(&m.Percolator.MaxIter, "maxiter", "", 10, "maximum number of training iterations")
+		percolatorCmd.Flags().IntVarP(&m.Percolator.Threads, "threads", "", 3, "number of threads used by Percolator")
+	}
+
+	RootCmd.AddCommand(percolatorCmd)
+}
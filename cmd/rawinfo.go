@@ -0,0 +1,42 @@
+// Package cmd RawInfo top level command
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"philosopher/lib/msg"
+	"philosopher/lib/rwi"
+
+	"github.com/spf13/cobra"
+)
+
+// rawinfoCmd represents the rawinfo command
+var rawinfoCmd = &cobra.Command{
+	Use:   "rawinfo",
+	Short: "Metadata, scan events and chromatograms from Thermo raw files",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		if len(args) < 1 {
+			msg.InputNotFound(errors.New("provide a raw file or a folder containing raw files"), "fatal")
+		}
+
+		msg.Executing("RawInfo", Version)
+
+		// reading raw files does not change the workspace
+		m = rwi.Run(m, args)
+
+		msg.Done()
+	},
+}
+
+func init() {
+
+	if len(os.Args) > 1 && os.Args[1] == "rawinfo" {
+
+		rawinfoCmd.Flags().StringVarP(&m.RawInfo.Output, "output", "", ".", "output folder for the scan tables, chromatograms and JSON files")
+		rawinfoCmd.Flags().BoolVarP(&m.RawInfo.JSON, "json", "", false, "save the metadata and scan events as JSON")
+	}
+
+	RootCmd.AddCommand(rawinfoCmd)
+}
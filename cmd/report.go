@@ -46,6 +46,9 @@ func init() {
 		reportCmd.Flags().BoolVarP(&m.Report.MZID, "mzid", "", false, "create a mzID output")
 		reportCmd.Flags().BoolVarP(&m.Report.IonMob, "ionmobility", "", false, "forces the printing of the ion mobility column")
 		reportCmd.Flags().BoolVarP(&m.Report.Prefix, "prefix", "", false, "add the project (folder) name as a prefix to the output files")
+		reportCmd.Flags().BoolVarP(&m.Report.MzML, "mzml", "", false, "create mzML files with the spectra of the reported PSMs")
+		reportCmd.Flags().BoolVarP(&m.Report.MS1, "ms1", "", false, "add the MS1 parent spectra to the mzML output")
+		reportCmd.Flags().StringVarP(&m.Report.Spectra, "spectra", "", ".", "folder containing the mzML files used for the mzML output")
 	}
 
 	RootCmd.AddCommand(reportCmd)
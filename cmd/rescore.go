@@ -0,0 +1,51 @@
+// Package cmd Rescore top level command
+package cmd
+
+import (
+	"os"
+
+	"philosopher/lib/met"
+	"philosopher/lib/msg"
+	"philosopher/lib/rsc"
+	"philosopher/lib/sys"
+
+	"github.com/spf13/cobra"
+)
+
+// rescoreCmd represents the rescore command
+var rescoreCmd = &cobra.Command{
+	Use:   "rescore",
+	Short: "Semi-supervised PSM rescoring with a linear discriminant",
+	Run: func(cmd *cobra.Command, args []string) {
+
+		m.FunctionInitCheckUp()
+
+		msg.Executing("Rescore ", Version)
+
+		m = rsc.Run(m, args)
+
+		m.Serialize()
+
+		// clean tmp
+		met.CleanTemp(m.Temp)
+
+		msg.Done()
+	},
+}
+
+func init() {
+
+	if len(os.Args) > 1 && os.Args[1] == "rescore" {
+
+		m.Restore(sys.Meta())
+
+		rescoreCmd.Flags().StringVarP(&m.Rescore.Model, "model", "", "lda", "linear model used for the discriminant (lda or svm)")
+		rescoreCmd.Flags().StringVarP(&m.Rescore.Tag, "tag", "", "", "decoy tag (default from the database command)")
+		rescoreCmd.Flags().StringVarP(&m.Rescore.SearchScore, "searchscore", "", "", "name or accession of a search engine score to add as a feature")
+		rescoreCmd.Flags().Float64VarP(&m.Rescore.TrainFDR, "trainfdr", "", 0.01, "FDR threshold to define positive examples in training")
+		rescoreCmd.Flags().IntVarP(&m.Rescore.Folds, "folds", "", 3, "number of cross validation folds")
+		rescoreCmd.Flags().IntVarP(&m.Rescore.MaxIter, "maxiter", "", 10, "maximum number of training iterations")
+	}
+
+	RootCmd.AddCommand(rescoreCmd)
+}
@@ -23,4 +23,8 @@ func Run(m met.Data, args []string) {
 	if m.Abacus.Protein {
 		proteinLevelAbacus(m, args)
 	}
+
+	if m.Abacus.Gene {
+		geneLevelAbacus(m, args)
+	}
 }
@@ -0,0 +1,136 @@
+// Package aba (Abacus), gene level
+package aba
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"philosopher/lib/met"
+	"philosopher/lib/msg"
+	"philosopher/lib/rep"
+	"philosopher/lib/sys"
+
+	"github.com/sirupsen/logrus"
+)
+
+// combinedGene is a gene reported by at least one data set. The best q-value is the lowest of the
+// data sets, it is not a FDR estimated on the pooled evidence since the gene reports only hold the
+// genes that passed the filter
+type combinedGene struct {
+	GeneName   string
+	BestQValue float64
+	Proteins   map[string]struct{}
+	Genes      map[string]rep.GeneEvidence
+}
+
+// geneLevelAbacus creates the gene combined report from the gene reports of each data set
+func geneLevelAbacus(m met.Data, args []string) {
+
+	var names []string
+	var genes = make(map[string]*combinedGene)
+
+	logrus.Info("Restoring gene results")
+
+	for _, i := range args {
+
+		var list rep.GeneEvidenceList
+		rep.RestoreGeneWithPath(&list, i)
+
+		// collect project names
+		prjName := i
+		if strings.Contains(prjName, string(filepath.Separator)) {
+			prjName = strings.Replace(filepath.Base(prjName), string(filepath.Separator), "", -1)
+		}
+
+		if len(list) == 0 {
+			msg.Custom(fmt.Errorf("%s has no gene results, run the filter with the gene option", prjName), "warning")
+		}
+
+		names = append(names, prjName)
+
+		for _, j := range list {
+
+			if j.IsDecoy {
+				continue
+			}
+
+			g, ok := genes[j.GeneName]
+			if !ok {
+				g = &combinedGene{GeneName: j.GeneName, BestQValue: j.QValue, Proteins: make(map[string]struct{}), Genes: make(map[string]rep.GeneEvidence)}
+				genes[j.GeneName] = g
+			}
+
+			if j.QValue < g.BestQValue {
+				g.BestQValue = j.QValue
+			}
+
+			for _, k := range j.Proteins {
+				g.Proteins[k] = struct{}{}
+			}
+
+			g.Genes[prjName] = j
+		}
+	}
+
+	sort.Strings(names)
+
+	var list []*combinedGene
+	for _, i := range genes {
+		list = append(list, i)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].GeneName < list[j].GeneName })
+
+	saveGeneAbacusResult(m.Temp, list, names)
+}
+
+// saveGeneAbacusResult creates the gene combined report with the spectral counts and intensities
+// of each data set
+func saveGeneAbacusResult(session string, genes []*combinedGene, namesList []string) {
+
+	output := fmt.Sprintf("%s%scombined_gene.tsv", session, string(filepath.Separator))
+
+	file, e := os.Create(output)
+	if e != nil {
+		msg.WriteFile(errors.New("cannot create the combined gene report"), "error")
+		return
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	header := "Gene\tProteins\tBest Q-Value"
+	for _, i := range namesList {
+		header += fmt.Sprintf("\t%s Spectral Count\t%s Unique Spectral Count\t%s Razor Spectral Count\t%s Razor Intensity", i, i, i, i)
+	}
+	fmt.Fprintln(w, header)
+
+	for _, i := range genes {
+
+		var proteins []string
+		for k := range i.Proteins {
+			proteins = append(proteins, k)
+		}
+		sort.Strings(proteins)
+
+		line := fmt.Sprintf("%s\t%s\t%.6f", i.GeneName, strings.Join(proteins, ", "), i.BestQValue)
+		for _, j := range namesList {
+			g := i.Genes[j]
+			line += fmt.Sprintf("\t%d\t%d\t%d\t%.4f", g.TotalSpC, g.UniqueSpC, g.URazorSpC, g.URazorIntensity)
+		}
+
+		fmt.Fprintln(w, line)
+	}
+
+	if e := w.Flush(); e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	// copy to work directory
+	sys.CopyFile(output, filepath.Base(output))
+}
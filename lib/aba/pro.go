@@ -20,6 +20,7 @@ import (
 	"philosopher/lib/fil"
 	"philosopher/lib/id"
 	"philosopher/lib/met"
+	"philosopher/lib/raz"
 	"philosopher/lib/rep"
 	"philosopher/lib/sys"
 
@@ -177,7 +178,7 @@ func processProteinCombinedFile(a met.Abacus, database dat.Base) rep.CombinedPro
 
 		// applies razor algorithm
 		if a.Razor {
-			protxml = fil.RazorFilter(protxml)
+			protxml = fil.RazorFilter(protxml, raz.Default, nil)
 		}
 
 		proid := fil.ProtXMLFilter(protxml, 0.01, a.PepProb, a.ProtProb, a.Picked, a.Razor, a.Tag)
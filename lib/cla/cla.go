@@ -85,3 +85,30 @@ func IsDecoyIon(names map[string]int, tag string) bool {
 
 	return class
 }
+
+// IsEntrapment identifies a Protein as an entrapment based on the entrapment tag
+func IsEntrapment(name string, tag string) bool {
+	return len(tag) > 0 && strings.HasPrefix(name, tag)
+}
+
+// IsEntrapmentProteins identifies an evidence as an entrapment when all the target proteins
+// it maps to are entrapments, a single target from the sample proteome is enough to keep it
+func IsEntrapmentProteins(names []string, tag, decoyTag string) bool {
+
+	var class bool
+
+	for _, i := range names {
+
+		if len(decoyTag) > 0 && strings.HasPrefix(i, decoyTag) {
+			continue
+		}
+
+		if !IsEntrapment(i, tag) {
+			return false
+		}
+
+		class = true
+	}
+
+	return class
+}
@@ -0,0 +1,164 @@
+// Package cnv (Convert) converts and subsets mass spectrometry data files
+package cnv
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/met"
+	"philosopher/lib/msg"
+	"philosopher/lib/mzn"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Run converts each input file to mzML, keeping only the spectra that pass the subset filters
+func Run(m met.Data, args []string) met.Data {
+
+	if !strings.EqualFold(m.Msconvert.Format, "mzML") {
+		msg.Custom(fmt.Errorf("unsupported output format %s, only mzML can be written", m.Msconvert.Format), "error")
+	}
+	m.Msconvert.Format = "mzML"
+
+	for _, i := range []*string{&m.Msconvert.MZBinaryEncoding, &m.Msconvert.IntensityBinaryEncoding} {
+		*i = strings.TrimSuffix(*i, "-bit")
+		if *i != "32" && *i != "64" {
+			msg.Custom(errors.New("the binary encoding must be either 32 or 64"), "error")
+		}
+	}
+
+	levels := splitList(m.Msconvert.MSLevel)
+	scans := parseScans(m.Msconvert.Scans)
+
+	if len(m.Msconvert.Output) == 0 {
+		m.Msconvert.Output = "."
+	}
+
+	for _, f := range args {
+
+		ext := strings.ToLower(filepath.Ext(f))
+		output := filepath.Join(m.Msconvert.Output, strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))+".mzML")
+
+		if abs(output) == abs(f) {
+			msg.Custom(fmt.Errorf("the output file %s would overwrite the input file, use a different output folder", output), "error")
+		}
+
+		var walk mzn.Walker
+
+		switch ext {
+		case ".raw":
+			var mz mzn.MsData
+			mz.ReadThermoRaw(f)
+			walk = mz.Walk
+		case ".mzml":
+			walk = mzn.StreamMzML(f)
+		default:
+			msg.InputNotFound(fmt.Errorf("%s is not a Thermo raw or mzML file", f), "error")
+		}
+
+		logrus.Info("Converting ", filepath.Base(f))
+
+		w := mzn.NewMzMLWriter(output, f, m.Version, m.Msconvert.MZBinaryEncoding, m.Msconvert.IntensityBinaryEncoding, m.Msconvert.Zlib, m.Msconvert.NoIndex)
+
+		walk(func(spec mzn.Spectrum) {
+
+			if spec.ScanStartTime < m.Msconvert.MinRT || (m.Msconvert.MaxRT > 0 && spec.ScanStartTime > m.Msconvert.MaxRT) {
+				return
+			}
+
+			if scans != nil && !scans.contains(spec.Scan) {
+				return
+			}
+
+			w.Write(spec)
+
+		}, levels...)
+
+		if w.Len() == 0 {
+			msg.Custom(fmt.Errorf("no spectra from %s passed the filters", filepath.Base(f)), "warning")
+		}
+
+		w.Close()
+
+		logrus.Info("Wrote ", w.Len(), " spectra to ", output)
+	}
+
+	return m
+}
+
+// scanRanges is a list of inclusive scan number ranges
+type scanRanges [][2]int
+
+// contains checks if the scan is inside any of the ranges
+func (s scanRanges) contains(scan string) bool {
+
+	sn, e := strconv.Atoi(scan)
+	if e != nil {
+		return false
+	}
+
+	for _, i := range s {
+		if sn >= i[0] && sn <= i[1] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseScans reads a list of scans and scan ranges, like 1-100,250,300-400
+func parseScans(list string) scanRanges {
+
+	var ranges scanRanges
+
+	for _, i := range splitList(list) {
+
+		bounds := strings.SplitN(i, "-", 2)
+
+		first, e := strconv.Atoi(bounds[0])
+		if e != nil {
+			msg.Custom(fmt.Errorf("invalid scan list: %s", list), "error")
+		}
+
+		last := first
+		if len(bounds) == 2 {
+			last, e = strconv.Atoi(bounds[1])
+			if e != nil || last < first {
+				msg.Custom(fmt.Errorf("invalid scan list: %s", list), "error")
+			}
+		}
+
+		ranges = append(ranges, [2]int{first, last})
+	}
+
+	return ranges
+}
+
+// splitList splits a comma separated list, ignoring empty values
+func splitList(list string) []string {
+
+	var values []string
+
+	for _, i := range strings.Split(list, ",") {
+		i = strings.TrimSpace(i)
+		if len(i) > 0 {
+			values = append(values, i)
+		}
+	}
+
+	return values
+}
+
+// abs returns the absolute path, or the path itself if it cannot be resolved
+func abs(f string) string {
+
+	p, e := filepath.Abs(f)
+	if e != nil {
+		return f
+	}
+
+	return p
+}
@@ -108,8 +108,24 @@ func Run(m met.Data) met.Data {
 		db.DownloadedFiles = append(db.DownloadedFiles, dbPath)
 	}
 
+	if len(m.Database.Entrapment) > 0 {
+		if len(m.Database.EntrapmentTag) == 0 || m.Database.EntrapmentTag == m.Database.Tag {
+			msg.Custom(errors.New("the entrapment sequences need a prefix different from the decoy prefix"), "error")
+		}
+		logrus.Info("Adding the entrapment sequences with the prefix ", m.Database.EntrapmentTag)
+	}
+
+	m.Database.Decoy = strings.ToLower(m.Database.Decoy)
+	if len(m.Database.Decoy) == 0 {
+		m.Database.Decoy = Reverse
+	}
+
+	if !IsDecoyMethod(m.Database.Decoy) {
+		msg.Custom(errors.New("the decoy method must be reverse, pseudo, shuffle or debruijn"), "error")
+	}
+
 	logrus.Info("Generating the target-decoy database")
-	db.Create(m.Temp, m.Database.Add, m.Database.Enz, m.Database.Tag, m.Database.Crap, m.Database.NoD, m.Database.CrapTag, ids)
+	db.Create(m.Temp, m.Database.Add, m.Database.Enz, m.Database.Tag, m.Database.Decoy, m.Database.Entrapment, m.Database.EntrapmentTag, m.Database.Crap, m.Database.NoD, m.Database.CrapTag, ids)
 
 	logrus.Info("Creating file")
 	customDB := db.Save(m.Home, m.Temp, m.Database.ID, m.Database.Tag, m.Database.Rev, m.Database.Iso, m.Database.NoD, m.Database.Crap)
@@ -117,7 +133,7 @@ func Run(m met.Data) met.Data {
 	db.ProcessDB(customDB, m.Database.Tag)
 
 	logrus.Info("Processing decoys")
-	db.Create(m.Temp, m.Database.Add, m.Database.Enz, m.Database.Tag, m.Database.Crap, m.Database.NoD, m.Database.CrapTag, ids)
+	db.Create(m.Temp, m.Database.Add, m.Database.Enz, m.Database.Tag, m.Database.Decoy, m.Database.Entrapment, m.Database.EntrapmentTag, m.Database.Crap, m.Database.NoD, m.Database.CrapTag, ids)
 
 	logrus.Info("Creating file")
 	db.Save(m.Home, m.Temp, m.Database.ID, m.Database.Tag, m.Database.Rev, m.Database.Iso, m.Database.NoD, m.Database.Crap)
@@ -259,8 +275,10 @@ func (d *Base) Fetch(uniprotID, proteomeID, temp string, iso, rev bool) {
 	d.DownloadedFiles = append(d.DownloadedFiles, d.UniProtDB)
 }
 
-// Create processes the given fasta file and add decoy sequences
-func (d *Base) Create(temp, add, enz, tag string, crap, noD, cTag bool, ids map[string]string) {
+// Create processes the given fasta file and add decoy sequences built with the decoy method. The
+// entrapment sequences come from a foreign proteome FASTA file, or are shuffled from the targets
+// when entrapment is shuffle, and they get decoys like any other sequence
+func (d *Base) Create(temp, add, enz, tag, decoy, entrapment, entrapmentTag string, crap, noD, cTag bool, ids map[string]string) {
 
 	d.TaDeDB = make(map[string]string)
 
@@ -277,6 +295,23 @@ func (d *Base) Create(temp, add, enz, tag string, crap, noD, cTag bool, ids map[
 			}
 		}
 
+		// the entrapment sequences are taken before the contaminants are added. The shuffled ones
+		// are seeded with the entrapment header, so they differ from the shuffled decoys
+		var entrapments = make(map[string]string)
+		if strings.EqualFold(entrapment, "shuffle") {
+			g := newDecoyGenerator(Shuffle, enz, db)
+			for k, v := range db {
+				entrapments[entrapmentTag+k] = g.Decoy(entrapmentTag+k, v)
+			}
+			if g.Clashes > 0 {
+				logrus.Warn(g.Clashes, " entrapment peptides are also target peptides")
+			}
+		} else if len(entrapment) > 0 {
+			for k, v := range fas.ParseFile(entrapment) {
+				entrapments[entrapmentTag+k] = v
+			}
+		}
+
 		// adding contaminants to database before reversion
 		// repeated entries are removed and substituted by contaminants
 		if crap {
@@ -309,18 +344,31 @@ func (d *Base) Create(temp, add, enz, tag string, crap, noD, cTag bool, ids map[
 
 		}
 
+		for k, v := range entrapments {
+			db[k] = v
+		}
+
+		var g *decoyGenerator
+		if !noD {
+			g = newDecoyGenerator(decoy, enz, db)
+		}
+
 		for h, s := range db {
 
 			th := ">" + h
 			d.TaDeDB[th] = s
 
 			if !noD {
-				dh := ">" + tag + h
-				d.TaDeDB[dh] = reverseSeq(s)
+				dh := ">" + decoyHeader(tag, h, decoy)
+				d.TaDeDB[dh] = g.Decoy(h, s)
 			}
 
 		}
 
+		if g != nil && g.Clashes > 0 {
+			logrus.Warn(g.Clashes, " decoy peptides are also target peptides")
+		}
+
 	}
 
 }
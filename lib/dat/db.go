@@ -604,3 +604,37 @@ func Classify(s, decoyTag string) string {
 
 	return "generic"
 }
+
+// GeneName returns the first gene name of a protein, or the protein name when it has no gene
+// annotation. Decoys keep the decoy tag, so each decoy gene competes with its target gene
+func GeneName(genes, protein, decoyTag string) string {
+
+	isDecoy := len(decoyTag) > 0 && strings.HasPrefix(protein, decoyTag)
+
+	name := protein
+	if isDecoy {
+		name = strings.TrimPrefix(protein, decoyTag)
+	}
+
+	if fields := strings.Fields(genes); len(fields) > 0 {
+		name = fields[0]
+	}
+
+	if isDecoy {
+		return decoyTag + name
+	}
+
+	return name
+}
+
+// GeneMap maps the protein names to their genes
+func (d *Base) GeneMap(decoyTag string) map[string]string {
+
+	var genes = make(map[string]string)
+
+	for _, i := range d.Records {
+		genes[i.PartHeader] = GeneName(i.GeneNames, i.PartHeader, decoyTag)
+	}
+
+	return genes
+}
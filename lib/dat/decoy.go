@@ -0,0 +1,296 @@
+package dat
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"strings"
+)
+
+// The decoy generation methods
+const (
+	Reverse       = "reverse"
+	PseudoReverse = "pseudo"
+	Shuffle       = "shuffle"
+	DeBruijn      = "debruijn"
+)
+
+// decoyTries is the number of shuffles tried before a decoy peptide found on the targets is kept
+const decoyTries = 10
+
+// minDecoyLength is the shortest decoy peptide counted when it is also a target peptide
+const minDecoyLength = 7
+
+// decoyGenerator builds the decoy sequences of the targets, one peptide at the time between the
+// cleavage sites of the enzyme
+type decoyGenerator struct {
+	Method  string
+	Sites   string
+	NTerm   bool
+	Targets map[string]struct{}
+	Clashes int
+}
+
+// IsDecoyMethod checks if the decoy generation method exists
+func IsDecoyMethod(method string) bool {
+	switch method {
+	case Reverse, PseudoReverse, Shuffle, DeBruijn:
+		return true
+	}
+	return false
+}
+
+// newDecoyGenerator prepares the decoy generation, the target peptides are kept for the identity
+// checks of the decoys
+func newDecoyGenerator(method, enz string, db map[string]string) *decoyGenerator {
+
+	var g = &decoyGenerator{Method: method, Targets: make(map[string]struct{})}
+	g.Sites, g.NTerm = cleavageSites(enz)
+
+	if method == Reverse {
+		return g
+	}
+
+	for _, s := range db {
+		for _, i := range splitPeptides(s, g.Sites, g.NTerm) {
+			g.Targets[i] = struct{}{}
+		}
+	}
+
+	return g
+}
+
+// cleavageSites returns the residues cut by the enzyme, and whether it cuts before them
+func cleavageSites(enz string) (string, bool) {
+
+	switch strings.ToLower(enz) {
+	case "lys_c":
+		return "K", false
+	case "lys_n":
+		return "K", true
+	case "glu_c":
+		return "DE", false
+	case "chymotrypsin":
+		return "FWYL", false
+	}
+
+	return "KR", false
+}
+
+// decoyHeader adds the decoy tag to the header, and records the generation method right after the
+// protein identifier, so the tag prefix and the description fields still parse
+func decoyHeader(tag, h, method string) string {
+
+	parts := strings.SplitN(h, " ", 2)
+
+	header := tag + parts[0] + " decoy_method=" + method
+	if len(parts) > 1 {
+		header += " " + parts[1]
+	}
+
+	return header
+}
+
+// Decoy builds the decoy of a target sequence. The pseudo-reversed, shuffled and de Bruijn decoys
+// keep the cleavage sites and the initial methionine in place, so the decoy peptides have the
+// masses and the termini of the target peptides
+func (g *decoyGenerator) Decoy(h, s string) string {
+
+	if g.Method == Reverse {
+		return reverseSeq(s)
+	}
+
+	// the header seeds the shuffle, so the same database is created every time
+	hash := fnv.New64a()
+	hash.Write([]byte(h))
+	random := rand.New(rand.NewSource(int64(hash.Sum64())))
+
+	var decoy strings.Builder
+
+	for i, j := range splitPeptides(s, g.Sites, g.NTerm) {
+
+		prefix, core, suffix := g.peptideCore(j, i == 0)
+
+		var d string
+		for try := 0; ; try++ {
+
+			switch g.Method {
+			case PseudoReverse:
+				d = reverseSeq(core)
+			case Shuffle:
+				d = shufflePeptide(core, random)
+			case DeBruijn:
+				// the seed comes from the peptide, the repeated target peptides give repeated decoys
+				d = deBruijnPeptide(core, peptideRandom(core, try))
+			}
+
+			if _, ok := g.Targets[prefix+d+suffix]; !ok || g.Method == PseudoReverse || try == decoyTries {
+				break
+			}
+		}
+
+		if _, ok := g.Targets[prefix+d+suffix]; ok && len(j) >= minDecoyLength {
+			g.Clashes++
+		}
+
+		decoy.WriteString(prefix + d + suffix)
+	}
+
+	return decoy.String()
+}
+
+// peptideCore splits a peptide in the residues kept in place, the cleavage site and the protein
+// initial methionine, and the residues the decoy rearranges
+func (g *decoyGenerator) peptideCore(p string, first bool) (string, string, string) {
+
+	var prefix, suffix string
+
+	if first && strings.HasPrefix(p, "M") {
+		prefix, p = p[:1], p[1:]
+	}
+
+	if g.NTerm {
+		if len(p) > 0 && strings.ContainsRune(g.Sites, rune(p[0])) {
+			prefix, p = prefix+p[:1], p[1:]
+		}
+	} else {
+		if len(p) > 0 && strings.ContainsRune(g.Sites, rune(p[len(p)-1])) {
+			p, suffix = p[:len(p)-1], p[len(p)-1:]
+		}
+	}
+
+	return prefix, p, suffix
+}
+
+// splitPeptides cuts the sequence after, or before, each cleavage site
+func splitPeptides(s, sites string, nTerm bool) []string {
+
+	var peptides []string
+	var start int
+
+	for i := range s {
+		if !strings.ContainsRune(sites, rune(s[i])) {
+			continue
+		}
+
+		if nTerm {
+			if i > start {
+				peptides = append(peptides, s[start:i])
+				start = i
+			}
+		} else {
+			peptides = append(peptides, s[start:i+1])
+			start = i + 1
+		}
+	}
+
+	if start < len(s) {
+		peptides = append(peptides, s[start:])
+	}
+
+	return peptides
+}
+
+// peptideRandom seeds a random source with the peptide and the try
+func peptideRandom(p string, try int) *rand.Rand {
+	hash := fnv.New64a()
+	hash.Write([]byte(p))
+	return rand.New(rand.NewSource(int64(hash.Sum64()) + int64(try)))
+}
+
+// shufflePeptide shuffles the residues of a peptide
+func shufflePeptide(p string, random *rand.Rand) string {
+
+	r := []rune(p)
+	random.Shuffle(len(r), func(i, j int) {
+		r[i], r[j] = r[j], r[i]
+	})
+
+	return string(r)
+}
+
+// deBruijnPeptide walks a random Eulerian path on the de Bruijn graph of the peptide, the residues
+// are the nodes and each pair of neighbour residues is an edge. The decoy keeps the residue pairs,
+// the first and the last residue of the target, as in the Altschul and Erickson shuffle
+func deBruijnPeptide(p string, random *rand.Rand) string {
+
+	if len(p) < 3 {
+		return p
+	}
+
+	last := p[len(p)-1]
+
+	var edges = make(map[byte][]byte)
+	var order []byte
+	for i := 0; i < len(p)-1; i++ {
+		if _, ok := edges[p[i]]; !ok {
+			order = append(order, p[i])
+		}
+		edges[p[i]] = append(edges[p[i]], p[i+1])
+	}
+
+	// the last edge leaving each residue must lead to the last residue, the last exits of the
+	// target are always a valid choice
+	var exits = make(map[byte]int)
+	for _, i := range order {
+		if i != last {
+			exits[i] = len(edges[i]) - 1
+		}
+	}
+
+	for try := 0; try < decoyTries; try++ {
+
+		var candidate = make(map[byte]int)
+		for _, i := range order {
+			if i != last {
+				candidate[i] = random.Intn(len(edges[i]))
+			}
+		}
+
+		if reachesLast(edges, candidate, last) {
+			exits = candidate
+			break
+		}
+	}
+
+	// the other edges are shuffled and the last exit goes to the end of each list
+	var walk = make(map[byte][]byte)
+	for _, i := range order {
+
+		e := append([]byte(nil), edges[i]...)
+
+		if k, ok := exits[i]; ok {
+			e[k], e[len(e)-1] = e[len(e)-1], e[k]
+			random.Shuffle(len(e)-1, func(a, b int) { e[a], e[b] = e[b], e[a] })
+		} else {
+			random.Shuffle(len(e), func(a, b int) { e[a], e[b] = e[b], e[a] })
+		}
+
+		walk[i] = e
+	}
+
+	var decoy = []byte{p[0]}
+	for len(decoy) < len(p) {
+		current := decoy[len(decoy)-1]
+		decoy = append(decoy, walk[current][0])
+		walk[current] = walk[current][1:]
+	}
+
+	return string(decoy)
+}
+
+// reachesLast checks that the last exits of every residue lead to the last residue
+func reachesLast(edges map[byte][]byte, exits map[byte]int, last byte) bool {
+
+	for i := range exits {
+
+		current := i
+		for steps := 0; current != last; steps++ {
+			if steps > len(exits) {
+				return false
+			}
+			current = edges[current][exits[current]]
+		}
+	}
+
+	return true
+}
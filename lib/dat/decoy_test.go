@@ -0,0 +1,182 @@
+package dat
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestDecoyGenerator(t *testing.T) {
+
+	db := map[string]string{
+		"sp|P1|A_HUMAN Protein A OS=Homo sapiens": "MPEPTIDEAKLLSAMPLERGHIJLMNPQK",
+		"sp|P2|B_HUMAN Protein B OS=Homo sapiens": "MSTVWYACDEFGHIKPEPTIDEAK",
+	}
+
+	sorted := func(s string) string {
+		r := []byte(s)
+		sort.Slice(r, func(i, j int) bool { return r[i] < r[j] })
+		return string(r)
+	}
+
+	for _, method := range []string{PseudoReverse, Shuffle, DeBruijn} {
+		t.Run(method, func(t *testing.T) {
+
+			g := newDecoyGenerator(method, "trypsin", db)
+
+			for h, s := range db {
+
+				decoy := g.Decoy(h, s)
+
+				if decoy != g.Decoy(h, s) {
+					t.Errorf("the %s decoy of %s changes between runs", method, h)
+				}
+
+				if sorted(decoy) != sorted(s) {
+					t.Errorf("got %s, not a rearrangement of %s", decoy, s)
+				}
+
+				// the cleavage sites and the initial methionine stay in place
+				for i := range s {
+					if (s[i] == 'K' || s[i] == 'R' || i == 0) && decoy[i] != s[i] {
+						t.Errorf("got %s for %s, the residue %d moved", decoy, s, i)
+					}
+				}
+			}
+		})
+	}
+
+	g := newDecoyGenerator(PseudoReverse, "trypsin", db)
+	if got := g.Decoy("P1", "MPEPTIDEAKLLSAMPLER"); got != "MAEDITPEPKELPMASLLR" {
+		t.Errorf("got pseudo-reversed %s, want MAEDITPEPKELPMASLLR", got)
+	}
+}
+
+func TestDeBruijnPeptide(t *testing.T) {
+
+	pairs := func(s string) map[string]int {
+		var p = make(map[string]int)
+		for i := 0; i < len(s)-1; i++ {
+			p[s[i:i+2]]++
+		}
+		return p
+	}
+
+	p := "ACDACEACFGHACIK"
+
+	for try := 0; try < 5; try++ {
+
+		decoy := deBruijnPeptide(p, peptideRandom(p, try))
+
+		if decoy[0] != p[0] || decoy[len(decoy)-1] != p[len(p)-1] {
+			t.Errorf("got %s, the termini of %s moved", decoy, p)
+		}
+
+		want := pairs(p)
+		got := pairs(decoy)
+		for k, v := range want {
+			if got[k] != v {
+				t.Errorf("got %d %s pairs in %s, want %d", got[k], k, decoy, v)
+			}
+		}
+	}
+
+	// the repeated peptides give the same decoy
+	if deBruijnPeptide(p, peptideRandom(p, 0)) != deBruijnPeptide(p, peptideRandom(p, 0)) {
+		t.Errorf("the de Bruijn decoy of %s is not repeated", p)
+	}
+}
+
+func TestCreateDecoyHeaders(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "dat")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	h := "sp|P1|A_HUMAN Protein A OS=Homo sapiens PE=1 SV=2"
+
+	f := filepath.Join(dir, "targets.fas")
+	if e := ioutil.WriteFile(f, []byte(">"+h+"\nMPEPTIDEAKLLSAMPLERGHIJLMNPQK\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	// every method records itself after the protein identifier, and leaves the description untouched
+	for _, method := range []string{Reverse, PseudoReverse, Shuffle, DeBruijn} {
+
+		var d Base
+		d.DownloadedFiles = []string{f}
+		d.Create(dir, "", "trypsin", "rev_", method, "", "", false, false, false, nil)
+
+		var headers []string
+		for k := range d.TaDeDB {
+			if strings.HasPrefix(k, ">rev_") {
+				headers = append(headers, k)
+			}
+		}
+
+		if len(headers) != 1 {
+			t.Fatalf("got headers %v for the %s decoys", d.TaDeDB, method)
+		}
+
+		fields := strings.Fields(headers[0])
+		if fields[0] != ">rev_sp|P1|A_HUMAN" || strings.TrimPrefix(fields[1], "decoy_method=") != method {
+			t.Errorf("got method %s from the header %s, want %s", fields[1], headers[0], method)
+		}
+
+		if !strings.HasSuffix(headers[0], " Protein A OS=Homo sapiens PE=1 SV=2") {
+			t.Errorf("the header %s lost the description", headers[0])
+		}
+	}
+}
+
+func TestCreateShuffledEntrapment(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "dat")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "targets.fas")
+	fasta := ">sp|P1|A_HUMAN Protein A OS=Homo sapiens\nMPEPTIDEAKLLSAMPLERGHIJLMNPQK\n>sp|P2|B_HUMAN Protein B OS=Homo sapiens\nMSTVWYACDEFGHIKPEPTIDEAK\n"
+	if e := ioutil.WriteFile(f, []byte(fasta), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	var d Base
+	d.DownloadedFiles = []string{f}
+	d.Create(dir, "", "trypsin", "rev_", Shuffle, "shuffle", "entrapment_", false, false, false, nil)
+
+	var targets = make(map[string]struct{})
+	for h, s := range d.TaDeDB {
+		if !strings.HasPrefix(h, ">entrapment_") && !strings.HasPrefix(h, ">rev_") {
+			for _, i := range splitPeptides(s, "KR", false) {
+				targets[i] = struct{}{}
+			}
+		}
+	}
+
+	// the entrapments are shuffled like the decoys, with their own seed and no target peptides
+	for _, h := range []string{"sp|P1|A_HUMAN Protein A OS=Homo sapiens", "sp|P2|B_HUMAN Protein B OS=Homo sapiens"} {
+
+		entrapment, ok := d.TaDeDB[">entrapment_"+h]
+		if !ok {
+			t.Fatalf("the entrapment of %s is missing from %v", h, d.TaDeDB)
+		}
+
+		if entrapment == d.TaDeDB[">"+h] || entrapment == d.TaDeDB[">"+decoyHeader("rev_", h, Shuffle)] {
+			t.Errorf("the entrapment %s of %s repeats the target or its decoy", entrapment, h)
+		}
+
+		for _, i := range splitPeptides(entrapment, "KR", false) {
+			if _, ok := targets[i]; ok && len(i) >= minDecoyLength {
+				t.Errorf("the entrapment peptide %s of %s is a target peptide", i, h)
+			}
+		}
+	}
+}
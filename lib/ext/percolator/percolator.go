@@ -0,0 +1,411 @@
+package percolator
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/cla"
+	"philosopher/lib/id"
+	"philosopher/lib/met"
+	"philosopher/lib/msg"
+	"philosopher/lib/sys"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Percolator represents the tool configuration
+type Percolator struct {
+	DefaultBin string
+}
+
+// result is the Percolator assessment of a PSM
+type result struct {
+	Score  float64
+	QValue float64
+	PEP    float64
+}
+
+// feature is a column of the pin file
+type feature struct {
+	Name  string
+	Value func(p *id.PeptideIdentification) float64
+}
+
+// New constructor
+func New(temp string) Percolator {
+
+	var self Percolator
+
+	self.DefaultBin = "percolator"
+
+	return self
+}
+
+// Run is the Percolator main entry point, it rescores the PSMs from the identification files and
+// keeps them in the workspace for the filter command
+func Run(m met.Data, args []string) met.Data {
+
+	var perc = New(m.Temp)
+
+	if len(args) == 0 {
+		msg.NoParametersFound(errors.New("missing identification files"), "error")
+	}
+
+	// get the database tag from database command
+	if len(m.Percolator.Tag) == 0 {
+		m.Percolator.Tag = m.Database.Tag
+	}
+
+	if len(m.Percolator.Bin) == 0 {
+		m.Percolator.Bin = perc.DefaultBin
+	}
+
+	if len(m.Percolator.Output) == 0 {
+		m.Percolator.Output = "percolator"
+	}
+
+	m.Percolator.InputFiles = args
+
+	var files []string
+	for _, i := range args {
+		files = append(files, id.ListInputFiles(i)...)
+	}
+
+	psms := id.ReadInputFiles(files, m.Percolator.Tag, m.Temp, false, m.Percolator.SearchScore)
+
+	pin := m.Percolator.Output + ".pin"
+	ids := writePin(psms.PeptideIdentification, pin, m.Percolator.Tag, len(m.Percolator.SearchScore) > 0)
+
+	logrus.Info("Wrote ", len(ids), " PSMs to ", pin)
+
+	targets, decoys := perc.Execute(m.Percolator, pin)
+
+	results := readPout(targets)
+	for k, v := range readPout(decoys) {
+		results[k] = v
+	}
+
+	if missing := assignResults(psms.PeptideIdentification, ids, results); missing > 0 {
+		msg.Custom(fmt.Errorf("%d PSMs are missing from the Percolator results", missing), "warning")
+	}
+
+	sys.Serialize(&psms, sys.PercolatorBin())
+
+	return m
+}
+
+// assignResults sets the Percolator probability, score, q-value and PEP of each PSM, and returns the
+// number of PSMs missing from the results. The target-decoy competition keeps the best PSM of
+// each spectrum, the others are filtered out without being counted as missing
+func assignResults(psms id.PepIDListPtrs, ids []string, results map[string]result) int {
+
+	var kept = make(map[string]bool)
+	for i, j := range ids {
+		if _, ok := results[j]; ok {
+			kept[psms[i].Spectrum] = true
+		}
+	}
+
+	var missing int
+	for i, j := range ids {
+
+		r, ok := results[j]
+		if !ok {
+			psms[i].Probability = 0
+			psms[i].QValue = 1
+			psms[i].PEP = 1
+			psms[i].Rescored = true
+			if !kept[psms[i].Spectrum] {
+				missing++
+			}
+			continue
+		}
+
+		psms[i].Probability = 1 - r.PEP
+		psms[i].DiscriminantScore = r.Score
+		psms[i].QValue = r.QValue
+		psms[i].PEP = r.PEP
+		psms[i].Rescored = true
+	}
+
+	return missing
+}
+
+// Execute runs Percolator on the pin file and returns the target and decoy result files
+func (p Percolator) Execute(params met.Percolator, pin string) (string, string) {
+
+	targets := params.Output + ".target.psms.txt"
+	decoys := params.Output + ".decoy.psms.txt"
+
+	cmd := exec.Command(params.Bin)
+
+	cmd.Args = append(cmd.Args, "--only-psms", "--post-processing-tdc")
+	cmd.Args = append(cmd.Args, "--results-psms", targets)
+	cmd.Args = append(cmd.Args, "--decoy-results-psms", decoys)
+
+	if params.TrainFDR > 0 {
+		cmd.Args = append(cmd.Args, "--trainFDR", strconv.FormatFloat(params.TrainFDR, 'f', -1, 64))
+	}
+
+	if params.TestFDR > 0 {
+		cmd.Args = append(cmd.Args, "--testFDR", strconv.FormatFloat(params.TestFDR, 'f', -1, 64))
+	}
+
+	if params.MaxIter > 0 {
+		cmd.Args = append(cmd.Args, "--maxiter", strconv.Itoa(params.MaxIter))
+	}
+
+	if params.Threads > 0 {
+		cmd.Args = append(cmd.Args, "--num-threads", strconv.Itoa(params.Threads))
+	}
+
+	cmd.Args = append(cmd.Args, pin)
+
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	e := cmd.Start()
+	if e != nil {
+		msg.ExecutingBinary(e, "error")
+	}
+
+	if e := cmd.Wait(); e != nil {
+		msg.ExecutingBinary(e, "error")
+	}
+
+	return targets, decoys
+}
+
+// writePin writes the Percolator input file with the features of each PSM and returns the PSM
+// identifiers in the same order as the list. The search score is a feature when it was selected,
+// and the optional scores are only used when they are present. The target-decoy competition
+// groups the PSMs by scan number, so each spectrum is numbered in the order it is found, and the
+// scans from different runs do not compete
+func writePin(psms id.PepIDListPtrs, f, decoyTag string, searchScore bool) []string {
+
+	features := pinFeatures(psms, searchScore)
+
+	file, e := os.Create(f)
+	if e != nil {
+		msg.WriteFile(e, "error")
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	header := []string{"SpecId", "Label", "ScanNr", "ExpMass", "CalcMass"}
+	for _, i := range features {
+		header = append(header, i.Name)
+	}
+	header = append(header, "Peptide", "Proteins")
+
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	var ids = make([]string, len(psms))
+	var seen = make(map[string]int)
+	var scans = make(map[string]int)
+
+	for i, p := range psms {
+
+		// the spectrum names repeat when the same spectrum is found on more than one file
+		specID := p.Spectrum
+		if n := seen[p.Spectrum]; n > 0 {
+			specID = fmt.Sprintf("%s_%d", p.Spectrum, n)
+		}
+		seen[p.Spectrum]++
+		ids[i] = specID
+
+		label := "1"
+		if cla.IsDecoyPSM(*p, decoyTag) {
+			label = "-1"
+		}
+
+		scan, ok := scans[p.Spectrum]
+		if !ok {
+			scan = len(scans) + 1
+			scans[p.Spectrum] = scan
+		}
+
+		line := []string{specID, label, strconv.Itoa(scan), formatFloat(p.PrecursorNeutralMass), formatFloat(p.CalcNeutralPepMass)}
+
+		for _, j := range features {
+			line = append(line, formatFloat(j.Value(p)))
+		}
+
+		peptide := p.ModifiedPeptide
+		if len(peptide) == 0 {
+			peptide = p.Peptide
+		}
+
+		line = append(line, fmt.Sprintf("%s.%s.%s", flank(p.PrevAA), peptide, flank(p.NextAA)), p.Protein)
+
+		var alt []string
+		for k := range p.AlternativeProteins {
+			if k != p.Protein {
+				alt = append(alt, k)
+			}
+		}
+		sort.Strings(alt)
+		line = append(line, alt...)
+
+		fmt.Fprintln(w, strings.Join(line, "\t"))
+	}
+
+	if e := w.Flush(); e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	return ids
+}
+
+// pinFeatures selects the features for the pin file
+func pinFeatures(psms id.PepIDListPtrs, searchScore bool) []feature {
+
+	var features []feature
+
+	present := func(v func(p *id.PeptideIdentification) float64) bool {
+		for _, i := range psms {
+			if v(i) != 0 {
+				return true
+			}
+		}
+		return false
+	}
+
+	optional := []feature{
+		{"hyperscore", func(p *id.PeptideIdentification) float64 { return p.Hyperscore }},
+		{"deltaHyperscore", func(p *id.PeptideIdentification) float64 {
+			if p.Nextscore == 0 {
+				return 0
+			}
+			return p.Hyperscore - p.Nextscore
+		}},
+		{"xcorr", func(p *id.PeptideIdentification) float64 { return p.Xcorr }},
+		{"deltaCn", func(p *id.PeptideIdentification) float64 { return p.DeltaCN }},
+		{"log10Expect", func(p *id.PeptideIdentification) float64 {
+			if p.Expectation <= 0 {
+				return 0
+			}
+			return -math.Log10(p.Expectation)
+		}},
+		{"spectralSim", func(p *id.PeptideIdentification) float64 { return p.SpectralSim }},
+		{"rtScore", func(p *id.PeptideIdentification) float64 { return p.Rtscore }},
+	}
+
+	if searchScore {
+		optional = append(optional, feature{"searchScore", func(p *id.PeptideIdentification) float64 { return p.SearchScore }})
+	}
+
+	for _, i := range optional {
+		if present(i.Value) {
+			features = append(features, i)
+		}
+	}
+
+	features = append(features,
+		feature{"massErrorPPM", func(p *id.PeptideIdentification) float64 {
+			if p.CalcNeutralPepMass == 0 {
+				return 0
+			}
+			return (p.PrecursorNeutralMass - p.CalcNeutralPepMass) / p.CalcNeutralPepMass * 1e6
+		}},
+		feature{"absMassdiff", func(p *id.PeptideIdentification) float64 { return math.Abs(p.Massdiff) }},
+		feature{"peptideLength", func(p *id.PeptideIdentification) float64 { return float64(len(p.Peptide)) }},
+	)
+
+	var charges = make(map[uint8]struct{})
+	for _, i := range psms {
+		charges[i.AssumedCharge] = struct{}{}
+	}
+
+	var list []int
+	for i := range charges {
+		list = append(list, int(i))
+	}
+	sort.Ints(list)
+
+	for _, i := range list {
+		z := uint8(i)
+		features = append(features, feature{fmt.Sprintf("charge%d", i), func(p *id.PeptideIdentification) float64 {
+			if p.AssumedCharge == z {
+				return 1
+			}
+			return 0
+		}})
+	}
+
+	features = append(features,
+		feature{"enzN", func(p *id.PeptideIdentification) float64 { return float64(p.NumberOfEnzymaticTermini) }},
+		feature{"missedCleavages", func(p *id.PeptideIdentification) float64 { return float64(p.NumberofMissedCleavages) }},
+	)
+
+	return features
+}
+
+// readPout reads the Percolator PSM results by PSM identifier
+func readPout(f string) map[string]result {
+
+	var results = make(map[string]result)
+
+	file, e := os.Open(f)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	var col = make(map[string]int)
+	if scanner.Scan() {
+		for i, j := range strings.Split(scanner.Text(), "\t") {
+			col[j] = i
+		}
+	}
+
+	for _, i := range []string{"PSMId", "score", "q-value", "posterior_error_prob"} {
+		if _, ok := col[i]; !ok {
+			msg.Custom(fmt.Errorf("%s is not a Percolator results file, the %s column is missing", filepath.Base(f), i), "error")
+		}
+	}
+
+	for scanner.Scan() {
+
+		row := strings.Split(scanner.Text(), "\t")
+		if len(row) < len(col) {
+			continue
+		}
+
+		var r result
+		r.Score, _ = strconv.ParseFloat(row[col["score"]], 64)
+		r.QValue, _ = strconv.ParseFloat(row[col["q-value"]], 64)
+		r.PEP, _ = strconv.ParseFloat(row[col["posterior_error_prob"]], 64)
+
+		results[row[col["PSMId"]]] = r
+	}
+
+	if e := scanner.Err(); e != nil {
+		msg.ReadFile(e, "error")
+	}
+
+	return results
+}
+
+// flank returns the flanking residue, or a dash for the protein termini
+func flank(aa []byte) string {
+	if len(aa) == 0 {
+		return "-"
+	}
+	return string(aa)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
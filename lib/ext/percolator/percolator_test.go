@@ -0,0 +1,110 @@
+package percolator
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"philosopher/lib/id"
+)
+
+func TestPinRoundTrip(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "percolator")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	// the same spectrum from two runs, and a target and a decoy competing for one spectrum
+	psms := id.PepIDListPtrs{
+		{Spectrum: "a.00100.00100.2", Peptide: "PEPTIDEK", Protein: "sp|P1|ONE", AssumedCharge: 2, Hyperscore: 30, PrevAA: []byte("K")},
+		{Spectrum: "b.00100.00100.2", Peptide: "PEPTIDEK", Protein: "sp|P1|ONE", AssumedCharge: 2, Hyperscore: 25},
+		{Spectrum: "b.00200.00200.3", Peptide: "SAMPLER", Protein: "sp|P2|TWO", AssumedCharge: 3, Hyperscore: 20},
+		{Spectrum: "b.00200.00200.3", Peptide: "ELPMASR", Protein: "rev_sp|P2|TWO", AssumedCharge: 3, Hyperscore: 10},
+	}
+
+	pin := filepath.Join(dir, "test.pin")
+	ids := writePin(psms, pin, "rev_", false)
+
+	want := []string{"a.00100.00100.2", "b.00100.00100.2", "b.00200.00200.3", "b.00200.00200.3_1"}
+	for i := range want {
+		if ids[i] != want[i] {
+			t.Errorf("got PSM identifier %s, want %s", ids[i], want[i])
+		}
+	}
+
+	file, e := os.Open(pin)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer file.Close()
+
+	var rows [][]string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		rows = append(rows, strings.Split(scanner.Text(), "\t"))
+	}
+
+	if len(rows) != 5 || rows[0][1] != "Label" || rows[0][2] != "ScanNr" || rows[0][5] != "hyperscore" {
+		t.Fatalf("got pin header %v", rows[0])
+	}
+
+	// the runs do not share scan numbers, and the competing PSMs do
+	tests := []struct {
+		label string
+		scan  string
+	}{
+		{"1", "1"},
+		{"1", "2"},
+		{"1", "3"},
+		{"-1", "3"},
+	}
+
+	for i, tt := range tests {
+		if rows[i+1][1] != tt.label || rows[i+1][2] != tt.scan {
+			t.Errorf("got label %s and scan %s for %s, want %s and %s", rows[i+1][1], rows[i+1][2], ids[i], tt.label, tt.scan)
+		}
+	}
+
+	if p := rows[1][len(rows[1])-2]; p != "K.PEPTIDEK.-" {
+		t.Errorf("got peptide %s, want K.PEPTIDEK.-", p)
+	}
+
+	// the decoy lost the competition, and the second run PSM is missing from the results
+	pout := filepath.Join(dir, "test.target.psms.txt")
+	doc := "PSMId\tscore\tq-value\tposterior_error_prob\tpeptide\tproteinIds\n" +
+		"a.00100.00100.2\t2.5\t0.001\t0.01\tK.PEPTIDEK.-\tsp|P1|ONE\n" +
+		"b.00200.00200.3\t1.5\t0.005\t0.2\t-.SAMPLER.-\tsp|P2|TWO\n"
+	if e := ioutil.WriteFile(pout, []byte(doc), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	results := readPout(pout)
+	if len(results) != 2 || results["b.00200.00200.3"].PEP != 0.2 {
+		t.Fatalf("got results %v", results)
+	}
+
+	if missing := assignResults(psms, ids, results); missing != 1 {
+		t.Errorf("got %d missing PSMs, want 1", missing)
+	}
+
+	assigned := []struct {
+		probability float64
+		qvalue      float64
+	}{
+		{0.99, 0.001},
+		{0, 1},
+		{0.8, 0.005},
+		{0, 1},
+	}
+
+	for i, tt := range assigned {
+		if psms[i].Probability != tt.probability || psms[i].QValue != tt.qvalue {
+			t.Errorf("got probability %f and q-value %f for %s, want %f and %f", psms[i].Probability, psms[i].QValue, ids[i], tt.probability, tt.qvalue)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package fil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"philosopher/lib/cla"
+	"philosopher/lib/dat"
+	"philosopher/lib/msg"
+	"philosopher/lib/rep"
+
+	"github.com/sirupsen/logrus"
+	"gonum.org/v1/plot"
+	"gonum.org/v1/plot/plotter"
+	"gonum.org/v1/plot/plotutil"
+	"gonum.org/v1/plot/vg"
+)
+
+// entrapmentSteps is the number of q-value thresholds evaluated on each level
+const entrapmentSteps = 50
+
+// entrapmentLevel has the q-values of the target identifications on one level, and which of them
+// are entrapments
+type entrapmentLevel struct {
+	Name       string
+	FDR        float64
+	QValues    []float64
+	Entrapment []bool
+}
+
+// entrapmentPoint compares the estimated FDR with the entrapment FDP at one q-value threshold
+type entrapmentPoint struct {
+	Threshold   float64
+	Estimated   float64
+	Targets     int
+	Entrapments int
+	LowerBound  float64
+	Combined    float64
+}
+
+// reportEntrapment compares the target-decoy FDR with the false discovery proportion observed on
+// the entrapment hits at the PSM, peptide and protein levels, the report and the plot are written
+// to the workspace
+func reportEntrapment(e rep.Evidence, workspace, tag, decoyTag string, psmFDR, pepFDR, protFDR float64) {
+
+	var db dat.Base
+	db.Restore()
+
+	ratio := entrapmentRatio(db.Records, tag)
+	if ratio == 0 {
+		msg.Custom(errors.New("the database has no entrapment sequences with the prefix "+tag), "warning")
+		return
+	}
+
+	logrus.Info("Estimating the entrapment FDP with an entrapment to target ratio of ", fmt.Sprintf("%.4f", ratio))
+
+	var levels []entrapmentLevel
+
+	var psm = entrapmentLevel{Name: "PSM", FDR: psmFDR}
+	for _, i := range e.PSM {
+		if i.IsDecoy {
+			continue
+		}
+		var names = []string{i.Protein}
+		for k := range i.MappedProteins {
+			names = append(names, k)
+		}
+		psm.QValues = append(psm.QValues, i.QValue)
+		psm.Entrapment = append(psm.Entrapment, cla.IsEntrapmentProteins(names, tag, decoyTag))
+	}
+	levels = append(levels, psm)
+
+	var peptide = entrapmentLevel{Name: "Peptide", FDR: pepFDR}
+	for _, i := range e.Peptides {
+		if i.IsDecoy {
+			continue
+		}
+		var names = []string{i.Protein}
+		for k := range i.MappedProteins {
+			names = append(names, k)
+		}
+		peptide.QValues = append(peptide.QValues, i.QValue)
+		peptide.Entrapment = append(peptide.Entrapment, cla.IsEntrapmentProteins(names, tag, decoyTag))
+	}
+	levels = append(levels, peptide)
+
+	if len(e.Proteins) > 0 {
+		var protein = entrapmentLevel{Name: "Protein", FDR: protFDR}
+		for _, i := range e.Proteins {
+			if i.IsDecoy {
+				continue
+			}
+			protein.QValues = append(protein.QValues, i.QValue)
+			protein.Entrapment = append(protein.Entrapment, cla.IsEntrapment(i.PartHeader, tag))
+		}
+		levels = append(levels, protein)
+	}
+
+	var points = make([][]entrapmentPoint, len(levels))
+	for i, j := range levels {
+		points[i] = entrapmentFDP(j, ratio)
+		if len(points[i]) > 0 {
+			last := points[i][len(points[i])-1]
+			logrus.WithFields(logrus.Fields{
+				"estimated":   fmt.Sprintf("%.4f", last.Estimated),
+				"lower bound": fmt.Sprintf("%.4f", last.LowerBound),
+				"combined":    fmt.Sprintf("%.4f", last.Combined),
+			}).Info(j.Name, " entrapment FDP")
+		}
+	}
+
+	printEntrapment(filepath.Join(workspace, "entrapment.tsv"), levels, points)
+	plotEntrapment(filepath.Join(workspace, "entrapment.png"), levels, points)
+}
+
+// entrapmentRatio is the size of the entrapment database relative to the sample database, counted
+// in residues so a foreign proteome with longer or shorter proteins is weighted correctly
+func entrapmentRatio(records []dat.Record, tag string) float64 {
+
+	var targets, entrapments float64
+
+	for _, i := range records {
+		if i.IsDecoy {
+			continue
+		}
+		if cla.IsEntrapment(i.PartHeader, tag) {
+			entrapments += float64(i.Length)
+		} else {
+			targets += float64(i.Length)
+		}
+	}
+
+	if targets == 0 {
+		return 0
+	}
+
+	return entrapments / targets
+}
+
+// entrapmentFDP counts the sample and entrapment identifications accepted on each q-value
+// threshold up to the FDR of the level. The lower bound counts only the entrapment hits as false,
+// the combined estimate adds the sample false hits expected from the database ratio
+func entrapmentFDP(level entrapmentLevel, ratio float64) []entrapmentPoint {
+
+	var points []entrapmentPoint
+
+	if len(level.QValues) == 0 || level.FDR <= 0 {
+		return points
+	}
+
+	var order = make([]int, len(level.QValues))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return level.QValues[order[i]] < level.QValues[order[j]] })
+
+	var next, targets, entrapments int
+	var estimated float64
+
+	for step := 1; step <= entrapmentSteps; step++ {
+
+		threshold := level.FDR * float64(step) / entrapmentSteps
+
+		for next < len(order) && level.QValues[order[next]] <= threshold {
+			if level.Entrapment[order[next]] {
+				entrapments++
+			} else {
+				targets++
+			}
+			estimated = level.QValues[order[next]]
+			next++
+		}
+
+		var p = entrapmentPoint{Threshold: threshold, Estimated: estimated, Targets: targets, Entrapments: entrapments}
+		if targets+entrapments > 0 {
+			p.LowerBound = float64(entrapments) / float64(targets+entrapments)
+			p.Combined = float64(entrapments) * (1 + 1/ratio) / float64(targets+entrapments)
+		}
+
+		points = append(points, p)
+	}
+
+	return points
+}
+
+// printEntrapment writes the entrapment FDP of each level
+func printEntrapment(output string, levels []entrapmentLevel, points [][]entrapmentPoint) {
+
+	file, e := os.Create(output)
+	if e != nil {
+		msg.WriteFile(errors.New("cannot create the entrapment report: "+e.Error()), "error")
+		return
+	}
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+
+	fmt.Fprintln(w, "Level\tQ-Value Threshold\tEstimated FDR\tTargets\tEntrapments\tLower Bound FDP\tCombined FDP")
+
+	for i, j := range levels {
+		for _, k := range points[i] {
+			fmt.Fprintf(w, "%s\t%.6f\t%.6f\t%d\t%d\t%.6f\t%.6f\n", j.Name, k.Threshold, k.Estimated, k.Targets, k.Entrapments, k.LowerBound, k.Combined)
+		}
+	}
+
+	if e := w.Flush(); e != nil {
+		msg.WriteFile(e, "error")
+	}
+}
+
+// plotEntrapment draws the entrapment FDP of each level against the estimated FDR
+func plotEntrapment(output string, levels []entrapmentLevel, points [][]entrapmentPoint) {
+
+	p := plot.New()
+
+	p.Title.Text = "Entrapment FDP"
+	p.X.Label.Text = "Estimated FDR"
+	p.Y.Label.Text = "Entrapment FDP"
+
+	var max float64
+	var lines []interface{}
+
+	for i, j := range levels {
+
+		if len(points[i]) == 0 {
+			continue
+		}
+
+		lower := make(plotter.XYs, len(points[i]))
+		combined := make(plotter.XYs, len(points[i]))
+		for k, l := range points[i] {
+			lower[k].X = l.Threshold
+			lower[k].Y = l.LowerBound
+			combined[k].X = l.Threshold
+			combined[k].Y = l.Combined
+		}
+
+		if j.FDR > max {
+			max = j.FDR
+		}
+
+		lines = append(lines, j.Name+" combined", combined, j.Name+" lower bound", lower)
+	}
+
+	lines = append(lines, "Estimated FDR", plotter.XYs{{X: 0, Y: 0}, {X: max, Y: max}})
+
+	if e := plotutil.AddLines(p, lines...); e != nil {
+		msg.Custom(e, "warning")
+		return
+	}
+
+	if e := p.Save(8*vg.Inch, 6*vg.Inch, output); e != nil {
+		msg.Custom(e, "warning")
+	}
+}
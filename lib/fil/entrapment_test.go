@@ -0,0 +1,53 @@
+package fil
+
+import (
+	"math"
+	"testing"
+
+	"philosopher/lib/dat"
+)
+
+func TestEntrapmentFDP(t *testing.T) {
+
+	records := []dat.Record{
+		{PartHeader: "sp|P1|ONE", Length: 300},
+		{PartHeader: "sp|P2|TWO", Length: 100},
+		{PartHeader: "entrapment_sp|P1|ONE", Length: 400},
+		{PartHeader: "rev_sp|P1|ONE", Length: 300, IsDecoy: true},
+	}
+
+	ratio := entrapmentRatio(records, "entrapment_")
+	if ratio != 1 {
+		t.Errorf("got entrapment ratio %f, want 1", ratio)
+	}
+
+	// 8 sample targets and 2 entrapments, the entrapments come last
+	level := entrapmentLevel{Name: "PSM", FDR: 0.05}
+	for i := 0; i < 10; i++ {
+		level.QValues = append(level.QValues, 0.005*float64(i))
+		level.Entrapment = append(level.Entrapment, i >= 8)
+	}
+
+	points := entrapmentFDP(level, ratio)
+	if len(points) != entrapmentSteps {
+		t.Fatalf("got %d thresholds, want %d", len(points), entrapmentSteps)
+	}
+
+	first := points[0]
+	if first.Targets != 1 || first.Entrapments != 0 || first.Combined != 0 {
+		t.Errorf("got %d targets, %d entrapments and FDP %f at the first threshold, want 1, 0 and 0", first.Targets, first.Entrapments, first.Combined)
+	}
+
+	last := points[len(points)-1]
+	if last.Targets != 8 || last.Entrapments != 2 {
+		t.Errorf("got %d targets and %d entrapments at the FDR, want 8 and 2", last.Targets, last.Entrapments)
+	}
+
+	if math.Abs(last.LowerBound-0.2) > 1e-9 || math.Abs(last.Combined-0.4) > 1e-9 {
+		t.Errorf("got lower bound %f and combined FDP %f, want 0.2 and 0.4", last.LowerBound, last.Combined)
+	}
+
+	if math.Abs(last.Estimated-0.045) > 1e-9 {
+		t.Errorf("got estimated FDR %f, want 0.045", last.Estimated)
+	}
+}
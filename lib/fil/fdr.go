@@ -3,6 +3,7 @@ package fil
 import (
 	"errors"
 	"fmt"
+	"math"
 	"sort"
 	"strings"
 	"sync"
@@ -11,6 +12,7 @@ import (
 	"philosopher/lib/id"
 	"philosopher/lib/msg"
 	"philosopher/lib/raz"
+	"philosopher/lib/rsc"
 
 	"github.com/sirupsen/logrus"
 )
@@ -23,68 +25,54 @@ func PepXMLFDRFilter(input map[string]id.PepIDListPtrs, targetFDR float64, level
 	var decoys uint
 	var calcFDR float64
 	var list id.PepIDListPtrs
-	var peplist id.PepIDListPtrs
 	var minProb float64 = 10
 
 	if strings.EqualFold(level, "PSM") {
 
-		// move all entries to list and count the number of targets and decoys
+		// move all entries to list
 		for _, i := range input {
-			for _, j := range i {
-				if cla.IsDecoyPSM(*j, decoyTag) {
-					decoys++
-				} else {
-					targets++
-				}
-				list = append(list, j)
-			}
+			list = append(list, i...)
 		}
 
-	} else if strings.EqualFold(level, "Peptide") {
+	} else if strings.EqualFold(level, "Peptide") || strings.EqualFold(level, "Ion") {
 
-		// 0 index means the one with highest score
+		// 0 index means the one with highest score, copied so the level q-values stay off the PSMs
 		for _, i := range input {
-			peplist = append(peplist, i[0])
+			best := *i[0]
+			list = append(list, &best)
 		}
 
-		for i := range peplist {
-			if cla.IsDecoyPSM(*peplist[i], decoyTag) {
-				decoys++
-			} else {
-				targets++
-			}
-			list = append(list, peplist[i])
-		}
+	}
 
-	} else if strings.EqualFold(level, "Ion") {
+	sort.Sort(list)
 
-		// 0 index means the one with highest score
-		for _, i := range input {
-			peplist = append(peplist, i[0])
-		}
+	var values = make([]float64, len(list))
+	var decoy = make([]bool, len(list))
+	for i := range list {
+		values[i] = list[i].Probability
+		decoy[i] = cla.IsDecoyPSM(*list[i], decoyTag)
+	}
 
-		for i := range peplist {
-			if cla.IsDecoyPSM(*peplist[i], decoyTag) {
-				decoys++
-			} else {
-				targets++
-			}
-			list = append(list, peplist[i])
-		}
+	// the q-values and PEPs are reported alongside the probability threshold
+	assignConfidence(list, values, decoy, level)
 
+	for i := range decoy {
+		if decoy[i] {
+			decoys++
+		} else {
+			targets++
+		}
 	}
 
-	sort.Sort(list)
-
 	var scoreMap = make(map[float64]float64)
 
 	limit := (len(list) - 1)
 
 	for j := limit; j >= 0; j-- {
 
-		scoreMap[list[j].Probability] = float64(decoys) / float64(targets)
+		scoreMap[values[j]] = float64(decoys) / float64(targets)
 
-		if cla.IsDecoyPSM(*list[j], decoyTag) {
+		if decoy[j] {
 			decoys--
 		} else {
 			targets--
@@ -111,11 +99,19 @@ func PepXMLFDRFilter(input map[string]id.PepIDListPtrs, targetFDR float64, level
 	targets = 0
 
 	for i := range list {
-		if list[i].Probability >= minProb {
+
+		keep := values[i] >= minProb
+
+		// the rescored PSMs are cut on the q-value they report
+		if list[i].Rescored && strings.EqualFold(level, "PSM") {
+			keep = list[i].QValue <= targetFDR
+		}
+
+		if keep {
 
 			cleanlist = append(cleanlist, list[i])
 
-			if cla.IsDecoyPSM(*list[i], decoyTag) {
+			if decoy[i] {
 				decoys++
 			} else {
 				targets++
@@ -133,6 +129,129 @@ func PepXMLFDRFilter(input map[string]id.PepIDListPtrs, targetFDR float64, level
 	return cleanlist, minProb
 }
 
+// scores are the search engine scores that can rank the PSMs in place of the probabilities, all
+// of them turned into higher is better
+var scores = map[string]func(p *id.PeptideIdentification) float64{
+	"expect":       func(p *id.PeptideIdentification) float64 { return -p.Expectation },
+	"hyperscore":   func(p *id.PeptideIdentification) float64 { return p.Hyperscore },
+	"xcorr":        func(p *id.PeptideIdentification) float64 { return p.Xcorr },
+	"search_score": func(p *id.PeptideIdentification) float64 { return p.SearchScore },
+}
+
+// IsFDRScore checks if the score can be used for the FDR filtering
+func IsFDRScore(score string) bool {
+	_, ok := scores[strings.ToLower(score)]
+	return ok
+}
+
+// filterFDR ranks the identifications by probability, or by the search engine score when one is given
+func filterFDR(input map[string]id.PepIDListPtrs, targetFDR float64, level, decoyTag, debug, score string) (id.PepIDListPtrs, float64) {
+
+	if len(score) > 0 {
+		return ScoreFDRFilter(input, targetFDR, level, decoyTag, score)
+	}
+
+	return PepXMLFDRFilter(input, targetFDR, level, decoyTag, debug)
+}
+
+// ScoreFDRFilter calculates the target-decoy q-values at the PSM, Ion or Peptide level ranking by a
+// search engine score, and keeps the identifications at or below the FDR. The peptides and ions
+// are represented by a copy of their best scoring PSM
+func ScoreFDRFilter(input map[string]id.PepIDListPtrs, targetFDR float64, level, decoyTag, score string) (id.PepIDListPtrs, float64) {
+
+	value, ok := scores[strings.ToLower(score)]
+	if !ok {
+		msg.Custom(fmt.Errorf("unknown FDR score %s, use expect, hyperscore, xcorr or search_score", score), "error")
+		return nil, 0
+	}
+
+	var list id.PepIDListPtrs
+
+	if strings.EqualFold(level, "PSM") {
+		for _, i := range input {
+			list = append(list, i...)
+		}
+	} else {
+		for _, i := range input {
+			best := i[0]
+			for _, j := range i[1:] {
+				if value(j) > value(best) {
+					best = j
+				}
+			}
+			c := *best
+			list = append(list, &c)
+		}
+	}
+
+	// the spectrum names break the ties, so the threshold does not depend on the map order
+	sort.SliceStable(list, func(i, j int) bool {
+		if value(list[i]) != value(list[j]) {
+			return value(list[i]) > value(list[j])
+		}
+		return list[i].SpectrumFileName().Str() < list[j].SpectrumFileName().Str()
+	})
+
+	var values = make([]float64, len(list))
+	var decoy = make([]bool, len(list))
+	for i := range list {
+		values[i] = value(list[i])
+		decoy[i] = cla.IsDecoyPSM(*list[i], decoyTag)
+	}
+
+	qvalues := assignConfidence(list, values, decoy, level)
+
+	var targets, decoys uint
+	var threshold = math.Inf(1)
+	var calcFDR float64
+	cleanlist := make(id.PepIDListPtrs, 0)
+
+	for i := range list {
+
+		if qvalues[i] > targetFDR {
+			continue
+		}
+
+		cleanlist = append(cleanlist, list[i])
+		threshold = values[i]
+		calcFDR = math.Max(calcFDR, qvalues[i])
+
+		if decoy[i] {
+			decoys++
+		} else {
+			targets++
+		}
+	}
+
+	msg := fmt.Sprintf("Converged to %.2f %% FDR with %d %ss", calcFDR*100, targets, level)
+	logrus.WithFields(logrus.Fields{
+		"decoy":     decoys,
+		"total":     (targets + decoys),
+		"threshold": threshold,
+	}).Info(msg)
+
+	return cleanlist, threshold
+}
+
+// assignConfidence stores the target-decoy q-value and the posterior error probability of each
+// identification, ranked by the values where higher is better, and returns the q-values. The PSMs
+// rescored by Percolator or the rescore command keep their own q-value and PEP
+func assignConfidence(list id.PepIDListPtrs, values []float64, decoy []bool, level string) []float64 {
+
+	qvalues := rsc.QValues(values, decoy)
+	peps := rsc.PEPs(values, decoy)
+
+	for i := range list {
+		if list[i].Rescored && strings.EqualFold(level, "PSM") {
+			continue
+		}
+		list[i].QValue = qvalues[i]
+		list[i].PEP = peps[i]
+	}
+
+	return qvalues
+}
+
 // PickedFDR employs the picked FDR strategy
 func PickedFDR(p id.ProtXML) id.ProtXML {
 
@@ -206,8 +325,9 @@ func PickedFDR(p id.ProtXML) id.ProtXML {
 // RazorCandidateMap is a list of razor candidates
 //type RazorCandidateMap map[string]RazorCandidate
 
-// RazorFilter classifies peptides as razor
-func RazorFilter(p id.ProtXML) id.ProtXML {
+// RazorFilter classifies peptides as razor, with the protein weights by default or with one of the
+// razor strategies, the annotations add the database information of the proteins
+func RazorFilter(p id.ProtXML, strategy string, annotations map[string]raz.Protein) id.ProtXML {
 
 	var r raz.RazorMap = make(map[string]raz.RazorCandidate)
 	var rList []string
@@ -268,126 +388,39 @@ func RazorFilter(p id.ProtXML) id.ProtXML {
 			}
 		}
 
-		// this will make the assignment more deterministic
+		// the peptides and their candidates are visited in order, so the same data always gives the
+		// same assignment
 		for k := range r {
 			rList = append(rList, k)
 		}
 		sort.Strings(rList)
 
-		var razorPair = make(map[string]string)
-
-		// get the best protein candidate for each peptide sequence and make the razor pair
-		for _, k := range rList {
-			// 1st pass: mark all cases with weight > 0.5
-			for pt, w := range r[k].MappedProteinsW {
-				if w > 0.5 {
-					razorPair[k] = pt
-				}
-			}
+		var evidence map[string]raz.Protein
+		if strategy != raz.Default {
+			evidence = razorEvidence(p, annotations)
 		}
 
-		// 2nd pass: mark all cases with highest group weight in the list
 		for _, k := range rList {
 
-			_, ok := razorPair[k]
-			if !ok {
+			razor := r[k]
 
-				var topPT string
-				var topCount int
-				var topGW float64
-				var topTNP int
-				var topGWMap = make(map[float64]uint8)
-				var topTNPMap = make(map[int]uint8)
-
-				if len(r[k].MappedProteinsGW) == 1 {
-
-					for pt := range r[k].MappedProteinsGW {
-						razorPair[k] = pt
-					}
-
-				} else if len(r[k].MappedProteinsGW) > 1 {
-
-					for pt, tnp := range r[k].MappedProteinsGW {
-						if tnp >= topGW {
-							topGW = tnp
-							topPT = pt
-							topGWMap[topGW]++
-						}
-					}
-
-					var tie bool
-					if topGWMap[topGW] >= 2 {
-						tie = true
-					}
-
-					if !tie {
-						razorPair[k] = topPT
-
-					} else {
-
-						var tnpList []string
-						for pt := range r[k].MappedProteinsTNP {
-							tnpList = append(tnpList, pt)
-						}
-
-						sort.Strings(tnpList)
-
-						for _, pt := range tnpList {
-							if r[k].MappedProteinsTNP[pt] >= topTNP {
-								topTNP = r[k].MappedProteinsTNP[pt]
-								topPT = pt
-								topTNPMap[topTNP]++
-							}
-						}
-
-						var tie bool
-						if topTNPMap[topTNP] >= 2 {
-							tie = true
-						}
-
-						if !tie {
-
-							var mplist []string
-							for pt := range r[k].MappedProteinsTNP {
-								mplist = append(mplist, pt)
-							}
-							sort.Strings(mplist)
-
-							for _, pt := range mplist {
-								if r[k].MappedProteinsTNP[pt] >= topCount {
-									topCount = r[k].MappedProteinsTNP[pt]
-									topPT = pt
-								}
-							}
-
-							razorPair[k] = topPT
-
-						} else {
-
-							var idList []string
-							for protein, id := range r[k].MappedproteinsSID {
-								id = fmt.Sprintf("%s#%s", id, protein)
-								idList = append(idList, id)
-							}
-
-							sort.Strings(idList)
-
-							id := strings.Split(idList[0], "#")
-							razorPair[k] = id[1]
-						}
+			if strategy == raz.Default {
+				razor.MappedProtein, razor.Rationale = weightedRazor(razor)
+			} else {
 
+				var candidates = make(map[string]raz.Protein)
+				for pt := range razor.MappedProteinsW {
+					v, ok := evidence[pt]
+					if !ok {
+						v = annotations[pt]
 					}
+					candidates[pt] = v
 				}
-			}
-		}
 
-		for _, k := range rList {
-			pt, ok := razorPair[k]
-			if ok {
-				razor := r[k]
-				razor.MappedProtein = pt
-				r[k] = razor
+				razor.MappedProtein, razor.Rationale = raz.Select(candidates, strategy)
 			}
+
+			r[k] = razor
 		}
 
 		r.Serialize()
@@ -426,6 +459,92 @@ func RazorFilter(p id.ProtXML) id.ProtXML {
 	return p
 }
 
+// weightedRazor chooses the razor protein with the peptide weights of the protein inference, then
+// the group weights, the total number of peptides and the group sibling
+func weightedRazor(rc raz.RazorCandidate) (string, string) {
+
+	var proteins []string
+	for pt := range rc.MappedProteinsW {
+		proteins = append(proteins, pt)
+	}
+	sort.Strings(proteins)
+
+	if len(proteins) == 0 {
+		return "", ""
+	}
+
+	for _, pt := range proteins {
+		if rc.MappedProteinsW[pt] > 0.5 {
+			return pt, "peptide weight"
+		}
+	}
+
+	if len(proteins) == 1 {
+		return proteins[0], "single protein"
+	}
+
+	// the proteins sharing the best score
+	best := func(score func(pt string) float64) []string {
+		var top []string
+		for _, pt := range proteins {
+			if len(top) == 0 || score(pt) > score(top[0]) {
+				top = []string{pt}
+			} else if score(pt) == score(top[0]) {
+				top = append(top, pt)
+			}
+		}
+		return top
+	}
+
+	if top := best(func(pt string) float64 { return rc.MappedProteinsGW[pt] }); len(top) == 1 {
+		return top[0], "group weight"
+	}
+
+	if top := best(func(pt string) float64 { return float64(rc.MappedProteinsTNP[pt]) }); len(top) == 1 {
+		return top[0], "total peptides"
+	}
+
+	var idList []string
+	for _, pt := range proteins {
+		idList = append(idList, fmt.Sprintf("%s#%s", rc.MappedproteinsSID[pt], pt))
+	}
+	sort.Strings(idList)
+
+	return strings.SplitN(idList[0], "#", 2)[1], "group sibling"
+}
+
+// razorEvidence collects the evidence of the proteins for the razor strategies, the
+// indistinguishable proteins share the evidence of their group entry
+func razorEvidence(p id.ProtXML, annotations map[string]raz.Protein) map[string]raz.Protein {
+
+	var evidence = make(map[string]raz.Protein)
+
+	for _, i := range p.Groups {
+		for _, j := range i.Proteins {
+
+			var peptides = make(map[string]struct{})
+			for _, k := range j.PeptideIons {
+				peptides[k.PeptideSequence] = struct{}{}
+			}
+
+			for _, k := range append([]string{j.ProteinName}, j.IndistinguishableProtein...) {
+
+				v := annotations[k]
+				v.Peptides = len(peptides)
+				v.Spectra = j.TotalNumberPeptides
+				v.Probability = j.Probability
+				if v.Length == 0 {
+					v.Length = j.Length
+				}
+
+				evidence[k] = v
+			}
+		}
+	}
+
+	return evidence
+}
+
 // ProtXMLFilter filters the protein list under a specific fdr
 func ProtXMLFilter(p id.ProtXML, targetFDR, pepProb, protProb float64, isPicked, isRazor bool, decoyTag string) id.ProtIDList {
 
@@ -468,16 +587,34 @@ func ProtXMLFilter(p id.ProtXML, targetFDR, pepProb, protProb float64, isPicked,
 		}
 	}
 
+	sort.Sort(&list)
+	if p.Posteriors {
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Probability > list[j].Probability })
+	}
+
+	var values = make([]float64, len(list))
+	var decoy = make([]bool, len(list))
 	for i := range list {
-		if cla.IsDecoyProtein(list[i], p.DecoyTag) {
+		values[i] = proteinScore(p, list[i])
+		decoy[i] = cla.IsDecoyProtein(list[i], p.DecoyTag)
+	}
+
+	// the q-values and PEPs are reported alongside the decoys / targets threshold
+	qvalues := rsc.QValues(values, decoy)
+	peps := rsc.PEPs(values, decoy)
+	for i := range list {
+		list[i].QValue = qvalues[i]
+		list[i].PEP = peps[i]
+	}
+
+	for i := range decoy {
+		if decoy[i] {
 			decoys++
 		} else {
 			targets++
 		}
 	}
 
-	sort.Sort(&list)
-
 	// from botttom to top, classify every protein block with a given fdr score
 	// the score is only calculates to the first (last) protein in each block
 	// proteins with the same score, get the same fdr value.
@@ -485,13 +622,12 @@ func ProtXMLFilter(p id.ProtXML, targetFDR, pepProb, protProb float64, isPicked,
 
 	for j := (len(list) - 1); j >= 0; j-- {
 
-		_, ok := FDRMap[list[j].TopPepProb]
+		_, ok := FDRMap[values[j]]
 		if !ok {
-			FDRMap[list[j].TopPepProb] = float64(decoys) / float64(targets)
-			//fmt.Println("probability:", list[j].TopPepProb, "targets:", targets, "decoys:", decoys, "Current FDR:", uti.Round(FDRMap[list[j].TopPepProb]*100, 5, 2))
+			FDRMap[values[j]] = float64(decoys) / float64(targets)
 		}
 
-		if cla.IsDecoyProtein(list[j], p.DecoyTag) {
+		if decoy[j] {
 			decoys--
 		} else {
 			targets--
@@ -509,18 +645,13 @@ func ProtXMLFilter(p id.ProtXML, targetFDR, pepProb, protProb float64, isPicked,
 	var currentFDR = 0.0
 	var probArray []float64
 	var probIndex int
-	var probList = make(map[float64]uint8)
 
 	for i := range topPepProb {
 
-		// for inspections
-		// fmt.Println(topPepProb[i], "\t", FDRMap[topPepProb[i]], "\t", uti.ToFixed(FDRMap[topPepProb[i]], 4), "\t", uti.Round(FDRMap[topPepProb[i]]*100, 5, 2))
-
 		probArray = append(probArray, topPepProb[i])
 
 		if FDRMap[topPepProb[i]] <= targetFDR {
 
-			probList[topPepProb[i]] = 0
 			minProb = topPepProb[i]
 			calcFDR = FDRMap[topPepProb[i]]
 
@@ -548,27 +679,22 @@ func ProtXMLFilter(p id.ProtXML, targetFDR, pepProb, protProb float64, isPicked,
 
 		if FDRMap[probArray[probIndex+1]] <= thresh {
 
-			probList[probArray[probIndex+1]] = 0
-
 			minProb = probArray[probIndex+1]
 			calcFDR = FDRMap[probArray[probIndex+1]]
 
 		}
 	}
 
-	// for inspections
-	// fmt.Println("index ", probIndex, probArray[probIndex], "minProb ", minProb, "calcFDR ", calcFDR)
-
 	var finalList id.ProtIDList
 	decoys = 0
 	targets = 0
 
 	for i := range list {
-		if list[i].TopPepProb >= currentTopPepProb {
+		if values[i] >= currentTopPepProb {
 
 			finalList = append(finalList, list[i])
 
-			if cla.IsDecoyProtein(list[i], p.DecoyTag) {
+			if decoy[i] {
 				decoys++
 			} else {
 				targets++
@@ -586,9 +712,20 @@ func ProtXMLFilter(p id.ProtXML, targetFDR, pepProb, protProb float64, isPicked,
 	return finalList
 }
 
+// proteinScore ranks a protein for the FDR, by its posterior when the proteins were scored by the
+// Bayesian protein model, or by its best peptide
+func proteinScore(p id.ProtXML, pro id.ProteinIdentification) float64 {
+
+	if p.Posteriors {
+		return pro.Probability
+	}
+
+	return pro.TopPepProb
+}
+
 // sequentialFDRControl estimates FDR levels by applying a second filter where all
 // proteins from the protein filtered list are matched against filtered PSMs
-func sequentialFDRControl(pep id.PepIDList, pro id.ProtIDList, psm, peptide, ion float64, decoyTag string) {
+func sequentialFDRControl(pep id.PepIDList, pro id.ProtIDList, psm, peptide, ion float64, decoyTag, score string, group, strata grouping) {
 
 	extPep := extractPSMfromPepXML("sequential", pep, pro)
 
@@ -607,11 +744,11 @@ func sequentialFDRControl(pep id.PepIDList, pro id.ProtIDList, psm, peptide, ion
 
 	wg := sync.WaitGroup{}
 	wg.Add(3)
-	filteredPSM, _ := PepXMLFDRFilter(uniqPsms, psm, "PSM", decoyTag, "")
+	filteredPSM, _ := groupFDRFilter(uniqPsms, psm, "PSM", decoyTag, "", score, combineGroupings(group, strata))
 	go func() { defer wg.Done(); filteredPSM.Serialize("psm") }()
-	filteredPeptides, _ := PepXMLFDRFilter(uniqPeps, peptide, "Peptide", decoyTag, "")
+	filteredPeptides, _ := groupFDRFilter(uniqPeps, peptide, "Peptide", decoyTag, "", score, group)
 	go func() { defer wg.Done(); filteredPeptides.Serialize("pep") }()
-	filteredIons, _ := PepXMLFDRFilter(uniqIons, ion, "Ion", decoyTag, "")
+	filteredIons, _ := groupFDRFilter(uniqIons, ion, "Ion", decoyTag, "", score, group)
 	go func() { defer wg.Done(); filteredIons.Serialize("ion") }()
 	wg.Wait()
 
@@ -619,7 +756,7 @@ func sequentialFDRControl(pep id.PepIDList, pro id.ProtIDList, psm, peptide, ion
 
 // twoDFDRFilter estimates FDR levels by applying a second filter by regenerating
 // a protein list with decoys from protXML and pepXML.
-func twoDFDRFilter(pep id.PepIDList, pro id.ProtIDList, psm, peptide, ion float64, decoyTag string) {
+func twoDFDRFilter(pep id.PepIDList, pro id.ProtIDList, psm, peptide, ion float64, decoyTag, score string, group, strata grouping) {
 
 	// filter protein list at given FDR level and regenerate protein list by adding pairing decoys
 	//logrus.Info("Creating mirror image from filtered protein list")
@@ -649,9 +786,9 @@ func twoDFDRFilter(pep id.PepIDList, pro id.ProtIDList, psm, peptide, ion float6
 		"ions":     len(uniqIons),
 	}).Info("Second filtering results")
 
-	filteredPSM, _ := PepXMLFDRFilter(uniqPsms, psm, "PSM", decoyTag, "")
-	filteredPeptides, _ := PepXMLFDRFilter(uniqPeps, peptide, "Peptide", decoyTag, "")
-	filteredIons, _ := PepXMLFDRFilter(uniqIons, ion, "Ion", decoyTag, "")
+	filteredPSM, _ := groupFDRFilter(uniqPsms, psm, "PSM", decoyTag, "", score, combineGroupings(group, strata))
+	filteredPeptides, _ := groupFDRFilter(uniqPeps, peptide, "Peptide", decoyTag, "", score, group)
+	filteredIons, _ := groupFDRFilter(uniqIons, ion, "Ion", decoyTag, "", score, group)
 
 	filteredPSM.Serialize("psm")
 	filteredPeptides.Serialize("pep")
@@ -1,5 +1,12 @@
 package fil
 
+import (
+	"fmt"
+	"testing"
+
+	"philosopher/lib/id"
+)
+
 // func TestPepXMLFDRFilter(t *testing.T) {
 
 // 	tes.SetupTestEnv()
@@ -113,3 +120,193 @@ package fil
 
 // 	//tes.ShutDowTestEnv()
 // }
+
+func TestScoreFDRFilter(t *testing.T) {
+
+	var psms = make(map[string]id.PepIDListPtrs)
+
+	add := func(name, protein string, expect float64) {
+		p := &id.PeptideIdentification{Spectrum: name, Peptide: name, Protein: protein, Expectation: expect}
+		psms[name] = append(psms[name], p)
+	}
+
+	for i := 1; i <= 20; i++ {
+		add(fmt.Sprintf("target%d", i), "P1", float64(i)*0.001)
+	}
+
+	add("decoy1", "rev_P1", 0.0105)
+	add("decoy2", "rev_P1", 0.0155)
+	add("decoy3", "rev_P1", 0.5)
+
+	got, threshold := ScoreFDRFilter(psms, 0.14, "PSM", "rev_", "expect")
+
+	if len(got) != 16 {
+		t.Errorf("got %d PSMs, want 15 targets and 1 decoy", len(got))
+	}
+
+	if threshold != -0.015 {
+		t.Errorf("got threshold %f, want -0.015", threshold)
+	}
+
+	// the q-values never decrease along the ranking, so the first target takes the lowest FDR
+	if q := psms["target1"][0].QValue; q != 0.1 {
+		t.Errorf("got q-value %f for the top target, want 0.1", q)
+	}
+
+	if q := psms["target20"][0].QValue; q != 0.15 {
+		t.Errorf("got q-value %f for the last target, want 0.15", q)
+	}
+
+	// a peptide is represented by its best PSM
+	peptides := map[string]id.PepIDListPtrs{
+		"PEPTIDE": {
+			&id.PeptideIdentification{Spectrum: "a", Peptide: "PEPTIDE", Protein: "P1", Hyperscore: 10},
+			&id.PeptideIdentification{Spectrum: "b", Peptide: "PEPTIDE", Protein: "P1", Hyperscore: 30},
+		},
+	}
+
+	got, _ = ScoreFDRFilter(peptides, 1, "Peptide", "rev_", "hyperscore")
+	if len(got) != 1 || got[0].Spectrum != "b" {
+		t.Errorf("got %v, want the PSM with the highest hyperscore", got)
+	}
+}
+
+func TestPepXMLFDRFilterConfidence(t *testing.T) {
+
+	var psms = make(map[string]id.PepIDListPtrs)
+	var peptides = make(map[string]id.PepIDListPtrs)
+
+	for i := 0; i < 20; i++ {
+
+		p := &id.PeptideIdentification{Spectrum: fmt.Sprintf("s%d", i), Peptide: fmt.Sprintf("PEP%d", i/2), Protein: "P1", Probability: 1 - float64(i)*0.01}
+		if i%5 == 4 {
+			p.Protein = "rev_P1"
+		}
+
+		psms[p.Spectrum] = append(psms[p.Spectrum], p)
+		peptides[p.Peptide] = append(peptides[p.Peptide], p)
+	}
+
+	PepXMLFDRFilter(psms, 0.01, "PSM", "rev_", "")
+
+	var qvalues = make(map[string]float64)
+	for k, v := range psms {
+		if v[0].QValue <= 0 || v[0].QValue > 1 || v[0].PEP < 0 || v[0].PEP > 1 {
+			t.Errorf("got q-value %f and PEP %f for %s", v[0].QValue, v[0].PEP, k)
+		}
+		qvalues[k] = v[0].QValue
+	}
+
+	// the q-values never decrease as the probabilities drop
+	if psms["s0"][0].QValue > psms["s19"][0].QValue {
+		t.Errorf("got q-values %f and %f, want them to grow as the probabilities drop", psms["s0"][0].QValue, psms["s19"][0].QValue)
+	}
+
+	got, _ := PepXMLFDRFilter(peptides, 1, "Peptide", "rev_", "")
+	if len(got) != 10 {
+		t.Errorf("got %d peptides, want 10", len(got))
+	}
+
+	// the peptide level works on copies, the PSMs keep their own q-values
+	for k, v := range psms {
+		if v[0].QValue != qvalues[k] {
+			t.Errorf("the q-value of %s changed from %f to %f after the peptide FDR", k, qvalues[k], v[0].QValue)
+		}
+	}
+}
+
+func TestPepXMLFDRFilterCut(t *testing.T) {
+
+	var psms = make(map[string]id.PepIDListPtrs)
+
+	add := func(name, protein string, probability float64) *id.PeptideIdentification {
+		p := &id.PeptideIdentification{Spectrum: name, Peptide: name, Protein: protein, Probability: probability}
+		psms[name] = append(psms[name], p)
+		return p
+	}
+
+	for i := 1; i <= 20; i++ {
+		add(fmt.Sprintf("target%d", i), "P1", 1-float64(i)*0.01)
+	}
+
+	add("decoy1", "rev_P1", 0.895)
+	add("decoy2", "rev_P1", 0.845)
+
+	// the cut keeps decoys / targets within the FDR, 0.1 at target 20, and the (decoys + 1) /
+	// targets q-values are reported alongside
+	got, threshold := PepXMLFDRFilter(psms, 0.1, "PSM", "rev_", "")
+
+	var targets int
+	for _, i := range got {
+		if i.Protein == "P1" {
+			targets++
+		}
+	}
+
+	if targets != 20 || threshold != psms["target20"][0].Probability {
+		t.Errorf("got %d target PSMs at probability %f, want 20 at %f", targets, threshold, psms["target20"][0].Probability)
+	}
+
+	if q := psms["target20"][0].QValue; q <= 0.1 {
+		t.Errorf("got q-value %f for target20, want the (decoys + 1) / targets estimate above 0.1", q)
+	}
+
+	// the rescored PSMs keep the q-value and PEP from Percolator or the rescore command, and are
+	// cut on that q-value
+	for _, v := range psms {
+		v[0].Rescored = true
+		v[0].QValue = 0.001
+		v[0].PEP = 0.002
+	}
+	psms["target1"][0].QValue = 0.5
+
+	got, _ = PepXMLFDRFilter(psms, 0.1, "PSM", "rev_", "")
+
+	if p := psms["target15"][0]; p.QValue != 0.001 || p.PEP != 0.002 {
+		t.Errorf("got q-value %f and PEP %f, want the rescored 0.001 and 0.002", p.QValue, p.PEP)
+	}
+
+	for _, i := range got {
+		if i.QValue > 0.1 {
+			t.Errorf("kept the rescored %s with q-value %f", i.Spectrum, i.QValue)
+		}
+	}
+
+	if len(got) != 21 {
+		t.Errorf("got %d rescored PSMs, want the 21 within the reported q-values", len(got))
+	}
+
+	// the peptides are estimated at their own level
+	got, _ = PepXMLFDRFilter(psms, 1, "Peptide", "rev_", "")
+	for _, i := range got {
+		if i.QValue == 0.001 {
+			t.Errorf("the peptide %s kept the PSM q-value", i.Peptide)
+		}
+	}
+}
+
+func TestProtXMLFilterPosteriors(t *testing.T) {
+
+	// the best peptides favour the decoy, the posteriors favour the targets
+	var p = id.ProtXML{DecoyTag: "rev_", Posteriors: true}
+	for i := 0; i < 10; i++ {
+		p.Groups = append(p.Groups, id.GroupIdentification{Proteins: id.ProtIDList{
+			{ProteinName: fmt.Sprintf("P%d", i), Probability: 0.99 - float64(i)*0.01, TopPepProb: 0.5, HasRazor: true},
+		}})
+	}
+	p.Groups = append(p.Groups, id.GroupIdentification{Proteins: id.ProtIDList{
+		{ProteinName: "rev_P0", Probability: 0.1, TopPepProb: 0.99, HasRazor: true},
+	}})
+
+	got := ProtXMLFilter(p, 0.05, 0, 0, false, true, "rev_")
+
+	if len(got) != 10 {
+		t.Fatalf("got %d proteins, want the 10 targets", len(got))
+	}
+
+	for _, i := range got {
+		if i.TopPepProb == i.Probability {
+			t.Errorf("the best peptide of %s was replaced by its posterior", i.ProteinName)
+		}
+	}
+}
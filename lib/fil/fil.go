@@ -10,10 +10,12 @@ import (
 	"sync"
 
 	"philosopher/lib/cla"
+	"philosopher/lib/dat"
 	"philosopher/lib/id"
 	"philosopher/lib/inf"
 	"philosopher/lib/met"
 	"philosopher/lib/mod"
+	"philosopher/lib/msg"
 	"philosopher/lib/raz"
 	"philosopher/lib/rep"
 	"philosopher/lib/sys"
@@ -28,6 +30,12 @@ func Run(f met.Data) met.Data {
 	var pep id.PepIDList
 	var pro id.ProtIDList
 
+	strategy, err := raz.ParseStrategy(f.Filter.RazorStrategy)
+	if err != nil {
+		msg.Custom(err, "error")
+	}
+	f.Filter.RazorStrategy = strategy
+
 	if len(f.Filter.RazorBin) > 0 {
 
 		f.Filter.Razor = true
@@ -58,20 +66,56 @@ func Run(f met.Data) met.Data {
 
 	logrus.Info("Processing peptide identification files")
 
+	if len(f.Filter.Score) > 0 {
+
+		f.Filter.Score = strings.ToLower(f.Filter.Score)
+
+		if !IsFDRScore(f.Filter.Score) {
+			msg.Custom(fmt.Errorf("unknown FDR score %s, use expect, hyperscore, xcorr or search_score", f.Filter.Score), "error")
+		}
+
+		logrus.Info("Ranking the identifications by ", f.Filter.Score, " for the PSM, ion and peptide FDR")
+	}
+
+	group := newGrouping(f.Filter.FDRGroup, f.Filter.SampleSheet)
+	strata := newStrata(f.Filter.Stratify)
+
 	// if no method is selected, force the 2D to be default
 	if len(f.Filter.Pox) > 0 && !f.Filter.TwoD && !f.Filter.Seq {
 		f.Filter.TwoD = true
 	}
 
-	pepid, searchEngine := id.ReadPepXMLInput(f.Filter.Pex, f.Filter.Tag, f.Temp, f.Filter.Model)
+	var pepid id.PepIDListPtrs
+	var searchEngine string
+
+	if f.Filter.Percolator {
+		pepid, searchEngine = id.ReadRescoredInput(sys.PercolatorBin(), "percolator")
+	} else if f.Filter.Rescore {
+		pepid, searchEngine = id.ReadRescoredInput(sys.RescoreBin(), "rescore")
+	} else {
+		pepid, searchEngine = id.ReadPepXMLInput(f.Filter.Pex, f.Filter.Tag, f.Temp, f.Filter.Model, f.Filter.SearchScore)
+	}
 
 	f.SearchEngine = searchEngine
 
-	psmT, pepT, ionT := processPeptideIdentifications(pepid, f.Filter.Tag, f.Filter.Mods, f.Filter.PsmFDR, f.Filter.PepFDR, f.Filter.IonFDR, f.Filter.Delta)
+	psmT, pepT, ionT := processPeptideIdentifications(pepid, f.Filter.Tag, f.Filter.Mods, f.Filter.PsmFDR, f.Filter.PepFDR, f.Filter.IonFDR, f.Filter.Delta, f.Filter.Score, group, strata)
 	_ = psmT
 	_ = pepT
 	_ = ionT
 
+	// the protein FDR is estimated for the genes
+	var genes map[string]string
+	if f.Filter.Gene {
+		logrus.Info("Estimating the protein FDR at the gene level")
+		genes = geneMap(f.Filter.Tag)
+	}
+
+	// the reviewed razor strategy needs the database annotations
+	var annotations map[string]raz.Protein
+	if f.Filter.RazorStrategy == raz.Reviewed {
+		annotations = razorAnnotations(f.Filter.Tag)
+	}
+
 	if _, err := os.Stat(sys.ProBin()); err == nil {
 
 		pro.Restore()
@@ -80,9 +124,15 @@ func Run(f met.Data) met.Data {
 
 		protXML := ReadProtXMLInput(f.Filter.Pox, f.Filter.Tag, f.Filter.Weight)
 
-		ProcessProteinIdentifications(protXML, f.Filter.PtFDR, f.Filter.PepFDR, f.Filter.ProtProb, f.Filter.Picked, f.Filter.Razor, false, f.Filter.Tag)
+		ProcessProteinIdentifications(protXML, f.Filter.PtFDR, f.Filter.PepFDR, f.Filter.ProtProb, f.Filter.Picked, f.Filter.Razor, false, f.Filter.Tag, f.Filter.RazorStrategy, annotations, genes)
 		pro.Restore()
 
+		if f.Filter.Razor {
+			var razorMap raz.RazorMap = make(map[string]raz.RazorCandidate)
+			razorMap.Restore(true)
+			razorMap.Report(f.Home)
+		}
+
 	} else {
 
 		if f.Filter.Inference {
@@ -90,14 +140,25 @@ func Run(f met.Data) met.Data {
 			var filteredPSM id.PepIDList
 			filteredPSM.Restore("psm")
 
-			pepid, razorMap, coverMap := inf.ProteinInference(filteredPSM)
+			pepid, razorMap, coverMap, groups := inf.ProteinInference(filteredPSM, f.Filter.RazorStrategy, f.Filter.Tag)
 			filteredPSM = nil
 
+			razorMap.Report(f.Home)
+
+			var posteriors map[string]float64
+			if f.Filter.Fido {
+				posteriors = inf.Fido(pepid, f.Filter.Tag)
+			}
+
+			// the peptides and ions keep the q-values estimated at their own level
+			peptides := levelIdentifications(pepid, "pep", func(i id.PeptideIdentification) string { return i.Peptide })
+			ions := levelIdentifications(pepid, "ion", ionKey)
+
 			pepid.Serialize("psm")
-			pepid.Serialize("pep")
-			pepid.Serialize("ion")
+			peptides.Serialize("pep")
+			ions.Serialize("ion")
 
-			processProteinInferenceIdentifications(pepid, razorMap, coverMap, f.Filter.PtFDR, f.Filter.PepFDR, f.Filter.ProtProb, f.Filter.Picked, f.Filter.Tag)
+			processProteinInferenceIdentifications(pepid, razorMap, coverMap, groups, posteriors, f.Filter.PtFDR, f.Filter.PepFDR, f.Filter.ProtProb, f.Filter.Picked, f.Filter.Tag, genes)
 		}
 	}
 	var pepxml id.PepXML
@@ -111,14 +172,14 @@ func Run(f met.Data) met.Data {
 		// sequential analysis
 		// filtered psm list and filtered prot list
 		pep.Restore("psm")
-		sequentialFDRControl(pep, pro, f.Filter.PsmFDR, f.Filter.PepFDR, f.Filter.IonFDR, f.Filter.Tag)
+		sequentialFDRControl(pep, pro, f.Filter.PsmFDR, f.Filter.PepFDR, f.Filter.IonFDR, f.Filter.Tag, f.Filter.Score, group, strata)
 		pep = nil
 
 	} else if f.Filter.TwoD {
 
 		// two-dimensional analysis
 		// complete pep list and filtered mirror-image prot list
-		twoDFDRFilter(pepxml.PeptideIdentification, pro, f.Filter.PsmFDR, f.Filter.PepFDR, f.Filter.IonFDR, f.Filter.Tag)
+		twoDFDRFilter(pepxml.PeptideIdentification, pro, f.Filter.PsmFDR, f.Filter.PepFDR, f.Filter.IonFDR, f.Filter.Tag, f.Filter.Score, group, strata)
 
 	}
 
@@ -176,11 +237,26 @@ func Run(f met.Data) met.Data {
 		e.UpdateNumberOfEnzymaticTermini(f.Filter.Tag)
 
 		e.CalculateProteinCoverage()
+
+		if f.Filter.Gene {
+			logrus.Info("Assembling the gene report")
+			e.AssembleGeneReport(f.Filter.Tag)
+		}
 	}
 
 	e = e.SyncPSMToPeptides(f.Filter.Tag)
 	e = e.SyncPSMToPeptideIons(f.Filter.Tag)
 
+	// the entrapment tag comes from the database command when it added the entrapment sequences
+	if len(f.Filter.Entrapment) == 0 && len(f.Database.Entrapment) > 0 {
+		f.Filter.Entrapment = f.Database.EntrapmentTag
+	}
+
+	if len(f.Filter.Entrapment) > 0 {
+		logrus.Info("Evaluating the FDR with the entrapment identifications")
+		reportEntrapment(e, f.Home, f.Filter.Entrapment, f.Filter.Tag, f.Filter.PsmFDR, f.Filter.PepFDR, f.Filter.PtFDR)
+	}
+
 	var countPSM, countPep, countIon, coutProtein int
 	for _, i := range e.PSM {
 		if !i.IsDecoy {
@@ -219,7 +295,7 @@ func Run(f met.Data) met.Data {
 }
 
 // processPeptideIdentifications reads and process pepXML
-func processPeptideIdentifications(p id.PepIDListPtrs, decoyTag, mods string, psm, peptide, ion float64, delta bool) (float64, float64, float64) {
+func processPeptideIdentifications(p id.PepIDListPtrs, decoyTag, mods string, psm, peptide, ion float64, delta bool, score string, group, strata grouping) (float64, float64, float64) {
 
 	// report charge profile
 	var t, d int
@@ -270,31 +346,34 @@ func processPeptideIdentifications(p id.PepIDListPtrs, decoyTag, mods string, ps
 		"ions":     len(uniqIons),
 	}).Info("Database search results")
 
-	filteredPSM, psmThreshold := PepXMLFDRFilter(uniqPsms, psm, "PSM", decoyTag, "")
+	// the strata only split the PSMs, the peptides and ions carry many charges and modifications
+	psmGroup := combineGroupings(group, strata)
+
+	filteredPSM, psmThreshold := groupFDRFilter(uniqPsms, psm, "PSM", decoyTag, "", score, psmGroup)
 	wg := sync.WaitGroup{}
 	wg.Add(3)
 	go func() { defer wg.Done(); filteredPSM.Serialize("psm") }()
 
-	filteredPeptides, peptideThreshold := PepXMLFDRFilter(uniqPeps, peptide, "Peptide", decoyTag, "")
+	filteredPeptides, peptideThreshold := groupFDRFilter(uniqPeps, peptide, "Peptide", decoyTag, "", score, group)
 	go func() { defer wg.Done(); filteredPeptides.Serialize("pep") }()
 
-	filteredIons, ionThreshold := PepXMLFDRFilter(uniqIons, ion, "Ion", decoyTag, "")
+	filteredIons, ionThreshold := groupFDRFilter(uniqIons, ion, "Ion", decoyTag, "", score, group)
 	go func() { defer wg.Done(); filteredIons.Serialize("ion") }()
 	wg.Wait()
 
 	// sug-group FDR filtering
 	if len(mods) > 0 {
-		ptmBasedPSMFiltering(uniqPsms, psm, decoyTag, mods)
+		ptmBasedPSMFiltering(uniqPsms, psm, decoyTag, mods, score, psmGroup)
 	}
 
 	if delta {
-		deltaMassBasedPSMFiltering(uniqPsms, psm, decoyTag)
+		deltaMassBasedPSMFiltering(uniqPsms, psm, decoyTag, score, psmGroup)
 	}
 
 	return psmThreshold, peptideThreshold, ionThreshold
 }
 
-func deltaMassBasedPSMFiltering(uniqPsms map[string]id.PepIDListPtrs, targetFDR float64, decoyTag string) {
+func deltaMassBasedPSMFiltering(uniqPsms map[string]id.PepIDListPtrs, targetFDR float64, decoyTag, score string, group grouping) {
 
 	logrus.Info("Separating PSMs based on the delta mass profile")
 
@@ -328,13 +407,13 @@ func deltaMassBasedPSMFiltering(uniqPsms map[string]id.PepIDListPtrs, targetFDR
 	}
 
 	logrus.Info("Filtering unmodified PSMs")
-	filteredUnmodPSM, _ := PepXMLFDRFilter(unModPSMs, targetFDR, "PSM", decoyTag, "")
+	filteredUnmodPSM, _ := groupFDRFilter(unModPSMs, targetFDR, "PSM", decoyTag, "", score, group)
 
 	logrus.Info("Filtering commonly modified PSMs")
-	filteredDefinedPSM, _ := PepXMLFDRFilter(commonModPSMs, targetFDR, "PSM", decoyTag, "")
+	filteredDefinedPSM, _ := groupFDRFilter(commonModPSMs, targetFDR, "PSM", decoyTag, "", score, group)
 
 	logrus.Info("Filtering glyco-modified PSMs")
-	filteredAllPSM, _ := PepXMLFDRFilter(glycoModPSMs, targetFDR, "PSM", decoyTag, "")
+	filteredAllPSM, _ := groupFDRFilter(glycoModPSMs, targetFDR, "PSM", decoyTag, "", score, group)
 
 	var combinedFiltered id.PepIDListPtrs
 
@@ -348,7 +427,7 @@ func deltaMassBasedPSMFiltering(uniqPsms map[string]id.PepIDListPtrs, targetFDR
 
 }
 
-func ptmBasedPSMFiltering(uniqPsms map[string]id.PepIDListPtrs, targetFDR float64, decoyTag, mods string) {
+func ptmBasedPSMFiltering(uniqPsms map[string]id.PepIDListPtrs, targetFDR float64, decoyTag, mods, score string, group grouping) {
 
 	logrus.Info("Separating PSMs based on the modification profile")
 
@@ -407,13 +486,13 @@ func ptmBasedPSMFiltering(uniqPsms map[string]id.PepIDListPtrs, targetFDR float6
 	}
 
 	logrus.Info("Filtering unmodified PSMs")
-	filteredUnmodPSM, _ := PepXMLFDRFilter(unModPSMs, targetFDR, "PSM", decoyTag, "")
+	filteredUnmodPSM, _ := groupFDRFilter(unModPSMs, targetFDR, "PSM", decoyTag, "", score, group)
 
 	logrus.Info("Filtering defined modified PSMs")
-	filteredDefinedPSM, _ := PepXMLFDRFilter(definedModPSMs, targetFDR, "PSM", decoyTag, "")
+	filteredDefinedPSM, _ := groupFDRFilter(definedModPSMs, targetFDR, "PSM", decoyTag, "", score, group)
 
 	logrus.Info("Filtering all other PSMs")
-	filteredAllPSM, _ := PepXMLFDRFilter(restModPSMs, targetFDR, "PSM", decoyTag, "X")
+	filteredAllPSM, _ := groupFDRFilter(restModPSMs, targetFDR, "PSM", decoyTag, "X", score, group)
 
 	var combinedFiltered id.PepIDListPtrs
 
@@ -467,7 +546,7 @@ func ExtractIonsFromPSMs(p id.PepIDListPtrs) map[string]id.PepIDListPtrs {
 	uniqMap := make(map[string]id.PepIDListPtrs)
 
 	for _, i := range p {
-		ion := fmt.Sprintf("%s#%d#%.4f", i.Peptide, i.AssumedCharge, i.CalcNeutralPepMass)
+		ion := ionKey(*i)
 		uniqMap[ion] = append(uniqMap[ion], i)
 	}
 
@@ -479,6 +558,47 @@ func ExtractIonsFromPSMs(p id.PepIDListPtrs) map[string]id.PepIDListPtrs {
 	return uniqMap
 }
 
+// ionKey identifies a peptide ion by sequence, charge and mass
+func ionKey(i id.PeptideIdentification) string {
+	return fmt.Sprintf("%s#%d#%.4f", i.Peptide, i.AssumedCharge, i.CalcNeutralPepMass)
+}
+
+// levelIdentifications represents each peptide or ion that passed its own FDR filter by its best
+// PSM after the protein inference, with the q-value and PEP estimated at that level
+func levelIdentifications(psms id.PepIDList, level string, key func(id.PeptideIdentification) string) id.PepIDList {
+
+	var filtered id.PepIDList
+	filtered.Restore(level)
+
+	var confidence = make(map[string]id.PeptideIdentification)
+	for _, i := range filtered {
+		confidence[key(i)] = i
+	}
+
+	var best = make(map[string]int)
+	var list id.PepIDList
+
+	for _, i := range psms {
+
+		c, ok := confidence[key(i)]
+		if !ok {
+			continue
+		}
+
+		i.QValue = c.QValue
+		i.PEP = c.PEP
+
+		if j, ok := best[key(i)]; !ok {
+			best[key(i)] = len(list)
+			list = append(list, i)
+		} else if i.Probability > list[j].Probability {
+			list[j] = i
+		}
+	}
+
+	return list
+}
+
 // GetUniquePeptides selects only unique pepetide for the given data structure
 func GetUniquePeptides(p id.PepIDListPtrs) map[string]id.PepIDListPtrs {
 
@@ -516,7 +636,7 @@ func ReadProtXMLInput(xmlFile, decoyTag string, weight float64) id.ProtXML {
 
 // ProcessProteinIdentifications checks if pickedFDR ar razor options should be applied to given data set, if they do,
 // the inputed protXML data is processed before filtered.
-func ProcessProteinIdentifications(p id.ProtXML, ptFDR, pepProb, protProb float64, isPicked, isRazor, isCombined bool, decoyTag string) string {
+func ProcessProteinIdentifications(p id.ProtXML, ptFDR, pepProb, protProb float64, isPicked, isRazor, isCombined bool, decoyTag, razorStrategy string, annotations map[string]raz.Protein, genes map[string]string) string {
 
 	var pid id.ProtIDList
 
@@ -534,11 +654,15 @@ func ProcessProteinIdentifications(p id.ProtXML, ptFDR, pepProb, protProb float6
 
 	// applies razor algorithm
 	if isRazor {
-		p = RazorFilter(p)
+		p = RazorFilter(p, razorStrategy, annotations)
 	}
 
-	// run the FDR filter for proteins
-	pid = ProtXMLFilter(p, ptFDR, pepProb, protProb, isPicked, isRazor, decoyTag)
+	// run the FDR filter for proteins, or for their genes
+	if genes != nil {
+		pid = PickedGeneFDR(p, ptFDR, pepProb, protProb, isRazor, decoyTag, genes)
+	} else {
+		pid = ProtXMLFilter(p, ptFDR, pepProb, protProb, isPicked, isRazor, decoyTag)
+	}
 
 	// save results on meta folder
 	if isCombined {
@@ -553,24 +677,16 @@ func ProcessProteinIdentifications(p id.ProtXML, ptFDR, pepProb, protProb float6
 
 // processProteinInferenceIdentifications checks if pickedFDR ar razor options should be applied to given data set, if they do,
 // the inputed Philosopher inference data is processed before filtered.
-func processProteinInferenceIdentifications(psm id.PepIDList, razorMap map[string]string, coverMap map[string]float64, ptFDR, pepProb, protProb float64, isPicked bool, decoyTag string) {
+func processProteinInferenceIdentifications(psm id.PepIDList, razorMap raz.RazorMap, coverMap map[string]float64, groups map[string]inf.ProteinGroup, posteriors map[string]float64, ptFDR, pepProb, protProb float64, isPicked bool, decoyTag string, genes map[string]string) {
 
 	var t int
 	var d int
 	var proXML id.ProtXML
-	var proGrps id.GroupList
 	var proteinList = make(map[string]id.ProteinIdentification)
 
 	// build the ProtXML strct
-	grpID := id.GroupIdentification{
-		GroupNumber: 0,
-		Probability: 1.00,
-	}
-
-	proGrps = append(proGrps, grpID)
-
 	proXML.DecoyTag = decoyTag
-	proXML.Groups = proGrps
+	proXML.Posteriors = posteriors != nil
 
 	for _, i := range psm {
 		_, ok := proteinList[i.Protein]
@@ -601,9 +717,9 @@ func processProteinInferenceIdentifications(psm id.PepIDList, razorMap map[strin
 	for _, i := range psm {
 
 		pro := proteinList[i.Protein]
-		razorProtein, ok := razorMap[i.Peptide]
+		razor, ok := razorMap[i.Peptide]
 
-		if ok && pro.ProteinName == razorProtein {
+		if ok && pro.ProteinName == razor.MappedProtein {
 
 			pro.Length = 0
 			pro.PercentCoverage = float32(coverMap[pro.ProteinName])
@@ -640,9 +756,9 @@ func processProteinInferenceIdentifications(psm id.PepIDList, razorMap map[strin
 	for _, i := range psm {
 
 		pro := proteinList[i.Protein]
-		razorProtein, ok := razorMap[i.Peptide]
+		razor, ok := razorMap[i.Peptide]
 
-		if ok && pro.ProteinName == razorProtein {
+		if ok && pro.ProteinName == razor.MappedProtein {
 
 			pro.UniqueStrippedPeptides = append(pro.UniqueStrippedPeptides, i.Peptide)
 			pro.TotalNumberPeptides++
@@ -659,8 +775,8 @@ func processProteinInferenceIdentifications(psm id.PepIDList, razorMap map[strin
 
 			for j := range i.AlternativeProteins {
 				pep.PeptideParentProtein = append(pep.PeptideParentProtein, j)
-				pro.IndistinguishableProtein = append(pro.IndistinguishableProtein, j)
 			}
+			sort.Strings(pep.PeptideParentProtein)
 
 			if i.Probability > pep.InitialProbability {
 				pep.InitialProbability = i.Probability
@@ -719,9 +835,42 @@ func processProteinInferenceIdentifications(psm id.PepIDList, razorMap map[strin
 		}
 	}
 
-	for _, i := range proteinList {
-		proXML.Groups[0].Proteins = append(proXML.Groups[0].Proteins, i)
+	// place the proteins in their parsimony groups
+	var groupIndex = make(map[uint32]int)
+
+	var names []string
+	for k := range proteinList {
+		names = append(names, k)
 	}
+	sort.Strings(names)
+
+	for _, k := range names {
+
+		i := proteinList[k]
+
+		// the protein FDR ranks the proteins by their posteriors instead of their best peptides
+		if v, ok := posteriors[k]; ok {
+			i.Probability = v
+		}
+
+		if g, ok := groups[k]; ok {
+			i.GroupNumber = g.Number
+			i.GroupSiblingID = g.Sibling
+			i.SubsumingProtein = g.Subsuming
+			i.IndistinguishableProtein = g.Indistinguishable
+		}
+
+		j, ok := groupIndex[i.GroupNumber]
+		if !ok {
+			j = len(proXML.Groups)
+			groupIndex[i.GroupNumber] = j
+			proXML.Groups = append(proXML.Groups, id.GroupIdentification{GroupNumber: i.GroupNumber, Probability: 1.00})
+		}
+
+		proXML.Groups[j].Proteins = append(proXML.Groups[j].Proteins, i)
+	}
+
+	sort.Slice(proXML.Groups, func(i, j int) bool { return proXML.Groups[i].GroupNumber < proXML.Groups[j].GroupNumber })
 
 	// tagget / decoy / threshold
 	logrus.WithFields(logrus.Fields{
@@ -729,14 +878,45 @@ func processProteinInferenceIdentifications(psm id.PepIDList, razorMap map[strin
 		"decoy":  d,
 	}).Info("Protein inference results")
 
-	// run the FDR filter for proteins
-	pid := ProtXMLFilter(proXML, ptFDR, pepProb, protProb, false, true, decoyTag)
+	// run the FDR filter for proteins, or for their genes
+	var pid id.ProtIDList
+	if genes != nil {
+		pid = PickedGeneFDR(proXML, ptFDR, pepProb, protProb, true, decoyTag, genes)
+	} else {
+		pid = ProtXMLFilter(proXML, ptFDR, pepProb, protProb, false, true, decoyTag)
+	}
 
 	// save results on meta folder
 	pid.Serialize()
 
 }
 
+// geneMap maps the database proteins to their genes
+func geneMap(decoyTag string) map[string]string {
+
+	var db dat.Base
+	db.Restore()
+
+	if len(db.Records) < 1 {
+		msg.DatabaseNotFound(errors.New(""), "error")
+	}
+
+	return db.GeneMap(decoyTag)
+}
+
+// razorAnnotations collects the database annotations of the proteins for the razor strategies
+func razorAnnotations(decoyTag string) map[string]raz.Protein {
+
+	var db dat.Base
+	db.Restore()
+
+	if len(db.Records) < 1 {
+		msg.DatabaseNotFound(errors.New(""), "error")
+	}
+
+	return raz.Annotate(db.Records, decoyTag)
+}
+
 // proteinProfile ...
 func proteinProfile(p id.ProtXML) (t, d int) {
 
@@ -2,6 +2,7 @@ package fil
 
 import (
 	"philosopher/lib/id"
+	"philosopher/lib/raz"
 	"philosopher/lib/sys"
 	"philosopher/lib/tes"
 	"philosopher/lib/uti"
@@ -39,7 +40,7 @@ func Test_readPepXMLInput(t *testing.T) {
 
 		t.Run(tt.name, func(t *testing.T) {
 
-			got, got1 := id.ReadPepXMLInput(tt.args.xmlFile, tt.args.decoyTag, tt.args.temp, tt.args.models)
+			got, got1 := id.ReadPepXMLInput(tt.args.xmlFile, tt.args.decoyTag, tt.args.temp, tt.args.models, "")
 			pepIDList = got
 
 			if !reflect.DeepEqual(len(got), tt.want) {
@@ -117,7 +118,7 @@ func Test_readPepXMLInput(t *testing.T) {
 	for _, tt := range test2 {
 
 		t.Run(tt.name, func(t *testing.T) {
-			got, got1, got2 := processPeptideIdentifications(pepIDList, tt.args.decoyTag, "", tt.args.psm, tt.args.peptide, tt.args.ion, false)
+			got, got1, got2 := processPeptideIdentifications(pepIDList, tt.args.decoyTag, "", tt.args.psm, tt.args.peptide, tt.args.ion, false, "", nil, nil)
 			if got != tt.want {
 				t.Errorf("processPeptideIdentifications(psm) got = %v, want %v", got, tt.want)
 			}
@@ -337,7 +338,7 @@ func Test_readProtXMLInput(t *testing.T) {
 	}
 	for _, tt := range test3 {
 		t.Run(tt.name, func(t *testing.T) {
-			ProcessProteinIdentifications(proXML, tt.args.ptFDR, tt.args.pepProb, tt.args.protProb, tt.args.isPicked, tt.args.isRazor, false, tt.args.decoyTag)
+			ProcessProteinIdentifications(proXML, tt.args.ptFDR, tt.args.pepProb, tt.args.protProb, tt.args.isPicked, tt.args.isRazor, false, tt.args.decoyTag, raz.Default, nil, nil)
 		})
 	}
 }
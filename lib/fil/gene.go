@@ -0,0 +1,156 @@
+package fil
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"philosopher/lib/cla"
+	"philosopher/lib/dat"
+	"philosopher/lib/id"
+	"philosopher/lib/msg"
+	"philosopher/lib/rsc"
+
+	"github.com/sirupsen/logrus"
+)
+
+// geneScore is the best protein score of a gene
+type geneScore struct {
+	Name  string
+	Score float64
+	Decoy bool
+}
+
+// PickedGeneFDR collapses the proteins to their genes and filters them with the picked
+// target-decoy competition at the gene level. Each gene is scored by its best protein, only the
+// best of a target gene and its decoy is kept, and the proteins of the accepted genes are returned
+func PickedGeneFDR(p id.ProtXML, targetFDR, pepProb, protProb float64, isRazor bool, decoyTag string, genes map[string]string) id.ProtIDList {
+
+	var list id.ProtIDList
+	for i := range p.Groups {
+		for j := range p.Groups[i].Proteins {
+
+			pro := p.Groups[i].Proteins[j]
+
+			if isRazor {
+				if pro.HasRazor {
+					list = append(list, pro)
+				}
+			} else if pro.TopPepProb >= pepProb && pro.TopPepProb >= protProb {
+				list = append(list, pro)
+			}
+		}
+	}
+
+	var scores = make(map[string]*geneScore)
+	var proteinGene = make([]string, len(list))
+
+	for i, j := range list {
+
+		gene, ok := genes[j.ProteinName]
+		if !ok {
+			gene = dat.GeneName("", j.ProteinName, decoyTag)
+		}
+		proteinGene[i] = gene
+
+		s, ok := scores[gene]
+		if !ok {
+			s = &geneScore{Name: gene, Decoy: cla.IsDecoyProtein(j, decoyTag)}
+			scores[gene] = s
+		}
+
+		if v := proteinScore(p, j); v > s.Score {
+			s.Score = v
+		}
+	}
+
+	picked := pickGenes(scores, decoyTag)
+
+	var values = make([]float64, len(picked))
+	var decoy = make([]bool, len(picked))
+	for i, j := range picked {
+		values[i] = j.Score
+		decoy[i] = j.Decoy
+	}
+
+	qvalues := rsc.QValues(values, decoy)
+	peps := rsc.PEPs(values, decoy)
+
+	type confidence struct {
+		QValue float64
+		PEP    float64
+	}
+
+	var accepted = make(map[string]confidence)
+	var targets, decoys int
+	var calcFDR float64
+
+	for i, j := range picked {
+		if qvalues[i] <= targetFDR {
+			accepted[j.Name] = confidence{qvalues[i], peps[i]}
+			calcFDR = math.Max(calcFDR, qvalues[i])
+			if j.Decoy {
+				decoys++
+			} else {
+				targets++
+			}
+		}
+	}
+
+	if targets == 0 {
+		msg.Custom(errors.New("the gene FDR filter didn't reach the desired threshold, try a higher threshold using the --prot parameter"), "warning")
+	}
+
+	var finalList id.ProtIDList
+	for i := range list {
+		if c, ok := accepted[proteinGene[i]]; ok {
+			list[i].QValue = c.QValue
+			list[i].PEP = c.PEP
+			finalList = append(finalList, list[i])
+		}
+	}
+
+	sort.Sort(&finalList)
+
+	logrus.WithFields(logrus.Fields{
+		"decoy":    decoys,
+		"total":    targets + decoys,
+		"proteins": len(finalList),
+	}).Info(fmt.Sprintf("Converged to %.2f %% FDR with %d genes", calcFDR*100, targets))
+
+	return finalList
+}
+
+// pickGenes keeps the best of each target and decoy gene pair, both are kept when they tie. The
+// picked genes are sorted from the best to the worst score
+func pickGenes(scores map[string]*geneScore, decoyTag string) []geneScore {
+
+	var picked []geneScore
+
+	for k, v := range scores {
+
+		var rival *geneScore
+		if v.Decoy {
+			rival = scores[strings.TrimPrefix(k, decoyTag)]
+		} else {
+			rival = scores[decoyTag+k]
+		}
+
+		if rival != nil && rival.Decoy != v.Decoy && rival.Score > v.Score {
+			continue
+		}
+
+		picked = append(picked, *v)
+	}
+
+	sort.Slice(picked, func(i, j int) bool {
+		if picked[i].Score != picked[j].Score {
+			return picked[i].Score > picked[j].Score
+		}
+		return picked[i].Name < picked[j].Name
+	})
+
+	return picked
+}
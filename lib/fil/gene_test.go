@@ -0,0 +1,71 @@
+package fil
+
+import (
+	"fmt"
+	"testing"
+
+	"philosopher/lib/id"
+)
+
+func TestPickedGeneFDR(t *testing.T) {
+
+	var p id.ProtXML
+	p.DecoyTag = "rev_"
+	p.Groups = append(p.Groups, id.GroupIdentification{})
+
+	var genes = make(map[string]string)
+
+	// 20 target genes with three isoforms each, and a decoy for the 5 worst genes
+	for i := 0; i < 20; i++ {
+
+		gene := fmt.Sprintf("GENE%02d", i)
+
+		for j := 0; j < 3; j++ {
+			name := fmt.Sprintf("sp|P%02d-%d|%s", i, j, gene)
+			genes[name] = gene
+			p.Groups[0].Proteins = append(p.Groups[0].Proteins, id.ProteinIdentification{ProteinName: name, TopPepProb: 0.99 - 0.01*float64(i) - 0.001*float64(j)})
+		}
+
+		if i >= 15 {
+			name := fmt.Sprintf("rev_sp|P%02d-0|%s", i, gene)
+			genes[name] = "rev_" + gene
+			p.Groups[0].Proteins = append(p.Groups[0].Proteins, id.ProteinIdentification{ProteinName: name, TopPepProb: 0.995})
+		}
+	}
+
+	// an unpaired decoy that scores above every target, the isoforms must not inflate the targets
+	p.Groups[0].Proteins = append(p.Groups[0].Proteins, id.ProteinIdentification{ProteinName: "rev_sp|Q99|OTHER", TopPepProb: 0.999})
+	genes["rev_sp|Q99|OTHER"] = "rev_OTHER"
+
+	list := PickedGeneFDR(p, 0.5, 0, 0, false, "rev_", genes)
+
+	var accepted = make(map[string]bool)
+	for _, i := range list {
+		accepted[genes[i.ProteinName]] = true
+		if i.QValue > 0.5 {
+			t.Errorf("got q-value %f for %s", i.QValue, i.ProteinName)
+		}
+	}
+
+	// the 5 worst genes lose to their decoys, so 15 targets remain against 6 decoys
+	for i := 0; i < 20; i++ {
+		gene := fmt.Sprintf("GENE%02d", i)
+		if i < 15 && !accepted[gene] {
+			t.Errorf("%s was not accepted", gene)
+		}
+		if i >= 15 && accepted[gene] {
+			t.Errorf("%s was accepted, want its decoy picked instead", gene)
+		}
+	}
+
+	var isoforms int
+	for _, i := range list {
+		if genes[i.ProteinName] == "GENE00" {
+			isoforms++
+		}
+	}
+
+	if isoforms != 3 {
+		t.Errorf("got %d proteins for GENE00, want its 3 isoforms", isoforms)
+	}
+}
@@ -0,0 +1,345 @@
+package fil
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"philosopher/lib/cla"
+	"philosopher/lib/id"
+	"philosopher/lib/msg"
+
+	"github.com/sirupsen/logrus"
+)
+
+// grouping returns the group of a PSM for the FDR estimation, like its run or its experiment
+type grouping func(p *id.PeptideIdentification) string
+
+// newGrouping creates the FDR grouping, by run or by the experiment each run belongs to in the
+// sample sheet. The sample sheet runs given with a directory are matched on the full path of the
+// spectra file, the others on the run name. The FDR is global when no mode is given
+func newGrouping(mode, sheet string) grouping {
+
+	switch strings.ToLower(mode) {
+	case "":
+		return nil
+	case "run":
+		logrus.Info("Estimating the PSM, ion and peptide FDR for each run")
+		return func(p *id.PeptideIdentification) string { return runName(p.Spectrum) }
+	case "experiment":
+		if len(sheet) == 0 {
+			msg.Custom(errors.New("the experiment FDR needs a sample sheet assigning each run to an experiment"), "error")
+			return nil
+		}
+
+		experiments := readSampleSheet(sheet)
+		logrus.Info("Estimating the PSM, ion and peptide FDR for each experiment")
+
+		var missing = make(map[string]struct{})
+		return func(p *id.PeptideIdentification) string {
+			run := runName(p.Spectrum)
+			if v, ok := experiments[runPath(p.SpectraFile)]; ok && len(p.SpectraFile) > 0 {
+				return v
+			} else if v, ok := experiments[run]; ok {
+				return v
+			}
+			if _, ok := missing[run]; !ok {
+				missing[run] = struct{}{}
+				logrus.Warn("the run ", run, " is not on the sample sheet, it is kept as its own experiment")
+			}
+			return run
+		}
+	}
+
+	msg.Custom(fmt.Errorf("unknown FDR group %s, use run or experiment", mode), "error")
+
+	return nil
+}
+
+// strata are the PSM properties that can split the FDR estimation
+var strata = map[string]func(p *id.PeptideIdentification) string{
+	"charge": func(p *id.PeptideIdentification) string {
+		if p.AssumedCharge >= 4 {
+			return "charge 4+"
+		}
+		return fmt.Sprintf("charge %d", p.AssumedCharge)
+	},
+	"ntt": func(p *id.PeptideIdentification) string {
+		return fmt.Sprintf("%d enzymatic termini", p.NumberOfEnzymaticTermini)
+	},
+	"nmc": func(p *id.PeptideIdentification) string {
+		if p.NumberofMissedCleavages >= 2 {
+			return "2+ missed cleavages"
+		}
+		return fmt.Sprintf("%d missed cleavages", p.NumberofMissedCleavages)
+	},
+	"mods": func(p *id.PeptideIdentification) string {
+		for _, i := range p.Modifications.IndexSlice {
+			if i.Variable {
+				return "modified"
+			}
+		}
+		return "unmodified"
+	},
+	"massdiff": func(p *id.PeptideIdentification) string {
+		if d := math.Abs(p.Massdiff); d > 145 {
+			return "mass offset above 145"
+		} else if d >= 3.5 {
+			return "mass offset 3.5 to 145"
+		}
+		return "mass offset below 3.5"
+	},
+	"cv": func(p *id.PeptideIdentification) string {
+		return "CV " + p.CompensationVoltage
+	},
+	"length": func(p *id.PeptideIdentification) string {
+		if len(p.Peptide) < 10 {
+			return "length below 10"
+		} else if len(p.Peptide) < 20 {
+			return "length 10 to 19"
+		}
+		return "length 20+"
+	},
+}
+
+// newStrata creates the grouping for the stratified FDR from a comma-separated list of PSM
+// properties, like charge,ntt
+func newStrata(list string) grouping {
+
+	var names []string
+	var groups []grouping
+
+	for _, i := range strings.Split(list, ",") {
+
+		i = strings.ToLower(strings.TrimSpace(i))
+		if len(i) == 0 {
+			continue
+		}
+
+		s, ok := strata[i]
+		if !ok {
+			msg.Custom(fmt.Errorf("unknown stratum %s, use charge, ntt, nmc, mods, massdiff, cv or length", i), "error")
+			continue
+		}
+
+		names = append(names, i)
+		groups = append(groups, s)
+	}
+
+	if len(groups) == 0 {
+		return nil
+	}
+
+	logrus.Info("Estimating the PSM FDR for each stratum of ", strings.Join(names, ", "))
+
+	return combineGroupings(groups...)
+}
+
+// combineGroupings joins the groupings, the FDR is estimated inside each combination of groups
+func combineGroupings(groups ...grouping) grouping {
+
+	var list []grouping
+	for _, i := range groups {
+		if i != nil {
+			list = append(list, i)
+		}
+	}
+
+	if len(list) == 0 {
+		return nil
+	} else if len(list) == 1 {
+		return list[0]
+	}
+
+	return func(p *id.PeptideIdentification) string {
+		var names = make([]string, len(list))
+		for i, j := range list {
+			names[i] = j(p)
+		}
+		return strings.Join(names, ", ")
+	}
+}
+
+// runName takes the run from the spectrum name, like run.00100.00100.2
+func runName(spectrum string) string {
+
+	parts := strings.Split(spectrum, ".")
+	if len(parts) > 3 {
+		return strings.Join(parts[:len(parts)-3], ".")
+	}
+
+	return parts[0]
+}
+
+// runPath removes the extension of a spectra file, and makes the paths with a directory absolute so
+// the sample sheet matches the spectra files of the identifications
+func runPath(f string) string {
+
+	f = strings.TrimSpace(f)
+	for _, i := range []string{".mzML", ".mzXML", ".mgf", ".raw", ".d"} {
+		if strings.HasSuffix(strings.ToLower(f), strings.ToLower(i)) {
+			f = f[:len(f)-len(i)]
+		}
+	}
+
+	if strings.ContainsAny(f, `/\`) {
+		if abs, e := filepath.Abs(f); e == nil {
+			f = abs
+		}
+	}
+
+	return f
+}
+
+// readSampleSheet reads the experiment of each run from a two column file, separated by tabs or
+// commas. The runs are file names or paths, the lines starting with # are comments
+func readSampleSheet(f string) map[string]string {
+
+	var experiments = make(map[string]string)
+
+	file, e := os.Open(f)
+	if e != nil {
+		msg.ReadFile(e, "error")
+		return experiments
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool { return r == '\t' || r == ',' })
+		if len(fields) < 2 {
+			msg.Custom(fmt.Errorf("the sample sheet line '%s' needs a run and an experiment", line), "warning")
+			continue
+		}
+
+		run := runPath(fields[0])
+		if v, ok := experiments[run]; ok && v != strings.TrimSpace(fields[1]) {
+			msg.Custom(fmt.Errorf("the run %s is assigned to %s and %s on the sample sheet, use the full paths to tell the runs apart", run, v, strings.TrimSpace(fields[1])), "warning")
+		}
+
+		experiments[run] = strings.TrimSpace(fields[1])
+	}
+
+	if e := scanner.Err(); e != nil {
+		msg.ReadFile(e, "error")
+	}
+
+	return experiments
+}
+
+// groupFDRFilter estimates the FDR inside each group and combines the results. The peptides and
+// ions accepted in more than one group keep their best q-value
+func groupFDRFilter(input map[string]id.PepIDListPtrs, targetFDR float64, level, decoyTag, debug, score string, group grouping) (id.PepIDListPtrs, float64) {
+
+	if group == nil {
+		return filterFDR(input, targetFDR, level, decoyTag, debug, score)
+	}
+
+	var groups = make(map[string]map[string]id.PepIDListPtrs)
+	for k, v := range input {
+		for _, i := range v {
+			g := group(i)
+			if _, ok := groups[g]; !ok {
+				groups[g] = make(map[string]id.PepIDListPtrs)
+			}
+			groups[g][k] = append(groups[g][k], i)
+		}
+	}
+
+	var names []string
+	for k := range groups {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var combined id.PepIDListPtrs
+	var index = make(map[string]int)
+	var threshold = math.Inf(1)
+
+	for _, g := range names {
+
+		// the best identification comes first, like on the global lists
+		for _, v := range groups[g] {
+			sort.Sort(v)
+		}
+
+		logrus.Info("Filtering ", level, "s in ", g)
+		filtered, t := filterFDR(groups[g], targetFDR, level, decoyTag, debug, score)
+		threshold = math.Min(threshold, t)
+
+		for _, i := range filtered {
+
+			key := identificationKey(i, level)
+
+			if j, ok := index[key]; ok {
+				if i.QValue < combined[j].QValue {
+					combined[j] = i
+				}
+				continue
+			}
+
+			index[key] = len(combined)
+			combined = append(combined, i)
+		}
+	}
+
+	// each group is at the target FDR, the peptides and ions shared by the groups can take the
+	// union above it
+	fdr, targets, decoys := combinedFDR(combined, decoyTag)
+
+	logrus.WithFields(logrus.Fields{
+		"decoy": decoys,
+		"total": targets + decoys,
+	}).Info(fmt.Sprintf("Combined %d groups at %.2f %% FDR with %d %ss", len(names), fdr*100, targets, level))
+
+	if fdr > targetFDR {
+		msg.Custom(fmt.Errorf("the %ss of all groups together are at %.2f %% FDR, above the %.2f %% target of each group", level, fdr*100, targetFDR*100), "warning")
+	}
+
+	return combined, threshold
+}
+
+// combinedFDR estimates the FDR of the identifications accepted in all groups, as (decoys + 1) /
+// targets like the q-values of each group
+func combinedFDR(list id.PepIDListPtrs, decoyTag string) (float64, int, int) {
+
+	var targets, decoys int
+	for _, i := range list {
+		if cla.IsDecoyPSM(*i, decoyTag) {
+			decoys++
+		} else {
+			targets++
+		}
+	}
+
+	if len(list) == 0 {
+		return 0, targets, decoys
+	} else if targets == 0 {
+		return 1, targets, decoys
+	}
+
+	return math.Min(float64(decoys+1)/float64(targets), 1), targets, decoys
+}
+
+// identificationKey identifies a PSM, an ion or a peptide
+func identificationKey(p *id.PeptideIdentification, level string) string {
+
+	if strings.EqualFold(level, "Peptide") {
+		return p.Peptide
+	} else if strings.EqualFold(level, "Ion") {
+		return ionKey(*p)
+	}
+
+	return p.SpectrumFileName().Str()
+}
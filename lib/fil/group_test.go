@@ -0,0 +1,172 @@
+package fil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"philosopher/lib/id"
+)
+
+func TestGroupFDRFilter(t *testing.T) {
+
+	var psms = make(map[string]id.PepIDListPtrs)
+
+	// the good run has 10 targets above its decoys, the bad run is half decoys
+	for i := 0; i < 20; i++ {
+
+		good := &id.PeptideIdentification{Spectrum: fmt.Sprintf("good.%05d.%05d.2", i, i), Peptide: "PEPTIDE", Protein: "P1", Hyperscore: float64(40 - i)}
+		if i >= 10 && i%2 == 0 {
+			good.Protein = "rev_P1"
+		}
+
+		bad := &id.PeptideIdentification{Spectrum: fmt.Sprintf("bad.%05d.%05d.2", i, i), Peptide: "PEPTIDE", Protein: "P1", Hyperscore: float64(60 - i)}
+		if i%2 == 0 {
+			bad.Protein = "rev_P1"
+		}
+
+		psms[good.SpectrumFileName().Str()] = id.PepIDListPtrs{good}
+		psms[bad.SpectrumFileName().Str()] = id.PepIDListPtrs{bad}
+	}
+
+	global, _ := groupFDRFilter(psms, 0.1, "PSM", "rev_", "", "hyperscore", nil)
+	if len(global) != 0 {
+		t.Errorf("got %d global PSMs, want the bad run to hide the good one", len(global))
+	}
+
+	group := newGrouping("run", "")
+	byRun, _ := groupFDRFilter(psms, 0.1, "PSM", "rev_", "", "hyperscore", group)
+	if len(byRun) != 10 {
+		t.Errorf("got %d PSMs, want the 10 targets of the good run", len(byRun))
+	}
+
+	for _, i := range byRun {
+		if runName(i.Spectrum) != "good" {
+			t.Errorf("got %s, want only PSMs from the good run", i.Spectrum)
+		}
+	}
+
+	// the peptide is found in both runs and is reported once
+	var peptides = map[string]id.PepIDListPtrs{"PEPTIDE": nil}
+	for _, v := range psms {
+		peptides["PEPTIDE"] = append(peptides["PEPTIDE"], v...)
+	}
+
+	combined, _ := groupFDRFilter(peptides, 1, "Peptide", "rev_", "", "hyperscore", group)
+	if len(combined) != 1 {
+		t.Errorf("got %d peptides, want PEPTIDE once", len(combined))
+	}
+
+	// the union counts the peptides shared by the groups once
+	union := id.PepIDListPtrs{
+		&id.PeptideIdentification{Protein: "P1"},
+		&id.PeptideIdentification{Protein: "P1"},
+		&id.PeptideIdentification{Protein: "P1"},
+		&id.PeptideIdentification{Protein: "P1"},
+		&id.PeptideIdentification{Protein: "rev_P1"},
+	}
+
+	if fdr, targets, decoys := combinedFDR(union, "rev_"); fdr != 0.5 || targets != 4 || decoys != 1 {
+		t.Errorf("got %f FDR with %d targets and %d decoys, want 0.5 with 4 and 1", fdr, targets, decoys)
+	}
+
+	if fdr, _, _ := combinedFDR(union[4:], "rev_"); fdr != 1 {
+		t.Errorf("got %f FDR for decoys only, want 1", fdr)
+	}
+}
+
+func TestReadSampleSheet(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "fil")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	// the runs named sample are told apart by their directories
+	a := filepath.Join(dir, "a", "sample.mzML")
+	b := filepath.Join(dir, "b", "sample.mzML")
+
+	f := filepath.Join(dir, "samples.tsv")
+	sheet := fmt.Sprintf("# run\texperiment\nrun1.mzML\tA\nrun2,B\n\n%s\tC\n%s\tD\n", a, b)
+	if e := ioutil.WriteFile(f, []byte(sheet), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	experiments := readSampleSheet(f)
+	if len(experiments) != 4 || experiments["run1"] != "A" || experiments["run2"] != "B" {
+		t.Errorf("got %v, want run1 in A and run2 in B", experiments)
+	}
+
+	group := newGrouping("experiment", f)
+
+	tests := []struct {
+		spectrum    string
+		spectraFile string
+		want        string
+	}{
+		{"sample.00100.00100.2", a, "C"},
+		{"sample.00100.00100.2", b, "D"},
+		{"sample.00100.00100.2", filepath.Join(dir, "sample.mzML"), "sample"},
+		{"run1.00100.00100.2", filepath.Join(dir, "c", "run1.mzML"), "A"},
+		{"run1.00100.00100.2", "", "A"},
+	}
+
+	for _, tt := range tests {
+		p := &id.PeptideIdentification{Spectrum: tt.spectrum, SpectraFile: tt.spectraFile}
+		if g := group(p); g != tt.want {
+			t.Errorf("got experiment %s for %s, want %s", g, tt.spectraFile, tt.want)
+		}
+	}
+
+	if v := runName("my.run.00100.00100.2"); v != "my.run" {
+		t.Errorf("got run %s, want my.run", v)
+	}
+}
+
+func TestStratifiedFDR(t *testing.T) {
+
+	var psms = make(map[string]id.PepIDListPtrs)
+
+	// the doubly charged PSMs are clean, the triply charged ones are half decoys with higher scores
+	for i := 0; i < 20; i++ {
+
+		clean := &id.PeptideIdentification{Spectrum: fmt.Sprintf("run.%05d.%05d.2", i, i), Peptide: "PEPTIDE", Protein: "P1", AssumedCharge: 2, Hyperscore: float64(40 - i)}
+		if i >= 10 && i%2 == 0 {
+			clean.Protein = "rev_P1"
+		}
+
+		noisy := &id.PeptideIdentification{Spectrum: fmt.Sprintf("run.%05d.%05d.3", i, i), Peptide: "PEPTIDER", Protein: "P1", AssumedCharge: 3, Hyperscore: float64(60 - i)}
+		if i%2 == 0 {
+			noisy.Protein = "rev_P1"
+		}
+
+		psms[clean.SpectrumFileName().Str()] = id.PepIDListPtrs{clean}
+		psms[noisy.SpectrumFileName().Str()] = id.PepIDListPtrs{noisy}
+	}
+
+	strata := newStrata("charge, ntt")
+
+	if v := strata(psms["run.00001.00001.2#"][0]); v != "charge 2, 0 enzymatic termini" {
+		t.Errorf("got stratum %s, want charge 2, 0 enzymatic termini", v)
+	}
+
+	got, _ := groupFDRFilter(psms, 0.1, "PSM", "rev_", "", "hyperscore", combineGroupings(nil, strata))
+	if len(got) != 10 {
+		t.Errorf("got %d PSMs, want the 10 doubly charged targets", len(got))
+	}
+
+	// the negative mass offsets are as large as the positive ones
+	massdiff := newStrata("massdiff")
+	for _, d := range []float64{-203.08, 203.08} {
+		if v := massdiff(&id.PeptideIdentification{Massdiff: d}); v != "mass offset above 145" {
+			t.Errorf("got stratum %s for mass offset %f, want mass offset above 145", v, d)
+		}
+	}
+
+	if newStrata("") != nil {
+		t.Errorf("an empty list should not stratify the PSMs")
+	}
+}
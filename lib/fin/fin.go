@@ -367,11 +367,11 @@ type ScanEvent struct {
 
 // Reaction ...
 type Reaction struct {
-	Precursormz float64
-	Unknown1    float64
-	Energy      float64
-	Unknown2    uint32
-	Unknown3    uint32
+	Precursormz    float64
+	IsolationWidth float64
+	Energy         float64
+	Unknown2       uint32
+	Unknown3       uint32
 }
 
 // FractionCollector ...
@@ -478,6 +478,18 @@ type Scan struct {
 // Analyzer is the mass analyzer
 type Analyzer int
 
+// String returns the Thermo name of the mass analyzer
+func (a Analyzer) String() string {
+
+	names := []string{"ITMS", "TQMS", "SQMS", "TOFMS", "FTMS", "Sector"}
+
+	if a < 0 || int(a) >= len(names) {
+		return "undefined"
+	}
+
+	return names[a]
+}
+
 // Fragment represents an MSn scan
 type Fragment struct {
 	// PrecursorMzs is only filled with mz values at MSx scans.
@@ -504,6 +516,10 @@ type RawData struct {
 	Detector        []string
 	Scanevents      ScanEvents
 	Scanindex       ScanIndex
+	FirstScan       int
+	Controllers     int
+	Trailer         GenericDataHeader
+	trailerAddr     uint64
 }
 
 // ProcessRaw calls other low level functions and fill out RawData struct
@@ -556,7 +572,10 @@ func (rd *RawData) ProcessRaw(f string) {
 	rd.SoftwareVersion = inst.Tag1.String()
 	rd.Scanevents = scanevents
 	rd.Scanindex = scanindex
+	rd.FirstScan = int(rh.SampleInfo.FirstScanNumber)
+	rd.Controllers = int(info.Preamble.NControllers)
 
+	rd.readTrailer(rh)
 }
 
 // ScanEventData ...
@@ -1300,11 +1319,12 @@ func (rd *RawData) spectrum(sn int, centroided ...bool) (s Spectrum) {
 	return
 }
 
-// Chromatography Experimental: read out chromatography data from a connected instrument
+// Chromatography Experimental: read out chromatography data from a connected instrument,
+// the MS controller has none and gives an empty trace
 func (rd *RawData) Chromatography(instr int) (cdata CDataPackets) {
 	info, ver := readHeaders(rd.File)
 
-	if uint32(instr) > info.Preamble.NControllers-1 {
+	if instr < 0 || uint32(instr) >= info.Preamble.NControllers {
 		log.Print(instr, " is higher than number of extra controllers: ", info.Preamble.NControllers-1)
 		return
 	}
@@ -1313,7 +1333,6 @@ func (rd *RawData) Chromatography(instr int) (cdata CDataPackets) {
 	readAt(rd.File, info.Preamble.RunHeaderAddr[instr], ver, rh)
 	//The ScantrailerAddr has to be 0. in other words: we're not looking at the MS runheader
 	if rh.ScantrailerAddr != 0 {
-		log.Println("You selected the MS instrument, no chromatography data can be read.")
 		return
 	}
 
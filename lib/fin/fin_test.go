@@ -0,0 +1,314 @@
+package fin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// rawScan is a scan of the synthetic raw file
+type rawScan struct {
+	level     uint8
+	precursor float64
+	charge    uint8
+	filter    string
+	peaks     []CentroidedPeak
+}
+
+// filterLength is the size of the filter text field of the synthetic scan trailer
+const filterLength = 64
+
+// writeRaw builds a minimal version 66 raw file with the scans, numbered from first. A trace
+// adds a chromatography controller before the MS one
+func writeRaw(t *testing.T, f string, first uint32, scans []rawScan, trace CDataPackets) {
+
+	put := func(w *bytes.Buffer, v ...interface{}) {
+		for _, i := range v {
+			if e := binary.Write(w, binary.LittleEndian, i); e != nil {
+				t.Fatal(e)
+			}
+		}
+	}
+
+	pascal := func(w *bytes.Buffer, s string) {
+		text := []rune(s)
+		put(w, int32(len(text)))
+		for _, i := range text {
+			put(w, uint16(i))
+		}
+	}
+
+	// the scan trailer lists a section gap, the charge state, the monoisotopic m/z and the filter
+	var trailerHeader bytes.Buffer
+	put(&trailerHeader, uint32(4))
+	for _, i := range []struct {
+		kind   uint32
+		length uint32
+		label  string
+	}{{genericGap, 0, "Trailer Extra:"}, {genericUChar, 0, "Charge State:"}, {genericDouble, 0, "Monoisotopic M/Z:"}, {genericString, filterLength, "Filter Text:"}} {
+		put(&trailerHeader, i.kind, i.length)
+		pascal(&trailerHeader, i.label)
+	}
+
+	var packets, events, params bytes.Buffer
+	var offsets []uint64
+
+	for _, i := range scans {
+
+		offsets = append(offsets, uint64(packets.Len()))
+		put(&packets, PacketHeader{PeaklistSize: uint32(len(i.peaks))}, uint32(len(i.peaks)), i.peaks)
+
+		var event ScanEvent
+		event.Preamble[6] = i.level
+		if i.level > 1 {
+			event.Preamble[10] = 1
+			put(&events, event.Preamble, uint32(0), uint32(1), Reaction{Precursormz: i.precursor, IsolationWidth: 2, Energy: 30})
+			put(&events, [2]float64{}, [3]uint32{}, FractionCollector{}, uint32(0))
+		} else {
+			put(&events, event.Preamble, uint32(0), uint32(0))
+			put(&events, FractionCollector{}, [4]uint32{}, FractionCollector{}, [3]uint32{}, FractionCollector{}, uint32(0))
+		}
+		put(&events, [2]float64{}, float64(0), float64(0), float64(0), [5]uint32{})
+
+		var filter [filterLength]byte
+		copy(filter[:], i.filter)
+		put(&params, i.charge, i.precursor, filter)
+	}
+
+	var controllers = []string{"run"}
+	if len(trace) > 0 {
+		controllers = []string{"trace", "run"}
+	}
+
+	// the section addresses do not depend on the run header values, so the file is laid out once
+	// to find them and written again with them
+	var rh, th RunHeader
+	var addr = make(map[string]uint64)
+
+	build := func() []byte {
+
+		var w bytes.Buffer
+		put(&w, FileHeader{Version: 66})
+
+		put(&w, InjectionData{})
+		for i := 0; i < 16; i++ {
+			pascal(&w, "")
+		}
+		put(&w, uint32(0))
+		for i := 0; i < 15; i++ {
+			pascal(&w, "")
+		}
+
+		put(&w, AutoSamplerPreamble{})
+		pascal(&w, "")
+
+		n := uint32(len(controllers))
+		put(&w, uint32(0), [8]uint16{}, uint32(0), uint32(0), n, n, uint32(0), uint32(0))
+		put(&w, [764]byte{}, addr["data"], uint64(0))
+		for _, i := range controllers {
+			put(&w, addr[i], uint64(0))
+		}
+		put(&w, make([]byte, 1032-16*len(controllers)))
+		for i := 0; i < 6; i++ {
+			pascal(&w, "")
+		}
+
+		if len(trace) > 0 {
+			addr["tracedata"] = uint64(w.Len())
+			put(&w, trace)
+
+			addr["trace"] = uint64(w.Len())
+			var h bytes.Buffer
+			put(&h, th)
+			w.Write(h.Bytes()[8:])
+		}
+
+		addr["data"] = uint64(w.Len())
+		w.Write(packets.Bytes())
+
+		// the run header is written without its address field
+		addr["run"] = uint64(w.Len())
+		var h bytes.Buffer
+		put(&h, rh)
+		w.Write(h.Bytes()[8:])
+
+		put(&w, [8]byte{}, uint32(0))
+		for _, i := range []string{"", "Synthetic", "", "", "", "", "", ""} {
+			pascal(&w, i)
+		}
+
+		// the error log and the scan event hierarchy are not decoded, any data can precede the header
+		addr["errorlog"] = uint64(w.Len())
+		w.Write([]byte{1, 2, 3, 4, 5})
+		w.Write(trailerHeader.Bytes())
+
+		addr["index"] = uint64(w.Len())
+		for k := range scans {
+			put(&w, ScanIndexEntry{Index: uint32(k), DataPacketSize: uint32(binary.Size(PacketHeader{}) + 4 + 8*len(scans[k].peaks)), Time: float64(k), Offset: offsets[k]})
+		}
+
+		addr["trailer"] = uint64(w.Len())
+		put(&w, uint32(len(scans)))
+		w.Write(events.Bytes())
+
+		addr["params"] = uint64(w.Len())
+		w.Write(params.Bytes())
+
+		return w.Bytes()
+	}
+
+	build()
+
+	rh.DataAddr = addr["data"]
+	rh.ErrorlogAddr = addr["errorlog"]
+	rh.ScanindexAddr = addr["index"]
+	rh.ScantrailerAddr = addr["trailer"]
+	rh.ScanparamsAddr = addr["params"]
+	rh.SampleInfo.FirstScanNumber = first
+	rh.SampleInfo.LastScanNumber = first + uint32(len(scans)) - 1
+
+	th.DataAddr = addr["tracedata"]
+	th.SampleInfo.FirstScanNumber = 1
+	th.SampleInfo.LastScanNumber = uint32(len(trace))
+
+	if e := ioutil.WriteFile(f, build(), 0644); e != nil {
+		t.Fatal(e)
+	}
+}
+
+func TestProcessRaw(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "fin")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "synthetic.raw")
+
+	writeRaw(t, f, 101, []rawScan{
+		{level: 1, filter: "FTMS + p NSI Full ms [350.00-1800.00]", peaks: []CentroidedPeak{{400, 10}, {500.25, 100}}},
+		{level: 2, precursor: 500.25, charge: 2, filter: "FTMS + c NSI d Full ms2 500.25@hcd30.00 [110.00-1000.00]", peaks: []CentroidedPeak{{175.12, 5}}},
+		{level: 2, precursor: 400, charge: 3, filter: "ITMS + c NSI d Full ms2 400.00@etd25.00@hcd20.00 [110.00-1000.00]", peaks: []CentroidedPeak{{147.11, 7}, {262.14, 3}}},
+	}, CDataPackets{{Value: 1.5, Time: 0.1}, {Value: 2.5, Time: 0.2}})
+
+	var rd RawData
+	rd.ProcessRaw(f)
+	defer rd.Close()
+
+	if rd.NScans() != 3 {
+		t.Fatalf("got %d scans, want 3", rd.NScans())
+	}
+
+	if len(rd.Trailer) != 4 {
+		t.Fatalf("got trailer header %v, want 4 fields", rd.Trailer)
+	}
+
+	if rd.Controllers != 2 {
+		t.Errorf("got %d controllers, want 2", rd.Controllers)
+	}
+
+	// the chromatography controller comes before the MS one
+	if trace := rd.Chromatography(0); len(trace) != 2 || trace[1].Value != 2.5 || trace[1].Time != 0.2 {
+		t.Errorf("got chromatography trace %v", trace)
+	}
+
+	if trace := rd.Chromatography(1); len(trace) != 0 {
+		t.Errorf("got chromatography trace %v from the MS controller", trace)
+	}
+
+	tests := []struct {
+		sn         int
+		scan       int
+		level      uint8
+		charge     int
+		precursor  string
+		activation string
+		peaks      int
+	}{
+		{1, 101, 1, 0, "0", "", 2},
+		{2, 102, 2, 2, "500.25", "HCD", 1},
+		{3, 103, 2, 3, "400", "ETHCD", 2},
+	}
+
+	for _, tt := range tests {
+
+		if got := rd.ScanNumber(tt.sn); got != tt.scan {
+			t.Errorf("got scan number %d for scan %d, want %d", got, tt.sn, tt.scan)
+		}
+
+		scan := rd.Scan(tt.sn)
+		if scan.MSLevel != tt.level {
+			t.Errorf("got level %d for scan %d, want %d", scan.MSLevel, tt.sn, tt.level)
+		}
+
+		if got := len(scan.Spectrum(true)); got != tt.peaks {
+			t.Errorf("got %d peaks for scan %d, want %d", got, tt.sn, tt.peaks)
+		}
+
+		if got := rd.ChargeState(tt.sn); got != tt.charge {
+			t.Errorf("got charge state %d for scan %d, want %d", got, tt.sn, tt.charge)
+		}
+
+		if got := rd.TrailerValues(tt.sn)["Monoisotopic M/Z"]; got != tt.precursor {
+			t.Errorf("got monoisotopic m/z %s for scan %d, want %s", got, tt.sn, tt.precursor)
+		}
+
+		if got := rd.Activation(tt.sn); got != tt.activation {
+			t.Errorf("got activation %s for scan %d, want %s", got, tt.sn, tt.activation)
+		}
+	}
+}
+
+func TestParseGenericHeader(t *testing.T) {
+
+	tests := []struct {
+		name string
+		data []byte
+		ok   bool
+	}{
+		{"empty", nil, false},
+		{"no fields", []byte{0, 0, 0, 0}, false},
+		{"truncated", []byte{1, 0, 0, 0, 5, 0, 0, 0}, false},
+		{"unknown type", []byte{1, 0, 0, 0, 0x20, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, false},
+		{"control characters", []byte{1, 0, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 7, 0}, false},
+		{"one field", []byte{1, 0, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 'Z', 0}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, n, ok := parseGenericHeader(tt.data)
+			if ok != tt.ok {
+				t.Fatalf("parseGenericHeader() ok = %t, want %t", ok, tt.ok)
+			}
+			if ok && (n != len(tt.data) || h.RecordSize() != 1) {
+				t.Errorf("parseGenericHeader() read %d bytes and %d record bytes", n, h.RecordSize())
+			}
+		})
+	}
+}
+
+func TestFilterActivation(t *testing.T) {
+
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{"", ""},
+		{"FTMS + p NSI Full ms [350.00-1800.00]", ""},
+		{"ITMS + c NSI d Full ms2 445.12@cid35.00 [110.00-905.00]", "CID"},
+		{"FTMS + c NSI d Full ms2 445.12@hcd30.00 [110.00-905.00]", "HCD"},
+		{"FTMS + c NSI d Full ms2 445.12@etd25.00@hcd20.00 [110.00-905.00]", "ETHCD"},
+		{"FTMS + c NSI d Full ms2 445.12@etd66.67@cid35.00 [110.00-905.00]", "ETD"},
+		{"FTMS + p NSI sps d Full ms3 445.12@cid35.00 300.15@hcd55.00 [100.00-500.00]", "HCD"},
+	}
+
+	for _, tt := range tests {
+		if got := filterActivation(tt.filter); got != tt.want {
+			t.Errorf("filterActivation(%q) = %s, want %s", tt.filter, got, tt.want)
+		}
+	}
+}
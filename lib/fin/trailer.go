@@ -0,0 +1,379 @@
+package fin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// The scan trailer, or scan parameters, holds one generic record per scan with the values the
+// instrument reports besides the spectrum, like the precursor charge state. The layout of the
+// records is given by a generic data header stored after the scan event hierarchy
+
+// generic data field types
+const (
+	genericGap        uint32 = 0x0
+	genericChar       uint32 = 0x1
+	genericTrueFalse  uint32 = 0x2
+	genericYesNo      uint32 = 0x3
+	genericOnOff      uint32 = 0x4
+	genericUChar      uint32 = 0x5
+	genericShort      uint32 = 0x6
+	genericUShort     uint32 = 0x7
+	genericLong       uint32 = 0x8
+	genericULong      uint32 = 0x9
+	genericFloat      uint32 = 0xA
+	genericDouble     uint32 = 0xB
+	genericString     uint32 = 0xC
+	genericWideString uint32 = 0xD
+)
+
+// limits used to tell a generic data header apart from the surrounding data
+const (
+	maxGenericFields = 4096
+	maxGenericLabel  = 1024
+	maxHeaderGap     = 1 << 16
+	maxSearchSize    = 1 << 26
+	maxChargeState   = 100
+)
+
+// chargeStateLabel is the trailer field holding the precursor charge state
+const chargeStateLabel = "Charge State:"
+
+// trailer fields describing the activation of the scan, recorded by some instruments only
+const (
+	filterTextLabel     = "Filter Text:"
+	activationTypeLabel = "Activation Type:"
+)
+
+// filterActivationRegex finds the activation types and energies of a filter precursor, like
+// 445.12@etd25.00@hcd20.00
+var filterActivationRegex = regexp.MustCompile(`@([a-z]+)[0-9.]*`)
+
+// GenericDataDescriptor describes a field of the generic records
+type GenericDataDescriptor struct {
+	Type   uint32
+	Length uint32
+	Label  PascalString
+}
+
+// GenericDataHeader is the list of fields of the generic records
+type GenericDataHeader []GenericDataDescriptor
+
+// size returns the number of bytes taken by the field in a record
+func (d GenericDataDescriptor) size() int {
+
+	switch d.Type {
+	case genericChar, genericTrueFalse, genericYesNo, genericOnOff, genericUChar:
+		return 1
+	case genericShort, genericUShort:
+		return 2
+	case genericLong, genericULong, genericFloat:
+		return 4
+	case genericDouble:
+		return 8
+	case genericString:
+		return int(d.Length)
+	case genericWideString:
+		return 2 * int(d.Length)
+	}
+
+	return 0
+}
+
+// value formats the field content from the record bytes
+func (d GenericDataDescriptor) value(b []byte) string {
+
+	switch d.Type {
+	case genericChar:
+		return string(b[:1])
+	case genericTrueFalse, genericYesNo, genericOnOff:
+		return strconv.FormatBool(b[0] != 0)
+	case genericUChar:
+		return strconv.Itoa(int(b[0]))
+	case genericShort:
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(b))))
+	case genericUShort:
+		return strconv.Itoa(int(binary.LittleEndian.Uint16(b)))
+	case genericLong:
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(b))))
+	case genericULong:
+		return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(b)), 10)
+	case genericFloat:
+		return strconv.FormatFloat(float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 'f', -1, 32)
+	case genericDouble:
+		return strconv.FormatFloat(math.Float64frombits(binary.LittleEndian.Uint64(b)), 'f', -1, 64)
+	case genericString:
+		return strings.TrimRight(string(b), "\x00 ")
+	case genericWideString:
+		var w = make([]uint16, len(b)/2)
+		for i := range w {
+			w[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(w)), "\x00 ")
+	}
+
+	return ""
+}
+
+// RecordSize returns the number of bytes of each record
+func (h GenericDataHeader) RecordSize() int {
+
+	var size int
+	for _, i := range h {
+		size += i.size()
+	}
+
+	return size
+}
+
+// Decode reads the values of a record, indexed by the field labels without the trailing colon
+func (h GenericDataHeader) Decode(record []byte) map[string]string {
+
+	var values = make(map[string]string)
+	var pos int
+
+	for _, i := range h {
+
+		size := i.size()
+		if pos+size > len(record) {
+			break
+		}
+
+		if i.Type != genericGap {
+			values[trailerLabel(i.Label.String())] = i.value(record[pos : pos+size])
+		}
+
+		pos += size
+	}
+
+	return values
+}
+
+// trailerLabel removes the colon and the spaces around the field labels
+func trailerLabel(s string) string {
+	return strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), ":"))
+}
+
+// parseGenericHeader decodes a generic data header from the start of b, and returns the number of
+// bytes read. Implausible field counts, types or labels make it fail
+func parseGenericHeader(b []byte) (GenericDataHeader, int, bool) {
+
+	if len(b) < 4 {
+		return nil, 0, false
+	}
+
+	n := binary.LittleEndian.Uint32(b)
+	if n == 0 || n > maxGenericFields {
+		return nil, 0, false
+	}
+
+	var h GenericDataHeader
+	var pos = 4
+
+	for i := uint32(0); i < n; i++ {
+
+		if pos+12 > len(b) {
+			return nil, 0, false
+		}
+
+		var d GenericDataDescriptor
+		d.Type = binary.LittleEndian.Uint32(b[pos:])
+		d.Length = binary.LittleEndian.Uint32(b[pos+4:])
+		d.Label.Length = int32(binary.LittleEndian.Uint32(b[pos+8:]))
+		pos += 12
+
+		if d.Type > genericWideString || d.Label.Length < 0 || d.Label.Length > maxGenericLabel {
+			return nil, 0, false
+		}
+
+		if (d.Type == genericString || d.Type == genericWideString) && d.Length > maxGenericLabel {
+			return nil, 0, false
+		}
+
+		if pos+2*int(d.Label.Length) > len(b) {
+			return nil, 0, false
+		}
+
+		d.Label.Text = make([]uint16, d.Label.Length)
+		for j := range d.Label.Text {
+			c := binary.LittleEndian.Uint16(b[pos+2*j:])
+			if c != 0 && c < 0x20 {
+				return nil, 0, false
+			}
+			d.Label.Text[j] = c
+		}
+		pos += 2 * int(d.Label.Length)
+
+		h = append(h, d)
+	}
+
+	return h, pos, true
+}
+
+// findTrailerHeader locates the scan trailer header in the data between the error log and the
+// scan index. The header is the generic data header that lists the charge state field, the
+// candidate starts before the label are checked until one of them decodes up to the label
+func findTrailerHeader(b []byte) (GenericDataHeader, bool) {
+
+	label := utf16Bytes(chargeStateLabel)
+
+	for offset := 0; ; {
+
+		k := bytes.Index(b[offset:], label)
+		if k < 0 {
+			return nil, false
+		}
+		k += offset
+
+		start := k - maxHeaderGap
+		if start < 0 {
+			start = 0
+		}
+
+		for p := start; p < k; p++ {
+
+			h, _, ok := parseGenericHeader(b[p:])
+			if !ok || h.RecordSize() == 0 {
+				continue
+			}
+
+			for _, i := range h {
+				if trailerLabel(i.Label.String()) == trailerLabel(chargeStateLabel) {
+					return h, true
+				}
+			}
+		}
+
+		offset = k + len(label)
+	}
+}
+
+// utf16Bytes encodes a string as UTF-16 little endian
+func utf16Bytes(s string) []byte {
+
+	var b []byte
+	for _, i := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(i), byte(i>>8))
+	}
+
+	return b
+}
+
+// readTrailer finds the scan trailer header of the MS run, the trailer is left empty when the
+// header is not found or the records do not fit in the file
+func (rd *RawData) readTrailer(rh RunHeader) {
+
+	if rh.ErrorlogAddr == 0 || rh.ScanindexAddr <= rh.ErrorlogAddr || rh.ScanparamsAddr == 0 {
+		return
+	}
+
+	end := rh.ScanindexAddr
+	if end-rh.ErrorlogAddr > maxSearchSize {
+		end = rh.ErrorlogAddr + maxSearchSize
+	}
+
+	b := make([]byte, end-rh.ErrorlogAddr)
+	if _, e := rd.File.ReadAt(b, int64(rh.ErrorlogAddr)); e != nil && e != io.EOF {
+		return
+	}
+
+	h, ok := findTrailerHeader(b)
+	if !ok {
+		return
+	}
+
+	info, e := rd.File.Stat()
+	if e != nil || rh.ScanparamsAddr+rd.ScanCount*uint64(h.RecordSize()) > uint64(info.Size()) {
+		return
+	}
+
+	rd.Trailer = h
+	rd.trailerAddr = rh.ScanparamsAddr
+}
+
+// TrailerValues returns the scan trailer values of the scan, indexed by the field labels
+func (rd *RawData) TrailerValues(sn int) map[string]string {
+
+	if len(rd.Trailer) == 0 || sn < 1 || sn > rd.NScans() {
+		return nil
+	}
+
+	size := rd.Trailer.RecordSize()
+	record := make([]byte, size)
+
+	if _, e := rd.File.ReadAt(record, int64(rd.trailerAddr)+int64(sn-1)*int64(size)); e != nil {
+		return nil
+	}
+
+	return rd.Trailer.Decode(record)
+}
+
+// ChargeState returns the precursor charge state of the scan from the scan trailer, zero when it
+// is unknown
+func (rd *RawData) ChargeState(sn int) int {
+
+	v, ok := rd.TrailerValues(sn)[trailerLabel(chargeStateLabel)]
+	if !ok {
+		return 0
+	}
+
+	z, e := strconv.Atoi(v)
+	if e != nil || z < 0 || z > maxChargeState {
+		return 0
+	}
+
+	return z
+}
+
+// ScanNumber returns the native scan number of the scan at the position sn, counted from 1
+func (rd *RawData) ScanNumber(sn int) int {
+	return rd.FirstScan + sn - 1
+}
+
+// Activation returns the activation type of the scan, like CID, HCD, ETD or ETHCD. The scan event
+// fields decoded by ProcessRaw do not hold it, so it comes from the scan filter or the activation
+// type of the scan trailer, and it is empty when the instrument records neither
+func (rd *RawData) Activation(sn int) string {
+
+	values := rd.TrailerValues(sn)
+
+	if a := filterActivation(values[trailerLabel(filterTextLabel)]); len(a) > 0 {
+		return a
+	}
+
+	return strings.ToUpper(strings.TrimSpace(values[trailerLabel(activationTypeLabel)]))
+}
+
+// filterActivation reads the activation of the last precursor from a scan filter, like
+// "FTMS + p NSI d Full ms2 445.12@hcd30.00 [110.00-905.00]". ETD with supplemental HCD
+// activation is reported as ETHCD
+func filterActivation(filter string) string {
+
+	var last string
+	for _, i := range strings.Fields(filter) {
+		if strings.Contains(i, "@") {
+			last = i
+		}
+	}
+
+	var methods []string
+	for _, i := range filterActivationRegex.FindAllStringSubmatch(strings.ToLower(last), -1) {
+		methods = append(methods, strings.ToUpper(i[1]))
+	}
+
+	if len(methods) == 0 {
+		return ""
+	}
+
+	if len(methods) > 1 && methods[0] == "ETD" && methods[1] == "HCD" {
+		return "ETHCD"
+	}
+
+	return methods[0]
+}
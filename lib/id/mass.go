@@ -0,0 +1,168 @@
+package id
+
+import (
+	"fmt"
+	"strings"
+
+	"philosopher/lib/bio"
+	"philosopher/lib/mod"
+	"philosopher/lib/msg"
+	"philosopher/lib/uti"
+)
+
+// the masses added to the peptide termini on the pepXML modified peptide notation
+const (
+	nTermMass = 1.007825
+	cTermMass = 17.002740
+)
+
+// residueMass holds the monoisotopic residue masses by amino acid code
+var residueMass = func() map[string]float64 {
+	var m = make(map[string]float64)
+	for _, i := range []string{"Alanine", "Arginine", "Asparagine", "Aspartic Acid", "Cysteine", "Glutamine", "Glutamic Acid", "Glycine", "Histidine", "Isoleucine", "Leucine", "Lysine", "Methionine", "Phenylalanine", "Proline", "Serine", "Threonine", "Tryptophan", "Tyrosine", "Valine"} {
+		aa := bio.New(i)
+		m[aa.Code] = aa.MonoIsotopeMass
+	}
+	return m
+}()
+
+// massShift is a modification reported as a mass difference on a peptide position, where 0 is the
+// N-terminus and the peptide length plus one is the C-terminus
+type massShift struct {
+	Position int
+	MassDiff float64
+	ID       string
+	Name     string
+}
+
+// mapModsFromMassShifts adds the modifications to the identification and builds the modified
+// peptide the same way the pepXML notation does
+func (p *PeptideIdentification) mapModsFromMassShifts(shifts []massShift) {
+
+	pModificationsIndex := make(map[string]mod.Modification)
+
+	var residue = make(map[int]float64)
+	var nTerm, cTerm float64
+
+	for _, i := range shifts {
+
+		massDiff := uti.ToFixed(i.MassDiff, 4)
+		m := mod.Modification{ID: i.ID, Name: i.Name, Type: mod.Assigned, MassDiff: massDiff}
+
+		switch {
+		case i.Position == 0:
+			nTerm += massDiff
+			m.AminoAcid = "N-term"
+			m.Index = fmt.Sprintf("N-term#%.4f", nTermMass+massDiff)
+		case i.Position == len(p.Peptide)+1:
+			cTerm += massDiff
+			m.AminoAcid = "C-term"
+			m.Index = fmt.Sprintf("C-term#%.4f", cTermMass+massDiff)
+		case i.Position > 0 && i.Position <= len(p.Peptide):
+			residue[i.Position] += massDiff
+			m.AminoAcid = string(p.Peptide[i.Position-1])
+			m.Position = i.Position
+			m.Index = fmt.Sprintf("%s#%d#%.4f", m.AminoAcid, i.Position, residueMass[m.AminoAcid]+massDiff)
+		default:
+			msg.Custom(fmt.Errorf("the modification position %d is outside of %s", i.Position, p.Peptide), "warning")
+			continue
+		}
+
+		pModificationsIndex[m.Index] = m
+	}
+
+	if len(residue) > 0 || nTerm != 0 || cTerm != 0 {
+
+		var sb strings.Builder
+
+		if nTerm != 0 {
+			fmt.Fprintf(&sb, "n[%.0f]", nTermMass+nTerm)
+		}
+
+		for i := range p.Peptide {
+			sb.WriteByte(p.Peptide[i])
+			if v, ok := residue[i+1]; ok {
+				fmt.Fprintf(&sb, "[%.0f]", residueMass[string(p.Peptide[i])]+v)
+			}
+		}
+
+		if cTerm != 0 {
+			fmt.Fprintf(&sb, "c[%.0f]", cTermMass+cTerm)
+		}
+
+		p.ModifiedPeptide = sb.String()
+	}
+
+	key := fmt.Sprintf("%.4f", p.Massdiff)
+	if _, ok := pModificationsIndex[key]; !ok {
+		pModificationsIndex[key] = mod.Modification{
+			Index:    key,
+			Name:     "Unknown",
+			Type:     mod.Observed,
+			MassDiff: p.Massdiff,
+		}
+	}
+
+	p.Modifications = mod.Modifications{Index: pModificationsIndex}.ToSlice()
+}
+
+// indexModifications marks the assigned modifications of the PSMs as fixed or variable and collects
+// them without their positions. The fixed modifications are given by residue and mass difference,
+// like C#57.0215; without them, the ones found on every occurrence of their residue are fixed
+func (p *PepXML) indexModifications(fixed map[string]bool) {
+
+	if fixed == nil {
+
+		var residues = make(map[string]int)
+		var modified = make(map[string]int)
+
+		for _, i := range p.PeptideIdentification {
+
+			residues["N-term"]++
+			residues["C-term"]++
+			for _, j := range i.Peptide {
+				residues[string(j)]++
+			}
+
+			for _, j := range i.Modifications.IndexSlice {
+				if j.Type == mod.Assigned {
+					modified[fmt.Sprintf("%s#%.4f", j.AminoAcid, j.MassDiff)]++
+				}
+			}
+		}
+
+		fixed = make(map[string]bool)
+		for k, v := range modified {
+			aa := strings.Split(k, "#")[0]
+			fixed[k] = v >= residues[aa]
+		}
+	}
+
+	if p.Modifications.Index == nil {
+		p.Modifications.Index = make(map[string]mod.Modification)
+	}
+
+	for i := range p.PeptideIdentification {
+		for j, k := range p.PeptideIdentification[i].Modifications.IndexSlice {
+
+			if k.Type != mod.Assigned {
+				continue
+			}
+
+			k.Variable = !fixed[fmt.Sprintf("%s#%.4f", k.AminoAcid, k.MassDiff)]
+			p.PeptideIdentification[i].Modifications.IndexSlice[j] = k
+
+			// the header index holds the modifications without their positions
+			key := k.Index
+			if len(k.AminoAcid) == 1 {
+				key = fmt.Sprintf("%s#%.4f", k.AminoAcid, residueMass[k.AminoAcid]+k.MassDiff)
+			}
+
+			if _, ok := p.Modifications.Index[key]; !ok {
+				k.Index = key
+				k.Position = 0
+				p.Modifications.Index[key] = k
+			}
+		}
+	}
+}
@@ -0,0 +1,400 @@
+package id
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/bio"
+	"philosopher/lib/mzn"
+	"philosopher/lib/psi"
+	"philosopher/lib/spc"
+	"philosopher/lib/uti"
+
+	"philosopher/lib/msg"
+
+	"github.com/sirupsen/logrus"
+)
+
+// scanPattern finds the scan number on the native spectrum identifiers, like controllerType=0
+// controllerNumber=1 scan=100
+var scanPattern = regexp.MustCompile(`(?:^|\s)(?:scan|spectrum)=(\d+)`)
+
+// indexPattern finds the 0-based position of the spectrum in the spectra file, used by the
+// identifiers of peak lists like index=99
+var indexPattern = regexp.MustCompile(`(?:^|\s)index=(\d+)`)
+
+// titlePattern matches the spectrum titles that follow the TPP spectrum name, like run.00100.00100.2
+var titlePattern = regexp.MustCompile(`^(.+)\.(\d+)\.(\d+)\.\d+$`)
+
+// IsMzIdentML checks if the file is an mzIdentML file
+func IsMzIdentML(f string) bool {
+	return strings.HasSuffix(strings.ToLower(f), ".mzid")
+}
+
+// ReadMzIdentML parses the mzIdentML results from any search engine, keeping the top ranked
+// identification for each spectrum
+func (p *PepXML) ReadMzIdentML(f string) {
+
+	logrus.Info("Parsing ", f)
+
+	var mzid psi.MzIdentML
+	mzid.Parse(f)
+
+	p.FileName = path.Base(f)
+	p.PeptideIdentification = nil
+	p.Modifications.Index = nil
+
+	if len(mzid.AnalysisSoftwareList.AnalysisSoftware) > 0 {
+		sw := mzid.AnalysisSoftwareList.AnalysisSoftware[0]
+		p.SearchEngine = sw.SoftwareName.CVParam.Name
+		if len(p.SearchEngine) == 0 {
+			p.SearchEngine = sw.SoftwareName.UserParam.Name
+		}
+		if len(p.SearchEngine) == 0 {
+			p.SearchEngine = sw.Name
+		}
+	}
+
+	if len(mzid.DataCollection.Inputs.SearchDatabase) > 0 {
+		p.Database = mzid.DataCollection.Inputs.SearchDatabase[0].Location
+	}
+
+	// the fixed modifications by residue and mass difference
+	var fixed = make(map[string]bool)
+
+	p.SearchParameters = nil
+	for _, i := range mzid.AnalysisProtocolCollection.SpectrumIdentificationProtocol {
+
+		for _, j := range i.AdditionalSearchParams.CVParam {
+			p.SearchParameters = append(p.SearchParameters, spc.Parameter{Name: j.Name, Value: j.Value})
+		}
+
+		for _, j := range i.AdditionalSearchParams.UserParam {
+			p.SearchParameters = append(p.SearchParameters, spc.Parameter{Name: j.Name, Value: j.Value})
+		}
+
+		for _, j := range i.ModificationParams.SearchModification {
+
+			var residues []string
+			for _, k := range j.SpecificityRules {
+				for _, l := range k.CVParam {
+					if strings.Contains(strings.ToLower(l.Name), "n-term") {
+						residues = append(residues, "N-term")
+					} else if strings.Contains(strings.ToLower(l.Name), "c-term") {
+						residues = append(residues, "C-term")
+					}
+				}
+			}
+
+			for _, k := range strings.Fields(j.Residues) {
+				if k != "." {
+					residues = append(residues, k)
+				}
+			}
+
+			for _, k := range residues {
+				fixed[fmt.Sprintf("%s#%.4f", k, uti.ToFixed(j.MassDelta, 4))] = j.FixedMod == "true"
+			}
+		}
+	}
+
+	var peptides = make(map[string]psi.Peptide)
+	for _, i := range mzid.SequenceCollection.Peptide {
+		peptides[i.ID] = i
+	}
+
+	var proteins = make(map[string]string)
+	for _, i := range mzid.SequenceCollection.DBSequence {
+		proteins[i.ID] = i.Accession
+	}
+
+	var evidences = make(map[string]psi.PeptideEvidence)
+	for _, i := range mzid.SequenceCollection.PeptideEvidence {
+		evidences[i.ID] = i
+	}
+
+	var sources = make(map[string]string)
+	var indexes = spectrumIndexes{dir: filepath.Dir(f), locations: make(map[string]string), scans: make(map[string]map[int]int)}
+
+	for _, i := range mzid.DataCollection.Inputs.SpectraData {
+
+		indexes.locations[i.ID] = i.Location
+
+		name := i.Location
+		if len(name) == 0 {
+			name = i.Name
+		}
+
+		// the locations may come from any platform
+		name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+		for _, j := range []string{".gz", ".mzML", ".mzXML", ".mgf", ".raw", ".d"} {
+			if strings.HasSuffix(strings.ToLower(name), strings.ToLower(j)) {
+				name = name[:len(name)-len(j)]
+			}
+		}
+
+		sources[i.ID] = name
+		p.SpectraFile = name
+	}
+
+	var missing bool
+
+	for _, i := range mzid.DataCollection.AnalysisData.SpectrumIdentificationList {
+		for _, j := range i.SpectrumIdentificationResult {
+
+			if len(j.SpectrumIdentificationItem) == 0 {
+				continue
+			}
+
+			top := j.SpectrumIdentificationItem[0]
+			for _, k := range j.SpectrumIdentificationItem[1:] {
+				if k.Rank < top.Rank {
+					top = k
+				}
+			}
+
+			psm := p.mzidPSM(j, top, sources[j.SpectraDataRef], &indexes, peptides, proteins, evidences)
+			psm.SpectraFile = strings.TrimPrefix(indexes.locations[j.SpectraDataRef], "file://")
+
+			if !psm.searchScore(top.CVParam, top.UserParam, p.ScoreName) {
+				missing = true
+			}
+
+			p.PeptideIdentification = append(p.PeptideIdentification, psm)
+		}
+	}
+
+	if missing && len(p.ScoreName) > 0 {
+		msg.Custom(fmt.Errorf("the search score %s is missing from some of the identifications in %s", p.ScoreName, p.FileName), "warning")
+	}
+
+	p.indexModifications(fixed)
+
+	if len(p.PeptideIdentification) == 0 {
+		msg.NoPSMFound(errors.New(f), "warning")
+	}
+}
+
+// mzidPSM converts a spectrum identification item into a peptide identification
+func (p *PepXML) mzidPSM(sir psi.SpectrumIdentificationResult, sii psi.SpectrumIdentificationItem, source string, indexes *spectrumIndexes, peptides map[string]psi.Peptide, proteins map[string]string, evidences map[string]psi.PeptideEvidence) PeptideIdentification {
+
+	var psm PeptideIdentification
+	psm.AlternativeProteins = make(map[string]string)
+
+	psm.SpectrumFile = p.FileName
+	psm.AssumedCharge = sii.ChargeState
+	psm.HitRank = sii.Rank
+
+	z := float64(sii.ChargeState)
+	psm.PrecursorNeutralMass = (sii.ExperimentalMassToCharge - bio.Proton) * z
+	psm.UncalibratedPrecursorNeutralMass = psm.PrecursorNeutralMass
+	psm.CalcNeutralPepMass = (sii.CalculatedMassToCharge - bio.Proton) * z
+	psm.Massdiff = uti.ToFixed(psm.PrecursorNeutralMass-psm.CalcNeutralPepMass, 4)
+
+	// the scan number comes from the result parameters, the TPP spectrum title or the spectrum identifier
+	var scan int
+	var title string
+
+	for _, i := range sir.CVParam {
+		switch i.Accession {
+		case "MS:1001115":
+			scan, _ = strconv.Atoi(strings.Fields(i.Value + " ")[0])
+		case "MS:1000796":
+			title = i.Value
+		case "MS:1000016", "MS:1000894":
+			psm.RetentionTime, _ = strconv.ParseFloat(i.Value, 64)
+			if strings.EqualFold(i.UnitName, "minute") {
+				psm.RetentionTime *= 60
+			}
+		}
+	}
+
+	if m := titlePattern.FindStringSubmatch(title); m != nil {
+		source = m[1]
+		if scan == 0 {
+			scan, _ = strconv.Atoi(m[2])
+		}
+	}
+
+	if scan == 0 {
+		if m := scanPattern.FindStringSubmatch(sir.SpectrumID); m != nil {
+			scan, _ = strconv.Atoi(m[1])
+		} else if m := indexPattern.FindStringSubmatch(sir.SpectrumID); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			scan = indexes.scan(sir.SpectraDataRef, index)
+		}
+	}
+
+	psm.Index = uint32(scan)
+	psm.Spectrum = fmt.Sprintf("%s.%05d.%05d.%d", source, scan, scan, sii.ChargeState)
+
+	pep := peptides[sii.PeptideRef]
+	psm.Peptide = pep.PeptideSequence.Value
+
+	// the first target evidence gives the protein, the others are alternatives
+	var evidence []psi.PeptideEvidence
+	var main = -1
+
+	for _, i := range sii.PeptideEvidenceRef {
+
+		ev, ok := evidences[i.PeptideEvidenceRef]
+		if !ok {
+			continue
+		}
+
+		ev.Name = proteins[ev.DBSequenceRef]
+
+		// decoys flagged by the search engine are given the decoy tag when their accession lacks it
+		if ev.IsDecoy == "true" && len(p.DecoyTag) > 0 && !strings.HasPrefix(ev.Name, p.DecoyTag) {
+			ev.Name = p.DecoyTag + ev.Name
+		}
+
+		if main < 0 && ev.IsDecoy != "true" && (len(p.DecoyTag) == 0 || !strings.HasPrefix(ev.Name, p.DecoyTag)) {
+			main = len(evidence)
+		}
+
+		evidence = append(evidence, ev)
+	}
+
+	if main < 0 {
+		main = 0
+	}
+
+	if len(evidence) > 0 {
+		psm.Protein = evidence[main].Name
+		psm.PrevAA = []byte(evidence[main].Pre)
+		psm.NextAA = []byte(evidence[main].Post)
+	}
+
+	for i, ev := range evidence {
+		if i != main && ev.Name != psm.Protein {
+			psm.AlternativeProteins[ev.Name] = ev.Pre + "#" + ev.Post
+		}
+	}
+
+	var shifts []massShift
+	for _, i := range pep.Modification {
+
+		pos, e := strconv.Atoi(i.Location)
+		if e != nil {
+			continue
+		}
+
+		shift := massShift{Position: pos, MassDiff: i.MonoIsotopicMassDelta}
+		if len(i.CVParam) > 0 && i.CVParam[0].Name != "unknown modification" {
+			shift.ID = i.CVParam[0].Accession
+			shift.Name = i.CVParam[0].Name
+		}
+
+		shifts = append(shifts, shift)
+	}
+
+	psm.mapModsFromMassShifts(shifts)
+
+	for _, i := range sii.CVParam {
+
+		name := strings.ToLower(i.Name)
+		value, e := strconv.ParseFloat(i.Value, 64)
+		if e != nil {
+			continue
+		}
+
+		switch {
+		case strings.Contains(name, "expect") || strings.HasSuffix(name, ":evalue"):
+			psm.Expectation = value
+		case strings.HasSuffix(name, "hyperscore"):
+			psm.Hyperscore = value
+		case strings.HasSuffix(name, "xcorr"):
+			psm.Xcorr = value
+		case strings.HasSuffix(name, "deltacn"):
+			psm.DeltaCN = value
+		}
+	}
+
+	psm.defaultSearchScore(p.ScoreName)
+
+	return psm
+}
+
+// spectrumIndexes maps the spectrum indexes of the spectra files to their scan numbers. Each
+// file is read the first time an identification refers to one of its spectra by index
+type spectrumIndexes struct {
+	dir       string
+	locations map[string]string
+	scans     map[string]map[int]int
+	missing   map[string]bool
+}
+
+// scan returns the scan number of the spectrum at the index of the spectra file. When the file
+// is not found the spectra are assumed to be numbered from 1 in the file order
+func (s *spectrumIndexes) scan(ref string, index int) int {
+
+	scans, ok := s.scans[ref]
+	if !ok {
+		scans = readSpectrumIndexes(s.locations[ref], s.dir)
+		s.scans[ref] = scans
+	}
+
+	if scan, ok := scans[index]; ok {
+		return scan
+	}
+
+	if s.missing == nil {
+		s.missing = make(map[string]bool)
+	}
+
+	if !s.missing[ref] {
+		msg.Custom(fmt.Errorf("the spectrum index %d was not found in the spectra file %s, the scan numbers are taken as the indexes plus one", index, s.locations[ref]), "warning")
+		s.missing[ref] = true
+	}
+
+	return index + 1
+}
+
+// readSpectrumIndexes reads the index and the scan number of the spectra from the spectra file,
+// found at its location or next to the identifications
+func readSpectrumIndexes(location, dir string) map[int]int {
+
+	var scans = make(map[int]int)
+
+	location = strings.TrimPrefix(strings.ReplaceAll(location, "\\", "/"), "file://")
+
+	var f string
+	for _, i := range []string{location, filepath.Join(dir, path.Base(location))} {
+		if info, e := os.Stat(i); e == nil && !info.IsDir() {
+			f = i
+			break
+		}
+	}
+
+	add := func(spec mzn.Spectrum) {
+		index, e1 := strconv.Atoi(spec.Index)
+		scan, e2 := strconv.Atoi(spec.Scan)
+		if e1 == nil && e2 == nil {
+			scans[index] = scan
+		}
+	}
+
+	var data mzn.MsData
+
+	switch strings.ToLower(filepath.Ext(f)) {
+	case ".mgf":
+		data.ReadMGF(f)
+	case ".mzxml":
+		data.ReadMzXML(f)
+	case ".mzml":
+		mzn.StreamMzML(f)(add)
+	}
+
+	for _, i := range data.Spectra {
+		add(i)
+	}
+
+	return scans
+}
@@ -0,0 +1,168 @@
+package id
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMzIdentML = `<?xml version="1.0" encoding="UTF-8"?>
+<MzIdentML xmlns="http://psidev.info/psi/pi/mzIdentML/1.2" id="test" version="1.2.0">
+<AnalysisSoftwareList>
+<AnalysisSoftware id="msgf"><SoftwareName><cvParam accession="MS:1002048" cvRef="PSI-MS" name="MS-GF+"/></SoftwareName></AnalysisSoftware>
+</AnalysisSoftwareList>
+<SequenceCollection>
+<DBSequence id="DBSeq1" accession="sp|P1|ONE" searchDatabase_ref="db"/>
+<DBSequence id="DBSeq2" accession="XXX_sp|P2|TWO" searchDatabase_ref="db"/>
+<Peptide id="Pep1"><PeptideSequence>MCPEPK</PeptideSequence>
+<Modification location="1" monoisotopicMassDelta="15.994915"><cvParam accession="UNIMOD:35" cvRef="UNIMOD" name="Oxidation"/></Modification>
+<Modification location="2" monoisotopicMassDelta="57.021464"><cvParam accession="UNIMOD:4" cvRef="UNIMOD" name="Carbamidomethyl"/></Modification>
+</Peptide>
+<PeptideEvidence id="PE1" dBSequence_ref="DBSeq2" peptide_ref="Pep1" pre="K" post="L" isDecoy="true"/>
+<PeptideEvidence id="PE2" dBSequence_ref="DBSeq1" peptide_ref="Pep1" pre="R" post="A" isDecoy="false"/>
+</SequenceCollection>
+<AnalysisProtocolCollection>
+<SpectrumIdentificationProtocol id="SIP" analysisSoftware_ref="msgf">
+<ModificationParams>
+<SearchModification fixedMod="true" massDelta="57.021464" residues="C"><cvParam accession="UNIMOD:4" cvRef="UNIMOD" name="Carbamidomethyl"/></SearchModification>
+<SearchModification fixedMod="false" massDelta="15.994915" residues="M"><cvParam accession="UNIMOD:35" cvRef="UNIMOD" name="Oxidation"/></SearchModification>
+</ModificationParams>
+</SpectrumIdentificationProtocol>
+</AnalysisProtocolCollection>
+<DataCollection>
+<Inputs><SpectraData id="SD1" location="C:\data\run1.mzML"/></Inputs>
+<AnalysisData>
+<SpectrumIdentificationList id="SIL">
+<SpectrumIdentificationResult id="SIR1" spectrumID="controllerType=0 controllerNumber=1 scan=42" spectraData_ref="SD1">
+<SpectrumIdentificationItem id="SII2" rank="2" chargeState="2" experimentalMassToCharge="500.0" calculatedMassToCharge="499.9" peptide_ref="Pep1">
+<PeptideEvidenceRef peptideEvidence_ref="PE2"/>
+<cvParam accession="MS:1002053" cvRef="PSI-MS" name="MS-GF:EValue" value="0.5"/>
+</SpectrumIdentificationItem>
+<SpectrumIdentificationItem id="SII1" rank="1" chargeState="2" experimentalMassToCharge="400.5" calculatedMassToCharge="400.5" peptide_ref="Pep1">
+<PeptideEvidenceRef peptideEvidence_ref="PE1"/>
+<PeptideEvidenceRef peptideEvidence_ref="PE2"/>
+<cvParam accession="MS:1002049" cvRef="PSI-MS" name="MS-GF:RawScore" value="120"/>
+<cvParam accession="MS:1002053" cvRef="PSI-MS" name="MS-GF:EValue" value="0.001"/>
+</SpectrumIdentificationItem>
+<cvParam accession="MS:1000016" cvRef="PSI-MS" name="scan start time" value="2.5" unitName="minute"/>
+</SpectrumIdentificationResult>
+</SpectrumIdentificationList>
+</AnalysisData>
+</DataCollection>
+</MzIdentML>
+`
+
+func TestPepXML_ReadMzIdentML(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "id")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "run1.mzid")
+	if e := ioutil.WriteFile(f, []byte(testMzIdentML), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	var p PepXML
+	p.DecoyTag = "XXX_"
+	p.ReadMzIdentML(f)
+
+	if p.SearchEngine != "MS-GF+" {
+		t.Errorf("got search engine %s, want MS-GF+", p.SearchEngine)
+	}
+
+	if len(p.PeptideIdentification) != 1 {
+		t.Fatalf("got %d PSMs, want 1", len(p.PeptideIdentification))
+	}
+
+	psm := p.PeptideIdentification[0]
+
+	if psm.Spectrum != "run1.00042.00042.2" || psm.HitRank != 1 || psm.RetentionTime != 150 {
+		t.Errorf("got %s rank %d at %f seconds, want the top hit from run1.00042.00042.2 at 150 seconds", psm.Spectrum, psm.HitRank, psm.RetentionTime)
+	}
+
+	if psm.Protein != "sp|P1|ONE" || string(psm.PrevAA) != "R" {
+		t.Errorf("got protein %s, want the target sp|P1|ONE", psm.Protein)
+	}
+
+	if _, ok := psm.AlternativeProteins["XXX_sp|P2|TWO"]; !ok {
+		t.Errorf("got alternative proteins %v, want the decoy XXX_sp|P2|TWO", psm.AlternativeProteins)
+	}
+
+	if psm.Expectation != 0.001 || math.Abs(psm.SearchScore-3) > 1e-9 {
+		t.Errorf("got expectation %f and search score %f, want 0.001 and 3", psm.Expectation, psm.SearchScore)
+	}
+
+	if psm.ModifiedPeptide != "M[147]C[160]PEPK" {
+		t.Errorf("got modified peptide %s, want M[147]C[160]PEPK", psm.ModifiedPeptide)
+	}
+
+	for _, i := range psm.Modifications.IndexSlice {
+		if i.AminoAcid == "C" && i.Variable {
+			t.Errorf("the fixed carbamidomethylation is marked as variable")
+		}
+		if i.AminoAcid == "M" && (!i.Variable || i.Name != "Oxidation") {
+			t.Errorf("got %+v, want a variable oxidation", i)
+		}
+	}
+
+	p.ScoreName = "MS-GF:RawScore"
+	p.ReadMzIdentML(f)
+
+	if p.PeptideIdentification[0].SearchScore != 120 {
+		t.Errorf("got search score %f, want the raw score 120", p.PeptideIdentification[0].SearchScore)
+	}
+}
+
+func TestPepXML_ReadMzIdentMLIndex(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "id")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	// the peak list identifiers count the spectra from 0
+	doc := strings.Replace(testMzIdentML, `spectrumID="controllerType=0 controllerNumber=1 scan=42"`, `spectrumID="index=1"`, 1)
+	doc = strings.Replace(doc, `location="C:\data\run1.mzML"`, `location="C:\data\run1.mgf"`, 1)
+
+	f := filepath.Join(dir, "run1.mzid")
+	if e := ioutil.WriteFile(f, []byte(doc), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	mgf := "BEGIN IONS\nTITLE=run1.00007.00007.2\nPEPMASS=400.5\n100 1\nEND IONS\nBEGIN IONS\nTITLE=run1.00011.00011.2\nPEPMASS=400.5\n100 1\nEND IONS\n"
+
+	tests := []struct {
+		name     string
+		mgf      bool
+		spectrum string
+	}{
+		{"index without the spectra file", false, "run1.00002.00002.2"},
+		{"index from the spectra file", true, "run1.00011.00011.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if tt.mgf {
+				if e := ioutil.WriteFile(filepath.Join(dir, "run1.mgf"), []byte(mgf), 0644); e != nil {
+					t.Fatal(e)
+				}
+			}
+
+			var p PepXML
+			p.DecoyTag = "XXX_"
+			p.ReadMzIdentML(f)
+
+			if len(p.PeptideIdentification) != 1 || p.PeptideIdentification[0].Spectrum != tt.spectrum {
+				t.Errorf("got %v, want the spectrum %s", p.PeptideIdentification, tt.spectrum)
+			}
+		})
+	}
+}
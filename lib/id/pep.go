@@ -3,6 +3,7 @@ package id
 import (
 	"errors"
 	"fmt"
+	"os"
 	"path"
 	"path/filepath"
 	"sort"
@@ -33,6 +34,7 @@ type PepXML struct {
 	DecoyTag              string
 	Database              string
 	Prophet               string
+	ScoreName             string
 	SearchParameters      []spc.Parameter
 	Models                []spc.DistributionPoint
 	Modifications         mod.Modifications
@@ -63,6 +65,7 @@ func (p PeptideIdentification) SpectrumFileName() SpectrumType {
 type PeptideIdentification struct {
 	Spectrum                         string
 	SpectrumFile                     string
+	SpectraFile                      string
 	Peptide                          string
 	Protein                          string
 	ModifiedPeptide                  string
@@ -78,6 +81,10 @@ type PeptideIdentification struct {
 	CalcNeutralPepMass               float64
 	Massdiff                         float64
 	Probability                      float64
+	QValue                           float64
+	PEP                              float64
+	Rescored                         bool
+	DiscriminantScore                float64
 	Expectation                      float64
 	Xcorr                            float64
 	DeltaCN                          float64
@@ -86,6 +93,7 @@ type PeptideIdentification struct {
 	Nextscore                        float64
 	SpectralSim                      float64
 	Rtscore                          float64
+	SearchScore                      float64
 	IonMobility                      float64
 	Intensity                        float64
 	PrevAA                           []byte
@@ -152,148 +160,235 @@ func (p PepIDListPtrs) Swap(i, j int) {
 // Read is the main function for parsing pepxml data
 func (p *PepXML) Read(f string) {
 
+	p.PeptideIdentification = nil
+
+	p.Stream(f, func(psm PeptideIdentification) {
+		p.PeptideIdentification = append(p.PeptideIdentification, psm)
+	})
+
+	if len(p.FileName) > 0 && len(p.PeptideIdentification) == 0 {
+		msg.NoPSMFound(errors.New(f), "warning")
+	}
+}
+
+// Stream reads the pepXML file one spectrum query at a time and hands over the identification
+// from the top ranked hit of each query. The search parameters and the modifications are set
+// before the first identification is handed over
+func (p *PepXML) Stream(f string, fun func(psm PeptideIdentification)) {
+
 	var xml spc.PepXML
 	logrus.Info("Parsing ", f)
-	xml.Parse(f)
-
-	var mpa = xml.MsmsPipelineAnalysis
-
-	if len(mpa.AnalysisSummary) > 0 {
-		p.FileName = path.Base(f)
-		p.Database = string(mpa.MsmsRunSummary.SearchSummary.SearchDatabase.LocalPath)
-		p.SpectraFile = fmt.Sprintf("%s%s", mpa.MsmsRunSummary.BaseName, mpa.MsmsRunSummary.RawData)
-
-		var models []spc.DistributionPoint
-
-		// collect distribution points from meta
-		for _, i := range mpa.AnalysisSummary[0].PeptideprophetSummary.DistributionPoint {
-			var m spc.DistributionPoint
-			m.Fvalue = i.Fvalue
-			m.Obs1Distr = i.Obs1Distr
-			m.Model1PosDistr = i.Model1PosDistr
-			m.Model1NegDistr = i.Model1NegDistr
-			m.Obs2Distr = i.Obs2Distr
-			m.Model2PosDistr = i.Model2PosDistr
-			m.Model2NegDistr = i.Model2NegDistr
-			m.Obs3Distr = i.Obs3Distr
-			m.Model3PosDistr = i.Model3PosDistr
-			m.Model3NegDistr = i.Model3NegDistr
-			m.Obs4Distr = i.Obs4Distr
-			m.Model4PosDistr = i.Model4PosDistr
-			m.Model4NegDistr = i.Model4NegDistr
-			m.Obs5Distr = i.Obs5Distr
-			m.Model5PosDistr = i.Model5PosDistr
-			m.Model5NegDistr = i.Model5NegDistr
-			m.Obs6Distr = i.Obs6Distr
-			m.Model6PosDistr = i.Model6PosDistr
-			m.Model6NegDistr = i.Model6NegDistr
-			m.Obs7Distr = i.Obs7Distr
-			m.Model7PosDistr = i.Model7PosDistr
-			m.Model7NegDistr = i.Model7NegDistr
-			models = append(models, m)
+
+	// number of header elements seen, a new search summary may bring more modifications
+	var header = -1
+
+	xml.Stream(f, false, func(sq spc.SpectrumQuery) {
+
+		var mpa = &xml.MsmsPipelineAnalysis
+
+		if len(mpa.AnalysisSummary) == 0 {
+			return
 		}
 
+		ss := mpa.MsmsRunSummary.SearchSummary
+		if n := len(ss.AminoAcidModifications) + len(ss.TerminalModifications) + len(ss.Parameter); n != header {
+			p.readHeader(f, mpa)
+			header = n
+		}
+
+		// merged files hold one run summary for each spectra file
+		psm := processSpectrumQuery(sq, p.Modifications, p.DecoyTag, p.FileName, p.ScoreName)
+		psm.SpectraFile = fmt.Sprintf("%s%s", mpa.MsmsRunSummary.BaseName, mpa.MsmsRunSummary.RawData)
+
+		fun(psm)
+	})
+
+	// files without identifications still carry their header
+	if header < 0 && len(xml.MsmsPipelineAnalysis.AnalysisSummary) > 0 {
+		p.readHeader(f, &xml.MsmsPipelineAnalysis)
+	}
+}
+
+// readHeader collects the models, modifications and search parameters from the pepXML header
+func (p *PepXML) readHeader(f string, mpa *spc.MsmsPipelineAnalysis) {
+
+	p.FileName = path.Base(f)
+	p.Database = string(mpa.MsmsRunSummary.SearchSummary.SearchDatabase.LocalPath)
+	p.SpectraFile = fmt.Sprintf("%s%s", mpa.MsmsRunSummary.BaseName, mpa.MsmsRunSummary.RawData)
+
+	var models []spc.DistributionPoint
+
+	// collect distribution points from meta
+	for _, i := range mpa.AnalysisSummary[0].PeptideprophetSummary.DistributionPoint {
+		var m spc.DistributionPoint
+		m.Fvalue = i.Fvalue
+		m.Obs1Distr = i.Obs1Distr
+		m.Model1PosDistr = i.Model1PosDistr
+		m.Model1NegDistr = i.Model1NegDistr
+		m.Obs2Distr = i.Obs2Distr
+		m.Model2PosDistr = i.Model2PosDistr
+		m.Model2NegDistr = i.Model2NegDistr
+		m.Obs3Distr = i.Obs3Distr
+		m.Model3PosDistr = i.Model3PosDistr
+		m.Model3NegDistr = i.Model3NegDistr
+		m.Obs4Distr = i.Obs4Distr
+		m.Model4PosDistr = i.Model4PosDistr
+		m.Model4NegDistr = i.Model4NegDistr
+		m.Obs5Distr = i.Obs5Distr
+		m.Model5PosDistr = i.Model5PosDistr
+		m.Model5NegDistr = i.Model5NegDistr
+		m.Obs6Distr = i.Obs6Distr
+		m.Model6PosDistr = i.Model6PosDistr
+		m.Model6NegDistr = i.Model6NegDistr
+		m.Obs7Distr = i.Obs7Distr
+		m.Model7PosDistr = i.Model7PosDistr
+		m.Model7NegDistr = i.Model7NegDistr
+		models = append(models, m)
+	}
+
+	if p.Modifications.Index == nil {
 		p.Modifications.Index = make(map[string]mod.Modification)
+	}
 
-		// get the search engine
-		p.SearchEngine = string(mpa.MsmsRunSummary.SearchSummary.SearchEngine)
-		if strings.Contains(string(mpa.MsmsRunSummary.SearchSummary.SearchEngineVersion), "MSFragger") {
-			p.SearchEngine = "MSFragger"
-		}
+	// get the search engine
+	p.SearchEngine = string(mpa.MsmsRunSummary.SearchSummary.SearchEngine)
+	if strings.Contains(string(mpa.MsmsRunSummary.SearchSummary.SearchEngineVersion), "MSFragger") {
+		p.SearchEngine = "MSFragger"
+	}
 
-		// map internal modifications from file
-		for _, i := range mpa.MsmsRunSummary.SearchSummary.AminoAcidModifications {
+	// map internal modifications from file
+	for _, i := range mpa.MsmsRunSummary.SearchSummary.AminoAcidModifications {
 
-			key := fmt.Sprintf("%s#%.4f", i.AminoAcid, i.Mass)
-			variableStr := string(i.Variable)
-			if variableStr != "Y" && variableStr != "N" {
-				panic(nil)
+		key := fmt.Sprintf("%s#%.4f", i.AminoAcid, i.Mass)
+		variableStr := string(i.Variable)
+		if variableStr != "Y" && variableStr != "N" {
+			panic(nil)
+		}
+		variable := variableStr == "Y"
+		_, ok := p.Modifications.Index[key]
+		if !ok {
+			m := mod.Modification{
+				Index:     key,
+				Type:      mod.Assigned,
+				MassDiff:  uti.ToFixed(i.MassDiff, 4),
+				Variable:  variable,
+				AminoAcid: string(i.AminoAcid),
 			}
-			variable := variableStr == "Y"
-			_, ok := p.Modifications.Index[key]
-			if !ok {
-				m := mod.Modification{
-					Index:     key,
-					Type:      mod.Assigned,
-					MassDiff:  uti.ToFixed(i.MassDiff, 4),
-					Variable:  variable,
-					AminoAcid: string(i.AminoAcid),
-				}
 
-				p.Modifications.Index[key] = m
-			}
+			p.Modifications.Index[key] = m
 		}
+	}
 
-		// map terminal modifications from file
-		for _, i := range mpa.MsmsRunSummary.SearchSummary.TerminalModifications {
+	// map terminal modifications from file
+	for _, i := range mpa.MsmsRunSummary.SearchSummary.TerminalModifications {
 
-			key := fmt.Sprintf("%s-term#%.4f", strings.ToUpper(string(i.Terminus)), i.Mass)
-			variableStr := string(i.Variable)
-			if variableStr != "Y" && variableStr != "N" {
-				panic(nil)
+		key := fmt.Sprintf("%s-term#%.4f", strings.ToUpper(string(i.Terminus)), i.Mass)
+		variableStr := string(i.Variable)
+		if variableStr != "Y" && variableStr != "N" {
+			panic(nil)
+		}
+		variable := variableStr == "Y"
+		_, ok := p.Modifications.Index[key]
+		if !ok {
+
+			m := mod.Modification{
+				Index:     key,
+				Type:      mod.Assigned,
+				MassDiff:  uti.ToFixed(i.MassDiff, 4),
+				Variable:  variable,
+				AminoAcid: fmt.Sprintf("%s-term", i.Terminus),
 			}
-			variable := variableStr == "Y"
-			_, ok := p.Modifications.Index[key]
-			if !ok {
-
-				m := mod.Modification{
-					Index:     key,
-					Type:      mod.Assigned,
-					MassDiff:  uti.ToFixed(i.MassDiff, 4),
-					Variable:  variable,
-					AminoAcid: fmt.Sprintf("%s-term", i.Terminus),
-				}
 
-				p.Modifications.Index[key] = m
-			}
+			p.Modifications.Index[key] = m
 		}
+	}
 
-		for _, i := range xml.MsmsPipelineAnalysis.MsmsRunSummary.SearchSummary.Parameter {
-			par := &spc.Parameter{
-				Name:  i.Name,
-				Value: i.Value,
-			}
-			p.SearchParameters = append(p.SearchParameters, *par)
-
+	p.SearchParameters = nil
+	for _, i := range mpa.MsmsRunSummary.SearchSummary.Parameter {
+		par := &spc.Parameter{
+			Name:  i.Name,
+			Value: i.Value,
 		}
+		p.SearchParameters = append(p.SearchParameters, *par)
 
-		//massDeviation := getMassDeviation(mpa.MsmsRunSummary.SpectrumQuery)
+	}
 
-		// start processing spectra queries
-		sq := mpa.MsmsRunSummary.SpectrumQuery
-		p.PeptideIdentification = make(PepIDList, len(sq), len(sq))
-		for idx, i := range sq {
-			p.PeptideIdentification[idx] = processSpectrumQuery(i, p.Modifications, p.DecoyTag, p.FileName)
-		}
+	p.Prophet = string(mpa.AnalysisSummary[0].Analysis)
+	p.Models = models
+}
 
-		p.Prophet = string(mpa.AnalysisSummary[0].Analysis)
-		p.Models = models
+// ReadPepXMLInput reads one or more fies and organize the data into PSM list. Besides pepXML, the
+// input can be MSFragger tsv or mzIdentML files, and the engine score named by searchScore is kept
+// as the search score of each PSM
+func ReadPepXMLInput(xmlFile, decoyTag, temp string, models bool, searchScore string) (PepIDListPtrs, string) {
 
-		// p.adjustMassDeviation()
+	files := ListInputFiles(xmlFile)
 
-		if len(p.PeptideIdentification) == 0 {
-			msg.NoPSMFound(errors.New(f), "warning")
+	for _, i := range files {
+		if IsFraggerTSV(i) || IsMzIdentML(i) {
+			logrus.Warn("MSFragger tsv and mzIdentML files carry no PeptideProphet probabilities, use a score to rank the PSMs")
+			break
 		}
+	}
+
+	pepXML := ReadInputFiles(files, decoyTag, temp, models, searchScore)
 
+	// serialize all pep files
+	sort.Sort(pepXML.PeptideIdentification)
+	pepXML.Serialize()
+
+	return pepXML.PeptideIdentification, pepXML.SearchEngine
+}
+
+// ReadRescoredInput reads the PSMs rescored by the percolator or rescore commands from the
+// workspace, in place of the identification files
+func ReadRescoredInput(f, command string) (PepIDListPtrs, string) {
+
+	if _, e := os.Stat(f); e != nil {
+		msg.Custom(fmt.Errorf("cannot find the rescored PSMs in the workspace, run the %s command first", command), "error")
 	}
+
+	var pepXML PepXML4Serialiazation
+	sys.Restore(&pepXML, f, false)
+
+	logrus.Info("Using ", len(pepXML.PeptideIdentification), " PSMs rescored by the ", command, " command")
+
+	// serialize all pep files
+	sort.Sort(pepXML.PeptideIdentification)
+	pepXML.Serialize()
+
+	return pepXML.PeptideIdentification, pepXML.SearchEngine
 }
 
-// ReadPepXMLInput reads one or more fies and organize the data into PSM list
-func ReadPepXMLInput(xmlFile, decoyTag, temp string, models bool) (PepIDListPtrs, string) {
+// ListInputFiles returns the identification files given as a file or as a directory, where
+// PTMProphet results are preferred over PeptideProphet ones, and MSFragger tsv or mzIdentML
+// files are used when there are no pepXML files
+func ListInputFiles(xmlFile string) []string {
 
 	var files = make(map[string]struct{})
-	var params []spc.Parameter
-	var modsIndex = make(map[string]mod.Modification)
-	var searchEngine string
 
-	if strings.Contains(xmlFile, "pep.xml") || strings.Contains(xmlFile, "pepXML") {
+	if strings.Contains(xmlFile, "pep.xml") || strings.Contains(xmlFile, "pepXML") || IsFraggerTSV(xmlFile) || IsMzIdentML(xmlFile) {
 		files[xmlFile] = struct{}{}
 	} else {
 
 		list := uti.IOReadDir(xmlFile, "pep.xml")
 
+		// MSFragger tsv and mzIdentML results are used when the folder has no pepXML files
 		if len(list) == 0 {
-			msg.NoParametersFound(errors.New("missing PeptideProphet pepXML files"), "error")
+			for _, i := range uti.IOReadDir(xmlFile, ".tsv") {
+				if IsFraggerTSV(i) {
+					files[i] = struct{}{}
+				}
+			}
+			for _, i := range uti.IOReadDir(xmlFile, ".mzid") {
+				if IsMzIdentML(i) {
+					files[i] = struct{}{}
+				}
+			}
+		}
+
+		if len(list) == 0 && len(files) == 0 {
+			msg.NoParametersFound(errors.New("missing PeptideProphet pepXML, MSFragger tsv or mzIdentML files"), "error")
 		}
 
 		// in case both PeptideProphet and PTMProphet files are present, use
@@ -317,23 +412,44 @@ func ReadPepXMLInput(xmlFile, decoyTag, temp string, models bool) (PepIDListPtrs
 
 	}
 
-	pepIdentList := make([]PepIDList, len(files))
 	sortedFiles := make([]string, 0, len(files))
 	for i := range files {
 		sortedFiles = append(sortedFiles, i)
 	}
 	sort.Strings(sortedFiles)
+
+	return sortedFiles
+}
+
+// ReadInputFiles reads the identification files into a single list of PSMs, where spectra matching
+// both targets and decoys are promoted to target hits
+func ReadInputFiles(sortedFiles []string, decoyTag, temp string, models bool, searchScore string) PepXML4Serialiazation {
+
+	var params []spc.Parameter
+	var modsIndex = make(map[string]mod.Modification)
+	var searchEngine string
+
+	pepIdentList := make([]PepIDList, len(sortedFiles))
 	mu := sync.Mutex{}
 	processSinglePepXML := func(idx int, i string) {
 		var p PepXML
 		p.DecoyTag = decoyTag
-		p.Read(i)
+		p.ScoreName = searchScore
+		if IsFraggerTSV(i) {
+			p.ReadFraggerTSV(i)
+		} else if IsMzIdentML(i) {
+			p.ReadMzIdentML(i)
+		} else {
+			p.Read(i)
+		}
 		if idx == 0 {
 			params = p.SearchParameters
 		}
 
 		// print models
-		if models {
+		if models && len(p.Models) == 0 {
+			logrus.Warn("No models found in ", filepath.Base(i))
+		} else if models {
 			if strings.EqualFold(p.Prophet, "interprophet") {
 				logrus.Error("Cannot print models for interprophet files")
 			} else {
@@ -368,9 +484,11 @@ func ReadPepXMLInput(xmlFile, decoyTag, temp string, models bool) (PepIDListPtrs
 		}(idx, i)
 	}
 	wg.Wait()
+
 	// create a "fake" global pepXML comprising all data
 	var pepXML PepXML4Serialiazation
 	pepXML.DecoyTag = decoyTag
+	pepXML.SearchEngine = searchEngine
 	pepXML.SearchParameters = params
 	pepXML.PeptideIdentification = make(PepIDListPtrs, 0)
 	for _, pepIdent := range pepIdentList {
@@ -383,14 +501,10 @@ func ReadPepXMLInput(xmlFile, decoyTag, temp string, models bool) (PepIDListPtrs
 	// promoting Spectra that matches to both decoys and targets to TRUE hits
 	pepXML.PromoteProteinIDs()
 
-	// serialize all pep files
-	sort.Sort(pepXML.PeptideIdentification)
-	pepXML.Serialize()
-
-	return pepXML.PeptideIdentification, searchEngine
+	return pepXML
 }
 
-func processSpectrumQuery(sq spc.SpectrumQuery, mods mod.Modifications, decoyTag, FileName string) PeptideIdentification {
+func processSpectrumQuery(sq spc.SpectrumQuery, mods mod.Modifications, decoyTag, FileName, scoreName string) PeptideIdentification {
 
 	var psm PeptideIdentification
 	psm.AlternativeProteins = make(map[string]string)
@@ -454,6 +568,10 @@ func processSpectrumQuery(sq spc.SpectrumQuery, mods mod.Modifications, decoyTag
 		}
 
 		for _, j := range i.Score {
+			if len(scoreName) > 0 && strings.EqualFold(string(j.Name), scoreName) {
+				value, _ := uti.ParseFloat(j.Value)
+				psm.SearchScore = normalizeScore(scoreName, value)
+			}
 			if string(j.Name) == "expect" {
 				eValue, _ := uti.ParseFloat(j.Value)
 				psm.Expectation = eValue
@@ -495,6 +613,7 @@ func processSpectrumQuery(sq spc.SpectrumQuery, mods mod.Modifications, decoyTag
 		psm.Spectrum = string(sq.Spectrum)
 
 		psm.mapModsFromPepXML(i.ModificationInfo, mods)
+		psm.defaultSearchScore(scoreName)
 	}
 
 	return psm
@@ -25,6 +25,7 @@ type ProtXML struct {
 	FileName   string
 	DecoyTag   string
 	RunOptions string
+	Posteriors bool
 	Groups     GroupList
 }
 
@@ -41,6 +42,7 @@ type ProteinIdentification struct {
 	ProteinName              string
 	Description              string
 	GroupSiblingID           string
+	SubsumingProtein         string
 	UniqueStrippedPeptides   []string
 	IndistinguishableProtein []string
 	GroupNumber              uint32
@@ -50,6 +52,8 @@ type ProteinIdentification struct {
 	PercentCoverage          float32
 	Probability              float64
 	TopPepProb               float64
+	QValue                   float64
+	PEP                      float64
 	PeptideIons              []PeptideIonIdentification
 	HasRazor                 bool
 }
@@ -146,6 +150,7 @@ func (p *ProtXML) Read(f string) {
 			ptid.Probability = j.Probability
 			ptid.PercentCoverage = j.PercentCoverage
 			ptid.GroupSiblingID = string(j.GroupSiblingID)
+			ptid.SubsumingProtein = string(j.SubsumingProteinEntry)
 			ptid.TotalNumberPeptides = j.TotalNumberPeptides
 			ptid.TopPepProb = 0
 
@@ -0,0 +1,76 @@
+package id
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/psi"
+)
+
+// searchScore keeps the engine score with the given name or accession as the PSM search score, it
+// reports false when the score is missing
+func (p *PeptideIdentification) searchScore(cv []psi.CVParam, user []psi.UserParam, name string) bool {
+
+	if len(name) == 0 {
+		return true
+	}
+
+	var values []string
+	for _, i := range cv {
+		if strings.EqualFold(i.Name, name) || strings.EqualFold(i.Accession, name) {
+			values = append(values, i.Value)
+		}
+	}
+
+	for _, i := range user {
+		if strings.EqualFold(i.Name, name) {
+			values = append(values, i.Value)
+		}
+	}
+
+	for _, i := range values {
+
+		value, e := strconv.ParseFloat(i, 64)
+		if e != nil {
+			continue
+		}
+
+		p.SearchScore = normalizeScore(name, value)
+		return true
+	}
+
+	return false
+}
+
+// defaultSearchScore uses the expectation value as the search score when no engine score was selected
+func (p *PeptideIdentification) defaultSearchScore(name string) {
+	if len(name) == 0 && p.Expectation > 0 {
+		p.SearchScore = normalizeScore("expect", p.Expectation)
+	}
+}
+
+// lowerIsBetter tells if lower values of the named score mean better identifications
+func lowerIsBetter(name string) bool {
+
+	name = strings.ToLower(name)
+
+	for _, i := range []string{"expect", "evalue", "e-value", "pvalue", "p-value", "qvalue", "q-value", "posterior error"} {
+		if strings.Contains(name, i) {
+			return true
+		}
+	}
+
+	return strings.HasSuffix(name, ":pep") || name == "pep"
+}
+
+// normalizeScore turns scores where lower values are better, like expectation values, into their
+// negative log, so that higher search scores are always better
+func normalizeScore(name string, value float64) float64 {
+
+	if !lowerIsBetter(name) {
+		return value
+	}
+
+	return -math.Log10(math.Max(value, math.SmallestNonzeroFloat64))
+}
@@ -0,0 +1,246 @@
+package id
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/mod"
+	"philosopher/lib/msg"
+	"philosopher/lib/uti"
+
+	"github.com/sirupsen/logrus"
+)
+
+// fraggerColumns are the columns every MSFragger tsv file carries
+var fraggerColumns = []string{"scannum", "charge", "peptide", "protein", "hyperscore"}
+
+// fraggerMod matches the entries of the modification_info column, like 5C(57.0215) or N-term(42.0106)
+var fraggerMod = regexp.MustCompile(`^(\d*)([A-Za-z-]+)\((-?[\d.]+)\)$`)
+
+// IsFraggerTSV checks if the file is a tab-separated MSFragger result file
+func IsFraggerTSV(f string) bool {
+
+	if !strings.EqualFold(filepath.Ext(f), ".tsv") {
+		return false
+	}
+
+	file, e := os.Open(f)
+	if e != nil {
+		return false
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+	if !scanner.Scan() {
+		return false
+	}
+
+	var header = make(map[string]struct{})
+	for _, i := range strings.Split(scanner.Text(), "\t") {
+		header[strings.TrimSpace(i)] = struct{}{}
+	}
+
+	for _, i := range fraggerColumns {
+		if _, ok := header[i]; !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ReadFraggerTSV parses the tab-separated output from MSFragger, keeping the top ranked hit for
+// each spectrum. The file has no header describing the search, so the modifications are
+// collected from the PSMs
+func (p *PepXML) ReadFraggerTSV(f string) {
+
+	logrus.Info("Parsing ", f)
+
+	file, e := os.Open(f)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+	defer file.Close()
+
+	p.FileName = path.Base(f)
+	p.SpectraFile = strings.TrimSuffix(p.FileName, filepath.Ext(p.FileName))
+	p.SearchEngine = "MSFragger"
+	p.PeptideIdentification = nil
+	p.Modifications.Index = make(map[string]mod.Modification)
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	var col = make(map[string]int)
+	if scanner.Scan() {
+		for i, j := range strings.Split(scanner.Text(), "\t") {
+			col[strings.TrimSpace(j)] = i
+		}
+	}
+
+	for _, i := range fraggerColumns {
+		if _, ok := col[i]; !ok {
+			msg.Custom(fmt.Errorf("%s is not an MSFragger tsv file, the %s column is missing", f, i), "error")
+		}
+	}
+
+	// the position of each scan in the list, used to keep the top ranked hit
+	var scans = make(map[string]int)
+
+	for scanner.Scan() {
+
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		psm := p.fraggerPSM(strings.Split(line, "\t"), col)
+
+		if i, ok := scans[psm.Spectrum]; ok {
+			if psm.HitRank < p.PeptideIdentification[i].HitRank {
+				p.PeptideIdentification[i] = psm
+			}
+			continue
+		}
+
+		scans[psm.Spectrum] = len(p.PeptideIdentification)
+		p.PeptideIdentification = append(p.PeptideIdentification, psm)
+	}
+
+	if e := scanner.Err(); e != nil {
+		msg.ReadFile(fmt.Errorf("%s: %s", f, e), "error")
+	}
+
+	p.indexModifications(nil)
+
+	if len(p.PeptideIdentification) == 0 {
+		msg.NoPSMFound(errors.New(f), "warning")
+	}
+}
+
+// fraggerPSM converts one row of the MSFragger tsv file into a peptide identification
+func (p *PepXML) fraggerPSM(row []string, col map[string]int) PeptideIdentification {
+
+	field := func(name string) string {
+		i, ok := col[name]
+		if !ok || i >= len(row) {
+			return ""
+		}
+		return strings.TrimSpace(row[i])
+	}
+
+	number := func(name string) float64 {
+		v, _ := strconv.ParseFloat(field(name), 64)
+		return v
+	}
+
+	var psm PeptideIdentification
+	psm.AlternativeProteins = make(map[string]string)
+
+	scan, _ := strconv.Atoi(field("scannum"))
+	charge, _ := strconv.Atoi(field("charge"))
+	rank, _ := strconv.Atoi(field("hit_rank"))
+	ntt, _ := strconv.Atoi(field("num_tol_term"))
+	nmc, _ := strconv.Atoi(field("num_missed_cleavages"))
+
+	if rank == 0 {
+		rank = 1
+	}
+
+	psm.Index = uint32(scan)
+	psm.Spectrum = fmt.Sprintf("%s.%05d.%05d.%d", p.SpectraFile, scan, scan, charge)
+	psm.SpectrumFile = p.FileName
+	psm.SpectraFile = p.SpectraFile
+	psm.AssumedCharge = uint8(charge)
+	psm.HitRank = uint8(rank)
+	psm.RetentionTime = number("retention_time")
+	psm.IonMobility = number("ion_mobility")
+	psm.CompensationVoltage = field("compensation_voltage")
+	psm.PrecursorNeutralMass = number("precursor_neutral_mass")
+	psm.UncalibratedPrecursorNeutralMass = psm.PrecursorNeutralMass
+	psm.CalcNeutralPepMass = number("calc_neutral_pep_mass")
+	psm.Massdiff = uti.ToFixed(number("massdiff"), 4)
+	psm.NumberOfEnzymaticTermini = uint8(ntt)
+	psm.NumberofMissedCleavages = uint8(nmc)
+	psm.Hyperscore = number("hyperscore")
+	psm.Nextscore = number("nextscore")
+	psm.Expectation = number("expectscore")
+
+	if len(p.ScoreName) > 0 {
+		psm.SearchScore = normalizeScore(p.ScoreName, number(p.ScoreName))
+	}
+	psm.defaultSearchScore(p.ScoreName)
+
+	psm.Peptide = field("peptide")
+	psm.Protein = field("protein")
+	psm.PrevAA = []byte(field("peptide_prev_aa"))
+	psm.NextAA = []byte(field("peptide_next_aa"))
+
+	// the alternative proteins are listed in the last column, or spread over the following ones,
+	// without their flanking residues, so the ones from the reference protein are used
+	if i, ok := col["alternative_proteins"]; ok && i < len(row) {
+		for _, j := range row[i:] {
+			for _, k := range strings.FieldsFunc(j, func(r rune) bool { return r == '@' || r == ';' }) {
+				k = strings.TrimSpace(k)
+				if len(k) > 0 && k != psm.Protein {
+					psm.AlternativeProteins[k] = string(psm.PrevAA) + "#" + string(psm.NextAA)
+				}
+			}
+		}
+	}
+
+	if len(field("best_locs")+field("best_score_with_delta_mass")+field("score_without_delta_mass")) != 0 {
+		psm.MSFragerLoc = &MSFraggerLoc{
+			MSFragerLocalization:                 field("best_locs"),
+			MSFraggerLocalizationScoreWithPTM:    field("best_score_with_delta_mass"),
+			MSFraggerLocalizationScoreWithoutPTM: field("score_without_delta_mass")}
+	}
+
+	psm.mapModsFromFraggerTSV(field("modification_info"))
+
+	return psm
+}
+
+// mapModsFromFraggerTSV reads the modification_info column, like 5C(57.0215), N-term(42.0106)
+func (p *PeptideIdentification) mapModsFromFraggerTSV(info string) {
+
+	var shifts []massShift
+
+	for _, i := range strings.Split(info, ",") {
+
+		i = strings.TrimSpace(i)
+		if len(i) == 0 {
+			continue
+		}
+
+		match := fraggerMod.FindStringSubmatch(i)
+		if match == nil {
+			msg.Custom(fmt.Errorf("cannot read the modification %s from %s", i, p.Spectrum), "warning")
+			continue
+		}
+
+		var shift massShift
+		shift.MassDiff, _ = strconv.ParseFloat(match[3], 64)
+
+		if len(match[1]) > 0 {
+			shift.Position, _ = strconv.Atoi(match[1])
+		} else if strings.EqualFold(match[2], "c-term") || strings.EqualFold(match[2], "c") {
+			shift.Position = len(p.Peptide) + 1
+		} else if !strings.EqualFold(match[2], "n-term") && !strings.EqualFold(match[2], "n") {
+			msg.Custom(fmt.Errorf("cannot read the modification %s from %s", i, p.Spectrum), "warning")
+			continue
+		}
+
+		shifts = append(shifts, shift)
+	}
+
+	p.mapModsFromMassShifts(shifts)
+}
@@ -0,0 +1,80 @@
+package id
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testFraggerTSV = "scannum\tprecursor_neutral_mass\tretention_time\tcharge\thit_rank\tpeptide\tpeptide_prev_aa\tpeptide_next_aa\tprotein\tmodification_info\tcalc_neutral_pep_mass\tmassdiff\tnum_tol_term\tnum_missed_cleavages\thyperscore\tnextscore\texpectscore\tbest_locs\tscore_without_delta_mass\tbest_score_with_delta_mass\talternative_proteins\n" +
+	"10\t1000.5\t120.5\t2\t2\tSECONDK\tR\tA\tP2\t\t1000.4\t0.1\t2\t0\t10.0\t8.0\t0.5\t\t\t\t\n" +
+	"10\t1000.5\t120.5\t2\t1\tMCPEPK\tK\tL\tP1\t1M(15.9949), 2C(57.0215), N-term(42.0106)\t1000.5\t0.0\t2\t0\t25.5\t10.0\t0.001\t\t\t\tP3@rev_P4\n" +
+	"11\t900.2\t130.0\t3\t1\tCMK\tK\tL\trev_P5\t1C(57.0215)\t900.2\t0.0\t1\t1\t12.0\t11.0\t0.2\tcaK\t3.0\t5.0\t\n"
+
+func TestPepXML_ReadFraggerTSV(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "id")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "run1.tsv")
+	if e := ioutil.WriteFile(f, []byte(testFraggerTSV), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	if !IsFraggerTSV(f) {
+		t.Fatalf("%s was not recognized as an MSFragger tsv file", f)
+	}
+
+	var p PepXML
+	p.ReadFraggerTSV(f)
+
+	if len(p.PeptideIdentification) != 2 {
+		t.Fatalf("got %d PSMs, want 2", len(p.PeptideIdentification))
+	}
+
+	psm := p.PeptideIdentification[0]
+
+	if psm.Spectrum != "run1.00010.00010.2" || psm.Peptide != "MCPEPK" || psm.HitRank != 1 {
+		t.Errorf("got %s %s rank %d, want the top hit from run1.00010.00010.2", psm.Spectrum, psm.Peptide, psm.HitRank)
+	}
+
+	if psm.Hyperscore != 25.5 || psm.Nextscore != 10.0 || psm.Expectation != 0.001 {
+		t.Errorf("got scores %f %f %f, want 25.5 10.0 0.001", psm.Hyperscore, psm.Nextscore, psm.Expectation)
+	}
+
+	if psm.ModifiedPeptide != "n[43]M[147]C[160]PEPK" {
+		t.Errorf("got modified peptide %s, want n[43]M[147]C[160]PEPK", psm.ModifiedPeptide)
+	}
+
+	if _, ok := psm.AlternativeProteins["P3"]; !ok || len(psm.AlternativeProteins) != 2 {
+		t.Errorf("got alternative proteins %v, want P3 and rev_P4", psm.AlternativeProteins)
+	}
+
+	var mods []string
+	for _, i := range psm.Modifications.IndexSlice {
+		if i.AminoAcid == "C" && i.Variable {
+			t.Errorf("carbamidomethylation on every cysteine should be fixed")
+		}
+		if i.AminoAcid == "M" && (!i.Variable || i.Position != 1) {
+			t.Errorf("got methionine oxidation %+v, want a variable modification on position 1", i)
+		}
+		mods = append(mods, i.Index)
+	}
+
+	if len(mods) != 4 {
+		t.Errorf("got modifications %s, want 3 assigned and 1 observed", strings.Join(mods, ", "))
+	}
+
+	if _, ok := p.Modifications.Index["M#147.0354"]; !ok {
+		t.Errorf("got modification index %v, want M#147.0354", p.Modifications.Index)
+	}
+
+	if p.PeptideIdentification[1].MSFragerLoc == nil || p.PeptideIdentification[1].MSFragerLoc.MSFragerLocalization != "caK" {
+		t.Errorf("the localization of %s is missing", p.PeptideIdentification[1].Spectrum)
+	}
+}
@@ -0,0 +1,370 @@
+package inf
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"philosopher/lib/id"
+
+	"github.com/sirupsen/logrus"
+)
+
+// FidoParameters are the protein prior, gamma, the probability of a present protein emitting each
+// of its peptides, alpha, and the probability of a peptide being observed by noise, beta
+type FidoParameters struct {
+	Gamma float64
+	Alpha float64
+	Beta  float64
+}
+
+// maxExact is the largest number of proteins in a group for the exact posterior, the bigger
+// groups are approximated by the mean field. The exact posterior visits every configuration of
+// the group for each parameter of the grid search, 1024 of them at most
+const maxExact = 10
+
+// fidoWeight balances the ranking of targets over decoys against the calibration of the
+// posteriors when tuning the parameters
+const fidoWeight = 0.15
+
+// fidoNode is a protein node with the indices of its peptides in the group
+type fidoNode struct {
+	Members  []string
+	Peptides []int
+	Decoy    bool
+}
+
+// fidoGroup is a connected component of the protein graph
+type fidoGroup struct {
+	Nodes    []fidoNode
+	Peptides []float64
+}
+
+// Fido scores the proteins with a Bayesian network of the proteins and their peptides, as in
+// Serang et al. 2010. The peptide probabilities come from their PEPs, and the parameters are tuned
+// by grid search on the target-decoy ranking. It returns the posterior of each protein
+func Fido(psm id.PepIDList, decoyTag string) map[string]float64 {
+
+	groups := fidoGraph(psm, decoyTag)
+
+	params := FidoParameters{Gamma: 0.5, Alpha: 0.1, Beta: 0.01}
+	best := math.Inf(-1)
+
+	for _, gamma := range []float64{0.1, 0.25, 0.5, 0.75, 0.9} {
+		for _, alpha := range []float64{0.01, 0.04, 0.09, 0.16, 0.25, 0.36, 0.5} {
+			for _, beta := range []float64{0, 0.01, 0.025, 0.05} {
+
+				p := FidoParameters{Gamma: gamma, Alpha: alpha, Beta: beta}
+
+				posteriors, decoys := fidoPosteriors(groups, p)
+				objective, ok := fidoObjective(posteriors, decoys)
+
+				if ok && objective > best {
+					best = objective
+					params = p
+				}
+			}
+		}
+	}
+
+	if math.IsInf(best, -1) {
+		logrus.Warn("there are no decoy proteins to tune the protein model, using the default parameters")
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"gamma": params.Gamma,
+		"alpha": params.Alpha,
+		"beta":  params.Beta,
+	}).Info("Scoring proteins with the Bayesian protein model")
+
+	return fidoScore(groups, params)
+}
+
+// fidoScore returns the posterior of each protein with the given parameters
+func fidoScore(groups []fidoGroup, params FidoParameters) map[string]float64 {
+
+	var scores = make(map[string]float64)
+
+	for _, g := range groups {
+		posteriors := groupPosteriors(g, params)
+		for i, j := range g.Nodes {
+			for _, k := range j.Members {
+				scores[k] = posteriors[i]
+			}
+		}
+	}
+
+	return scores
+}
+
+// fidoGraph builds the protein groups with the probability of each peptide, one minus its best PEP
+func fidoGraph(psm id.PepIDList, decoyTag string) []fidoGroup {
+
+	var peptideProbability = make(map[string]float64)
+	for _, i := range psm {
+		if p := 1 - i.PEP; p > peptideProbability[i.Peptide] {
+			peptideProbability[i.Peptide] = p
+		}
+	}
+
+	var groups []fidoGroup
+
+	for _, c := range proteinGraph(psm) {
+
+		var g fidoGroup
+		var index = make(map[string]int)
+
+		for _, i := range c {
+
+			// without a decoy tag there are no decoys
+			var n = fidoNode{Members: i.Members, Decoy: len(decoyTag) > 0}
+			for _, j := range i.Members {
+				if !strings.HasPrefix(j, decoyTag) {
+					n.Decoy = false
+				}
+			}
+
+			var peptides []string
+			for k := range i.Peptides {
+				peptides = append(peptides, k)
+			}
+			sort.Strings(peptides)
+
+			for _, k := range peptides {
+				j, ok := index[k]
+				if !ok {
+					j = len(g.Peptides)
+					index[k] = j
+					g.Peptides = append(g.Peptides, peptideProbability[k])
+				}
+				n.Peptides = append(n.Peptides, j)
+			}
+
+			g.Nodes = append(g.Nodes, n)
+		}
+
+		groups = append(groups, g)
+	}
+
+	return groups
+}
+
+// fidoPosteriors returns the posterior of each protein node, and whether it is a decoy
+func fidoPosteriors(groups []fidoGroup, params FidoParameters) ([]float64, []bool) {
+
+	var posteriors []float64
+	var decoys []bool
+
+	for _, g := range groups {
+		posteriors = append(posteriors, groupPosteriors(g, params)...)
+		for _, i := range g.Nodes {
+			decoys = append(decoys, i.Decoy)
+		}
+	}
+
+	return posteriors, decoys
+}
+
+// groupPosteriors marginalizes the protein configurations of a group. A peptide is emitted with
+// probability 1 - (1 - beta)(1 - alpha)^n by n present proteins, and its observation is weighted
+// by its probability
+func groupPosteriors(g fidoGroup, params FidoParameters) []float64 {
+
+	n := len(g.Nodes)
+	if n > maxExact {
+		return meanFieldPosteriors(g, params)
+	}
+
+	var proteins = make([][]int, len(g.Peptides))
+	for i, j := range g.Nodes {
+		for _, k := range j.Peptides {
+			proteins[k] = append(proteins[k], i)
+		}
+	}
+
+	var logWeights = make([]float64, 1<<uint(n))
+	var max = math.Inf(-1)
+
+	for mask := range logWeights {
+
+		var w float64
+		for i := 0; i < n; i++ {
+			if mask&(1<<uint(i)) != 0 {
+				w += math.Log(params.Gamma)
+			} else {
+				w += math.Log(1 - params.Gamma)
+			}
+		}
+
+		for i, j := range proteins {
+			var present int
+			for _, k := range j {
+				if mask&(1<<uint(k)) != 0 {
+					present++
+				}
+			}
+			w += peptideLikelihood(g.Peptides[i], (1-params.Beta)*math.Pow(1-params.Alpha, float64(present)))
+		}
+
+		logWeights[mask] = w
+		max = math.Max(max, w)
+	}
+
+	var total float64
+	var posteriors = make([]float64, n)
+
+	for mask, w := range logWeights {
+		e := math.Exp(w - max)
+		total += e
+		for i := 0; i < n; i++ {
+			if mask&(1<<uint(i)) != 0 {
+				posteriors[i] += e
+			}
+		}
+	}
+
+	for i := range posteriors {
+		posteriors[i] /= total
+	}
+
+	return posteriors
+}
+
+// meanFieldPosteriors approximates the posteriors of the big groups, each protein sees the others
+// present with their current posterior
+func meanFieldPosteriors(g fidoGroup, params FidoParameters) []float64 {
+
+	var proteins = make([][]int, len(g.Peptides))
+	for i, j := range g.Nodes {
+		for _, k := range j.Peptides {
+			proteins[k] = append(proteins[k], i)
+		}
+	}
+
+	var posteriors = make([]float64, len(g.Nodes))
+	for i := range posteriors {
+		posteriors[i] = params.Gamma
+	}
+
+	for iteration := 0; iteration < 20; iteration++ {
+		for i, j := range g.Nodes {
+
+			present := math.Log(params.Gamma)
+			absent := math.Log(1 - params.Gamma)
+
+			for _, k := range j.Peptides {
+
+				// the probability that no other protein emits the peptide
+				others := 1 - params.Beta
+				for _, l := range proteins[k] {
+					if l != i {
+						others *= 1 - posteriors[l]*params.Alpha
+					}
+				}
+
+				present += peptideLikelihood(g.Peptides[k], others*(1-params.Alpha))
+				absent += peptideLikelihood(g.Peptides[k], others)
+			}
+
+			posteriors[i] = 1 / (1 + math.Exp(absent-present))
+		}
+	}
+
+	return posteriors
+}
+
+// peptideLikelihood is the log likelihood of a peptide with the given probability when it is not
+// emitted with probability missing
+func peptideLikelihood(probability, missing float64) float64 {
+	return math.Log(math.Max(probability*(1-missing)+(1-probability)*missing, 1e-300))
+}
+
+// fidoObjective rates the posteriors by the area under the ROC curve up to 50 decoys, penalized by
+// the squared difference between the estimated and the empirical FDR
+func fidoObjective(posteriors []float64, decoys []bool) (float64, bool) {
+
+	const maxDecoys = 50
+
+	var order = make([]int, len(posteriors))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool { return posteriors[order[i]] > posteriors[order[j]] })
+
+	var totalDecoys, totalTargets int
+	for _, i := range decoys {
+		if i {
+			totalDecoys++
+		} else {
+			totalTargets++
+		}
+	}
+
+	if totalDecoys == 0 || totalTargets == 0 {
+		return 0, false
+	}
+
+	limit := totalDecoys
+	if limit > maxDecoys {
+		limit = maxDecoys
+	}
+
+	var targets, seenDecoys int
+	var sse, expected float64
+	var points int
+
+	for i := 0; i < len(order); {
+
+		// the tied posteriors are accepted together
+		j := i
+		for j < len(order) && posteriors[order[j]] == posteriors[order[i]] {
+			if decoys[order[j]] {
+				seenDecoys++
+			} else {
+				targets++
+				expected += 1 - posteriors[order[j]]
+			}
+			j++
+		}
+		i = j
+
+		if targets > 0 {
+			empirical := float64(seenDecoys) / float64(targets)
+			if empirical <= 0.1 {
+				estimated := expected / float64(targets)
+				sse += (estimated - empirical) * (estimated - empirical)
+				points++
+			}
+		}
+	}
+
+	area := rocArea(order, decoys, limit) / float64(limit*totalTargets)
+
+	var mse float64
+	if points > 0 {
+		mse = sse / float64(points)
+	}
+
+	return (1-fidoWeight)*area - fidoWeight*mse, true
+}
+
+// rocArea adds the number of targets ranked before each of the first decoys
+func rocArea(order []int, decoys []bool, limit int) float64 {
+
+	var area float64
+	var targets, seen int
+
+	for _, i := range order {
+		if decoys[i] {
+			area += float64(targets)
+			seen++
+			if seen == limit {
+				break
+			}
+		} else {
+			targets++
+		}
+	}
+
+	return area
+}
@@ -0,0 +1,95 @@
+package inf
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"philosopher/lib/id"
+)
+
+func TestFido(t *testing.T) {
+
+	var list id.PepIDList
+
+	// targets with two confident peptides, decoys with one poor peptide
+	for i := 0; i < 10; i++ {
+		target := fmt.Sprintf("T%d", i)
+		list = append(list, psm(target+"A", 0.99, target), psm(target+"B", 0.98, target))
+
+		decoy := fmt.Sprintf("rev_D%d", i)
+		list = append(list, psm(decoy+"A", 0.2, decoy))
+	}
+
+	// W only shares the peptide of S, which is explained by S alone
+	list = append(list, psm("SA", 0.99, "S", "W"), psm("SB", 0.99, "S"))
+
+	posteriors := Fido(list, "rev_")
+
+	for k, v := range posteriors {
+		if v < 0 || v > 1 || math.IsNaN(v) {
+			t.Errorf("got posterior %f for %s", v, k)
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		target, decoy := fmt.Sprintf("T%d", i), fmt.Sprintf("rev_D%d", i)
+		if posteriors[target] <= posteriors[decoy] {
+			t.Errorf("got posterior %f for %s, not above %f for %s", posteriors[target], target, posteriors[decoy], decoy)
+		}
+	}
+
+	if posteriors["W"] >= posteriors["S"] {
+		t.Errorf("got posterior %f for W, want it below %f for S", posteriors["W"], posteriors["S"])
+	}
+}
+
+func TestMeanFieldPosteriors(t *testing.T) {
+
+	g := fidoGroup{
+		Nodes: []fidoNode{
+			{Members: []string{"A"}, Peptides: []int{0, 1}},
+			{Members: []string{"B"}, Peptides: []int{1, 2}},
+			{Members: []string{"C"}, Peptides: []int{3}},
+		},
+		Peptides: []float64{0.99, 0.9, 0.2, 0.6},
+	}
+
+	params := FidoParameters{Gamma: 0.5, Alpha: 0.25, Beta: 0.01}
+
+	exact := groupPosteriors(g, params)
+	approximate := meanFieldPosteriors(g, params)
+
+	for i := range exact {
+		if math.Abs(exact[i]-approximate[i]) > 0.1 {
+			t.Errorf("got mean field posterior %f for %s, want about %f", approximate[i], g.Nodes[i].Members[0], exact[i])
+		}
+	}
+}
+
+func TestFidoGraphDecoyTag(t *testing.T) {
+
+	list := id.PepIDList{psm("PEPA", 0.9, "A"), psm("PEPB", 0.9, "rev_B")}
+
+	for _, tt := range []struct {
+		tag    string
+		decoys int
+	}{
+		{"rev_", 1},
+		{"", 0},
+	} {
+
+		var decoys int
+		for _, g := range fidoGraph(list, tt.tag) {
+			for _, i := range g.Nodes {
+				if i.Decoy {
+					decoys++
+				}
+			}
+		}
+
+		if decoys != tt.decoys {
+			t.Errorf("got %d decoy proteins with the tag %q, want %d", decoys, tt.tag, tt.decoys)
+		}
+	}
+}
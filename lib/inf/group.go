@@ -0,0 +1,445 @@
+package inf
+
+import (
+	"sort"
+	"strings"
+
+	"philosopher/lib/id"
+
+	"github.com/sirupsen/logrus"
+)
+
+// maxExactCover is the largest number of proteins in a group searched for the smallest covering
+// set, the larger groups keep the greedy set cover
+const maxExactCover = 20
+
+// ProteinGroup places a protein in the parsimony groups, like the ProteinProphet groups. The
+// proteins of a group share peptides, the subgroup letters come first for the minimal set of
+// proteins explaining the group peptides, then for the subsumable proteins, whose peptides are
+// all explained by that set, and last for the subset proteins, whose peptides are all found on
+// the subsuming protein
+type ProteinGroup struct {
+	Number            uint32
+	Sibling           string
+	Subsuming         string
+	Indistinguishable []string
+}
+
+// proteinNode merges the indistinguishable proteins, which have the same peptides
+type proteinNode struct {
+	Members  []string
+	Peptides map[string]struct{}
+	Subset   bool
+}
+
+// groupProteins splits the protein graph in groups, numbered from the most to the least probable
+func groupProteins(psm id.PepIDList) map[string]ProteinGroup {
+
+	var peptideProbability = make(map[string]float64)
+	for _, i := range psm {
+		if i.Probability > peptideProbability[i.Peptide] {
+			peptideProbability[i.Peptide] = i.Probability
+		}
+	}
+
+	components := proteinGraph(psm)
+
+	// the most probable groups come first
+	var best = make([]float64, len(components))
+	for i, j := range components {
+		for _, k := range j {
+			for l := range k.Peptides {
+				if peptideProbability[l] > best[i] {
+					best[i] = peptideProbability[l]
+				}
+			}
+		}
+	}
+
+	var order = make([]int, len(components))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := order[i], order[j]
+		if best[a] != best[b] {
+			return best[a] > best[b]
+		}
+		return components[a][0].Members[0] < components[b][0].Members[0]
+	})
+
+	var groups = make(map[string]ProteinGroup)
+	var greedy int
+
+	for i, j := range order {
+
+		number := uint32(i + 1)
+
+		siblings, subsuming, exact := parsimony(components[j])
+		if !exact {
+			greedy++
+		}
+
+		for k, l := range siblings {
+			for _, m := range l.Members {
+
+				g := ProteinGroup{Number: number, Sibling: siblingID(k), Subsuming: subsuming[l]}
+
+				for _, n := range l.Members {
+					if n != m {
+						g.Indistinguishable = append(g.Indistinguishable, n)
+					}
+				}
+
+				groups[m] = g
+			}
+		}
+	}
+
+	if greedy > 0 {
+		logrus.Info(greedy, " protein groups have more than ", maxExactCover, " proteins, their minimal sets come from the greedy set cover")
+	}
+
+	return groups
+}
+
+// proteinGraph builds the bipartite graph between proteins and peptides, merges the
+// indistinguishable proteins and splits the graph in connected components
+func proteinGraph(psm id.PepIDList) [][]*proteinNode {
+
+	var proteinPeptides = make(map[string]map[string]struct{})
+
+	for _, i := range psm {
+
+		names := []string{i.Protein}
+		for j := range i.AlternativeProteins {
+			names = append(names, j)
+		}
+
+		for _, j := range names {
+			if _, ok := proteinPeptides[j]; !ok {
+				proteinPeptides[j] = make(map[string]struct{})
+			}
+			proteinPeptides[j][i.Peptide] = struct{}{}
+		}
+	}
+
+	// indistinguishable proteins
+	var nodeIndex = make(map[string]int)
+	var nodes []*proteinNode
+
+	var proteins []string
+	for k := range proteinPeptides {
+		proteins = append(proteins, k)
+	}
+	sort.Strings(proteins)
+
+	for _, i := range proteins {
+
+		key := peptideKey(proteinPeptides[i])
+
+		if j, ok := nodeIndex[key]; ok {
+			nodes[j].Members = append(nodes[j].Members, i)
+			continue
+		}
+
+		nodeIndex[key] = len(nodes)
+		nodes = append(nodes, &proteinNode{Members: []string{i}, Peptides: proteinPeptides[i]})
+	}
+
+	// connected components, the proteins sharing a peptide are joined
+	var parent = make([]int, len(nodes))
+	for i := range parent {
+		parent[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	var peptideNode = make(map[string]int)
+	for i, j := range nodes {
+		for k := range j.Peptides {
+			if l, ok := peptideNode[k]; ok {
+				a, b := find(i), find(l)
+				if a != b {
+					parent[b] = a
+				}
+			} else {
+				peptideNode[k] = i
+			}
+		}
+	}
+
+	var componentIndex = make(map[int]int)
+	var components [][]*proteinNode
+	for i, j := range nodes {
+		root := find(i)
+		c, ok := componentIndex[root]
+		if !ok {
+			c = len(components)
+			componentIndex[root] = c
+			components = append(components, nil)
+		}
+		components[c] = append(components[c], j)
+	}
+
+	return components
+}
+
+// parsimony orders the proteins of a group: the smallest covering set, then the subsumable
+// proteins and the subset proteins. The subset proteins are mapped to the first protein containing
+// all their peptides. The covering set is exact up to maxExactCover proteins, the larger groups
+// keep the greedy set cover, which is reported by the last return value
+func parsimony(component []*proteinNode) ([]*proteinNode, map[*proteinNode]string, bool) {
+
+	var subsuming = make(map[*proteinNode]string)
+
+	sort.Slice(component, func(i, j int) bool { return component[i].Members[0] < component[j].Members[0] })
+
+	// the subset proteins are never needed to cover the peptides
+	var candidates []*proteinNode
+	for _, i := range component {
+		i.Subset = false
+		for _, j := range component {
+			if i != j && len(i.Peptides) < len(j.Peptides) && isSubset(i.Peptides, j.Peptides) {
+				i.Subset = true
+				break
+			}
+		}
+		if !i.Subset {
+			candidates = append(candidates, i)
+		}
+	}
+
+	cover := greedyCover(candidates)
+
+	exact := len(candidates) <= maxExactCover
+	if exact {
+		cover = exactCover(candidates, cover)
+	}
+
+	var chosen = make(map[*proteinNode]struct{})
+	for _, i := range cover {
+		chosen[i] = struct{}{}
+	}
+
+	siblings := cover
+
+	var subsets []*proteinNode
+	for _, i := range component {
+		if _, ok := chosen[i]; ok {
+			continue
+		}
+		if i.Subset {
+			subsets = append(subsets, i)
+		} else {
+			siblings = append(siblings, i)
+		}
+	}
+
+	siblings = append(siblings, subsets...)
+
+	for _, i := range subsets {
+		for _, j := range siblings {
+			if !j.Subset && isSubset(i.Peptides, j.Peptides) {
+				subsuming[i] = j.Members[0]
+				break
+			}
+		}
+	}
+
+	return siblings, subsuming, exact
+}
+
+// greedyCover picks the protein explaining the most unexplained peptides until all of them are
+// explained, the ties go to the protein with more peptides
+func greedyCover(candidates []*proteinNode) []*proteinNode {
+
+	var covered = make(map[string]struct{})
+	var chosen = make(map[*proteinNode]struct{})
+	var cover []*proteinNode
+
+	for {
+
+		var next *proteinNode
+		var gain int
+
+		for _, i := range candidates {
+
+			if _, ok := chosen[i]; ok {
+				continue
+			}
+
+			var n int
+			for k := range i.Peptides {
+				if _, ok := covered[k]; !ok {
+					n++
+				}
+			}
+
+			if n > gain || (n == gain && n > 0 && len(i.Peptides) > len(next.Peptides)) {
+				next = i
+				gain = n
+			}
+		}
+
+		if next == nil {
+			break
+		}
+
+		chosen[next] = struct{}{}
+		cover = append(cover, next)
+		for k := range next.Peptides {
+			covered[k] = struct{}{}
+		}
+	}
+
+	return cover
+}
+
+// exactCover searches for a covering set smaller than the given one. The search branches on the
+// proteins of the unexplained peptide found on the fewest proteins, and drops the branches that
+// cannot beat the best set found so far. The proteins of the set are ordered by the number of
+// peptides they explain, like the greedy set cover
+func exactCover(candidates []*proteinNode, best []*proteinNode) []*proteinNode {
+
+	var proteins = make(map[string][]*proteinNode)
+	var peptides []string
+
+	for _, i := range candidates {
+		for k := range i.Peptides {
+			if _, ok := proteins[k]; !ok {
+				peptides = append(peptides, k)
+			}
+			proteins[k] = append(proteins[k], i)
+		}
+	}
+	sort.Strings(peptides)
+
+	var covered = make(map[string]int)
+	var uncovered = len(peptides)
+	var current []*proteinNode
+
+	var search func()
+	search = func() {
+
+		if len(current)+1 >= len(best) {
+			return
+		}
+
+		var next string
+		for _, i := range peptides {
+			if covered[i] == 0 && (len(next) == 0 || len(proteins[i]) < len(proteins[next])) {
+				next = i
+			}
+		}
+
+		for _, i := range proteins[next] {
+
+			current = append(current, i)
+			for k := range i.Peptides {
+				if covered[k]++; covered[k] == 1 {
+					uncovered--
+				}
+			}
+
+			if uncovered == 0 {
+				best = append([]*proteinNode(nil), current...)
+			} else {
+				search()
+			}
+
+			for k := range i.Peptides {
+				if covered[k]--; covered[k] == 0 {
+					uncovered++
+				}
+			}
+			current = current[:len(current)-1]
+
+			if len(current)+1 >= len(best) {
+				return
+			}
+		}
+	}
+
+	if len(best) > 1 {
+		search()
+	}
+
+	return coverOrder(best)
+}
+
+// coverOrder sorts the covering set by the peptides each protein explains that the previous ones
+// did not, the ties keep the protein order
+func coverOrder(cover []*proteinNode) []*proteinNode {
+
+	var remaining = append([]*proteinNode(nil), cover...)
+	var covered = make(map[string]struct{})
+	var ordered []*proteinNode
+
+	for len(remaining) > 0 {
+
+		var next, gain = 0, -1
+		for i, j := range remaining {
+
+			var n int
+			for k := range j.Peptides {
+				if _, ok := covered[k]; !ok {
+					n++
+				}
+			}
+
+			if n > gain || (n == gain && len(j.Peptides) > len(remaining[next].Peptides)) {
+				next, gain = i, n
+			}
+		}
+
+		ordered = append(ordered, remaining[next])
+		for k := range remaining[next].Peptides {
+			covered[k] = struct{}{}
+		}
+		remaining = append(remaining[:next], remaining[next+1:]...)
+	}
+
+	return ordered
+}
+
+// isSubset checks if all the peptides of a are in b
+func isSubset(a, b map[string]struct{}) bool {
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// peptideKey identifies a set of peptides
+func peptideKey(peptides map[string]struct{}) string {
+
+	var list []string
+	for k := range peptides {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+
+	return strings.Join(list, ",")
+}
+
+// siblingID names the proteins of a group like ProteinProphet, a to z, then aa, ab and so on
+func siblingID(i int) string {
+
+	var id string
+	for i >= 0 {
+		id = string(rune('a'+i%26)) + id
+		i = i/26 - 1
+	}
+
+	return id
+}
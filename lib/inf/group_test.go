@@ -0,0 +1,100 @@
+package inf
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"philosopher/lib/id"
+)
+
+// psm builds an identification of the peptide on the proteins, with the probability and a PEP of
+// one minus the probability
+func psm(peptide string, probability float64, proteins ...string) id.PeptideIdentification {
+	p := id.PeptideIdentification{Peptide: peptide, Protein: proteins[0], Probability: probability, PEP: 1 - probability, AlternativeProteins: make(map[string]string)}
+	for _, i := range proteins[1:] {
+		p.AlternativeProteins[i] = ""
+	}
+	return p
+}
+
+func TestGroupProteins(t *testing.T) {
+
+	// A and B are indistinguishable, C is a subset of A, D and E explain the peptides of F, G is on
+	// its own
+	list := id.PepIDList{
+		psm("PEPA", 0.9, "A", "B", "C"),
+		psm("PEPB", 0.9, "A", "B"),
+		psm("PEPD", 0.99, "D", "F"),
+		psm("PEPDE", 0.99, "D", "E"),
+		psm("PEPE", 0.99, "E", "F"),
+		psm("PEPG", 0.5, "G"),
+	}
+
+	groups := groupProteins(list)
+
+	want := map[string]ProteinGroup{
+		"D": {Number: 1, Sibling: "a"},
+		"E": {Number: 1, Sibling: "b"},
+		"F": {Number: 1, Sibling: "c"},
+		"A": {Number: 2, Sibling: "a", Indistinguishable: []string{"B"}},
+		"B": {Number: 2, Sibling: "a", Indistinguishable: []string{"A"}},
+		"C": {Number: 2, Sibling: "b", Subsuming: "A"},
+		"G": {Number: 3, Sibling: "a"},
+	}
+
+	for k, v := range want {
+		if !reflect.DeepEqual(groups[k], v) {
+			t.Errorf("got %+v for %s, want %+v", groups[k], k, v)
+		}
+	}
+
+	if len(groups) != len(want) {
+		t.Errorf("got %d grouped proteins, want %d", len(groups), len(want))
+	}
+}
+
+func TestParsimonyExactCover(t *testing.T) {
+
+	node := func(name string, peptides ...string) *proteinNode {
+		n := &proteinNode{Members: []string{name}, Peptides: make(map[string]struct{})}
+		for _, i := range peptides {
+			n.Peptides[i] = struct{}{}
+		}
+		return n
+	}
+
+	// the greedy set cover takes C first and needs A and B after it, A and B alone explain all the
+	// peptides
+	a := node("A", "P1", "P2", "P3")
+	b := node("B", "P4", "P5", "P6")
+	c := node("C", "P1", "P2", "P4", "P5")
+
+	if got := greedyCover([]*proteinNode{a, b, c}); len(got) != 3 {
+		t.Errorf("got a greedy cover of %d proteins, want 3", len(got))
+	}
+
+	siblings, _, exact := parsimony([]*proteinNode{c, b, a})
+	if !exact || len(siblings) != 3 || siblings[0] != a || siblings[1] != b || siblings[2] != c {
+		t.Errorf("got %v, want A and B covering the peptides before C", siblings)
+	}
+
+	// the larger groups keep the greedy set cover
+	var large []*proteinNode
+	for i := 0; i <= maxExactCover; i++ {
+		large = append(large, node(fmt.Sprintf("L%02d", i), "SHARED", fmt.Sprintf("P%02d", i)))
+	}
+
+	siblings, _, exact = parsimony(large)
+	if exact || len(siblings) != len(large) {
+		t.Errorf("got %d proteins with exact %t, want %d from the greedy set cover", len(siblings), exact, len(large))
+	}
+}
+
+func TestSiblingID(t *testing.T) {
+	for i, want := range map[int]string{0: "a", 25: "z", 26: "aa", 27: "ab", 52: "ba"} {
+		if got := siblingID(i); got != want {
+			t.Errorf("got sibling %s for %d, want %s", got, i, want)
+		}
+	}
+}
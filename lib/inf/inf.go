@@ -8,6 +8,7 @@ import (
 
 	"philosopher/lib/dat"
 	"philosopher/lib/id"
+	"philosopher/lib/raz"
 	"philosopher/lib/uti"
 )
 
@@ -25,8 +26,9 @@ type Peptide struct {
 	MappedProteinsWithDecoys map[string]int
 }
 
-// ProteinInference ...
-func ProteinInference(psm id.PepIDList) (id.PepIDList, map[string]string, map[string]float64) {
+// ProteinInference assigns the razor peptides with the razor strategy, the protein coverage and the
+// parsimony groups
+func ProteinInference(psm id.PepIDList, strategy, decoyTag string) (id.PepIDList, raz.RazorMap, map[string]float64, map[string]ProteinGroup) {
 
 	var peptideList []Peptide
 	var exclusionList = make(map[string]int)
@@ -149,42 +151,41 @@ func ProteinInference(psm id.PepIDList) (id.PepIDList, map[string]string, map[st
 
 	proteinCoverageMap := calculateProteinCoverage(proteinPepSeqMap, db)
 
-	// assign razor
-	var razorMap = make(map[string]string)
-	for i := range peptideList {
+	groups := groupProteins(psm)
 
-		var protein string
-		var candidateProteins []string
-		var tnp int
-		var coverage float64
+	// assign razor
+	var evidence map[string]raz.Protein
+	if strategy != raz.Default {
+		evidence = razorEvidence(psm, proteinTNP, raz.Annotate(db.Records, decoyTag))
+	}
 
-		for k := range peptideList[i].MappedProteins {
-			candidateProteins = append(candidateProteins, k)
-		}
+	var razorMap raz.RazorMap = make(map[string]raz.RazorCandidate)
+	for i := range peptideList {
 
-		sort.Strings(candidateProteins)
+		var protein, rationale string
 
-		for _, j := range candidateProteins {
+		if strategy == raz.Default {
+			protein, rationale = coverageRazor(peptideList[i].MappedProteins, proteinCoverageMap)
+		} else {
 
-			if peptideList[i].MappedProteins[j] > tnp {
-				tnp = peptideList[i].MappedProteins[j]
-				protein = j
+			var candidates = make(map[string]raz.Protein)
+			for k := range peptideList[i].MappedProteins {
+				candidates[k] = evidence[k]
 			}
-		}
 
-		for _, j := range candidateProteins {
-
-			if peptideList[i].MappedProteins[j] == tnp && proteinCoverageMap[j] > coverage {
-				coverage = proteinCoverageMap[j]
-				protein = j
-			}
+			protein, rationale = raz.Select(candidates, strategy)
 		}
 
 		if len(protein) > 0 {
 			peptideList[i].Protein = protein
 		}
 
-		razorMap[peptideList[i].Sequence] = peptideList[i].Protein
+		razorMap[peptideList[i].Sequence] = raz.RazorCandidate{
+			Sequence:          peptideList[i].Sequence,
+			MappedProtein:     peptideList[i].Protein,
+			Rationale:         rationale,
+			MappedProteinsTNP: peptideList[i].MappedProteins,
+		}
 	}
 
 	//spew.Dump(peptideList)
@@ -192,9 +193,11 @@ func ProteinInference(psm id.PepIDList) (id.PepIDList, map[string]string, map[st
 
 	// update PSMs
 	for i := range psm {
-		pt, ok := razorMap[psm[i].Peptide]
+		v, ok := razorMap[psm[i].Peptide]
 		if ok {
 
+			pt := v.MappedProtein
+
 			if pt != psm[i].Protein {
 
 				psm[i].AlternativeProteins[psm[i].Protein] = string(psm[i].PrevAA) + "#" + string(psm[i].NextAA)
@@ -216,7 +219,113 @@ func ProteinInference(psm id.PepIDList) (id.PepIDList, map[string]string, map[st
 		}
 	}
 
-	return psm, razorMap, proteinCoverageMap
+	return psm, razorMap, proteinCoverageMap, groups
+}
+
+// coverageRazor chooses the razor protein with the most peptides, then with the highest coverage,
+// then by name
+func coverageRazor(mappedProteins map[string]int, proteinCoverageMap map[string]float64) (string, string) {
+
+	var protein string
+	var candidateProteins []string
+	var tnp int
+	var coverage float64
+
+	for k := range mappedProteins {
+		candidateProteins = append(candidateProteins, k)
+	}
+
+	sort.Strings(candidateProteins)
+
+	if len(candidateProteins) == 0 {
+		return "", ""
+	} else if len(candidateProteins) == 1 {
+		return candidateProteins[0], "single protein"
+	}
+
+	for _, j := range candidateProteins {
+
+		if mappedProteins[j] > tnp {
+			tnp = mappedProteins[j]
+			protein = j
+		}
+	}
+
+	var tied []string
+	for _, j := range candidateProteins {
+		if mappedProteins[j] == tnp {
+			tied = append(tied, j)
+		}
+	}
+
+	if len(tied) == 1 {
+		return protein, "total peptides"
+	}
+
+	var covered int
+	for _, j := range tied {
+
+		if proteinCoverageMap[j] > coverage {
+			coverage = proteinCoverageMap[j]
+			protein = j
+			covered = 0
+		}
+
+		if proteinCoverageMap[j] == coverage {
+			covered++
+		}
+	}
+
+	if covered == 1 {
+		return protein, "coverage"
+	}
+
+	return protein, "alphabetical order"
+}
+
+// razorEvidence collects the peptides, spectra and best probability of each protein for the razor
+// strategies, on top of their database annotations
+func razorEvidence(psm id.PepIDList, proteinTNP map[string]int, annotations map[string]raz.Protein) map[string]raz.Protein {
+
+	var peptides = make(map[string]map[string]struct{})
+	var evidence = make(map[string]raz.Protein)
+
+	for _, i := range psm {
+
+		names := []string{i.Protein}
+		for j := range i.AlternativeProteins {
+			if j != i.Protein {
+				names = append(names, j)
+			}
+		}
+
+		for _, j := range names {
+
+			if _, ok := peptides[j]; !ok {
+				peptides[j] = make(map[string]struct{})
+			}
+			peptides[j][i.Peptide] = struct{}{}
+
+			v, ok := evidence[j]
+			if !ok {
+				v = annotations[j]
+			}
+
+			if i.Probability > v.Probability {
+				v.Probability = i.Probability
+			}
+
+			evidence[j] = v
+		}
+	}
+
+	for k, v := range evidence {
+		v.Peptides = len(peptides[k])
+		v.Spectra = proteinTNP[k]
+		evidence[k] = v
+	}
+
+	return evidence
 }
 
 // calculateProteinCoverage returns a percentage of coverage based on a set of peptides
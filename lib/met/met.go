@@ -36,6 +36,7 @@ type Data struct {
 	ProjectName    string
 	SearchEngine   string
 	Msconvert      Msconvert
+	RawInfo        RawInfo
 	Idconvert      Idconvert
 	Database       Database
 	MSFragger      MSFragger
@@ -44,6 +45,8 @@ type Data struct {
 	InterProphet   InterProphet
 	ProteinProphet ProteinProphet
 	PTMProphet     PTMProphet
+	Percolator     Percolator
+	Rescore        Rescore
 	Filter         Filter
 	Quantify       Quantify
 	BioQuant       BioQuant
@@ -60,10 +63,20 @@ type Msconvert struct {
 	Format                  string
 	MZBinaryEncoding        string
 	IntensityBinaryEncoding string
+	MSLevel                 string
+	Scans                   string
+	MinRT                   float64
+	MaxRT                   float64
 	NoIndex                 bool
 	Zlib                    bool
 }
 
+// RawInfo options and parameters
+type RawInfo struct {
+	Output string
+	JSON   bool
+}
+
 // Idconvert optioons and parameters
 type Idconvert struct {
 	Format string
@@ -71,18 +84,21 @@ type Idconvert struct {
 
 // Database options and parameters
 type Database struct {
-	ID        string `yaml:"id"`
-	Annot     string `yaml:"protein_database"`
-	Enz       string `yaml:"enzyme"`
-	Tag       string `yaml:"decoy_tag"`
-	Add       string `yaml:"add"`
-	Custom    string `yaml:"custom"`
-	TimeStamp string `yaml:"timestamp"`
-	Crap      bool   `yaml:"contam"`
-	CrapTag   bool   `yaml:"contaminant_tag"`
-	Rev       bool   `yaml:"reviewed"`
-	Iso       bool   `yaml:"isoform"`
-	NoD       bool   `yaml:"nodecoys"`
+	ID            string `yaml:"id"`
+	Annot         string `yaml:"protein_database"`
+	Enz           string `yaml:"enzyme"`
+	Tag           string `yaml:"decoy_tag"`
+	Add           string `yaml:"add"`
+	Custom        string `yaml:"custom"`
+	Decoy         string `yaml:"decoy"`
+	Entrapment    string `yaml:"entrapment"`
+	EntrapmentTag string `yaml:"entrapment_tag"`
+	TimeStamp     string `yaml:"timestamp"`
+	Crap          bool   `yaml:"contam"`
+	CrapTag       bool   `yaml:"contaminant_tag"`
+	Rev           bool   `yaml:"reviewed"`
+	Iso           bool   `yaml:"isoform"`
+	NoD           bool   `yaml:"nodecoys"`
 }
 
 // Comet options and parameters
@@ -337,28 +353,63 @@ type PTMProphet struct {
 	NoMinoFactor       bool    `yaml:"nominofactor"`
 }
 
+// Percolator options and parameters
+type Percolator struct {
+	Bin         string  `yaml:"path"`
+	Output      string  `yaml:"output"`
+	Tag         string  `yaml:"decoyTag"`
+	SearchScore string  `yaml:"searchScore"`
+	TrainFDR    float64 `yaml:"trainFDR"`
+	TestFDR     float64 `yaml:"testFDR"`
+	MaxIter     int     `yaml:"maxIter"`
+	Threads     int     `yaml:"threads"`
+	InputFiles  []string
+}
+
+// Rescore options and parameters
+type Rescore struct {
+	Model       string  `yaml:"model"`
+	Tag         string  `yaml:"decoyTag"`
+	SearchScore string  `yaml:"searchScore"`
+	TrainFDR    float64 `yaml:"trainFDR"`
+	Folds       int     `yaml:"folds"`
+	MaxIter     int     `yaml:"maxIter"`
+	InputFiles  []string
+}
+
 // Filter options and parameters
 type Filter struct {
-	Pex       string  `yaml:"pepxml"`
-	Pox       string  `yaml:"protxml"`
-	Tag       string  `yaml:"tag"`
-	Mods      string  `yaml:"mods"`
-	RazorBin  string  `yaml:"razorbin"`
-	PsmFDR    float64 `yaml:"psmFDR"`
-	PepFDR    float64 `yaml:"peptideFDR"`
-	IonFDR    float64 `yaml:"ionFDR"`
-	PtFDR     float64 `yaml:"proteinFDR"`
-	ProtProb  float64 `yaml:"proteinProbability"`
-	PepProb   float64 `yaml:"peptideProbability"`
-	Weight    float64 `yaml:"peptideWeight"`
-	Model     bool    `yaml:"models"`
-	Razor     bool    `yaml:"razor"`
-	Picked    bool    `yaml:"picked"`
-	Seq       bool    `yaml:"sequential"`
-	TwoD      bool    `yaml:"two-dimensional"`
-	Mapmods   bool    `yaml:"mapMods"`
-	Delta     bool    `yaml:"delta"`
-	Inference bool
+	Pex           string  `yaml:"pepxml"`
+	Pox           string  `yaml:"protxml"`
+	Tag           string  `yaml:"tag"`
+	Mods          string  `yaml:"mods"`
+	RazorBin      string  `yaml:"razorbin"`
+	RazorStrategy string  `yaml:"razorStrategy"`
+	SearchScore   string  `yaml:"searchScore"`
+	Score         string  `yaml:"score"`
+	FDRGroup      string  `yaml:"fdrGroup"`
+	SampleSheet   string  `yaml:"sampleSheet"`
+	Stratify      string  `yaml:"stratify"`
+	Entrapment    string  `yaml:"entrapment"`
+	PsmFDR        float64 `yaml:"psmFDR"`
+	PepFDR        float64 `yaml:"peptideFDR"`
+	IonFDR        float64 `yaml:"ionFDR"`
+	PtFDR         float64 `yaml:"proteinFDR"`
+	ProtProb      float64 `yaml:"proteinProbability"`
+	PepProb       float64 `yaml:"peptideProbability"`
+	Weight        float64 `yaml:"peptideWeight"`
+	Model         bool    `yaml:"models"`
+	Razor         bool    `yaml:"razor"`
+	Picked        bool    `yaml:"picked"`
+	Gene          bool    `yaml:"gene"`
+	Fido          bool    `yaml:"fido"`
+	Seq           bool    `yaml:"sequential"`
+	TwoD          bool    `yaml:"two-dimensional"`
+	Mapmods       bool    `yaml:"mapMods"`
+	Delta         bool    `yaml:"delta"`
+	Percolator    bool    `yaml:"percolator"`
+	Rescore       bool    `yaml:"rescore"`
+	Inference     bool
 }
 
 // Quantify options and parameters
@@ -397,6 +448,7 @@ type Abacus struct {
 	Protein  bool    `yaml:"protein"`
 	Razor    bool    `yaml:"razor"`
 	Picked   bool    `yaml:"picked"`
+	Gene     bool    `yaml:"gene"`
 	Labels   bool    `yaml:"labels"`
 	Unique   bool    `yaml:"uniqueOnly"`
 	Reprint  bool    `yaml:"reprint"`
@@ -411,12 +463,15 @@ type BioQuant struct {
 
 // Report options and parameters
 type Report struct {
-	Decoys       bool `yaml:"withDecoys"`
-	RemoveContam bool `yaml:"removecontam"`
-	MSstats      bool `yaml:"msstats"`
-	MZID         bool `yaml:"mzID"`
-	IonMob       bool `yaml:"ionmobility"`
-	Prefix       bool `yaml:"prefix"`
+	Decoys       bool   `yaml:"withDecoys"`
+	RemoveContam bool   `yaml:"removecontam"`
+	MSstats      bool   `yaml:"msstats"`
+	MZID         bool   `yaml:"mzID"`
+	IonMob       bool   `yaml:"ionmobility"`
+	Prefix       bool   `yaml:"prefix"`
+	MzML         bool   `yaml:"mzML"`
+	MS1          bool   `yaml:"ms1"`
+	Spectra      string `yaml:"spectra"`
 }
 
 // TMTIntegrator options and parameters
@@ -85,6 +85,24 @@ func ReadingMzMLZlib(e error, t string) {
 
 }
 
+// ReadingMzMLNumpress call when trying to read mzML MS-Numpress spectra
+func ReadingMzMLNumpress(e error, t string) {
+
+	m := fmt.Sprintf("Error trying to read mzML MS-Numpress data. %s", e)
+
+	callLogrus(m, t)
+
+}
+
+// UnsupportedCompression call when the mzML binary data compression is not supported
+func UnsupportedCompression(e error, t string) {
+
+	m := fmt.Sprintf("The mzML binary data compression is not supported: %s. Please convert the raw files again using zlib, MS-Numpress or no compression", e)
+
+	callLogrus(m, t)
+
+}
+
 // WriteFile call for failed file writing event
 func WriteFile(e error, t string) {
 
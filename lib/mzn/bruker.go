@@ -0,0 +1,242 @@
+package mzn
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"philosopher/lib/msg"
+	"philosopher/lib/tdf"
+)
+
+// tdfLayout numbers the spectra from a timsTOF acquisition. MS1 frames and precursors are
+// numbered in acquisition order, and each precursor comes after the last PASEF frame that
+// fragments it
+type tdfLayout struct {
+	frameScan     map[int]int
+	precursorScan map[int]int
+	frameTime     map[int]float64
+	firstFrame    map[int]int
+	ending        map[int][]int
+}
+
+// StreamBrukerTDF returns a Walker that decodes the frames of a timsTOF .d folder one at a time.
+// MS1 frames carry the inverse reduced ion mobility of every peak, and the PASEF fragments of
+// each precursor are summed into a single MS2 spectrum
+func StreamBrukerTDF(dir string) Walker {
+
+	return func(fun func(spec Spectrum), levels ...string) {
+
+		var d tdf.Data
+		if e := d.Read(dir); e != nil {
+			msg.ReadFile(e, "error")
+		}
+		defer d.Close()
+
+		layout := newTDFLayout(&d)
+
+		// fragment intensities summed by TOF index for the precursors not yet complete
+		var fragments = make(map[int]map[uint32]float64)
+
+		for _, f := range d.Frames {
+
+			if f.MsMsType == tdf.MS1 && isLevel("1", levels) {
+
+				fun(tdfMS1Spectrum(&d, f, layout.frameScan[f.ID]))
+
+			} else if f.MsMsType == tdf.PASEF && isLevel("2", levels) {
+
+				peaks, e := d.ReadFrame(f)
+				if e != nil {
+					msg.ReadFile(e, "error")
+				}
+
+				for _, i := range d.PasefInfo[f.ID] {
+
+					sum, ok := fragments[i.Precursor]
+					if !ok {
+						sum = make(map[uint32]float64)
+						fragments[i.Precursor] = sum
+					}
+
+					// the scan range end is exclusive
+					for j := i.ScanNumStart; j < i.ScanNumEnd && j < len(peaks.Tof); j++ {
+						for k := range peaks.Tof[j] {
+							sum[peaks.Tof[j][k]] += float64(peaks.Intensity[j][k])
+						}
+					}
+				}
+
+				for _, i := range layout.ending[f.ID] {
+					fun(tdfMS2Spectrum(&d, layout, i, fragments[i]))
+					delete(fragments, i)
+				}
+			}
+		}
+	}
+}
+
+// newTDFLayout assigns the scan numbers to the frames and precursors
+func newTDFLayout(d *tdf.Data) tdfLayout {
+
+	var l = tdfLayout{
+		frameScan:     make(map[int]int),
+		precursorScan: make(map[int]int),
+		frameTime:     make(map[int]float64),
+		firstFrame:    make(map[int]int),
+		ending:        make(map[int][]int),
+	}
+
+	var lastFrame = make(map[int]int)
+
+	for _, f := range d.Frames {
+
+		l.frameTime[f.ID] = f.Time
+
+		for _, i := range d.PasefInfo[f.ID] {
+			if _, ok := l.firstFrame[i.Precursor]; !ok {
+				l.firstFrame[i.Precursor] = f.ID
+			}
+			lastFrame[i.Precursor] = f.ID
+		}
+	}
+
+	for p, f := range lastFrame {
+		l.ending[f] = append(l.ending[f], p)
+	}
+
+	var scan int
+
+	for _, f := range d.Frames {
+
+		if f.MsMsType == tdf.MS1 {
+			scan++
+			l.frameScan[f.ID] = scan
+			continue
+		}
+
+		sort.Ints(l.ending[f.ID])
+		for _, p := range l.ending[f.ID] {
+			scan++
+			l.precursorScan[p] = scan
+		}
+	}
+
+	return l
+}
+
+// tdfMS1Spectrum creates a spectrum with all peaks from a MS1 frame, sorted by m/z
+func tdfMS1Spectrum(d *tdf.Data, f tdf.Frame, scan int) Spectrum {
+
+	var spec Spectrum
+
+	spec.Scan = strconv.Itoa(scan)
+	spec.Index = strconv.Itoa(scan - 1)
+	spec.Level = "1"
+	spec.SpectrumName = fmt.Sprintf("frame=%d", f.ID)
+	spec.ScanStartTime = f.Time / 60
+
+	spec.Mz.Precision = "64"
+	spec.Intensity.Precision = "64"
+	spec.IonMobility.Precision = "64"
+
+	peaks, e := d.ReadFrame(f)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+
+	type peak struct {
+		mz, intensity, mobility float64
+	}
+
+	var list []peak
+	for i := range peaks.Tof {
+		mobility := d.ScanToOneOverK0(f, float64(i))
+		for j := range peaks.Tof[i] {
+			list = append(list, peak{d.TofToMz(f, peaks.Tof[i][j]), float64(peaks.Intensity[i][j]), mobility})
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].mz < list[j].mz })
+
+	spec.Mz.DecodedStream = make([]float64, len(list))
+	spec.Intensity.DecodedStream = make([]float64, len(list))
+	spec.IonMobility.DecodedStream = make([]float64, len(list))
+
+	for i := range list {
+		spec.Mz.DecodedStream[i] = list[i].mz
+		spec.Intensity.DecodedStream[i] = list[i].intensity
+		spec.IonMobility.DecodedStream[i] = list[i].mobility
+	}
+
+	return spec
+}
+
+// tdfMS2Spectrum creates the spectrum of a PASEF precursor from its summed fragments
+func tdfMS2Spectrum(d *tdf.Data, l tdfLayout, id int, fragments map[uint32]float64) Spectrum {
+
+	var spec Spectrum
+
+	p := d.Precursors[id]
+	scan := l.precursorScan[id]
+
+	spec.Scan = strconv.Itoa(scan)
+	spec.Index = strconv.Itoa(scan - 1)
+	spec.Level = "2"
+	spec.SpectrumName = fmt.Sprintf("precursor=%d", id)
+	spec.ScanStartTime = l.frameTime[l.firstFrame[id]] / 60
+
+	parent, ok := l.frameScan[p.Parent]
+	if ok {
+		spec.Precursor.ParentScan = strconv.Itoa(parent)
+		spec.Precursor.ParentIndex = strconv.Itoa(parent - 1)
+	}
+
+	spec.Precursor.SelectedIon = p.MonoisotopicMz
+	if spec.Precursor.SelectedIon == 0 {
+		spec.Precursor.SelectedIon = p.LargestPeakMz
+	}
+	spec.Precursor.SelectedIonIntensity = p.Intensity
+	spec.Precursor.ChargeState = p.Charge
+
+	// the timsTOF fragments the precursors by collision-induced dissociation
+	spec.Precursor.Activation = "CID"
+
+	// the precursor mobility comes from its parent frame, and the fragments from the PASEF frames
+	if f, ok := d.Frame(p.Parent); ok {
+		spec.Precursor.IonMobility = d.ScanToOneOverK0(f, p.ScanNumber)
+	}
+
+	pasef, _ := d.Frame(l.firstFrame[id])
+
+	// all PASEF frames of a precursor share the same isolation window
+	for _, i := range d.PasefInfo[l.firstFrame[id]] {
+		if i.Precursor == id {
+			spec.Precursor.TargetIon = i.IsolationMz
+			spec.Precursor.IsolationWindowLowerOffset = i.IsolationWidth / 2
+			spec.Precursor.IsolationWindowUpperOffset = i.IsolationWidth / 2
+			spec.Precursor.CollisionEnergy = i.CollisionEnergy
+			break
+		}
+	}
+
+	var tofs []uint32
+	for i := range fragments {
+		tofs = append(tofs, i)
+	}
+
+	// m/z increases with the TOF index
+	sort.Slice(tofs, func(i, j int) bool { return tofs[i] < tofs[j] })
+
+	spec.Mz.Precision = "64"
+	spec.Intensity.Precision = "64"
+	spec.Mz.DecodedStream = make([]float64, len(tofs))
+	spec.Intensity.DecodedStream = make([]float64, len(tofs))
+
+	for i := range tofs {
+		spec.Mz.DecodedStream[i] = d.TofToMz(pasef, tofs[i])
+		spec.Intensity.DecodedStream[i] = fragments[tofs[i]]
+	}
+
+	return spec
+}
@@ -0,0 +1,286 @@
+package mzn
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"philosopher/lib/msg"
+	"philosopher/lib/sys"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// cacheVersion changes every time the layout of the cached spectra changes
+const cacheVersion = 1
+
+// cacheHeader is the first record of a cache file
+type cacheHeader struct {
+	Version  int
+	Checksum string
+}
+
+// cachedSpectrum is the compact form of a spectrum, only the MS1 spectra keep their peaks
+type cachedSpectrum struct {
+	Index               string
+	Scan                string
+	Level               string
+	SpectrumName        string
+	CompensationVoltage string
+	ScanStartTime       float64
+	Precursor           Precursor
+	Mz                  []float64
+	Intensity           []float64
+	IonMobility         []float64
+}
+
+// cacheEntry is the state of a source file when its checksum was calculated
+type cacheEntry struct {
+	Size     int64
+	ModTime  int64
+	Checksum string
+}
+
+// CachedWalker returns a Walker that reads the spectra of a source file from the workspace cache.
+// The cache holds the decoded MS1 peaks and the metadata of the other spectra, so their peaks are
+// not available. The cache is built from walk on the first use, or when the source file changes,
+// and it is not used outside a workspace
+func CachedWalker(source string, walk Walker) Walker {
+
+	return func(fun func(spec Spectrum), levels ...string) {
+
+		if _, e := os.Stat(sys.MetaDir()); e != nil {
+			walk(fun, levels...)
+			return
+		}
+
+		sum := sourceChecksum(source)
+
+		if readCache(sys.RawCacheBin(sum), sum, fun, levels) {
+			return
+		}
+
+		logrus.Info("Caching spectra from ", filepath.Base(source))
+		writeCache(sys.RawCacheBin(sum), sum, walk, fun, levels)
+	}
+}
+
+// readCache visits the cached spectra, it returns false if the cache is missing or outdated
+func readCache(f, sum string, fun func(spec Spectrum), levels []string) bool {
+
+	file, e := os.Open(f)
+	if e != nil {
+		return false
+	}
+	defer file.Close()
+
+	dec := msgpack.NewDecoder(bufio.NewReader(file))
+
+	var header cacheHeader
+	if e := dec.Decode(&header); e != nil || header.Version != cacheVersion || header.Checksum != sum {
+		return false
+	}
+
+	for {
+
+		var c cachedSpectrum
+
+		e := dec.Decode(&c)
+		if e == io.EOF {
+			break
+		} else if e != nil {
+			msg.DecodeMsgPck(fmt.Errorf("%s: %s", f, e), "error")
+		}
+
+		if !isLevel(c.Level, levels) {
+			continue
+		}
+
+		spec := Spectrum{
+			Index:               c.Index,
+			Scan:                c.Scan,
+			Level:               c.Level,
+			SpectrumName:        c.SpectrumName,
+			CompensationVoltage: c.CompensationVoltage,
+			ScanStartTime:       c.ScanStartTime,
+			Precursor:           c.Precursor,
+		}
+
+		spec.Mz.Precision = "64"
+		spec.Mz.DecodedStream = c.Mz
+		spec.Intensity.Precision = "64"
+		spec.Intensity.DecodedStream = c.Intensity
+
+		if len(c.IonMobility) > 0 {
+			spec.IonMobility.Precision = "64"
+			spec.IonMobility.DecodedStream = c.IonMobility
+		}
+
+		fun(spec)
+	}
+
+	return true
+}
+
+// writeCache visits all spectra from walk, storing them in the cache and handing over the ones
+// from the requested levels
+func writeCache(f, sum string, walk Walker, fun func(spec Spectrum), levels []string) {
+
+	tmp := f + ".tmp"
+
+	file, e := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, sys.FilePermission())
+	if e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	w := bufio.NewWriter(file)
+	enc := msgpack.NewEncoder(w)
+
+	if e := enc.Encode(cacheHeader{Version: cacheVersion, Checksum: sum}); e != nil {
+		msg.MarshalFile(e, "error")
+	}
+
+	walk(func(spec Spectrum) {
+
+		c := cachedSpectrum{
+			Index:               spec.Index,
+			Scan:                spec.Scan,
+			Level:               spec.Level,
+			SpectrumName:        spec.SpectrumName,
+			CompensationVoltage: spec.CompensationVoltage,
+			ScanStartTime:       spec.ScanStartTime,
+			Precursor:           spec.Precursor,
+		}
+
+		if spec.Level == "1" {
+			c.Mz = spec.Mz.DecodedStream
+			c.Intensity = spec.Intensity.DecodedStream
+			c.IonMobility = spec.IonMobility.DecodedStream
+		}
+
+		if e := enc.Encode(&c); e != nil {
+			msg.MarshalFile(e, "error")
+		}
+
+		if isLevel(spec.Level, levels) {
+			fun(spec)
+		}
+	})
+
+	if e := w.Flush(); e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	if e := file.Close(); e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	// the cache only becomes visible when complete
+	if e := os.Rename(tmp, f); e != nil {
+		msg.WriteFile(e, "error")
+	}
+}
+
+// sourceChecksum returns the checksum of a source file. Checksums are kept in the raw.bin manifest
+// and calculated again only when the size or the modification time of the file change, in which
+// case the outdated cache is removed
+func sourceChecksum(source string) string {
+
+	abs, e := filepath.Abs(source)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+
+	var manifest = make(map[string]cacheEntry)
+	if _, e := os.Stat(sys.RawBin()); e == nil {
+		sys.Restore(&manifest, sys.RawBin(), true)
+	}
+
+	files := sourceFiles(abs)
+
+	var entry cacheEntry
+	for _, i := range files {
+
+		info, e := os.Stat(i)
+		if e != nil {
+			msg.ReadFile(e, "error")
+		}
+
+		entry.Size += info.Size()
+		if info.ModTime().UnixNano() > entry.ModTime {
+			entry.ModTime = info.ModTime().UnixNano()
+		}
+	}
+
+	old, ok := manifest[abs]
+	if ok && old.Size == entry.Size && old.ModTime == entry.ModTime {
+		return old.Checksum
+	}
+
+	h := sha1.New()
+	for _, i := range files {
+
+		// folders like Bruker .d are identified by the names and contents of their files
+		rel, _ := filepath.Rel(abs, i)
+		io.WriteString(h, rel)
+
+		file, e := os.Open(i)
+		if e != nil {
+			msg.ReadFile(e, "error")
+		}
+
+		if _, e := io.Copy(h, file); e != nil {
+			msg.ReadFile(e, "error")
+		}
+
+		file.Close()
+	}
+
+	entry.Checksum = hex.EncodeToString(h.Sum(nil))
+
+	if ok && old.Checksum != entry.Checksum {
+		os.Remove(sys.RawCacheBin(old.Checksum))
+	}
+
+	manifest[abs] = entry
+	sys.Serialize(&manifest, sys.RawBin())
+
+	return entry.Checksum
+}
+
+// sourceFiles lists the files of a source, the source itself or every file inside a folder
+func sourceFiles(source string) []string {
+
+	info, e := os.Stat(source)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+
+	if !info.IsDir() {
+		return []string{source}
+	}
+
+	var files []string
+
+	// files are visited in lexical order
+	e = filepath.Walk(source, func(path string, info os.FileInfo, e error) error {
+		if e != nil {
+			return e
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+
+	return files
+}
@@ -0,0 +1,258 @@
+package mzn
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"philosopher/lib/msg"
+	"philosopher/lib/psi"
+
+	"github.com/rogpeppe/go-charset/charset"
+)
+
+// nativeScanRegex matches the scan number of the spectrum native IDs, like scan=1234
+var nativeScanRegex = regexp.MustCompile(`scan=(\d+)`)
+
+// Index gives random access to the spectra of an mzML file using the byte offsets
+// from the indexList, or from an index built on the fly when the file has none
+type Index struct {
+	FileName string
+	IDs      []string
+	offsets  []int64
+	native   map[string]int
+	scans    map[string]int
+	file     *os.File
+}
+
+// NewIndex opens the mzML file and loads its spectrum offsets
+func NewIndex(f string) *Index {
+
+	file, e := os.Open(f)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+
+	p := &Index{FileName: f, file: file, native: make(map[string]int), scans: make(map[string]int)}
+
+	if !p.readIndexList() || !p.isValid() {
+		msg.Custom(errors.New("the mzML index is missing or corrupted, indexing the file"), "warning")
+		p.buildIndex()
+	}
+
+	for i := range p.IDs {
+		p.native[p.IDs[i]] = i
+		p.scans[nativeScan(p.IDs[i], i)] = i
+	}
+
+	return p
+}
+
+// Close closes the mzML file
+func (p *Index) Close() error {
+	return p.file.Close()
+}
+
+// Len returns the number of indexed spectra
+func (p *Index) Len() int {
+	return len(p.offsets)
+}
+
+// Spectrum returns the spectrum referenced by its native ID. The binary data is not decoded
+func (p *Index) Spectrum(id string) (Spectrum, bool) {
+
+	i, ok := p.native[id]
+	if !ok {
+		return Spectrum{}, false
+	}
+
+	return p.read(i)
+}
+
+// Scan returns the spectrum referenced by its scan number. The binary data is not decoded
+func (p *Index) Scan(scan string) (Spectrum, bool) {
+
+	i, ok := p.scans[scan]
+	if !ok {
+		return Spectrum{}, false
+	}
+
+	return p.read(i)
+}
+
+// Walker returns a Walker that visits only the given scans, in ascending order
+func (p *Index) Walker(scans []string) Walker {
+
+	var list []int
+	var seen = make(map[int]uint8)
+
+	for _, i := range scans {
+		sn, e := strconv.Atoi(i)
+		if e != nil {
+			continue
+		}
+		if _, ok := seen[sn]; !ok {
+			seen[sn] = 0
+			list = append(list, sn)
+		}
+	}
+
+	sort.Ints(list)
+
+	return func(fun func(spec Spectrum), levels ...string) {
+		for _, sn := range list {
+			spec, ok := p.Scan(strconv.Itoa(sn))
+			if ok && isLevel(spec.Level, levels) {
+				spec.Decode()
+				fun(spec)
+			}
+		}
+	}
+}
+
+// read decodes the spectrum element at the given index position
+func (p *Index) read(i int) (Spectrum, bool) {
+
+	if _, e := p.file.Seek(p.offsets[i], io.SeekStart); e != nil {
+		msg.Custom(errors.New("error seeking file"), "error")
+	}
+
+	decoder := xml.NewDecoder(bufio.NewReader(p.file))
+	decoder.CharsetReader = charset.NewReader
+
+	var mzSpec psi.Spectrum
+	if e := decoder.Decode(&mzSpec); e != nil {
+		msg.DecodeMsgPck(e, "warning")
+		return Spectrum{}, false
+	}
+
+	return processSpectrum(mzSpec), true
+}
+
+// readIndexList loads the spectrum offsets from the indexList at the end of the file
+func (p *Index) readIndexList() bool {
+
+	stat, e := p.file.Stat()
+	if e != nil {
+		return false
+	}
+
+	// the indexListOffset tag is found among the last lines of the document
+	tail := int64(4096)
+	if stat.Size() < tail {
+		tail = stat.Size()
+	}
+
+	b := make([]byte, tail)
+	if _, e := p.file.ReadAt(b, stat.Size()-tail); e != nil && e != io.EOF {
+		return false
+	}
+
+	match := regexp.MustCompile(`<indexListOffset>\s*(\d+)\s*</indexListOffset>`).FindSubmatch(b)
+	if match == nil {
+		return false
+	}
+
+	offset, e := strconv.ParseInt(string(match[1]), 10, 64)
+	if e != nil || offset >= stat.Size() {
+		return false
+	}
+
+	if _, e := p.file.Seek(offset, io.SeekStart); e != nil {
+		return false
+	}
+
+	decoder := xml.NewDecoder(bufio.NewReader(p.file))
+	decoder.CharsetReader = charset.NewReader
+
+	var il psi.IndexList
+	if e := decoder.Decode(&il); e != nil {
+		return false
+	}
+
+	for _, i := range il.Index {
+		if i.Name == "spectrum" {
+			for _, j := range i.Offset {
+				p.IDs = append(p.IDs, j.IDRef)
+				p.offsets = append(p.offsets, j.Value)
+			}
+		}
+	}
+
+	return len(p.offsets) > 0
+}
+
+// isValid checks that the first and last offsets point to a spectrum element
+func (p *Index) isValid() bool {
+
+	var tag = []byte("<spectrum ")
+
+	for _, i := range []int64{p.offsets[0], p.offsets[len(p.offsets)-1]} {
+		b := make([]byte, len(tag))
+		if _, e := p.file.ReadAt(b, i); e != nil || !bytes.Equal(b, tag) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// buildIndex tokenizes the whole file and records the offsets of every spectrum element
+func (p *Index) buildIndex() {
+
+	p.IDs = nil
+	p.offsets = nil
+
+	if _, e := p.file.Seek(0, io.SeekStart); e != nil {
+		msg.Custom(errors.New("error seeking file"), "error")
+	}
+
+	decoder := xml.NewDecoder(bufio.NewReader(p.file))
+	decoder.CharsetReader = charset.NewReader
+
+	for {
+
+		offset := decoder.InputOffset()
+
+		t, e := decoder.Token()
+		if e == io.EOF {
+			break
+		} else if e != nil {
+			msg.DecodeMsgPck(e, "error")
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if se.Name.Local == "spectrum" {
+
+			var id string
+			for _, i := range se.Attr {
+				if i.Name.Local == "id" {
+					id = i.Value
+				}
+			}
+
+			p.IDs = append(p.IDs, id)
+			p.offsets = append(p.offsets, offset)
+
+			decoder.Skip()
+
+		} else if se.Name.Local == "chromatogramList" {
+			break
+		}
+	}
+
+	if len(p.offsets) == 0 {
+		msg.NoSpectraFound(errors.New(""), "error")
+	}
+
+}
@@ -0,0 +1,70 @@
+package mzn_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"philosopher/lib/mzn"
+)
+
+func TestIndexNativeScans(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "mzn")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	// the scans of a DDA file do not start at 1 and are not contiguous
+	body := strings.Replace(testMzML, `id="scan=1"`, `id="controllerType=0 controllerNumber=1 scan=5"`, 1)
+	body = strings.Replace(body, `id="scan=2"`, `id="controllerType=0 controllerNumber=1 scan=9"`, 1)
+
+	unindexed := body
+	body = strings.Replace(body, "</indexedmzML>\n", "", 1)
+	first := strings.Index(body, `<spectrum index="0"`)
+	second := strings.Index(body, `<spectrum index="1"`)
+	indexed := fmt.Sprintf("%s<indexList count=\"1\"><index name=\"spectrum\"><offset idRef=\"controllerType=0 controllerNumber=1 scan=5\">%d</offset><offset idRef=\"controllerType=0 controllerNumber=1 scan=9\">%d</offset></index></indexList>\n<indexListOffset>%d</indexListOffset>\n</indexedmzML>\n", body, first, second, len(body))
+
+	tests := []struct {
+		scan  string
+		found bool
+		level string
+	}{
+		{"5", true, "1"},
+		{"9", true, "2"},
+		{"1", false, ""},
+		{"2", false, ""},
+	}
+
+	for name, doc := range map[string]string{"indexed": indexed, "unindexed": unindexed} {
+
+		f := filepath.Join(dir, name+".mzML")
+		if e := ioutil.WriteFile(f, []byte(doc), 0644); e != nil {
+			t.Fatal(e)
+		}
+
+		idx := mzn.NewIndex(f)
+
+		for _, tt := range tests {
+			spec, ok := idx.Scan(tt.scan)
+			if ok != tt.found || (ok && (spec.Scan != tt.scan || spec.Level != tt.level)) {
+				t.Errorf("%s: Scan(%s) = %s level %s, %v, want level %s, %v", name, tt.scan, spec.Scan, spec.Level, ok, tt.level, tt.found)
+			}
+		}
+
+		var scans []string
+		idx.Walker([]string{"9", "5", "7"})(func(s mzn.Spectrum) {
+			scans = append(scans, s.Scan)
+		})
+
+		if len(scans) != 2 || scans[0] != "5" || scans[1] != "9" {
+			t.Errorf("%s: Walker scans are incorrect, got %v, want %v", name, scans, []string{"5", "9"})
+		}
+
+		idx.Close()
+	}
+}
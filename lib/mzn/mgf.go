@@ -0,0 +1,178 @@
+package mzn
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/msg"
+)
+
+// ReadMGF parses the MS2 spectra from a Mascot Generic Format file
+func (p *MsData) ReadMGF(f string) {
+
+	file, e := os.Open(f)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+	defer file.Close()
+
+	p.FileName = f
+
+	var spectra Spectra
+	var spec Spectrum
+	var inIons bool
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if len(line) == 0 || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if line == "BEGIN IONS" {
+			spec = Spectrum{Level: "2"}
+			spec.Mz.Precision = "64"
+			spec.Intensity.Precision = "64"
+			inIons = true
+			continue
+		}
+
+		if !inIons {
+			continue
+		}
+
+		if line == "END IONS" {
+
+			spec.Index = strconv.Itoa(len(spectra))
+			if len(spec.Scan) == 0 {
+				spec.Scan = scanFromTitle(spec.SpectrumName, len(spectra)+1)
+			}
+
+			spectra = append(spectra, spec)
+			inIons = false
+			continue
+		}
+
+		if eq := strings.Index(line, "="); eq > 0 && !isNumeric(line[0]) {
+			parseMGFHeader(&spec, strings.ToUpper(line[:eq]), strings.TrimSpace(line[eq+1:]))
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		mz, e := strconv.ParseFloat(fields[0], 64)
+		if e != nil {
+			msg.CastFloatToString(e, "error")
+		}
+
+		intensity, e := strconv.ParseFloat(fields[1], 64)
+		if e != nil {
+			msg.CastFloatToString(e, "error")
+		}
+
+		spec.Mz.DecodedStream = append(spec.Mz.DecodedStream, mz)
+		spec.Intensity.DecodedStream = append(spec.Intensity.DecodedStream, intensity)
+	}
+
+	if e := scanner.Err(); e != nil {
+		msg.ReadFile(e, "error")
+	}
+
+	if len(spectra) == 0 {
+		msg.NoSpectraFound(errors.New(""), "error")
+	}
+
+	p.Spectra = spectra
+}
+
+// parseMGFHeader sets the spectrum attributes from the MGF header lines
+func parseMGFHeader(spec *Spectrum, key, value string) {
+
+	switch key {
+	case "TITLE":
+		spec.SpectrumName = value
+
+	case "SCANS":
+		// scan ranges from merged spectra are referenced by the first scan
+		spec.Scan = strings.Split(value, "-")[0]
+		if scan, e := strconv.Atoi(spec.Scan); e == nil {
+			spec.Scan = strconv.Itoa(scan)
+		}
+
+	case "RTINSECONDS":
+		rt, e := strconv.ParseFloat(strings.Split(value, "-")[0], 64)
+		if e != nil {
+			msg.CastFloatToString(e, "error")
+		}
+		spec.ScanStartTime = rt / 60
+
+	case "PEPMASS":
+		fields := strings.Fields(value)
+		if len(fields) == 0 {
+			return
+		}
+
+		mz, e := strconv.ParseFloat(fields[0], 64)
+		if e != nil {
+			msg.CastFloatToString(e, "error")
+		}
+		spec.Precursor.SelectedIon = mz
+		spec.Precursor.TargetIon = mz
+
+		if len(fields) > 1 {
+			intensity, e := strconv.ParseFloat(fields[1], 64)
+			if e != nil {
+				msg.CastFloatToString(e, "error")
+			}
+			spec.Precursor.SelectedIonIntensity = intensity
+		}
+
+	case "CHARGE":
+		// multiple charges like 2+ and 3+ are ambiguous, the first one is kept
+		charges := strings.Fields(strings.Replace(value, ",", " ", -1))
+		if len(charges) == 0 {
+			return
+		}
+
+		charge := strings.TrimRight(charges[0], "+-")
+		z, e := strconv.Atoi(charge)
+		if e == nil {
+			spec.Precursor.ChargeState = z
+		}
+	}
+
+}
+
+// tppScanRegex matches the scan number of the TPP spectrum titles, like file.1234.1234.2
+var tppScanRegex = regexp.MustCompile(`\.(\d+)\.\d+\.\d+(?:\s|$)`)
+
+// scanFromTitle extracts the scan number from the spectrum title, using the native ID or
+// the TPP naming convention. The spectrum position is used when none is found
+func scanFromTitle(title string, position int) string {
+
+	for _, re := range []*regexp.Regexp{nativeScanRegex, tppScanRegex} {
+		match := re.FindStringSubmatch(title)
+		if match != nil {
+			scan, _ := strconv.Atoi(match[1])
+			return strconv.Itoa(scan)
+		}
+	}
+
+	return strconv.Itoa(position)
+}
+
+// isNumeric checks if the character is part of a number
+func isNumeric(c byte) bool {
+	return (c >= '0' && c <= '9') || c == '.' || c == '-' || c == '+'
+}
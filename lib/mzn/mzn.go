@@ -6,12 +6,16 @@ import (
 	"encoding/base64"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"math"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 
+	"philosopher/lib/fin"
 	"philosopher/lib/msg"
 
 	"philosopher/lib/psi"
@@ -54,6 +58,9 @@ type Precursor struct {
 	TargetIonIntensity         float64
 	IsolationWindowLowerOffset float64
 	IsolationWindowUpperOffset float64
+	IonMobility                float64
+	Activation                 string
+	CollisionEnergy            float64
 }
 
 // Mz struct
@@ -187,35 +194,100 @@ func (p *MsData) ReadRaw(fileName, f string) {
 	p.Spectra = spectra
 }
 
-// Read is the main function for parsing mzML data
-func (p *MsData) Read(f string) {
-
-	var xml psi.IndexedMzML
-	xml.Parse(f)
+// ReadThermoRaw is the main function for parsing Thermo Raw data with the native reader
+func (p *MsData) ReadThermoRaw(f string) {
 
-	if xml.MzML.SoftwareList.Software[0].ID == "pwiz" {
-		version, _ := strconv.Atoi(strings.Replace(xml.MzML.SoftwareList.Software[0].Version, ".", "", -1))
-		if version <= 3020232 {
-			msg.Custom(errors.New("the msconvert version used to convert this file is not supported, or is deprecated. Please update your ProteoWizard and convert the raw files again"), "warning")
-		}
-	}
+	var rd fin.RawData
+	rd.ProcessRaw(f)
+	defer rd.Close()
 
 	p.FileName = f
 
+	if len(rd.Trailer) == 0 {
+		logrus.Warning("the scan trailer of ", filepath.Base(f), " was not found, the precursor charge states are unknown")
+	}
+
 	var spectra Spectra
-	sl := xml.MzML.Run.SpectrumList
 
-	for _, i := range sl.Spectrum {
+	// the most recent scan on each MS level, used to find the parent of MSn scans
+	var lastScan = make(map[uint8]int)
+
+	for sn := 1; sn <= rd.NScans(); sn++ {
+
+		scan := rd.Scan(sn)
+		if scan.MSLevel < 1 {
+			continue
+		}
+
+		var spec Spectrum
+
+		spec.Scan = strconv.Itoa(rd.ScanNumber(sn))
+		spec.Index = strconv.Itoa(sn - 1)
+		spec.Level = strconv.Itoa(int(scan.MSLevel))
+		spec.ScanStartTime = scan.Time
+
+		if scan.MSLevel > 1 {
+
+			parent, ok := lastScan[scan.MSLevel-1]
+			if ok {
+				spec.Precursor.ParentScan = strconv.Itoa(rd.ScanNumber(parent))
+				spec.Precursor.ParentIndex = strconv.Itoa(parent - 1)
+			}
+
+			spec.Precursor.ChargeState = rd.ChargeState(sn)
+			spec.Precursor.Activation = rd.Activation(sn)
+
+			// the last reaction describes the ion isolated for this scan
+			reactions := rd.Scanevents[sn-1].Reaction
+			if len(reactions) > 0 {
+				r := reactions[len(reactions)-1]
+				spec.Precursor.SelectedIon = r.Precursormz
+				spec.Precursor.TargetIon = r.Precursormz
+				spec.Precursor.CollisionEnergy = r.Energy
+
+				if r.IsolationWidth > 0 {
+					spec.Precursor.IsolationWindowLowerOffset = r.IsolationWidth / 2
+					spec.Precursor.IsolationWindowUpperOffset = r.IsolationWidth / 2
+				}
+			}
+		}
+
+		lastScan[scan.MSLevel] = sn
+
+		peaks := scan.Spectrum(true)
+		sort.Sort(peaks)
+
+		spec.Mz.Precision = "64"
+		spec.Intensity.Precision = "64"
+		spec.Mz.DecodedStream = make([]float64, len(peaks))
+		spec.Intensity.DecodedStream = make([]float64, len(peaks))
 
-		spectrum := processSpectrum(i)
+		for i := range peaks {
+			spec.Mz.DecodedStream[i] = peaks[i].Mz
+			spec.Intensity.DecodedStream[i] = float64(peaks[i].I)
+		}
 
-		spectra = append(spectra, spectrum)
+		spectra = append(spectra, spec)
 	}
 
 	if len(spectra) == 0 {
 		msg.NoSpectraFound(errors.New(""), "error")
 	}
 
+	p.Spectra = spectra
+}
+
+// Read is the main function for parsing mzML data
+func (p *MsData) Read(f string) {
+
+	p.FileName = f
+
+	var spectra Spectra
+
+	readMzML(f, func(mzSpec psi.Spectrum) {
+		spectra = append(spectra, processSpectrum(mzSpec))
+	})
+
 	p.Spectra = spectra
 
 }
@@ -225,11 +297,10 @@ func processSpectrum(mzSpec psi.Spectrum) Spectrum {
 	var spec Spectrum
 
 	spec.Index = string(mzSpec.Index)
+	spec.SpectrumName = mzSpec.ID
 
-	indexStr := string(mzSpec.Index)
-	indexInt, _ := strconv.Atoi(indexStr)
-	indexInt++
-	spec.Scan = string(strconv.Itoa(indexInt))
+	indexInt, _ := strconv.Atoi(spec.Index)
+	spec.Scan = nativeScan(mzSpec.ID, indexInt)
 
 	for _, j := range mzSpec.CVParam {
 		if string(j.Accession) == "MS:1000511" {
@@ -317,59 +388,59 @@ func processSpectrum(mzSpec psi.Spectrum) Spectrum {
 				}
 				spec.Precursor.SelectedIonIntensity = val
 			}
-		}
-	}
 
-	spec.Mz.Stream = mzSpec.BinaryDataArrayList.BinaryDataArray[0].Binary.Value
-	for _, j := range mzSpec.BinaryDataArrayList.BinaryDataArray[0].CVParam {
-		if string(j.Accession) == "MS:1000523" {
-			spec.Mz.Precision = "64"
-		} else if string(j.Accession) == "MS:1000521" {
-			spec.Mz.Precision = "32"
+			// inverse reduced ion mobility
+			if string(j.Accession) == "MS:1002815" {
+				val, e := strconv.ParseFloat(j.Value, 64)
+				if e != nil {
+					msg.CastFloatToString(e, "error")
+				}
+				spec.Precursor.IonMobility = val
+			}
 		}
 
-		if string(j.Accession) == "MS:1000574" {
-			spec.Mz.Compression = "1"
-		} else if string(j.Accession) == "MS:1000576" {
-			spec.Mz.Compression = "0"
-		}
-	}
+		for _, j := range mzSpec.PrecursorList.Precursor[0].Activation.CVParam {
 
-	spec.Intensity.Stream = mzSpec.BinaryDataArrayList.BinaryDataArray[1].Binary.Value
-	for _, j := range mzSpec.BinaryDataArrayList.BinaryDataArray[1].CVParam {
-		if string(j.Accession) == "MS:1000523" {
-			spec.Intensity.Precision = "64"
-		} else if string(j.Accession) == "MS:1000521" {
-			spec.Intensity.Precision = "32"
-		}
+			if method, ok := activationType(string(j.Accession)); ok {
+				spec.Precursor.Activation = method
+			}
 
-		if string(j.Accession) == "MS:1000574" {
-			spec.Intensity.Compression = "1"
-		} else if string(j.Accession) == "MS:1000576" {
-			spec.Intensity.Compression = "0"
+			if string(j.Accession) == "MS:1000045" {
+				val, e := strconv.ParseFloat(j.Value, 64)
+				if e != nil {
+					msg.CastFloatToString(e, "error")
+				}
+				spec.Precursor.CollisionEnergy = val
+			}
 		}
 	}
 
+	spec.Mz.Stream = mzSpec.BinaryDataArrayList.BinaryDataArray[0].Binary.Value
+	spec.Mz.Precision, spec.Mz.Compression = binaryParams(mzSpec.BinaryDataArrayList.BinaryDataArray[0].CVParam)
+
+	spec.Intensity.Stream = mzSpec.BinaryDataArrayList.BinaryDataArray[1].Binary.Value
+	spec.Intensity.Precision, spec.Intensity.Compression = binaryParams(mzSpec.BinaryDataArrayList.BinaryDataArray[1].CVParam)
+
 	if mzSpec.BinaryDataArrayList.Count == 3 {
 		spec.IonMobility.Stream = mzSpec.BinaryDataArrayList.BinaryDataArray[2].Binary.Value
-		for _, j := range mzSpec.BinaryDataArrayList.BinaryDataArray[2].CVParam {
-			if string(j.Accession) == "MS:1000523" {
-				spec.IonMobility.Precision = "64"
-			} else if string(j.Accession) == "MS:1000521" {
-				spec.IonMobility.Precision = "32"
-			}
-
-			if string(j.Accession) == "MS:1000574" {
-				spec.IonMobility.Compression = "1"
-			} else if string(j.Accession) == "MS:1000576" {
-				spec.IonMobility.Compression = "0"
-			}
-		}
+		spec.IonMobility.Precision, spec.IonMobility.Compression = binaryParams(mzSpec.BinaryDataArrayList.BinaryDataArray[2].CVParam)
 	}
 
 	return spec
 }
 
+// nativeScan returns the scan number from the spectrum native ID, or the position in the file
+// when the ID does not carry one
+func nativeScan(id string, index int) string {
+
+	match := nativeScanRegex.FindStringSubmatch(id)
+	if match != nil {
+		return match[1]
+	}
+
+	return strconv.Itoa(index + 1)
+}
+
 // Decode processes the binary data
 func (s *Spectrum) Decode() {
 
@@ -388,8 +459,43 @@ func (s *Spectrum) Decode() {
 
 }
 
+// compressionTypes maps the PSI-MS binary data compression accessions to the
+// compression labels used by readEncoded
+var compressionTypes = map[string]string{
+	"MS:1000576": "0",
+	"MS:1000574": "1",
+	"MS:1002312": "linear",
+	"MS:1002313": "pic",
+	"MS:1002314": "slof",
+	"MS:1002746": "linear-zlib",
+	"MS:1002747": "pic-zlib",
+	"MS:1002748": "slof-zlib",
+}
+
+// binaryParams reads the precision and compression from the binary data array CV terms
+func binaryParams(cv []psi.CVParam) (string, string) {
+
+	var precision, compression string
+
+	for _, j := range cv {
+		if string(j.Accession) == "MS:1000523" {
+			precision = "64"
+		} else if string(j.Accession) == "MS:1000521" {
+			precision = "32"
+		}
+
+		if c, ok := compressionTypes[j.Accession]; ok {
+			compression = c
+		} else if strings.Contains(strings.ToLower(j.Name), "compression") {
+			msg.UnsupportedCompression(fmt.Errorf("%s (%s)", j.Name, j.Accession), "error")
+		}
+	}
+
+	return precision, compression
+}
+
 // readEncoded transforms the binary data into float64 values
-func readEncoded(bin []byte, precision, isCompressed string) []float64 {
+func readEncoded(bin []byte, precision, compression string) []float64 {
 
 	var stream []uint8
 	var floatArray []float64
@@ -398,13 +504,10 @@ func readEncoded(bin []byte, precision, isCompressed string) []float64 {
 	b64 := base64.NewDecoder(base64.StdEncoding, b)
 
 	var bytestream bytes.Buffer
-	if isCompressed == "1" {
+	if compression == "1" || strings.HasSuffix(compression, "-zlib") {
 		r, e := zlib.NewReader(b64)
 		if e != nil {
-			msg.ReadingMzMLZlib(e, "fatal")
-			var emptyArray []float64
-			emptyArray = append(emptyArray, 0.0)
-			return emptyArray
+			msg.ReadingMzMLZlib(e, "error")
 		}
 		io.Copy(&bytestream, r)
 	} else {
@@ -413,6 +516,18 @@ func readEncoded(bin []byte, precision, isCompressed string) []float64 {
 
 	dataArray := bytestream.Bytes()
 
+	switch strings.TrimSuffix(compression, "-zlib") {
+	case "", "0", "1":
+	case "linear":
+		return readNumpress(decodeLinear(dataArray))
+	case "pic":
+		return readNumpress(decodePic(dataArray))
+	case "slof":
+		return readNumpress(decodeSlof(dataArray))
+	default:
+		msg.UnsupportedCompression(errors.New(compression), "error")
+	}
+
 	var counter int
 
 	if precision == "32" {
@@ -445,3 +560,13 @@ func readEncoded(bin []byte, precision, isCompressed string) []float64 {
 
 	return floatArray
 }
+
+// readNumpress checks the result of the MS-Numpress decoding
+func readNumpress(floatArray []float64, e error) []float64 {
+
+	if e != nil {
+		msg.ReadingMzMLNumpress(e, "error")
+	}
+
+	return floatArray
+}
@@ -1,9 +1,18 @@
 package mzn_test
 
 import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
 
 	"philosopher/lib/mzn"
+	"philosopher/lib/psi"
 	"philosopher/lib/tes"
 	"philosopher/lib/uti"
 )
@@ -108,3 +117,481 @@ func TestMS2Spectra(t *testing.T) {
 		t.Errorf("Spectrum number is incorrect, got %f, want %f", spec.Precursor.IsolationWindowLowerOffset, 0.2500)
 	}
 }
+
+func TestStreamMzML(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "mzn")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "stream.mzML")
+	if e := ioutil.WriteFile(f, []byte(testMzML), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	var levels []string
+	mzn.StreamMzML(f)(func(s mzn.Spectrum) {
+		levels = append(levels, s.Level)
+	})
+
+	if len(levels) != 2 {
+		t.Errorf("Spectra number is incorrect, got %d, want %d", len(levels), 2)
+	}
+
+	var ms2 []mzn.Spectrum
+	mzn.StreamMzML(f)(func(s mzn.Spectrum) {
+		ms2 = append(ms2, s)
+	}, "2")
+
+	if len(ms2) != 1 {
+		t.Fatalf("MS2 spectra number is incorrect, got %d, want %d", len(ms2), 1)
+	}
+
+	if ms2[0].Scan != "2" || ms2[0].Precursor.ParentScan != "1" {
+		t.Errorf("Spectrum scan is incorrect, got %s (parent %s), want 2 (parent 1)", ms2[0].Scan, ms2[0].Precursor.ParentScan)
+	}
+
+	if len(ms2[0].Mz.DecodedStream) != 2 || ms2[0].Mz.DecodedStream[1] != 200.5 {
+		t.Errorf("Spectrum MZ is incorrect, got %v, want %v", ms2[0].Mz.DecodedStream, []float64{100.25, 200.5})
+	}
+
+	if ms2[0].Intensity.DecodedStream[0] != 1000 {
+		t.Errorf("Spectrum Intensity is incorrect, got %f, want %f", ms2[0].Intensity.DecodedStream[0], 1000.0)
+	}
+}
+
+func TestStreamBrukerTDF(t *testing.T) {
+
+	// the synthetic acquisition has 299 MS1 frames, the first one with peaks, and a PASEF frame
+	// fragmenting a precursor from the first frame on the scans 1 and 2
+	var ms1, ms2 []mzn.Spectrum
+	mzn.StreamBrukerTDF(filepath.Join("..", "tdf", "testdata", "calibrated.d"))(func(s mzn.Spectrum) {
+		if s.Level == "1" {
+			ms1 = append(ms1, s)
+		} else {
+			ms2 = append(ms2, s)
+		}
+	})
+
+	if len(ms1) != 299 || len(ms2) != 1 {
+		t.Fatalf("Spectra number is incorrect, got %d MS1 and %d MS2, want 299 and 1", len(ms1), len(ms2))
+	}
+
+	tests := []struct {
+		name     string
+		spec     mzn.Spectrum
+		scan     string
+		peaks    int
+		mobility []float64
+	}{
+		{"MS1", ms1[0], "1", 3, []float64{1.6, 1.104, 1.6}},
+		{"MS2", ms2[0], "2", 1, nil},
+		{"empty MS1", ms1[1], "3", 0, []float64{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			if tt.spec.Scan != tt.scan {
+				t.Errorf("Spectrum scan is incorrect, got %s, want %s", tt.spec.Scan, tt.scan)
+			}
+
+			mz := tt.spec.Mz.DecodedStream
+			if len(mz) != tt.peaks || len(tt.spec.Intensity.DecodedStream) != tt.peaks {
+				t.Fatalf("Spectrum peaks number is incorrect, got %d, want %d", len(mz), tt.peaks)
+			}
+
+			for i := 1; i < len(mz); i++ {
+				if mz[i] <= mz[i-1] || mz[i-1] < 100 || mz[i] > 1700 {
+					t.Errorf("Spectrum MZ is incorrect, got %v", mz)
+				}
+			}
+
+			if tt.mobility != nil && len(tt.spec.IonMobility.DecodedStream) != len(tt.mobility) {
+				t.Fatalf("Spectrum mobility is incorrect, got %v, want %v", tt.spec.IonMobility.DecodedStream, tt.mobility)
+			}
+
+			for i := range tt.mobility {
+				if math.Abs(tt.spec.IonMobility.DecodedStream[i]-tt.mobility[i]) > 1e-9 {
+					t.Errorf("Spectrum mobility is incorrect, got %v, want %v", tt.spec.IonMobility.DecodedStream, tt.mobility)
+				}
+			}
+		})
+	}
+
+	p := ms2[0].Precursor
+	if p.ParentScan != "1" || p.ChargeState != 2 || p.TargetIon != 500.5 || math.Abs(p.IonMobility-1.104) > 1e-9 || p.Activation != "CID" || p.CollisionEnergy != 30 {
+		t.Errorf("Precursor is incorrect, got %+v", p)
+	}
+
+	// the fragments of both scans share the TOF index and are summed
+	if ms2[0].Intensity.DecodedStream[0] != 10 {
+		t.Errorf("Spectrum Intensity is incorrect, got %f, want %f", ms2[0].Intensity.DecodedStream[0], 10.0)
+	}
+}
+
+// mz 100.25, 200.5 and intensities 1000, 2000 as uncompressed 64-bit floats
+const testMzML = `<?xml version="1.0" encoding="utf-8"?>
+<indexedmzML>
+<mzML>
+<softwareList count="1"><software id="test" version="1.0"/></softwareList>
+<run id="stream">
+<spectrumList count="2">
+<spectrum index="0" id="scan=1" defaultArrayLength="2">
+<cvParam accession="MS:1000511" name="ms level" value="1"/>
+<scanList count="1"><scan><cvParam accession="MS:1000016" name="scan start time" value="1.5"/></scan></scanList>
+<binaryDataArrayList count="2">
+<binaryDataArray><cvParam accession="MS:1000523"/><cvParam accession="MS:1000576"/><binary>AAAAAAAQWUAAAAAAABBpQA==</binary></binaryDataArray>
+<binaryDataArray><cvParam accession="MS:1000523"/><cvParam accession="MS:1000576"/><binary>AAAAAABAj0AAAAAAAECfQA==</binary></binaryDataArray>
+</binaryDataArrayList>
+</spectrum>
+<spectrum index="1" id="scan=2" defaultArrayLength="2">
+<cvParam accession="MS:1000511" name="ms level" value="2"/>
+<scanList count="1"><scan><cvParam accession="MS:1000016" name="scan start time" value="1.6"/></scan></scanList>
+<precursorList count="1"><precursor spectrumRef="scan=1">
+<selectedIonList count="1"><selectedIon><cvParam accession="MS:1000744" value="100.25"/><cvParam accession="MS:1000041" value="2"/></selectedIon></selectedIonList>
+<activation><cvParam accession="MS:1000422" name="beam-type collision-induced dissociation"/><cvParam accession="MS:1000045" value="30"/></activation>
+</precursor></precursorList>
+<binaryDataArrayList count="2">
+<binaryDataArray><cvParam accession="MS:1000523"/><cvParam accession="MS:1000576"/><binary>AAAAAAAQWUAAAAAAABBpQA==</binary></binaryDataArray>
+<binaryDataArray><cvParam accession="MS:1000523"/><cvParam accession="MS:1000576"/><binary>AAAAAABAj0AAAAAAAECfQA==</binary></binaryDataArray>
+</binaryDataArrayList>
+</spectrum>
+</spectrumList>
+</run>
+</mzML>
+</indexedmzML>
+`
+
+func TestIndex(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "mzn")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	// append an indexList pointing to both spectra
+	body := strings.Replace(testMzML, "</indexedmzML>\n", "", 1)
+	first := strings.Index(body, `<spectrum index="0"`)
+	second := strings.Index(body, `<spectrum index="1"`)
+	indexed := fmt.Sprintf("%s<indexList count=\"1\"><index name=\"spectrum\"><offset idRef=\"scan=1\">%d</offset><offset idRef=\"scan=2\">%d</offset></index></indexList>\n<indexListOffset>%d</indexListOffset>\n</indexedmzML>\n", body, first, second, len(body))
+
+	for name, doc := range map[string]string{"indexed": indexed, "unindexed": testMzML} {
+
+		f := filepath.Join(dir, name+".mzML")
+		if e := ioutil.WriteFile(f, []byte(doc), 0644); e != nil {
+			t.Fatal(e)
+		}
+
+		idx := mzn.NewIndex(f)
+
+		if idx.Len() != 2 {
+			t.Errorf("%s: Spectra number is incorrect, got %d, want %d", name, idx.Len(), 2)
+		}
+
+		spec, ok := idx.Spectrum("scan=2")
+		if !ok || spec.Level != "2" || spec.Precursor.ChargeState != 2 {
+			t.Errorf("%s: Spectrum scan=2 is incorrect, got level %s charge %d", name, spec.Level, spec.Precursor.ChargeState)
+		}
+
+		var scans []string
+		idx.Walker([]string{"2", "1", "2"})(func(s mzn.Spectrum) {
+			scans = append(scans, s.Scan)
+		})
+
+		if len(scans) != 2 || scans[0] != "1" || scans[1] != "2" {
+			t.Errorf("%s: Walker scans are incorrect, got %v, want %v", name, scans, []string{"1", "2"})
+		}
+
+		idx.Close()
+	}
+}
+
+func TestDecodeNumpress(t *testing.T) {
+
+	// fixed point 1.0, 100 and 101 as the first values, and a zero residual for 102
+	linear := []byte{0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 100, 0, 0, 0, 101, 0, 0, 0, 0x80}
+
+	// 1, 2 and 3 stored with 7 leading zeros each
+	pic := []byte{0x71, 0x72, 0x73}
+
+	// fixed point 1.0, and the logged values 0 and 1
+	slof := []byte{0x3f, 0xf0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0}
+
+	spec := mzn.Spectrum{
+		Mz:          mzn.Mz{Stream: []byte(base64.StdEncoding.EncodeToString(linear)), Precision: "64", Compression: "linear"},
+		Intensity:   mzn.Intensity{Stream: []byte(base64.StdEncoding.EncodeToString(pic)), Precision: "64", Compression: "pic"},
+		IonMobility: mzn.IonMobility{Stream: []byte(base64.StdEncoding.EncodeToString(slof)), Precision: "64", Compression: "slof"},
+	}
+
+	spec.Decode()
+
+	if !reflect.DeepEqual(spec.Mz.DecodedStream, []float64{100, 101, 102}) {
+		t.Errorf("Linear decoding is incorrect, got %v, want %v", spec.Mz.DecodedStream, []float64{100, 101, 102})
+	}
+
+	if !reflect.DeepEqual(spec.Intensity.DecodedStream, []float64{1, 2, 3}) {
+		t.Errorf("Pic decoding is incorrect, got %v, want %v", spec.Intensity.DecodedStream, []float64{1, 2, 3})
+	}
+
+	if !reflect.DeepEqual(spec.IonMobility.DecodedStream, []float64{0, math.Exp(1) - 1}) {
+		t.Errorf("Slof decoding is incorrect, got %v, want %v", spec.IonMobility.DecodedStream, []float64{0, math.Exp(1) - 1})
+	}
+}
+
+const testMGF = `BEGIN IONS
+TITLE=sample.01234.01234.2
+RTINSECONDS=90
+PEPMASS=500.25 12000
+CHARGE=2+
+100.5 10
+200.5 20
+END IONS
+BEGIN IONS
+TITLE=sample controllerType=0 controllerNumber=1 scan=1240
+RTINSECONDS=96
+PEPMASS=600.75
+CHARGE=3+
+150.5 30
+END IONS
+`
+
+func TestReadMGF(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "mzn")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "sample.mgf")
+	if e := ioutil.WriteFile(f, []byte(testMGF), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	var mz mzn.MsData
+	mz.ReadMGF(f)
+
+	if len(mz.Spectra) != 2 {
+		t.Fatalf("Spectra number is incorrect, got %d, want %d", len(mz.Spectra), 2)
+	}
+
+	if mz.Spectra[0].Scan != "1234" || mz.Spectra[1].Scan != "1240" {
+		t.Errorf("Scans are incorrect, got %s and %s, want %s and %s", mz.Spectra[0].Scan, mz.Spectra[1].Scan, "1234", "1240")
+	}
+
+	if mz.Spectra[0].ScanStartTime != 1.5 || mz.Spectra[0].Precursor.ChargeState != 2 || mz.Spectra[0].Precursor.TargetIon != 500.25 {
+		t.Errorf("Spectrum attributes are incorrect, got %v", mz.Spectra[0])
+	}
+
+	if !reflect.DeepEqual(mz.Spectra[0].Intensity.DecodedStream, []float64{10, 20}) {
+		t.Errorf("Intensities are incorrect, got %v, want %v", mz.Spectra[0].Intensity.DecodedStream, []float64{10, 20})
+	}
+}
+
+func TestReadMGFEmptyValues(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "mzn")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "empty.mgf")
+	if e := ioutil.WriteFile(f, []byte("BEGIN IONS\nTITLE=empty.00007.00007.0\nPEPMASS=\nCHARGE= \n100.5 10\nEND IONS\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	var mz mzn.MsData
+	mz.ReadMGF(f)
+
+	if len(mz.Spectra) != 1 || mz.Spectra[0].Precursor.TargetIon != 0 || mz.Spectra[0].Precursor.ChargeState != 0 {
+		t.Errorf("Spectra are incorrect, got %v", mz.Spectra)
+	}
+}
+
+const testMzXML = `<?xml version="1.0" encoding="ISO-8859-1"?>
+<mzXML xmlns="http://sashimi.sourceforge.net/schema_revision/mzXML_3.2">
+<msRun scanCount="2">
+<scan num="1" msLevel="1" peaksCount="2" retentionTime="PT90S">
+<peaks precision="32" byteOrder="network" compressionType="none" contentType="m/z-int">QsiAAER6AABDSIAARPoAAA==</peaks>
+<scan num="2" msLevel="2" peaksCount="1" retentionTime="PT96S">
+<precursorMz precursorIntensity="1000" precursorCharge="2" windowWideness="1.6" activationMethod="ETD">100.25</precursorMz>
+<peaks precision="32" byteOrder="network" compressionType="none" contentType="m/z-int">QxaAAEP6AAA=</peaks>
+</scan>
+</scan>
+</msRun>
+</mzXML>
+`
+
+func TestReadMzXML(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "mzn")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "sample.mzXML")
+	if e := ioutil.WriteFile(f, []byte(testMzXML), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	var mz mzn.MsData
+	mz.ReadMzXML(f)
+
+	if len(mz.Spectra) != 2 {
+		t.Fatalf("Spectra number is incorrect, got %d, want %d", len(mz.Spectra), 2)
+	}
+
+	if mz.Spectra[0].Level != "1" || mz.Spectra[1].Level != "2" {
+		t.Errorf("Spectra order is incorrect, got levels %s and %s", mz.Spectra[0].Level, mz.Spectra[1].Level)
+	}
+
+	if !reflect.DeepEqual(mz.Spectra[0].Mz.DecodedStream, []float64{100.25, 200.5}) {
+		t.Errorf("M/z values are incorrect, got %v, want %v", mz.Spectra[0].Mz.DecodedStream, []float64{100.25, 200.5})
+	}
+
+	p := mz.Spectra[1].Precursor
+	if p.ParentScan != "1" || p.ChargeState != 2 || p.TargetIon != 100.25 || p.IsolationWindowLowerOffset != 0.8 || p.Activation != "ETD" {
+		t.Errorf("Precursor is incorrect, got %v", p)
+	}
+
+	if mz.Spectra[1].ScanStartTime != 1.6 {
+		t.Errorf("Retention time is incorrect, got %v, want %v", mz.Spectra[1].ScanStartTime, 1.6)
+	}
+}
+
+func TestMzMLWriter(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "mzn")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "source.mzML")
+	if e := ioutil.WriteFile(src, []byte(testMzML), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	for _, zlib := range []bool{false, true} {
+
+		f := filepath.Join(dir, fmt.Sprintf("zlib_%v.mzML", zlib))
+
+		w := mzn.NewMzMLWriter(f, src, "test", "64", "32", zlib, false)
+		mzn.StreamMzML(src)(func(s mzn.Spectrum) {
+			w.Write(s, psi.UserParam{Name: "peptide", Value: "PEPTIDE", Type: "xsd:string"})
+		})
+		w.Close()
+
+		doc, _ := ioutil.ReadFile(f)
+		if strings.Count(string(doc), `<userParam name="peptide" value="PEPTIDE" type="xsd:string"/>`) != 2 {
+			t.Errorf("zlib %v: User parameters are missing", zlib)
+		}
+
+		idx := mzn.NewIndex(f)
+
+		if idx.Len() != 2 {
+			t.Errorf("zlib %v: Spectra number is incorrect, got %d, want %d", zlib, idx.Len(), 2)
+		}
+
+		spec, ok := idx.Spectrum("scan=2")
+		if !ok || spec.Level != "2" || spec.Precursor.ChargeState != 2 || spec.Precursor.ParentScan != "1" {
+			t.Errorf("zlib %v: Spectrum scan=2 is incorrect, got level %s charge %d parent %s", zlib, spec.Level, spec.Precursor.ChargeState, spec.Precursor.ParentScan)
+		}
+
+		if spec.Precursor.Activation != "HCD" || spec.Precursor.CollisionEnergy != 30 {
+			t.Errorf("zlib %v: Activation is incorrect, got %s at %f, want HCD at 30", zlib, spec.Precursor.Activation, spec.Precursor.CollisionEnergy)
+		}
+
+		// only the concrete terms are written
+		for _, i := range []string{"MS:1000044", "MS:1000031"} {
+			if strings.Contains(string(doc), i) {
+				t.Errorf("zlib %v: the abstract term %s is written", zlib, i)
+			}
+		}
+
+		var got, want []float64
+		mzn.StreamMzML(src)(func(s mzn.Spectrum) {
+			want = append(want, s.Mz.DecodedStream...)
+		})
+		mzn.StreamMzML(f)(func(s mzn.Spectrum) {
+			got = append(got, s.Mz.DecodedStream...)
+		})
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("zlib %v: m/z values are incorrect, got %v, want %v", zlib, got, want)
+		}
+
+		idx.Close()
+	}
+}
+
+func TestCachedWalker(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "mzn")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+
+	os.Chdir(dir)
+	os.Mkdir(".meta", 0755)
+
+	f := filepath.Join(dir, "source.mzML")
+	if e := ioutil.WriteFile(f, []byte(testMzML), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	var reads int
+	source := func(fun func(spec mzn.Spectrum), levels ...string) {
+		reads++
+		mzn.StreamMzML(f)(fun, levels...)
+	}
+
+	for n := 1; n <= 2; n++ {
+
+		var levels []string
+		var ms1Peaks, ms2Peaks int
+
+		mzn.CachedWalker(f, source)(func(s mzn.Spectrum) {
+			levels = append(levels, s.Level)
+			if s.Level == "1" {
+				ms1Peaks += len(s.Mz.DecodedStream)
+			} else if n == 2 {
+				ms2Peaks += len(s.Mz.DecodedStream)
+			}
+		})
+
+		if !reflect.DeepEqual(levels, []string{"1", "2"}) || ms1Peaks == 0 || ms2Peaks != 0 {
+			t.Errorf("walk %d: Cached spectra are incorrect, got levels %v with %d MS1 and %d MS2 peaks", n, levels, ms1Peaks, ms2Peaks)
+		}
+	}
+
+	if reads != 1 {
+		t.Errorf("Source reads are incorrect, got %d, want %d", reads, 1)
+	}
+
+	// changing the source invalidates the cache
+	if e := ioutil.WriteFile(f, []byte(testMzML+"\n"), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	mzn.CachedWalker(f, source)(func(s mzn.Spectrum) {}, "2")
+
+	if reads != 2 {
+		t.Errorf("Source reads after a change are incorrect, got %d, want %d", reads, 2)
+	}
+
+	caches, _ := filepath.Glob(filepath.Join(".meta", "raw-*.bin"))
+	if len(caches) != 1 {
+		t.Errorf("Cache files are incorrect, got %v", caches)
+	}
+}
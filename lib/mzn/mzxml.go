@@ -0,0 +1,265 @@
+package mzn
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/msg"
+
+	"github.com/rogpeppe/go-charset/charset"
+)
+
+// precursorMz tag from mzXML files
+type precursorMz struct {
+	PrecursorScanNum   string  `xml:"precursorScanNum,attr"`
+	PrecursorIntensity float64 `xml:"precursorIntensity,attr"`
+	PrecursorCharge    int     `xml:"precursorCharge,attr"`
+	WindowWideness     float64 `xml:"windowWideness,attr"`
+	ActivationMethod   string  `xml:"activationMethod,attr"`
+	Value              string  `xml:",chardata"`
+}
+
+// peaks tag from mzXML files
+type peaks struct {
+	Precision       string `xml:"precision,attr"`
+	ByteOrder       string `xml:"byteOrder,attr"`
+	CompressionType string `xml:"compressionType,attr"`
+	Value           []byte `xml:",chardata"`
+}
+
+// ReadMzXML parses the spectra from a mzXML file, including the MS1 scans
+func (p *MsData) ReadMzXML(f string) {
+
+	xmlFile, e := os.Open(f)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+	defer xmlFile.Close()
+
+	p.FileName = f
+
+	decoder := xml.NewDecoder(bufio.NewReader(xmlFile))
+	decoder.CharsetReader = charset.NewReader
+
+	var spectra Spectra
+
+	// MSn scans can be nested inside their parent scans
+	var open []*Spectrum
+
+	// the most recent scan on each MS level, used when the precursor scan is not reported
+	var lastScan = make(map[string]string)
+
+	for {
+
+		t, e := decoder.Token()
+		if e == io.EOF {
+			break
+		} else if e != nil {
+			msg.DecodeMsgPck(e, "error")
+		}
+
+		switch el := t.(type) {
+		case xml.StartElement:
+
+			switch el.Name.Local {
+			case "scan":
+
+				spec := mzXMLScan(el.Attr)
+
+				level, _ := strconv.Atoi(spec.Level)
+				if level > 1 {
+					spec.Precursor.ParentScan = lastScan[strconv.Itoa(level-1)]
+				}
+				lastScan[spec.Level] = spec.Scan
+
+				open = append(open, spec)
+
+			case "precursorMz":
+
+				var pm precursorMz
+				if e := decoder.DecodeElement(&pm, &el); e != nil {
+					msg.DecodeMsgPck(e, "error")
+				}
+
+				if len(open) > 0 {
+					setMzXMLPrecursor(open[len(open)-1], pm)
+				}
+
+			case "peaks":
+
+				var pk peaks
+				if e := decoder.DecodeElement(&pk, &el); e != nil {
+					msg.DecodeMsgPck(e, "error")
+				}
+
+				if len(open) > 0 {
+					spec := open[len(open)-1]
+					spec.Mz.DecodedStream, spec.Intensity.DecodedStream = readPeaks(pk)
+				}
+			}
+
+		case xml.EndElement:
+
+			if el.Name.Local == "scan" && len(open) > 0 {
+				spectra = append(spectra, *open[len(open)-1])
+				open = open[:len(open)-1]
+			}
+		}
+	}
+
+	if len(spectra) == 0 {
+		msg.NoSpectraFound(errors.New(""), "error")
+	}
+
+	// nested scans are closed after their fragments
+	sort.SliceStable(spectra, func(i, j int) bool {
+		a, _ := strconv.Atoi(spectra[i].Scan)
+		b, _ := strconv.Atoi(spectra[j].Scan)
+		return a < b
+	})
+
+	for i := range spectra {
+		spectra[i].Index = strconv.Itoa(i)
+	}
+
+	p.Spectra = spectra
+}
+
+// mzXMLScan creates a spectrum from the scan attributes
+func mzXMLScan(attr []xml.Attr) *Spectrum {
+
+	var spec Spectrum
+
+	spec.Mz.Precision = "64"
+	spec.Intensity.Precision = "64"
+
+	for _, i := range attr {
+		switch i.Name.Local {
+		case "num":
+			spec.Scan = i.Value
+		case "msLevel":
+			spec.Level = i.Value
+		case "retentionTime":
+			spec.ScanStartTime = durationToMinutes(i.Value)
+		case "compensationVoltage":
+			spec.CompensationVoltage = i.Value
+		}
+	}
+
+	return &spec
+}
+
+// setMzXMLPrecursor sets the precursor information of a MSn scan
+func setMzXMLPrecursor(spec *Spectrum, pm precursorMz) {
+
+	mz, e := strconv.ParseFloat(strings.TrimSpace(pm.Value), 64)
+	if e != nil {
+		msg.CastFloatToString(e, "error")
+	}
+
+	spec.Precursor.SelectedIon = mz
+	spec.Precursor.TargetIon = mz
+	spec.Precursor.SelectedIonIntensity = pm.PrecursorIntensity
+	spec.Precursor.ChargeState = pm.PrecursorCharge
+
+	if len(pm.PrecursorScanNum) > 0 {
+		spec.Precursor.ParentScan = pm.PrecursorScanNum
+	}
+
+	if pm.WindowWideness > 0 {
+		spec.Precursor.IsolationWindowLowerOffset = pm.WindowWideness / 2
+		spec.Precursor.IsolationWindowUpperOffset = pm.WindowWideness / 2
+	}
+
+	if _, ok := dissociationMethods[strings.ToUpper(pm.ActivationMethod)]; ok {
+		spec.Precursor.Activation = strings.ToUpper(pm.ActivationMethod)
+	}
+
+}
+
+// readPeaks decodes the interleaved m/z and intensity pairs
+func readPeaks(pk peaks) ([]float64, []float64) {
+
+	var mz, intensity []float64
+
+	b64 := base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.TrimSpace(pk.Value)))
+
+	var bytestream bytes.Buffer
+	if pk.CompressionType == "zlib" {
+		r, e := zlib.NewReader(b64)
+		if e != nil {
+			msg.ReadingMzMLZlib(e, "error")
+		}
+		io.Copy(&bytestream, r)
+	} else {
+		io.Copy(&bytestream, b64)
+	}
+
+	dataArray := bytestream.Bytes()
+
+	// peaks are stored in network byte order unless stated otherwise
+	var order binary.ByteOrder = binary.BigEndian
+	if pk.ByteOrder == "little" {
+		order = binary.LittleEndian
+	}
+
+	var size = 4
+	if pk.Precision == "64" {
+		size = 8
+	}
+
+	for i := 0; i+2*size <= len(dataArray); i += 2 * size {
+		if size == 8 {
+			mz = append(mz, math.Float64frombits(order.Uint64(dataArray[i:i+8])))
+			intensity = append(intensity, math.Float64frombits(order.Uint64(dataArray[i+8:i+16])))
+		} else {
+			mz = append(mz, float64(math.Float32frombits(order.Uint32(dataArray[i:i+4]))))
+			intensity = append(intensity, float64(math.Float32frombits(order.Uint32(dataArray[i+4:i+8]))))
+		}
+	}
+
+	return mz, intensity
+}
+
+// durationToMinutes converts the xs:duration retention times (e.g. PT123.4S) to minutes
+func durationToMinutes(d string) float64 {
+
+	var minutes float64
+	var number string
+
+	for _, c := range strings.TrimPrefix(strings.TrimPrefix(d, "P"), "T") {
+
+		if (c >= '0' && c <= '9') || c == '.' {
+			number += string(c)
+			continue
+		}
+
+		v, e := strconv.ParseFloat(number, 64)
+		if e != nil {
+			msg.CastFloatToString(e, "error")
+		}
+		number = ""
+
+		switch c {
+		case 'H':
+			minutes += v * 60
+		case 'M':
+			minutes += v
+		case 'S':
+			minutes += v / 60
+		}
+	}
+
+	return minutes
+}
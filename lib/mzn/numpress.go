@@ -0,0 +1,168 @@
+package mzn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// MS-Numpress decoders, following the reference implementation from
+// https://github.com/ms-numpress/ms-numpress
+
+var errNumpressCorrupt = errors.New("corrupt MS-Numpress data")
+
+// decodeLinear decodes data encoded with the MS-Numpress linear prediction compression
+func decodeLinear(data []byte) ([]float64, error) {
+
+	var result []float64
+
+	if len(data) == 8 {
+		return result, nil
+	}
+
+	if len(data) < 12 {
+		return nil, errNumpressCorrupt
+	}
+
+	fixedPoint := decodeFixedPoint(data)
+
+	var ints [3]int64
+	ints[1] = int64(binary.LittleEndian.Uint32(data[8:12]))
+	result = append(result, float64(ints[1])/fixedPoint)
+
+	if len(data) == 12 {
+		return result, nil
+	}
+
+	if len(data) < 16 {
+		return nil, errNumpressCorrupt
+	}
+
+	ints[2] = int64(binary.LittleEndian.Uint32(data[12:16]))
+	result = append(result, float64(ints[2])/fixedPoint)
+
+	var half int
+	var di = 16
+
+	for di < len(data) {
+
+		if di == len(data)-1 && half == 1 && data[di]&0xf == 0x0 {
+			break
+		}
+
+		buff, e := decodeInt(data, &di, &half)
+		if e != nil {
+			return nil, e
+		}
+
+		ints[0] = ints[1]
+		ints[1] = ints[2]
+
+		extrapol := ints[1] + (ints[1] - ints[0])
+		y := extrapol + int64(int32(buff))
+
+		result = append(result, float64(y)/fixedPoint)
+		ints[2] = y
+	}
+
+	return result, nil
+}
+
+// decodePic decodes data encoded with the MS-Numpress positive integer compression
+func decodePic(data []byte) ([]float64, error) {
+
+	var result []float64
+	var half int
+	var di int
+
+	for di < len(data) {
+
+		if di == len(data)-1 && half == 1 && data[di]&0xf == 0x0 {
+			break
+		}
+
+		count, e := decodeInt(data, &di, &half)
+		if e != nil {
+			return nil, e
+		}
+
+		result = append(result, float64(count))
+	}
+
+	return result, nil
+}
+
+// decodeSlof decodes data encoded with the MS-Numpress short logged float compression
+func decodeSlof(data []byte) ([]float64, error) {
+
+	var result []float64
+
+	if len(data) < 8 || (len(data)-8)%2 != 0 {
+		return nil, errNumpressCorrupt
+	}
+
+	fixedPoint := decodeFixedPoint(data)
+
+	for i := 8; i < len(data); i += 2 {
+		x := binary.LittleEndian.Uint16(data[i : i+2])
+		result = append(result, math.Exp(float64(x)/fixedPoint)-1)
+	}
+
+	return result, nil
+}
+
+// decodeFixedPoint reads the big-endian fixed point stored in the first 8 bytes
+func decodeFixedPoint(data []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(data[0:8]))
+}
+
+// decodeInt reads a variable length integer stored as half bytes. The first half byte
+// holds the number of leading zeros, or leading ones when larger than 8
+func decodeInt(data []byte, di, half *int) (uint32, error) {
+
+	var res uint32
+	var n int
+
+	head := nextHalfByte(data, di, half)
+
+	if head <= 8 {
+		n = int(head)
+	} else {
+		n = int(head) - 8
+		for i := 0; i < n; i++ {
+			res |= 0xf0000000 >> uint(4*i)
+		}
+	}
+
+	if n == 8 {
+		return res, nil
+	}
+
+	if *di+((8-n)-(1-*half))/2 >= len(data) {
+		return 0, errNumpressCorrupt
+	}
+
+	for i := n; i < 8; i++ {
+		hb := nextHalfByte(data, di, half)
+		res |= uint32(hb) << uint((i-n)*4)
+	}
+
+	return res, nil
+}
+
+// nextHalfByte returns the next half byte and moves the position forward
+func nextHalfByte(data []byte, di, half *int) byte {
+
+	var hb byte
+
+	if *half == 0 {
+		hb = data[*di] >> 4
+	} else {
+		hb = data[*di] & 0xf
+		*di++
+	}
+
+	*half = 1 - *half
+
+	return hb
+}
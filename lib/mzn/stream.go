@@ -0,0 +1,138 @@
+package mzn
+
+import (
+	"bufio"
+	"encoding/xml"
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/msg"
+	"philosopher/lib/psi"
+
+	"github.com/rogpeppe/go-charset/charset"
+
+	// anon charset
+	_ "github.com/rogpeppe/go-charset/data"
+)
+
+// Walker visits the spectra of a data set one at a time. Only the spectra from the
+// given MS levels are visited, or all of them if no level is given. Spectra are
+// handed over already decoded
+type Walker func(fun func(spec Spectrum), levels ...string)
+
+// Walk visits the spectra held in memory
+func (p MsData) Walk(fun func(spec Spectrum), levels ...string) {
+
+	for _, i := range p.Spectra {
+		if isLevel(i.Level, levels) {
+			i.Decode()
+			fun(i)
+		}
+	}
+
+}
+
+// StreamMzML returns a Walker that reads the mzML file from disk every time it is called,
+// keeping a single spectrum in memory at any given moment
+func StreamMzML(f string) Walker {
+
+	return func(fun func(spec Spectrum), levels ...string) {
+		readMzML(f, func(mzSpec psi.Spectrum) {
+
+			spec := processSpectrum(mzSpec)
+
+			if isLevel(spec.Level, levels) {
+				spec.Decode()
+				fun(spec)
+			}
+		})
+	}
+}
+
+// readMzML tokenizes the mzML file and calls fun for every spectrum element
+func readMzML(f string, fun func(mzSpec psi.Spectrum)) {
+
+	xmlFile, e := os.Open(f)
+	if e != nil {
+		msg.ReadFile(e, "error")
+	}
+	defer xmlFile.Close()
+
+	decoder := xml.NewDecoder(bufio.NewReader(xmlFile))
+	decoder.CharsetReader = charset.NewReader
+
+	var counter int
+
+	for {
+
+		t, e := decoder.Token()
+		if e == io.EOF {
+			break
+		} else if e != nil {
+			msg.DecodeMsgPck(e, "error")
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		if se.Name.Local == "softwareList" {
+
+			var sl psi.SoftwareList
+			if e := decoder.DecodeElement(&sl, &se); e != nil {
+				msg.DecodeMsgPck(e, "error")
+			}
+			checkSoftware(sl)
+
+		} else if se.Name.Local == "spectrum" {
+
+			var mzSpec psi.Spectrum
+			if e := decoder.DecodeElement(&mzSpec, &se); e != nil {
+				msg.DecodeMsgPck(e, "error")
+			}
+			counter++
+			fun(mzSpec)
+
+		} else if se.Name.Local == "chromatogramList" {
+			// nothing else to be read after the spectra
+			break
+		}
+	}
+
+	if counter == 0 {
+		msg.NoSpectraFound(errors.New(""), "error")
+	}
+
+}
+
+// checkSoftware warns about mzML files converted with outdated msconvert versions
+func checkSoftware(sl psi.SoftwareList) {
+
+	if len(sl.Software) > 0 && sl.Software[0].ID == "pwiz" {
+		version, _ := strconv.Atoi(strings.Replace(sl.Software[0].Version, ".", "", -1))
+		if version <= 3020232 {
+			msg.Custom(errors.New("the msconvert version used to convert this file is not supported, or is deprecated. Please update your ProteoWizard and convert the raw files again"), "warning")
+		}
+	}
+
+}
+
+// isLevel checks if the spectrum level is in the list, an empty list accepts all levels
+func isLevel(level string, levels []string) bool {
+
+	if len(levels) == 0 {
+		return true
+	}
+
+	for _, i := range levels {
+		if i == level {
+			return true
+		}
+	}
+
+	return false
+}
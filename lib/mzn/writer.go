@@ -0,0 +1,490 @@
+package mzn
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"philosopher/lib/msg"
+	"philosopher/lib/psi"
+)
+
+// MzMLWriter writes spectra to a mzML file one at a time. The spectra are kept in a temporary
+// file until Close, when the spectrum count and the index offsets are known
+type MzMLWriter struct {
+	FileName           string
+	SourceFile         string
+	Version            string
+	MzPrecision        string
+	IntensityPrecision string
+	Zlib               bool
+	NoIndex            bool
+	tmp                *os.File
+	body               *bufio.Writer
+	written            int64
+	ids                []string
+	offsets            []int64
+	levels             map[string]bool
+}
+
+// NewMzMLWriter creates a writer for the output file, the source file is described in the header
+func NewMzMLWriter(f, source, version, mzPrecision, intensityPrecision string, zlib, noIndex bool) *MzMLWriter {
+
+	tmp, e := ioutil.TempFile(filepath.Dir(f), ".philosopher-mzml-")
+	if e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	w := &MzMLWriter{
+		FileName:           f,
+		SourceFile:         source,
+		Version:            version,
+		MzPrecision:        mzPrecision,
+		IntensityPrecision: intensityPrecision,
+		Zlib:               zlib,
+		NoIndex:            noIndex,
+		tmp:                tmp,
+		body:               bufio.NewWriter(tmp),
+		levels:             make(map[string]bool),
+	}
+
+	return w
+}
+
+// Len returns the number of spectra written so far
+func (w *MzMLWriter) Len() int {
+	return len(w.ids)
+}
+
+// Write adds a decoded spectrum to the file, the user parameters annotate the spectrum
+func (w *MzMLWriter) Write(spec Spectrum, params ...psi.UserParam) {
+
+	id := spectrumID(spec)
+	index := len(w.ids)
+
+	w.ids = append(w.ids, id)
+	w.offsets = append(w.offsets, w.written)
+	w.levels[spec.Level] = true
+
+	var b bytes.Buffer
+
+	fmt.Fprintf(&b, "      <spectrum index=\"%d\" id=\"%s\" defaultArrayLength=\"%d\">\n", index, escape(id), len(spec.Mz.DecodedStream))
+	writeCV(&b, 8, "MS:1000511", "ms level", spec.Level, "")
+
+	if spec.Level == "1" {
+		writeCV(&b, 8, "MS:1000579", "MS1 spectrum", "", "")
+	} else {
+		writeCV(&b, 8, "MS:1000580", "MSn spectrum", "", "")
+	}
+
+	if len(spec.Mz.DecodedStream) > 0 {
+
+		var tic, baseMz, baseInt float64
+		for i := range spec.Intensity.DecodedStream {
+			tic += spec.Intensity.DecodedStream[i]
+			if spec.Intensity.DecodedStream[i] > baseInt {
+				baseInt = spec.Intensity.DecodedStream[i]
+				baseMz = spec.Mz.DecodedStream[i]
+			}
+		}
+
+		writeCV(&b, 8, "MS:1000504", "base peak m/z", formatFloat(baseMz), "mz")
+		writeCV(&b, 8, "MS:1000505", "base peak intensity", formatFloat(baseInt), "counts")
+		writeCV(&b, 8, "MS:1000285", "total ion current", formatFloat(tic), "")
+		writeCV(&b, 8, "MS:1000528", "lowest observed m/z", formatFloat(spec.Mz.DecodedStream[0]), "mz")
+		writeCV(&b, 8, "MS:1000527", "highest observed m/z", formatFloat(spec.Mz.DecodedStream[len(spec.Mz.DecodedStream)-1]), "mz")
+	}
+
+	for _, i := range params {
+		fmt.Fprintf(&b, "        <userParam name=\"%s\" value=\"%s\"", escape(i.Name), escape(i.Value))
+		if len(i.Type) > 0 {
+			fmt.Fprintf(&b, " type=\"%s\"", escape(i.Type))
+		}
+		b.WriteString("/>\n")
+	}
+
+	b.WriteString("        <scanList count=\"1\">\n")
+	writeCV(&b, 10, "MS:1000795", "no combination", "", "")
+	b.WriteString("          <scan>\n")
+	writeCV(&b, 12, "MS:1000016", "scan start time", formatFloat(spec.ScanStartTime), "minute")
+	if len(spec.CompensationVoltage) > 0 {
+		writeCV(&b, 12, "MS:1001581", "FAIMS compensation voltage", spec.CompensationVoltage, "volt")
+	}
+	b.WriteString("          </scan>\n")
+	b.WriteString("        </scanList>\n")
+
+	if spec.Level != "1" && (spec.Precursor.SelectedIon > 0 || spec.Precursor.TargetIon > 0) {
+		writePrecursor(&b, id, spec.Precursor)
+	}
+
+	var arrays = 2
+	if len(spec.IonMobility.DecodedStream) > 0 {
+		arrays = 3
+	}
+
+	fmt.Fprintf(&b, "        <binaryDataArrayList count=\"%d\">\n", arrays)
+	w.writeArray(&b, spec.Mz.DecodedStream, w.MzPrecision, "MS:1000514", "m/z array", "mz")
+	w.writeArray(&b, spec.Intensity.DecodedStream, w.IntensityPrecision, "MS:1000515", "intensity array", "counts")
+	if arrays == 3 {
+		w.writeArray(&b, spec.IonMobility.DecodedStream, "64", "MS:1003008", "raw inverse reduced ion mobility array", "mobility")
+	}
+	b.WriteString("        </binaryDataArrayList>\n")
+	b.WriteString("      </spectrum>\n")
+
+	data := b.Bytes()
+
+	// nest the spectra inside the indexedmzML element
+	if !w.NoIndex {
+		data = append([]byte("  "), bytes.Replace(bytes.TrimSuffix(data, []byte("\n")), []byte("\n"), []byte("\n  "), -1)...)
+		data = append(data, '\n')
+	}
+
+	n, e := w.body.Write(data)
+	if e != nil {
+		msg.WriteFile(e, "error")
+	}
+	w.written += int64(n)
+
+}
+
+// Close assembles the mzML document from the header, the spectra and the index
+func (w *MzMLWriter) Close() {
+
+	if e := w.body.Flush(); e != nil {
+		msg.WriteFile(e, "error")
+	}
+	defer os.Remove(w.tmp.Name())
+	defer w.tmp.Close()
+
+	out, e := os.Create(w.FileName)
+	if e != nil {
+		msg.WriteFile(e, "error")
+	}
+	defer out.Close()
+
+	// the checksum covers the document up to the fileChecksum opening tag
+	var sum hash.Hash = sha1.New()
+	cw := &countingWriter{w: bufio.NewWriter(io.MultiWriter(out, sum))}
+
+	cw.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n")
+
+	var indent = ""
+	if !w.NoIndex {
+		cw.WriteString("<indexedmzML xmlns=\"http://psi.hupo.org/ms/mzml\" xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\" xsi:schemaLocation=\"http://psi.hupo.org/ms/mzml http://psidev.info/files/ms/mzML/xsd/mzML1.1.2_idx.xsd\">\n")
+		indent = "  "
+	}
+
+	cw.WriteString(w.header(indent))
+
+	start := cw.n
+	if _, e := w.tmp.Seek(0, io.SeekStart); e != nil {
+		msg.ReadFile(e, "error")
+	}
+	if _, e := io.Copy(cw, w.tmp); e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	cw.WriteString(fmt.Sprintf("%s    </spectrumList>\n%s  </run>\n%s</mzML>\n", indent, indent, indent))
+
+	if !w.NoIndex {
+
+		indexOffset := cw.n
+
+		cw.WriteString("  <indexList count=\"1\">\n")
+		cw.WriteString("    <index name=\"spectrum\">\n")
+		for i := range w.ids {
+			// the offsets point to the spectrum tag, after the indentation
+			cw.WriteString(fmt.Sprintf("      <offset idRef=\"%s\">%d</offset>\n", escape(w.ids[i]), start+w.offsets[i]+int64(len(indent)+6)))
+		}
+		cw.WriteString("    </index>\n")
+		cw.WriteString("  </indexList>\n")
+		cw.WriteString(fmt.Sprintf("  <indexListOffset>%d</indexListOffset>\n", indexOffset))
+		cw.WriteString("  <fileChecksum>")
+
+		if e := cw.w.Flush(); e != nil {
+			msg.WriteFile(e, "error")
+		}
+
+		fmt.Fprintf(out, "%x</fileChecksum>\n</indexedmzML>\n", sum.Sum(nil))
+
+	} else if e := cw.w.Flush(); e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+}
+
+// header writes the mzML metadata and the opening tags of the spectrum list
+func (w *MzMLWriter) header(indent string) string {
+
+	var b bytes.Buffer
+
+	name := strings.TrimSuffix(filepath.Base(w.FileName), filepath.Ext(w.FileName))
+	source := filepath.Base(w.SourceFile)
+	location, _ := filepath.Abs(filepath.Dir(w.SourceFile))
+
+	var nativeFormat, nativeName = "MS:1000824", "no nativeID format"
+	if len(w.ids) > 0 && strings.HasPrefix(w.ids[0], "controllerType=") {
+		nativeFormat, nativeName = "MS:1000768", "Thermo nativeID format"
+	} else if len(w.ids) > 0 && strings.HasPrefix(w.ids[0], "scan=") {
+		nativeFormat, nativeName = "MS:1000776", "scan number only nativeID format"
+	}
+
+	var fileFormat, fileFormatName = "MS:1000584", "mzML format"
+	if strings.EqualFold(filepath.Ext(source), ".raw") {
+		fileFormat, fileFormatName = "MS:1000563", "Thermo RAW format"
+	}
+
+	fmt.Fprintf(&b, "<mzML xmlns=\"http://psi.hupo.org/ms/mzml\" xmlns:xsi=\"http://www.w3.org/2001/XMLSchema-instance\" xsi:schemaLocation=\"http://psi.hupo.org/ms/mzml http://psidev.info/files/ms/mzML/xsd/mzML1.1.0.xsd\" id=\"%s\" version=\"1.1.0\">\n", escape(name))
+	b.WriteString("  <cvList count=\"2\">\n")
+	b.WriteString("    <cv id=\"MS\" fullName=\"Proteomics Standards Initiative Mass Spectrometry Ontology\" version=\"4.1.30\" URI=\"https://raw.githubusercontent.com/HUPO-PSI/psi-ms-CV/master/psi-ms.obo\"/>\n")
+	b.WriteString("    <cv id=\"UO\" fullName=\"Unit Ontology\" version=\"09:04:2014\" URI=\"https://raw.githubusercontent.com/bio-ontology-research-group/unit-ontology/master/unit.obo\"/>\n")
+	b.WriteString("  </cvList>\n")
+	b.WriteString("  <fileDescription>\n")
+	b.WriteString("    <fileContent>\n")
+	if w.levels["1"] {
+		writeCV(&b, 6, "MS:1000579", "MS1 spectrum", "", "")
+	}
+	if len(w.levels) > 1 || (len(w.levels) == 1 && !w.levels["1"]) {
+		writeCV(&b, 6, "MS:1000580", "MSn spectrum", "", "")
+	}
+	b.WriteString("    </fileContent>\n")
+	b.WriteString("    <sourceFileList count=\"1\">\n")
+	fmt.Fprintf(&b, "      <sourceFile id=\"SF1\" name=\"%s\" location=\"file://%s\">\n", escape(source), escape(filepath.ToSlash(location)))
+	writeCV(&b, 8, nativeFormat, nativeName, "", "")
+	writeCV(&b, 8, fileFormat, fileFormatName, "", "")
+	b.WriteString("      </sourceFile>\n")
+	b.WriteString("    </sourceFileList>\n")
+	b.WriteString("  </fileDescription>\n")
+	b.WriteString("  <softwareList count=\"1\">\n")
+	fmt.Fprintf(&b, "    <software id=\"philosopher\" version=\"%s\">\n", escape(w.Version))
+	writeCV(&b, 6, "MS:1000799", "custom unreleased software tool", "philosopher", "")
+	b.WriteString("    </software>\n")
+	b.WriteString("  </softwareList>\n")
+	b.WriteString("  <instrumentConfigurationList count=\"1\">\n")
+	// the spectra do not carry the instrument model, so the configuration has no model term
+	b.WriteString("    <instrumentConfiguration id=\"IC1\">\n")
+	b.WriteString("    </instrumentConfiguration>\n")
+	b.WriteString("  </instrumentConfigurationList>\n")
+	b.WriteString("  <dataProcessingList count=\"1\">\n")
+	b.WriteString("    <dataProcessing id=\"philosopher_conversion\">\n")
+	b.WriteString("      <processingMethod order=\"0\" softwareRef=\"philosopher\">\n")
+	writeCV(&b, 8, "MS:1000544", "Conversion to mzML", "", "")
+	b.WriteString("      </processingMethod>\n")
+	b.WriteString("    </dataProcessing>\n")
+	b.WriteString("  </dataProcessingList>\n")
+	fmt.Fprintf(&b, "  <run id=\"%s\" defaultInstrumentConfigurationRef=\"IC1\" defaultSourceFileRef=\"SF1\">\n", escape(name))
+	fmt.Fprintf(&b, "    <spectrumList count=\"%d\" defaultDataProcessingRef=\"philosopher_conversion\">\n", len(w.ids))
+
+	if len(indent) == 0 {
+		return b.String()
+	}
+
+	// nest the mzML element inside the indexedmzML one
+	lines := strings.SplitAfter(b.String(), "\n")
+	for i := range lines {
+		if len(lines[i]) > 0 {
+			lines[i] = indent + lines[i]
+		}
+	}
+
+	return strings.Join(lines, "")
+}
+
+// writeArray encodes a binary data array
+func (w *MzMLWriter) writeArray(b *bytes.Buffer, values []float64, precision, accession, name, unit string) {
+
+	var raw bytes.Buffer
+
+	for _, v := range values {
+		if precision == "32" {
+			binary.Write(&raw, binary.LittleEndian, math.Float32bits(float32(v)))
+		} else {
+			binary.Write(&raw, binary.LittleEndian, math.Float64bits(v))
+		}
+	}
+
+	data := raw.Bytes()
+
+	if w.Zlib {
+		var z bytes.Buffer
+		zw := zlib.NewWriter(&z)
+		zw.Write(data)
+		zw.Close()
+		data = z.Bytes()
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+
+	fmt.Fprintf(b, "          <binaryDataArray encodedLength=\"%d\">\n", len(encoded))
+
+	if precision == "32" {
+		writeCV(b, 12, "MS:1000521", "32-bit float", "", "")
+	} else {
+		writeCV(b, 12, "MS:1000523", "64-bit float", "", "")
+	}
+
+	if w.Zlib {
+		writeCV(b, 12, "MS:1000574", "zlib compression", "", "")
+	} else {
+		writeCV(b, 12, "MS:1000576", "no compression", "", "")
+	}
+
+	writeCV(b, 12, accession, name, "", unit)
+
+	fmt.Fprintf(b, "            <binary>%s</binary>\n", encoded)
+	b.WriteString("          </binaryDataArray>\n")
+
+}
+
+// writePrecursor writes the isolation window and the selected ion of a MSn spectrum
+func writePrecursor(b *bytes.Buffer, id string, p Precursor) {
+
+	b.WriteString("        <precursorList count=\"1\">\n")
+
+	// the parent is referenced using the same native ID format
+	if len(p.ParentScan) > 0 && nativeScanRegex.MatchString(id) {
+		ref := nativeScanRegex.ReplaceAllString(id, "scan="+p.ParentScan)
+		fmt.Fprintf(b, "          <precursor spectrumRef=\"%s\">\n", escape(ref))
+	} else {
+		b.WriteString("          <precursor>\n")
+	}
+
+	if p.TargetIon > 0 {
+		b.WriteString("            <isolationWindow>\n")
+		writeCV(b, 14, "MS:1000827", "isolation window target m/z", formatFloat(p.TargetIon), "mz")
+		writeCV(b, 14, "MS:1000828", "isolation window lower offset", formatFloat(p.IsolationWindowLowerOffset), "mz")
+		writeCV(b, 14, "MS:1000829", "isolation window upper offset", formatFloat(p.IsolationWindowUpperOffset), "mz")
+		b.WriteString("            </isolationWindow>\n")
+	}
+
+	selected := p.SelectedIon
+	if selected == 0 {
+		selected = p.TargetIon
+	}
+
+	b.WriteString("            <selectedIonList count=\"1\">\n")
+	b.WriteString("              <selectedIon>\n")
+	writeCV(b, 16, "MS:1000744", "selected ion m/z", formatFloat(selected), "mz")
+	if p.ChargeState > 0 {
+		writeCV(b, 16, "MS:1000041", "charge state", fmt.Sprintf("%d", p.ChargeState), "")
+	}
+	if p.SelectedIonIntensity > 0 {
+		writeCV(b, 16, "MS:1000042", "peak intensity", formatFloat(p.SelectedIonIntensity), "counts")
+	}
+	if p.IonMobility > 0 {
+		writeCV(b, 16, "MS:1002815", "inverse reduced ion mobility", formatFloat(p.IonMobility), "mobility")
+	}
+	b.WriteString("              </selectedIon>\n")
+	b.WriteString("            </selectedIonList>\n")
+	// the activation is left without terms when the dissociation method is unknown
+	b.WriteString("            <activation>\n")
+	if m, ok := dissociationMethods[p.Activation]; ok {
+		writeCV(b, 14, m[0], m[1], "", "")
+	}
+	if p.CollisionEnergy > 0 {
+		writeCV(b, 14, "MS:1000045", "collision energy", formatFloat(p.CollisionEnergy), "electronvolt")
+	}
+	b.WriteString("            </activation>\n")
+	b.WriteString("          </precursor>\n")
+	b.WriteString("        </precursorList>\n")
+
+}
+
+// units used by the cvParams
+var cvUnits = map[string][3]string{
+	"mz":           {"MS", "MS:1000040", "m/z"},
+	"counts":       {"MS", "MS:1000131", "number of detector counts"},
+	"minute":       {"UO", "UO:0000031", "minute"},
+	"volt":         {"UO", "UO:0000218", "volt"},
+	"mobility":     {"MS", "MS:1002814", "volt-second per square centimeter"},
+	"electronvolt": {"UO", "UO:0000266", "electronvolt"},
+}
+
+// dissociationMethods maps the activation types to the dissociation method terms
+var dissociationMethods = map[string][2]string{
+	"CID":   {"MS:1000133", "collision-induced dissociation"},
+	"HCD":   {"MS:1000422", "beam-type collision-induced dissociation"},
+	"ETD":   {"MS:1000598", "electron transfer dissociation"},
+	"ECD":   {"MS:1000250", "electron capture dissociation"},
+	"PQD":   {"MS:1000599", "pulsed q dissociation"},
+	"ETHCD": {"MS:1002631", "electron-transfer/higher-energy collision dissociation"},
+}
+
+// activationType returns the activation type of a dissociation method term
+func activationType(accession string) (string, bool) {
+
+	for k, v := range dissociationMethods {
+		if v[0] == accession {
+			return k, true
+		}
+	}
+
+	return "", false
+}
+
+// writeCV writes a cvParam line with the given indentation
+func writeCV(b *bytes.Buffer, indent int, accession, name, value, unit string) {
+
+	b.WriteString(strings.Repeat(" ", indent))
+	fmt.Fprintf(b, "<cvParam cvRef=\"MS\" accession=\"%s\" name=\"%s\" value=\"%s\"", accession, name, escape(value))
+
+	if u, ok := cvUnits[unit]; ok {
+		fmt.Fprintf(b, " unitCvRef=\"%s\" unitAccession=\"%s\" unitName=\"%s\"", u[0], u[1], u[2])
+	}
+
+	b.WriteString("/>\n")
+}
+
+// spectrumID returns the native ID of the spectrum, Thermo style when the source has none
+func spectrumID(spec Spectrum) string {
+
+	if len(spec.SpectrumName) > 0 {
+		return spec.SpectrumName
+	}
+
+	return "controllerType=0 controllerNumber=1 scan=" + spec.Scan
+}
+
+// formatFloat prints a float with the shortest representation
+func formatFloat(v float64) string {
+	return fmt.Sprintf("%v", v)
+}
+
+// escape replaces the XML special characters
+func escape(s string) string {
+	var b bytes.Buffer
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// countingWriter keeps track of the number of bytes written
+type countingWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, e := c.w.Write(p)
+	c.n += int64(n)
+	return n, e
+}
+
+func (c *countingWriter) WriteString(s string) {
+	n, e := c.w.WriteString(s)
+	if e != nil {
+		msg.WriteFile(e, "error")
+	}
+	c.n += int64(n)
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"philosopher/lib/dat"
 	"philosopher/lib/msg"
@@ -21,6 +22,7 @@ import (
 	"philosopher/lib/ext/ptmprophet"
 	"philosopher/lib/fil"
 	"philosopher/lib/qua"
+	"philosopher/lib/raz"
 	"philosopher/lib/rep"
 
 	"philosopher/lib/ext/comet"
@@ -500,8 +502,25 @@ func CombinedProteinList(meta met.Data, p Directives, dir string, data []string)
 	p.Abacus.Picked = p.Filter.Picked
 	p.Abacus.Razor = p.Filter.Razor
 
+	// the genes and the razor annotations come from the database annotated on the first data set
+	var genes map[string]string
+	var annotations map[string]raz.Protein
+	if p.Filter.Gene || p.Filter.RazorStrategy == raz.Reviewed {
+
+		var db dat.Base
+		db.RestoreWithPath(data[0])
+
+		if p.Filter.Gene {
+			genes = db.GeneMap(p.DatabaseSearch.DecoyTag)
+		}
+
+		if p.Filter.RazorStrategy == raz.Reviewed {
+			annotations = raz.Annotate(db.Records, p.DatabaseSearch.DecoyTag)
+		}
+	}
+
 	protXML := fil.ReadProtXMLInput("combined.prot.xml", p.DatabaseSearch.DecoyTag, p.Filter.Weight)
-	proBin := fil.ProcessProteinIdentifications(protXML, p.Filter.PtFDR, p.Filter.PepFDR, p.Filter.ProtProb, p.Abacus.Picked, p.Abacus.Razor, true, p.DatabaseSearch.DecoyTag)
+	proBin := fil.ProcessProteinIdentifications(protXML, p.Filter.PtFDR, p.Filter.PepFDR, p.Filter.ProtProb, p.Abacus.Picked, p.Abacus.Razor, true, p.DatabaseSearch.DecoyTag, p.Filter.RazorStrategy, annotations, genes)
 
 	for _, i := range data {
 		dest := fmt.Sprintf("%s%s.meta%spro.bin", i, string(filepath.Separator), string(filepath.Separator))
@@ -540,7 +559,7 @@ func FreeQuant(meta met.Data, p Directives, dir string, data []string) met.Data
 
 		meta.Quantify = p.Freequant
 		meta.Quantify.Dir = dsAbs
-		meta.Quantify.Format = "mzML"
+		meta.Quantify.Format = spectraFormat(p.Freequant.Format, false)
 		meta.Quantify.Pex = fmt.Sprintf("%s%sinteract.pep.xml", dsAbs, string(filepath.Separator))
 		meta.Quantify.Tag = "rev_"
 
@@ -556,6 +575,33 @@ func FreeQuant(meta met.Data, p Directives, dir string, data []string) met.Data
 	return meta
 }
 
+// spectraFormat normalizes the spectra format of the quantification, mzML by default. The MGF files
+// have no MS1 spectra, so they are only accepted for the isobaric quantification
+func spectraFormat(format string, mgf bool) string {
+
+	switch strings.ToLower(format) {
+	case "", "mzml":
+		return "mzML"
+	case "mzxml":
+		return "mzXML"
+	case "d":
+		return "d"
+	case "mgf":
+		if mgf {
+			return "mgf"
+		}
+		msg.Custom(errors.New("MGF files have no MS1 spectra to trace the precursors, use mzML, mzXML or d"), "error")
+	default:
+		if mgf {
+			msg.InputNotFound(errors.New("unknown file format, use mzML, mzXML, mgf or d"), "error")
+		} else {
+			msg.InputNotFound(errors.New("unknown file format, use mzML, mzXML or d"), "error")
+		}
+	}
+
+	return format
+}
+
 // LabelQuant executes the isobaric-tag quantification method
 func LabelQuant(meta met.Data, p Directives, dir string, data []string) met.Data {
 
@@ -580,7 +626,7 @@ func LabelQuant(meta met.Data, p Directives, dir string, data []string) met.Data
 
 		meta.Quantify = p.LabelQuant
 		meta.Quantify.Dir = dsAbs
-		meta.Quantify.Format = "mzML"
+		meta.Quantify.Format = spectraFormat(p.LabelQuant.Format, true)
 		meta.Quantify.Annot = annotation[0]
 		meta.Quantify.Brand = p.LabelQuant.Brand
 		meta.Quantify.Pex = fmt.Sprintf("%s%sinteract.pep.xml", dsAbs, string(filepath.Separator))
@@ -6,9 +6,32 @@ import (
 
 // IndexedMzML is the root level tag
 type IndexedMzML struct {
-	XMLName xml.Name `xml:"indexedmzML"`
-	Name    string
-	MzML    MzML `xml:"mzML"`
+	XMLName         xml.Name `xml:"indexedmzML"`
+	Name            string
+	MzML            MzML      `xml:"mzML"`
+	IndexList       IndexList `xml:"indexList"`
+	IndexListOffset int64     `xml:"indexListOffset"`
+}
+
+// IndexList is the list of byte offsets for the spectra and chromatograms of the mzML document
+type IndexList struct {
+	XMLName xml.Name `xml:"indexList"`
+	Count   int      `xml:"count,attr"`
+	Index   []Index  `xml:"index"`
+}
+
+// Index is a byte offset index for one type of element, either spectrum or chromatogram
+type Index struct {
+	XMLName xml.Name `xml:"index"`
+	Name    string   `xml:"name,attr"`
+	Offset  []Offset `xml:"offset"`
+}
+
+// Offset is the byte offset of an element, referenced by its native ID
+type Offset struct {
+	XMLName xml.Name `xml:"offset"`
+	IDRef   string   `xml:"idRef,attr"`
+	Value   int64    `xml:",chardata"`
 }
 
 // MzML This is the root element for the Proteomics Standards Initiative (PSI) mzML schema, which is intended to
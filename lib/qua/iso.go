@@ -18,309 +18,307 @@ import (
 
 const (
 	mzDeltaWindow float64 = 0.5
+	ms1BufferSize int     = 32
+	imDeltaWindow float64 = 0.05
 )
 
 // prepareLabelStructureWithMS2 instantiates the Label objects and maps them against the fragment scans in order to get the channel intensities
-func prepareLabelStructureWithMS2(dir, format, brand, plex string, tol float64, mz mzn.MsData) map[string]iso.Labels {
+func prepareLabelStructureWithMS2(dir, format, brand, plex string, tol float64, walk mzn.Walker) map[string]iso.Labels {
 
 	// get all spectra names from PSMs and create the label list
 	var labels = make(map[string]iso.Labels)
 	ppmPrecision := tol / math.Pow(10, 6)
 
-	for _, i := range mz.Spectra {
-		if i.Level == "2" {
+	walk(func(i mzn.Spectrum) {
 
-			var labelData iso.Labels
-			if brand == "tmt" {
-				labelData = tmt.New(plex)
-			} else if brand == "itraq" {
-				labelData = trq.New(plex)
-			} else if brand == "xtag" {
-				labelData = xta.New(plex)
-			}
-
-			// left-pad the spectrum scan
-			paddedScan := fmt.Sprintf("%05s", i.Scan)
+		var labelData iso.Labels
+		if brand == "tmt" {
+			labelData = tmt.New(plex)
+		} else if brand == "itraq" {
+			labelData = trq.New(plex)
+		} else if brand == "xtag" {
+			labelData = xta.New(plex)
+		}
 
-			labelData.Index = i.Index
-			labelData.Scan = paddedScan
-			labelData.ChargeState = i.Precursor.ChargeState
+		// left-pad the spectrum scan
+		paddedScan := fmt.Sprintf("%05s", i.Scan)
 
-			for j := range i.Mz.DecodedStream {
+		labelData.Index = i.Index
+		labelData.Scan = paddedScan
+		labelData.ChargeState = i.Precursor.ChargeState
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel1.Mz+(ppmPrecision*labelData.Channel1.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel1.Mz-(ppmPrecision*labelData.Channel1.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel1.Intensity {
-						labelData.Channel1.Intensity = i.Intensity.DecodedStream[j]
-					}
-				}
+		for j := range i.Mz.DecodedStream {
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel2.Mz+(ppmPrecision*labelData.Channel2.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel2.Mz-(ppmPrecision*labelData.Channel2.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel2.Intensity {
-						labelData.Channel2.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel1.Mz+(ppmPrecision*labelData.Channel1.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel1.Mz-(ppmPrecision*labelData.Channel1.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel1.Intensity {
+					labelData.Channel1.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel3.Mz+(ppmPrecision*labelData.Channel3.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel3.Mz-(ppmPrecision*labelData.Channel3.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel3.Intensity {
-						labelData.Channel3.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel2.Mz+(ppmPrecision*labelData.Channel2.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel2.Mz-(ppmPrecision*labelData.Channel2.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel2.Intensity {
+					labelData.Channel2.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel4.Mz+(ppmPrecision*labelData.Channel4.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel4.Mz-(ppmPrecision*labelData.Channel4.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel4.Intensity {
-						labelData.Channel4.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel3.Mz+(ppmPrecision*labelData.Channel3.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel3.Mz-(ppmPrecision*labelData.Channel3.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel3.Intensity {
+					labelData.Channel3.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel5.Mz+(ppmPrecision*labelData.Channel5.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel5.Mz-(ppmPrecision*labelData.Channel5.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel5.Intensity {
-						labelData.Channel5.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel4.Mz+(ppmPrecision*labelData.Channel4.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel4.Mz-(ppmPrecision*labelData.Channel4.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel4.Intensity {
+					labelData.Channel4.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel6.Mz+(ppmPrecision*labelData.Channel6.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel6.Mz-(ppmPrecision*labelData.Channel6.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel6.Intensity {
-						labelData.Channel6.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel5.Mz+(ppmPrecision*labelData.Channel5.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel5.Mz-(ppmPrecision*labelData.Channel5.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel5.Intensity {
+					labelData.Channel5.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel7.Mz+(ppmPrecision*labelData.Channel7.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel7.Mz-(ppmPrecision*labelData.Channel7.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel7.Intensity {
-						labelData.Channel7.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel6.Mz+(ppmPrecision*labelData.Channel6.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel6.Mz-(ppmPrecision*labelData.Channel6.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel6.Intensity {
+					labelData.Channel6.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel8.Mz+(ppmPrecision*labelData.Channel8.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel8.Mz-(ppmPrecision*labelData.Channel8.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel8.Intensity {
-						labelData.Channel8.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel7.Mz+(ppmPrecision*labelData.Channel7.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel7.Mz-(ppmPrecision*labelData.Channel7.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel7.Intensity {
+					labelData.Channel7.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel9.Mz+(ppmPrecision*labelData.Channel9.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel9.Mz-(ppmPrecision*labelData.Channel9.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel9.Intensity {
-						labelData.Channel9.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel8.Mz+(ppmPrecision*labelData.Channel8.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel8.Mz-(ppmPrecision*labelData.Channel8.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel8.Intensity {
+					labelData.Channel8.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel10.Mz+(ppmPrecision*labelData.Channel10.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel10.Mz-(ppmPrecision*labelData.Channel10.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel10.Intensity {
-						labelData.Channel10.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel9.Mz+(ppmPrecision*labelData.Channel9.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel9.Mz-(ppmPrecision*labelData.Channel9.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel9.Intensity {
+					labelData.Channel9.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel11.Mz+(ppmPrecision*labelData.Channel11.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel11.Mz-(ppmPrecision*labelData.Channel11.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel11.Intensity {
-						labelData.Channel11.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel10.Mz+(ppmPrecision*labelData.Channel10.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel10.Mz-(ppmPrecision*labelData.Channel10.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel10.Intensity {
+					labelData.Channel10.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel12.Mz+(ppmPrecision*labelData.Channel12.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel12.Mz-(ppmPrecision*labelData.Channel12.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel12.Intensity {
-						labelData.Channel12.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel11.Mz+(ppmPrecision*labelData.Channel11.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel11.Mz-(ppmPrecision*labelData.Channel11.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel11.Intensity {
+					labelData.Channel11.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel13.Mz+(ppmPrecision*labelData.Channel13.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel13.Mz-(ppmPrecision*labelData.Channel13.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel13.Intensity {
-						labelData.Channel13.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel12.Mz+(ppmPrecision*labelData.Channel12.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel12.Mz-(ppmPrecision*labelData.Channel12.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel12.Intensity {
+					labelData.Channel12.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel14.Mz+(ppmPrecision*labelData.Channel14.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel14.Mz-(ppmPrecision*labelData.Channel14.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel14.Intensity {
-						labelData.Channel14.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel13.Mz+(ppmPrecision*labelData.Channel13.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel13.Mz-(ppmPrecision*labelData.Channel13.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel13.Intensity {
+					labelData.Channel13.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel15.Mz+(ppmPrecision*labelData.Channel15.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel15.Mz-(ppmPrecision*labelData.Channel15.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel15.Intensity {
-						labelData.Channel15.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel14.Mz+(ppmPrecision*labelData.Channel14.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel14.Mz-(ppmPrecision*labelData.Channel14.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel14.Intensity {
+					labelData.Channel14.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel16.Mz+(ppmPrecision*labelData.Channel16.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel16.Mz-(ppmPrecision*labelData.Channel16.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel16.Intensity {
-						labelData.Channel16.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel15.Mz+(ppmPrecision*labelData.Channel15.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel15.Mz-(ppmPrecision*labelData.Channel15.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel15.Intensity {
+					labelData.Channel15.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel17.Mz+(ppmPrecision*labelData.Channel17.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel17.Mz-(ppmPrecision*labelData.Channel17.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel17.Intensity {
-						labelData.Channel17.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel16.Mz+(ppmPrecision*labelData.Channel16.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel16.Mz-(ppmPrecision*labelData.Channel16.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel16.Intensity {
+					labelData.Channel16.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel18.Mz+(ppmPrecision*labelData.Channel18.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel18.Mz-(ppmPrecision*labelData.Channel18.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel18.Intensity {
-						labelData.Channel18.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel17.Mz+(ppmPrecision*labelData.Channel17.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel17.Mz-(ppmPrecision*labelData.Channel17.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel17.Intensity {
+					labelData.Channel17.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if brand != "xtag" && i.Mz.DecodedStream[j] > 137 {
-					break
-				} else if i.Mz.DecodedStream[j] > 450 {
-					break
+			if i.Mz.DecodedStream[j] <= (labelData.Channel18.Mz+(ppmPrecision*labelData.Channel18.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel18.Mz-(ppmPrecision*labelData.Channel18.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel18.Intensity {
+					labelData.Channel18.Intensity = i.Intensity.DecodedStream[j]
 				}
-
 			}
 
-			labels[paddedScan] = labelData
+			if brand != "xtag" && i.Mz.DecodedStream[j] > 137 {
+				break
+			} else if i.Mz.DecodedStream[j] > 450 {
+				break
+			}
 
 		}
-	}
+
+		labels[paddedScan] = labelData
+
+	}, "2")
 
 	return labels
 }
 
 // prepareLabelStructureWithMS3 instantiates the Label objects and maps them against the fragment scans in order to get the channel intensities
-func prepareLabelStructureWithMS3(dir, format, brand, plex string, tol float64, mz mzn.MsData) map[string]iso.Labels {
+func prepareLabelStructureWithMS3(dir, format, brand, plex string, tol float64, walk mzn.Walker) map[string]iso.Labels {
 
 	// get all spectra names from PSMs and create the label list
 	var labels = make(map[string]iso.Labels)
 	ppmPrecision := tol / math.Pow(10, 6)
 
-	for _, i := range mz.Spectra {
-		if i.Level == "3" {
+	walk(func(i mzn.Spectrum) {
 
-			var labelData iso.Labels
-			if brand == "tmt" {
-				labelData = tmt.New(plex)
-			} else if brand == "itraq" {
-				labelData = trq.New(plex)
-			} else if brand == "xtag" {
-				labelData = xta.New(plex)
-			}
-
-			// left-pad the spectrum scan
-			paddedScan := fmt.Sprintf("%05s", i.Scan)
-			precPaddedScan := fmt.Sprintf("%05s", i.Precursor.ParentScan)
+		var labelData iso.Labels
+		if brand == "tmt" {
+			labelData = tmt.New(plex)
+		} else if brand == "itraq" {
+			labelData = trq.New(plex)
+		} else if brand == "xtag" {
+			labelData = xta.New(plex)
+		}
 
-			labelData.Index = i.Index
-			labelData.Scan = paddedScan
-			labelData.ChargeState = i.Precursor.ChargeState
+		// left-pad the spectrum scan
+		paddedScan := fmt.Sprintf("%05s", i.Scan)
+		precPaddedScan := fmt.Sprintf("%05s", i.Precursor.ParentScan)
 
-			for j := range i.Mz.DecodedStream {
+		labelData.Index = i.Index
+		labelData.Scan = paddedScan
+		labelData.ChargeState = i.Precursor.ChargeState
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel1.Mz+(ppmPrecision*labelData.Channel1.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel1.Mz-(ppmPrecision*labelData.Channel1.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel1.Intensity {
-						labelData.Channel1.Intensity = i.Intensity.DecodedStream[j]
-					}
-				}
+		for j := range i.Mz.DecodedStream {
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel2.Mz+(ppmPrecision*labelData.Channel2.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel2.Mz-(ppmPrecision*labelData.Channel2.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel2.Intensity {
-						labelData.Channel2.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel1.Mz+(ppmPrecision*labelData.Channel1.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel1.Mz-(ppmPrecision*labelData.Channel1.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel1.Intensity {
+					labelData.Channel1.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel3.Mz+(ppmPrecision*labelData.Channel3.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel3.Mz-(ppmPrecision*labelData.Channel3.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel3.Intensity {
-						labelData.Channel3.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel2.Mz+(ppmPrecision*labelData.Channel2.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel2.Mz-(ppmPrecision*labelData.Channel2.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel2.Intensity {
+					labelData.Channel2.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel4.Mz+(ppmPrecision*labelData.Channel4.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel4.Mz-(ppmPrecision*labelData.Channel4.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel4.Intensity {
-						labelData.Channel4.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel3.Mz+(ppmPrecision*labelData.Channel3.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel3.Mz-(ppmPrecision*labelData.Channel3.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel3.Intensity {
+					labelData.Channel3.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel5.Mz+(ppmPrecision*labelData.Channel5.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel5.Mz-(ppmPrecision*labelData.Channel5.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel5.Intensity {
-						labelData.Channel5.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel4.Mz+(ppmPrecision*labelData.Channel4.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel4.Mz-(ppmPrecision*labelData.Channel4.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel4.Intensity {
+					labelData.Channel4.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel6.Mz+(ppmPrecision*labelData.Channel6.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel6.Mz-(ppmPrecision*labelData.Channel6.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel6.Intensity {
-						labelData.Channel6.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel5.Mz+(ppmPrecision*labelData.Channel5.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel5.Mz-(ppmPrecision*labelData.Channel5.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel5.Intensity {
+					labelData.Channel5.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel7.Mz+(ppmPrecision*labelData.Channel7.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel7.Mz-(ppmPrecision*labelData.Channel7.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel7.Intensity {
-						labelData.Channel7.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel6.Mz+(ppmPrecision*labelData.Channel6.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel6.Mz-(ppmPrecision*labelData.Channel6.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel6.Intensity {
+					labelData.Channel6.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel8.Mz+(ppmPrecision*labelData.Channel8.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel8.Mz-(ppmPrecision*labelData.Channel8.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel8.Intensity {
-						labelData.Channel8.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel7.Mz+(ppmPrecision*labelData.Channel7.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel7.Mz-(ppmPrecision*labelData.Channel7.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel7.Intensity {
+					labelData.Channel7.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel9.Mz+(ppmPrecision*labelData.Channel9.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel9.Mz-(ppmPrecision*labelData.Channel9.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel9.Intensity {
-						labelData.Channel9.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel8.Mz+(ppmPrecision*labelData.Channel8.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel8.Mz-(ppmPrecision*labelData.Channel8.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel8.Intensity {
+					labelData.Channel8.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel10.Mz+(ppmPrecision*labelData.Channel10.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel10.Mz-(ppmPrecision*labelData.Channel10.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel10.Intensity {
-						labelData.Channel10.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel9.Mz+(ppmPrecision*labelData.Channel9.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel9.Mz-(ppmPrecision*labelData.Channel9.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel9.Intensity {
+					labelData.Channel9.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel11.Mz+(ppmPrecision*labelData.Channel11.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel11.Mz-(ppmPrecision*labelData.Channel11.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel11.Intensity {
-						labelData.Channel11.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel10.Mz+(ppmPrecision*labelData.Channel10.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel10.Mz-(ppmPrecision*labelData.Channel10.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel10.Intensity {
+					labelData.Channel10.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel12.Mz+(ppmPrecision*labelData.Channel12.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel12.Mz-(ppmPrecision*labelData.Channel12.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel12.Intensity {
-						labelData.Channel12.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel11.Mz+(ppmPrecision*labelData.Channel11.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel11.Mz-(ppmPrecision*labelData.Channel11.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel11.Intensity {
+					labelData.Channel11.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel13.Mz+(ppmPrecision*labelData.Channel13.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel13.Mz-(ppmPrecision*labelData.Channel13.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel13.Intensity {
-						labelData.Channel13.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel12.Mz+(ppmPrecision*labelData.Channel12.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel12.Mz-(ppmPrecision*labelData.Channel12.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel12.Intensity {
+					labelData.Channel12.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel14.Mz+(ppmPrecision*labelData.Channel14.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel14.Mz-(ppmPrecision*labelData.Channel14.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel14.Intensity {
-						labelData.Channel14.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel13.Mz+(ppmPrecision*labelData.Channel13.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel13.Mz-(ppmPrecision*labelData.Channel13.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel13.Intensity {
+					labelData.Channel13.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel15.Mz+(ppmPrecision*labelData.Channel15.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel15.Mz-(ppmPrecision*labelData.Channel15.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel15.Intensity {
-						labelData.Channel15.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel14.Mz+(ppmPrecision*labelData.Channel14.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel14.Mz-(ppmPrecision*labelData.Channel14.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel14.Intensity {
+					labelData.Channel14.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel16.Mz+(ppmPrecision*labelData.Channel16.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel16.Mz-(ppmPrecision*labelData.Channel16.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel16.Intensity {
-						labelData.Channel16.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel15.Mz+(ppmPrecision*labelData.Channel15.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel15.Mz-(ppmPrecision*labelData.Channel15.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel15.Intensity {
+					labelData.Channel15.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel17.Mz+(ppmPrecision*labelData.Channel17.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel17.Mz-(ppmPrecision*labelData.Channel17.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel17.Intensity {
-						labelData.Channel17.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel16.Mz+(ppmPrecision*labelData.Channel16.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel16.Mz-(ppmPrecision*labelData.Channel16.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel16.Intensity {
+					labelData.Channel16.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if i.Mz.DecodedStream[j] <= (labelData.Channel18.Mz+(ppmPrecision*labelData.Channel18.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel18.Mz-(ppmPrecision*labelData.Channel18.Mz)) {
-					if i.Intensity.DecodedStream[j] > labelData.Channel18.Intensity {
-						labelData.Channel18.Intensity = i.Intensity.DecodedStream[j]
-					}
+			if i.Mz.DecodedStream[j] <= (labelData.Channel17.Mz+(ppmPrecision*labelData.Channel17.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel17.Mz-(ppmPrecision*labelData.Channel17.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel17.Intensity {
+					labelData.Channel17.Intensity = i.Intensity.DecodedStream[j]
 				}
+			}
 
-				if brand != "xtag" && i.Mz.DecodedStream[j] > 137 {
-					break
-				} else if i.Mz.DecodedStream[j] > 450 {
-					break
+			if i.Mz.DecodedStream[j] <= (labelData.Channel18.Mz+(ppmPrecision*labelData.Channel18.Mz)) && i.Mz.DecodedStream[j] >= (labelData.Channel18.Mz-(ppmPrecision*labelData.Channel18.Mz)) {
+				if i.Intensity.DecodedStream[j] > labelData.Channel18.Intensity {
+					labelData.Channel18.Intensity = i.Intensity.DecodedStream[j]
 				}
-
 			}
 
-			labels[precPaddedScan] = labelData
+			if brand != "xtag" && i.Mz.DecodedStream[j] > 137 {
+				break
+			} else if i.Mz.DecodedStream[j] > 450 {
+				break
+			}
 
 		}
-	}
+
+		labels[precPaddedScan] = labelData
+
+	}, "3")
 
 	return labels
 }
@@ -4,7 +4,6 @@ import (
 	"errors"
 	"fmt"
 	"math"
-	"path/filepath"
 	"philosopher/lib/bio"
 	"philosopher/lib/id"
 	"philosopher/lib/msg"
@@ -41,7 +40,6 @@ func peakIntensity(evi rep.Evidence, dir, format string, rTWin, pTWin, tol float
 	var spectra = make(map[string][]id.SpectrumType)
 	var ppmPrecision = make(map[id.SpectrumType]float64)
 	var mzMap = make(map[string]float64)
-	var mzCVMap = make(map[string]string)
 	var minRT = make(map[id.SpectrumType]float64)
 	var maxRT = make(map[id.SpectrumType]float64)
 	var compVoltageMap = make(map[id.SpectrumType]string)
@@ -80,40 +78,36 @@ func peakIntensity(evi rep.Evidence, dir, format string, rTWin, pTWin, tol float
 	for _, s := range sourceList {
 
 		logrus.Info("Processing ", s)
-		var mz mzn.MsData
-		var fileName string
 
-		if isRaw {
-			//fileName = fmt.Sprintf("%s%s%s.raw", dir, string(filepath.Separator), s)
-			//stream := rawfilereader.Run(fileName, "")
-			//mz.ReadRaw(s, stream)
-		} else {
-			fileName = fmt.Sprintf("%s%s%s.mzML", dir, string(filepath.Separator), s)
-			mz.Read(fileName)
-		}
-
-		for i := range mz.Spectra {
+		// only the MS1 peaks and the fragment metadata are needed, so the spectra come from the workspace cache
+		walk := mzn.CachedWalker(sourceFileName(dir, format, s, isRaw), spectraWalker(dir, format, s, isRaw))
 
-			spectrum := fmt.Sprintf("%s.%05s.%05s.%d", s, mz.Spectra[i].Scan, mz.Spectra[i].Scan, mz.Spectra[i].Precursor.ChargeState)
-
-			if mz.Spectra[i].Level == "1" {
-				if !isRaw {
-					mz.Spectra[i].Decode()
-				}
+		// PSMs indexed by the left-padded scan number
+		var scans = make(map[string][]id.SpectrumType)
+		for _, j := range spectra[s] {
+			split := strings.Split(j.Spectrum, ".")
+			scans[split[1]] = append(scans[split[1]], j)
+		}
 
-				if isFaims {
-					mzCVMap[mz.Spectra[i].Scan] = mz.Spectra[i].CompensationVoltage
-				}
+		// the fragment scans define the precursor m/z used for the traces
+		walk(func(spec mzn.Spectrum) {
+			spectrum := fmt.Sprintf("%s.%05s.%05s.%d", s, spec.Scan, spec.Scan, spec.Precursor.ChargeState)
+			_, ok := mzMap[spectrum]
+			if ok {
+				mzMap[spectrum] = spec.Precursor.TargetIon
+			}
 
-			} else if mz.Spectra[i].Level == "2" {
-				_, ok := mzMap[spectrum]
-				if ok {
-					mzMap[spectrum] = mz.Spectra[i].Precursor.TargetIon
+			// the measured mobility is kept when the search engine does not report it
+			for _, j := range scans[fmt.Sprintf("%05s", spec.Scan)] {
+				psm := psmMap[j]
+				if psm.IonMobility == 0 && spec.Precursor.IonMobility > 0 {
+					psm.IonMobility = spec.Precursor.IonMobility
+					psmMap[j] = psm
 				}
 			}
-		}
+		}, "2")
 
-		mappedPurity := calculateIonPurity(dir, format, mz, sourceMap[s])
+		mappedPurity, _ := calculateIonPurity(dir, format, walk, nil, sourceMap[s])
 
 		for _, j := range mappedPurity {
 			v, ok := psmMap[j.SpectrumFileName()]
@@ -126,11 +120,20 @@ func peakIntensity(evi rep.Evidence, dir, format string, rTWin, pTWin, tol float
 
 		v, ok := spectra[s]
 		if ok {
+
+			var mobility = make(map[id.SpectrumType]float64)
+			for _, j := range v {
+				mobility[j] = psmMap[j].IonMobility
+			}
+
+			traces := xic(walk, v, minRT, maxRT, ppmPrecision, mzMap, mobility)
+
 			for _, j := range v {
 
-				measuredFaims, measured, retrieved := xic(mz.Spectra, minRT[j], maxRT[j], ppmPrecision[j], mzMap[j.Str()])
+				measured := traces[j].list
+				measuredFaims := traces[j].faims
 
-				if retrieved {
+				if len(measured) >= 5 {
 
 					var timeW = retentionTime[j] / 60
 					var topI = 0.0
@@ -176,6 +179,7 @@ func peakIntensity(evi rep.Evidence, dir, format string, rTWin, pTWin, tol float
 		v, ok := psmMap[evi.PSM[i].SpectrumFileName()]
 		if ok {
 			evi.PSM[i].Purity = v.Purity
+			evi.PSM[i].IonMobility = v.IonMobility
 		}
 
 	}
@@ -183,42 +187,69 @@ func peakIntensity(evi rep.Evidence, dir, format string, rTWin, pTWin, tol float
 	return evi
 }
 
-// xic extract ion chomatograms
-func xic(mz mzn.Spectra, minRT, maxRT, ppmPrecision, mzValue float64) (map[string]float64, map[float64]float64, bool) {
+// trace holds the extracted ion chromatogram of a PSM, indexed by retention time and by compensation voltage
+type trace struct {
+	list  map[float64]float64
+	faims map[string]float64
+}
+
+// xic extract ion chomatograms for all PSMs in a single pass over the MS1 spectra. When the MS1 peaks
+// carry ion mobility, like timsTOF frames, the peaks within the mobility window of the PSM are summed
+func xic(walk mzn.Walker, psms []id.SpectrumType, minRT, maxRT map[id.SpectrumType]float64, ppmPrecision map[id.SpectrumType]float64, mzMap map[string]float64, mobility map[id.SpectrumType]float64) map[id.SpectrumType]trace {
 
-	var list = make(map[float64]float64)
-	var ms1CompensationVoltage = make(map[string]float64)
+	var traces = make(map[id.SpectrumType]trace)
 
-	for j := range mz {
-		if mz[j].Level == "1" {
+	// all windows have the same width, so sorting by the lower bound also sorts by the upper bound
+	var sorted = make([]id.SpectrumType, len(psms))
+	copy(sorted, psms)
+	sort.Slice(sorted, func(i, j int) bool { return minRT[sorted[i]] < minRT[sorted[j]] })
 
-			if mz[j].ScanStartTime >= minRT && mz[j].ScanStartTime <= maxRT {
+	for _, j := range sorted {
+		traces[j] = trace{list: make(map[float64]float64), faims: make(map[string]float64)}
+	}
 
-				lowi := sort.Search(len(mz[j].Mz.DecodedStream), func(i int) bool { return mz[j].Mz.DecodedStream[i] >= mzValue-ppmPrecision*mzValue })
-				highi := sort.Search(len(mz[j].Mz.DecodedStream), func(i int) bool { return mz[j].Mz.DecodedStream[i] >= mzValue+ppmPrecision*mzValue })
+	walk(func(spec mzn.Spectrum) {
 
-				var maxI = 0.0
+		lo := sort.Search(len(sorted), func(i int) bool { return maxRT[sorted[i]] >= spec.ScanStartTime })
+		hi := sort.Search(len(sorted), func(i int) bool { return minRT[sorted[i]] > spec.ScanStartTime })
 
-				for _, k := range mz[j].Intensity.DecodedStream[lowi:highi] {
-					if k > maxI {
-						maxI = k
+		hasMobility := len(spec.IonMobility.DecodedStream) == len(spec.Mz.DecodedStream)
+
+		for _, j := range sorted[lo:hi] {
+
+			mzValue := mzMap[j.Str()]
+
+			lowi := sort.Search(len(spec.Mz.DecodedStream), func(i int) bool { return spec.Mz.DecodedStream[i] >= mzValue-ppmPrecision[j]*mzValue })
+			highi := sort.Search(len(spec.Mz.DecodedStream), func(i int) bool { return spec.Mz.DecodedStream[i] >= mzValue+ppmPrecision[j]*mzValue })
+
+			var maxI = 0.0
+
+			if hasMobility && mobility[j] > 0 {
+
+				for k := lowi; k < highi; k++ {
+					if math.Abs(spec.IonMobility.DecodedStream[k]-mobility[j]) <= imDeltaWindow {
+						maxI += spec.Intensity.DecodedStream[k]
 					}
 				}
 
-				if maxI > 0 {
-					list[mz[j].ScanStartTime] = maxI
-					ms1CompensationVoltage[mz[j].CompensationVoltage] = maxI
+			} else {
+
+				for _, k := range spec.Intensity.DecodedStream[lowi:highi] {
+					if k > maxI {
+						maxI = k
+					}
 				}
+			}
 
+			if maxI > 0 {
+				traces[j].list[spec.ScanStartTime] = maxI
+				traces[j].faims[spec.CompensationVoltage] = maxI
 			}
 		}
-	}
 
-	if len(list) >= 5 {
-		return ms1CompensationVoltage, list, true
-	}
+	}, "1")
 
-	return ms1CompensationVoltage, list, false
+	return traces
 }
 
 func calculateIntensities(e rep.Evidence) rep.Evidence {
@@ -8,6 +8,7 @@ import (
 	"philosopher/lib/id"
 	"philosopher/lib/xta"
 	"sort"
+	"strconv"
 	"strings"
 
 	"philosopher/lib/iso"
@@ -92,42 +93,56 @@ func RunIsobaricLabelQuantification(p met.Quantify, mods bool) met.Quantify {
 
 	logrus.Info("Calculating intensities and ion interference")
 
+	// the purity threshold is dropped for the files where the ion purity cannot be calculated
+	var noPurity = make(map[string]bool)
+
 	for i := range sourceList {
 
-		var mz mzn.MsData
-		var fileName string
+		var idx *mzn.Index
+		var walk mzn.Walker
 
 		logrus.Info("Processing ", sourceList[i])
 
-		if p.Raw {
-			//fileName = fmt.Sprintf("%s%s%s.raw", p.Dir, string(filepath.Separator), sourceList[i])
-			//stream := rawfilereader.Run(fileName, "")
-			//mz.ReadRaw(fileName, stream)
+		if !p.Raw && p.Format == "mzML" {
 
-		} else {
+			fileName := fmt.Sprintf("%s%s%s.mzML", p.Dir, string(filepath.Separator), sourceList[i])
 
-			fileName = fmt.Sprintf("%s%s%s.mzML", p.Dir, string(filepath.Separator), sourceList[i])
-			mz.Read(fileName)
+			// only the scans referenced by PSMs and their parents are decoded
+			idx = mzn.NewIndex(fileName)
+			walk = idx.Walker(isobaricScans(idx, sourceMap[sourceList[i]], p.Level))
 
-			for i := range mz.Spectra {
-				mz.Spectra[i].Decode()
-			}
+		} else {
+			walk = spectraWalker(p.Dir, p.Format, sourceList[i], p.Raw)
+		}
+
+		// the ion purity only needs the MS1 peaks, so it can be calculated from the workspace cache
+		purityWalk := walk
+		if idx == nil {
+			purityWalk = mzn.CachedWalker(sourceFileName(p.Dir, p.Format, sourceList[i], p.Raw), walk)
 		}
 
-		mappedPurity := calculateIonPurity(p.Dir, p.Format, mz, sourceMap[sourceList[i]])
+		mappedPurity, hasMS1 := calculateIonPurity(p.Dir, p.Format, purityWalk, idx, sourceMap[sourceList[i]])
+		if !hasMS1 {
+			msg.Custom(fmt.Errorf("no MS1 spectra found for %s, the ion purity filter will not be applied", sourceList[i]), "warning")
+			noPurity[sourceList[i]] = true
+		}
 
 		var labels map[string]iso.Labels
 		if p.Level == 3 {
-			labels = prepareLabelStructureWithMS3(p.Dir, p.Format, p.Brand, p.Plex, p.Tol, mz)
+			labels = prepareLabelStructureWithMS3(p.Dir, p.Format, p.Brand, p.Plex, p.Tol, walk)
 
 		} else {
-			labels = prepareLabelStructureWithMS2(p.Dir, p.Format, p.Brand, p.Plex, p.Tol, mz)
+			labels = prepareLabelStructureWithMS2(p.Dir, p.Format, p.Brand, p.Plex, p.Tol, walk)
 		}
 
 		labels = assignLabelNames(labels, p.LabelNames, p.Brand, p.Plex)
 
 		mappedPSM := mapLabeledSpectra(labels, p.Purity, sourceMap[sourceList[i]])
 
+		if idx != nil {
+			idx.Close()
+		}
+
 		for _, j := range mappedPurity {
 			v, ok := psmMap[j.SpectrumFileName()]
 			if ok {
@@ -159,7 +174,7 @@ func RunIsobaricLabelQuantification(p met.Quantify, mods bool) met.Quantify {
 
 	// classification and filtering based on quality filters
 	logrus.Info("Filtering spectra for label quantification")
-	spectrumMap, phosphoSpectrumMap := classification(evi, mods, p.BestPSM, p.RemoveLow, p.Purity, p.MinProb)
+	spectrumMap, phosphoSpectrumMap := classification(evi, mods, p.BestPSM, p.RemoveLow, p.Purity, p.MinProb, noPurity)
 
 	// assignment happens only for general PSMs
 	evi = assignUsage(evi, spectrumMap)
@@ -583,7 +598,7 @@ func assignLabelNames(labels map[string]iso.Labels, labelNames map[string]string
 	return labels
 }
 
-func classification(evi rep.Evidence, mods, best bool, remove, purity, probability float64) (map[id.SpectrumType]iso.Labels, map[id.SpectrumType]iso.Labels) {
+func classification(evi rep.Evidence, mods, best bool, remove, purity, probability float64, noPurity map[string]bool) (map[id.SpectrumType]iso.Labels, map[id.SpectrumType]iso.Labels) {
 
 	var spectrumMap = make(map[id.SpectrumType]iso.Labels)
 	var phosphoSpectrumMap = make(map[id.SpectrumType]iso.Labels)
@@ -593,7 +608,7 @@ func classification(evi rep.Evidence, mods, best bool, remove, purity, probabili
 
 	// 1st check: Purity the score and the Probability levels
 	for _, i := range evi.PSM {
-		if i.Probability >= probability && i.Purity >= purity {
+		if i.Probability >= probability && (i.Purity >= purity || noPurity[strings.Split(i.Spectrum, ".")[0]]) {
 
 			spectrumMap[i.SpectrumFileName()] = *i.Labels
 			bestMap[i.SpectrumFileName()] = 0
@@ -733,130 +748,256 @@ func classification(evi rep.Evidence, mods, best bool, remove, purity, probabili
 	return spectrumMap, phosphoSpectrumMap
 }
 
-// calculateIonPurity verifies how much interference there is on the precursor scans for each fragment
-func calculateIonPurity(d, f string, mz mzn.MsData, evi []rep.PSMEvidence) []rep.PSMEvidence {
+// isobaricScans lists the fragment scans referenced by the PSMs, their parent MS1 scans
+// and, for MS3 quantification, the MS3 scans derived from them
+func isobaricScans(idx *mzn.Index, evi []rep.PSMEvidence, level int) []string {
 
-	// index MS1 and MS2 spectra in a dictionary
-	var indexedMS1 = make(map[string]mzn.Spectrum)
-	var indexedMS2 = make(map[string]mzn.Spectrum)
+	var scans []string
 
-	var MS1Peaks = make(map[string][]float64)
-	var MS1Int = make(map[string][]float64)
+	for _, i := range evi {
 
-	for i := range mz.Spectra {
+		split := strings.Split(i.Spectrum, ".")
+		sn, e := strconv.Atoi(split[1])
+		if e != nil {
+			continue
+		}
 
-		if mz.Spectra[i].Level == "1" {
+		spec, ok := idx.Scan(strconv.Itoa(sn))
+		if !ok {
+			continue
+		}
 
-			// left-pad the spectrum index
-			paddedIndex := fmt.Sprintf("%05s", mz.Spectra[i].Index)
+		scans = append(scans, spec.Scan)
+		if len(spec.Precursor.ParentScan) > 0 {
+			scans = append(scans, spec.Precursor.ParentScan)
+		}
 
-			// left-pad the spectrum scan
-			paddedScan := fmt.Sprintf("%05s", mz.Spectra[i].Scan)
+		if level == 3 {
+			// the MS3 scans follow their MS2 within the same duty cycle
+			for n := sn + 1; n <= idx.Len(); n++ {
+				next, ok := idx.Scan(strconv.Itoa(n))
+				if !ok || next.Level == "1" {
+					break
+				}
+				if next.Level == "3" && next.Precursor.ParentScan == spec.Scan {
+					scans = append(scans, next.Scan)
+					break
+				}
+			}
+		}
+	}
+
+	return scans
+}
+
+// spectraWalker reads the spectra of a source file, using the reader for the given format. The
+// formats without a streaming reader are loaded in memory on the first walk
+func spectraWalker(dir, format, source string, isRaw bool) mzn.Walker {
 
-			mz.Spectra[i].Index = paddedIndex
-			mz.Spectra[i].Scan = paddedScan
+	fileName := sourceFileName(dir, format, source, isRaw)
 
-			indexedMS1[paddedScan] = mz.Spectra[i]
+	if !isRaw && format == "d" {
+		return mzn.StreamBrukerTDF(fileName)
+	} else if !isRaw && format != "mzXML" && format != "mgf" {
+		return mzn.StreamMzML(fileName)
+	}
 
-			MS1Peaks[paddedScan] = mz.Spectra[i].Mz.DecodedStream
-			MS1Int[paddedScan] = mz.Spectra[i].Intensity.DecodedStream
+	var mz *mzn.MsData
 
-		} else if mz.Spectra[i].Level == "2" {
+	return func(fun func(spec mzn.Spectrum), levels ...string) {
 
-			if mz.Spectra[i].Precursor.IsolationWindowLowerOffset == 0 && mz.Spectra[i].Precursor.IsolationWindowUpperOffset == 0 {
-				mz.Spectra[i].Precursor.IsolationWindowLowerOffset = mzDeltaWindow
-				mz.Spectra[i].Precursor.IsolationWindowUpperOffset = mzDeltaWindow
+		if mz == nil {
+			mz = &mzn.MsData{}
+			if isRaw {
+				mz.ReadThermoRaw(fileName)
+			} else if format == "mzXML" {
+				mz.ReadMzXML(fileName)
+			} else {
+				mz.ReadMGF(fileName)
 			}
+		}
 
-			// left-pad the spectrum index
-			paddedIndex := fmt.Sprintf("%05s", mz.Spectra[i].Index)
+		mz.Walk(fun, levels...)
+	}
+}
 
-			// left-pad the spectrum scan
-			paddedScan := fmt.Sprintf("%05s", mz.Spectra[i].Scan)
+// sourceFileName is the path to the spectra file of a source
+func sourceFileName(dir, format, source string, isRaw bool) string {
 
-			// left-pad the precursor spectrum index
-			paddedPI := fmt.Sprintf("%05s", mz.Spectra[i].Precursor.ParentIndex)
+	if isRaw {
+		format = "raw"
+	}
 
-			// left-pad the precursor spectrum scan
-			paddedPS := fmt.Sprintf("%05s", mz.Spectra[i].Precursor.ParentScan)
+	return fmt.Sprintf("%s%s%s.%s", dir, string(filepath.Separator), source, format)
+}
 
-			mz.Spectra[i].Index = paddedIndex
-			mz.Spectra[i].Scan = paddedScan
-			mz.Spectra[i].Precursor.ParentIndex = paddedPI
-			mz.Spectra[i].Precursor.ParentScan = paddedPS
+// calculateIonPurity verifies how much interference there is on the precursor scans for each fragment.
+// Files without MS1 spectra, like MGF, have no purity and false is returned. Only the most recent MS1
+// spectra are kept in memory, the parents that were dropped before their fragments show up are read
+// through the mzML index when there is one, or in a second pass over the MS1 spectra
+func calculateIonPurity(d, f string, walk mzn.Walker, idx *mzn.Index, evi []rep.PSMEvidence) ([]rep.PSMEvidence, bool) {
 
-			stream := MS1Peaks[paddedPS]
+	// index the PSMs by the left-padded scan number of their fragment spectrum
+	var psmScans = make(map[string][]int)
+	for i := range evi {
+		split := strings.Split(evi[i].Spectrum, ".")
+		psmScans[split[1]] = append(psmScans[split[1]], i)
+	}
 
-			for j := range stream {
-				if stream[j] >= (mz.Spectra[i].Precursor.TargetIon-mz.Spectra[i].Precursor.IsolationWindowLowerOffset) && stream[j] <= (mz.Spectra[i].Precursor.TargetIon+mz.Spectra[i].Precursor.IsolationWindowUpperOffset) {
-					if MS1Int[mz.Spectra[i].Precursor.ParentScan][j] > mz.Spectra[i].Precursor.TargetIonIntensity {
-						mz.Spectra[i].Precursor.TargetIonIntensity = MS1Int[mz.Spectra[i].Precursor.ParentScan][j]
-					}
+	var indexedMS1 = make(map[string]mzn.Spectrum)
+	var ms1Order []string
+	var hasMS1 bool
+
+	// the fragments waiting for a parent that is no longer in memory, by parent scan
+	var pending = make(map[string][]mzn.Spectrum)
+
+	assign := func(v1, spec mzn.Spectrum) {
+
+		for j := range v1.Mz.DecodedStream {
+			if v1.Mz.DecodedStream[j] >= (spec.Precursor.TargetIon-spec.Precursor.IsolationWindowLowerOffset) && v1.Mz.DecodedStream[j] <= (spec.Precursor.TargetIon+spec.Precursor.IsolationWindowUpperOffset) {
+				if v1.Intensity.DecodedStream[j] > spec.Precursor.TargetIonIntensity {
+					spec.Precursor.TargetIonIntensity = v1.Intensity.DecodedStream[j]
 				}
 			}
+		}
 
-			indexedMS2[paddedScan] = mz.Spectra[i]
+		for _, i := range psmScans[fmt.Sprintf("%05s", spec.Scan)] {
+			evi[i].Purity = ionPurity(v1, spec, evi[i].AssumedCharge)
 		}
 	}
 
-	for i := range evi {
+	walk(func(spec mzn.Spectrum) {
 
-		// get spectrum index
-		split := strings.Split(evi[i].Spectrum, ".")
+		if spec.Level == "1" {
 
-		v2, ok := indexedMS2[split[1]]
-		if ok {
+			hasMS1 = true
 
-			v1 := indexedMS1[v2.Precursor.ParentScan]
+			// left-pad the spectrum scan
+			paddedScan := fmt.Sprintf("%05s", spec.Scan)
 
-			var ions = make(map[float64]float64)
-			var isolationWindowSummedInt float64
+			indexedMS1[paddedScan] = spec
+			ms1Order = append(ms1Order, paddedScan)
 
-			for k := range v1.Mz.DecodedStream {
-				if v1.Mz.DecodedStream[k] >= (v2.Precursor.TargetIon-v2.Precursor.IsolationWindowUpperOffset) && v1.Mz.DecodedStream[k] <= (v2.Precursor.TargetIon+v2.Precursor.IsolationWindowUpperOffset) {
-					ions[v1.Mz.DecodedStream[k]] = v1.Intensity.DecodedStream[k]
-					isolationWindowSummedInt += v1.Intensity.DecodedStream[k]
-				}
+			if len(ms1Order) > ms1BufferSize {
+				delete(indexedMS1, ms1Order[0])
+				ms1Order = ms1Order[1:]
 			}
 
-			// create the list of mz differences for each peak
-			var mzRatio []float64
-			for k := 1; k <= 6; k++ {
-				r := float64(k) * (float64(1) / float64(v2.Precursor.ChargeState))
-				mzRatio = append(mzRatio, uti.Round(r, 5, 2))
-			}
+			return
+		}
 
-			var isotopePackage = make(map[float64]float64)
-			isotopePackage[v2.Precursor.TargetIon] = v2.Precursor.TargetIonIntensity
-			isotopesInt := v2.Precursor.TargetIonIntensity
+		if _, ok := psmScans[fmt.Sprintf("%05s", spec.Scan)]; !ok {
+			return
+		}
 
-			for k, v := range ions {
-				for _, m := range mzRatio {
-					if math.Abs(v2.Precursor.TargetIon-k) <= (m+0.025) && math.Abs(v2.Precursor.TargetIon-k) >= (m-0.025) {
-						if v != v2.Precursor.TargetIonIntensity {
-							isotopePackage[k] = v
-							isotopesInt += v
-						}
-						break
-					}
-				}
-			}
+		if spec.Precursor.IsolationWindowLowerOffset == 0 && spec.Precursor.IsolationWindowUpperOffset == 0 {
+			spec.Precursor.IsolationWindowLowerOffset = mzDeltaWindow
+			spec.Precursor.IsolationWindowUpperOffset = mzDeltaWindow
+		}
 
-			if isolationWindowSummedInt < 0 {
-				msg.Custom(errors.New("summed intensity within isolation window is negative, should not happen"), "warning")
-			}
-			if isotopesInt < 0 {
-				msg.Custom(errors.New("isotopes summed intensity is negative, should not happen"), "warning")
+		parentScan := fmt.Sprintf("%05s", spec.Precursor.ParentScan)
+
+		if v1, ok := indexedMS1[parentScan]; ok {
+			assign(v1, spec)
+			return
+		}
+
+		if idx != nil {
+			if v1, ok := idx.Scan(spec.Precursor.ParentScan); ok {
+				v1.Decode()
+				assign(v1, spec)
+				return
 			}
+		}
 
-			if isolationWindowSummedInt <= 0 || isotopesInt <= 0 {
-				evi[i].Purity = 0
-			} else {
-				evi[i].Purity = uti.Round((isotopesInt / isolationWindowSummedInt), 5, 2)
+		// only the precursor is needed later on
+		spec.Mz.DecodedStream = nil
+		spec.Intensity.DecodedStream = nil
+		spec.IonMobility.DecodedStream = nil
+		pending[parentScan] = append(pending[parentScan], spec)
+
+	}, "1", "2")
+
+	if len(pending) > 0 {
+
+		walk(func(v1 mzn.Spectrum) {
+
+			paddedScan := fmt.Sprintf("%05s", v1.Scan)
+
+			for _, i := range pending[paddedScan] {
+				assign(v1, i)
 			}
 
+			delete(pending, paddedScan)
+
+		}, "1")
+	}
+
+	if len(pending) > 0 {
+
+		var missing int
+		for _, i := range pending {
+			missing += len(i)
 		}
+
+		msg.Custom(fmt.Errorf("the parent MS1 scans of %d fragment spectra were not found, their ion purity is 0", missing), "warning")
 	}
 
-	return evi
+	return evi, hasMS1
+}
+
+// ionPurity calculates the fraction of the isolation window intensity that belongs to the precursor isotopic envelope
+func ionPurity(v1, v2 mzn.Spectrum, assumedCharge uint8) float64 {
+
+	var ions = make(map[float64]float64)
+	var isolationWindowSummedInt float64
+
+	for k := range v1.Mz.DecodedStream {
+		if v1.Mz.DecodedStream[k] >= (v2.Precursor.TargetIon-v2.Precursor.IsolationWindowUpperOffset) && v1.Mz.DecodedStream[k] <= (v2.Precursor.TargetIon+v2.Precursor.IsolationWindowUpperOffset) {
+			ions[v1.Mz.DecodedStream[k]] = v1.Intensity.DecodedStream[k]
+			isolationWindowSummedInt += v1.Intensity.DecodedStream[k]
+		}
+	}
+
+	// the native raw reader does not report the precursor charge, use the PSM one instead
+	charge := v2.Precursor.ChargeState
+	if charge == 0 {
+		charge = int(assumedCharge)
+	}
+
+	// create the list of mz differences for each peak
+	var mzRatio []float64
+	for k := 1; k <= 6; k++ {
+		r := float64(k) * (float64(1) / float64(charge))
+		mzRatio = append(mzRatio, uti.Round(r, 5, 2))
+	}
+
+	var isotopePackage = make(map[float64]float64)
+	isotopePackage[v2.Precursor.TargetIon] = v2.Precursor.TargetIonIntensity
+	isotopesInt := v2.Precursor.TargetIonIntensity
+
+	for k, v := range ions {
+		for _, m := range mzRatio {
+			if math.Abs(v2.Precursor.TargetIon-k) <= (m+0.025) && math.Abs(v2.Precursor.TargetIon-k) >= (m-0.025) {
+				if v != v2.Precursor.TargetIonIntensity {
+					isotopePackage[k] = v
+					isotopesInt += v
+				}
+				break
+			}
+		}
+	}
+
+	if isolationWindowSummedInt < 0 {
+		msg.Custom(errors.New("summed intensity within isolation window is negative, should not happen"), "warning")
+	}
+	if isotopesInt < 0 {
+		msg.Custom(errors.New("isotopes summed intensity is negative, should not happen"), "warning")
+	}
+
+	if isolationWindowSummedInt <= 0 || isotopesInt <= 0 {
+		return 0
+	}
+
+	return uti.Round((isotopesInt / isolationWindowSummedInt), 5, 2)
 }
@@ -0,0 +1,49 @@
+package qua
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+
+	"philosopher/lib/mzn"
+	"philosopher/lib/rep"
+)
+
+func TestCalculateIonPurityEvictedParent(t *testing.T) {
+
+	ms1 := func(scan int) mzn.Spectrum {
+		var s mzn.Spectrum
+		s.Scan = strconv.Itoa(scan)
+		s.Level = "1"
+		s.Mz.DecodedStream = []float64{500, 500.3, 501}
+		s.Intensity.DecodedStream = []float64{100, 50, 100}
+		return s
+	}
+
+	// the fragment of scan 1 comes after more MS1 spectra than the ones kept in memory
+	var data mzn.MsData
+	data.Spectra = append(data.Spectra, ms1(1))
+	for i := 2; i <= ms1BufferSize+2; i++ {
+		data.Spectra = append(data.Spectra, ms1(i))
+	}
+
+	var fragment mzn.Spectrum
+	fragment.Scan = strconv.Itoa(ms1BufferSize + 3)
+	fragment.Level = "2"
+	fragment.Precursor.ParentScan = "1"
+	fragment.Precursor.TargetIon = 500
+	fragment.Precursor.ChargeState = 2
+	data.Spectra = append(data.Spectra, fragment)
+
+	evi := []rep.PSMEvidence{{Spectrum: fmt.Sprintf("run.%05d.%05d.2", ms1BufferSize+3, ms1BufferSize+3), AssumedCharge: 2}}
+
+	evi, hasMS1 := calculateIonPurity("", "", data.Walk, nil, evi)
+
+	if !hasMS1 {
+		t.Fatal("the MS1 spectra were not found")
+	}
+
+	if evi[0].Purity != 0.66 {
+		t.Errorf("got purity %f, want 0.66", evi[0].Purity)
+	}
+}
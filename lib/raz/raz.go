@@ -8,6 +8,7 @@ import (
 type RazorCandidate struct {
 	Sequence          string
 	MappedProtein     string
+	Rationale         string
 	MappedproteinsSID map[string]string
 	MappedProteinsW   map[string]float64
 	MappedProteinsGW  map[string]float64
@@ -0,0 +1,194 @@
+package raz
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/dat"
+	"philosopher/lib/msg"
+)
+
+// The razor assignment strategies, the default keeps the weights of the protein inference
+const (
+	Default     = ""
+	Peptides    = "peptides"
+	Spectra     = "spectra"
+	Probability = "probability"
+	Length      = "length"
+	Reviewed    = "reviewed"
+)
+
+// Protein is the evidence of a razor candidate protein
+type Protein struct {
+	Peptides    int
+	Spectra     int
+	Probability float64
+	Length      int
+	Existence   int
+	Reviewed    bool
+}
+
+// criterion compares two candidates, it is positive when the first one is preferred
+type criterion struct {
+	Name    string
+	Compare func(a, b Protein) int
+}
+
+var criteria = map[string]criterion{
+	Peptides: {"most peptides", func(a, b Protein) int { return a.Peptides - b.Peptides }},
+	Spectra:  {"most spectra", func(a, b Protein) int { return a.Spectra - b.Spectra }},
+	Probability: {"highest probability", func(a, b Protein) int {
+		if a.Probability > b.Probability {
+			return 1
+		} else if a.Probability < b.Probability {
+			return -1
+		}
+		return 0
+	}},
+	Length: {"longest protein", func(a, b Protein) int { return a.Length - b.Length }},
+	Reviewed: {"reviewed entry", func(a, b Protein) int {
+		if a.Reviewed != b.Reviewed {
+			if a.Reviewed {
+				return 1
+			}
+			return -1
+		}
+		return b.Existence - a.Existence
+	}},
+}
+
+// the criteria breaking the ties of the chosen strategy
+var tieBreakers = []string{Peptides, Spectra, Probability, Reviewed, Length}
+
+// ParseStrategy normalizes the razor assignment strategy, and returns an error when it does not exist
+func ParseStrategy(strategy string) (string, error) {
+
+	strategy = strings.ToLower(strings.TrimSpace(strategy))
+	if strategy == Default {
+		return strategy, nil
+	}
+
+	if _, ok := criteria[strategy]; !ok {
+		return strategy, fmt.Errorf("unknown razor strategy %s, use peptides, spectra, probability, length or reviewed", strategy)
+	}
+
+	return strategy, nil
+}
+
+// Select chooses the razor protein among the candidates with the strategy, the remaining criteria
+// and the protein names break the ties, so the same candidates always give the same protein. The
+// rationale is the criterion that separates the razor protein from the runner-up
+func Select(candidates map[string]Protein, strategy string) (string, string) {
+
+	var names []string
+	for k := range candidates {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return "", ""
+	}
+
+	if len(names) == 1 {
+		return names[0], "single protein"
+	}
+
+	var order []criterion
+	if c, ok := criteria[strategy]; ok {
+		order = append(order, c)
+	}
+
+	for _, i := range tieBreakers {
+		if i != strategy {
+			order = append(order, criteria[i])
+		}
+	}
+
+	// the first criterion that tells two proteins apart decides
+	decide := func(a, b string) (int, string) {
+		for _, i := range order {
+			if c := i.Compare(candidates[a], candidates[b]); c != 0 {
+				return c, i.Name
+			}
+		}
+		return 0, "alphabetical order"
+	}
+
+	sort.SliceStable(names, func(i, j int) bool {
+		c, _ := decide(names[i], names[j])
+		return c > 0
+	})
+
+	_, rationale := decide(names[0], names[1])
+
+	return names[0], rationale
+}
+
+// Annotate collects the length, the existence level and the review status of the proteins from the
+// database, the reviewed UniProt entries start with sp|
+func Annotate(records []dat.Record, decoyTag string) map[string]Protein {
+
+	var proteins = make(map[string]Protein)
+
+	for _, i := range records {
+
+		var p Protein
+
+		p.Length = i.Length
+		p.Reviewed = strings.HasPrefix(strings.TrimPrefix(i.OriginalHeader, decoyTag), "sp|")
+
+		// the levels go from 1, evidence at protein level, to 5, uncertain
+		p.Existence = 6
+		if len(i.ProteinExistence) > 0 {
+			if v, e := strconv.Atoi(i.ProteinExistence[:1]); e == nil {
+				p.Existence = v
+			}
+		}
+
+		proteins[i.PartHeader] = p
+	}
+
+	return proteins
+}
+
+// Report writes the razor protein of each peptide, its candidates and the rationale of the choice
+func (p RazorMap) Report(workspace string) {
+
+	output := fmt.Sprintf("%s%srazor.tsv", workspace, string(filepath.Separator))
+
+	file, e := os.Create(output)
+	if e != nil {
+		msg.WriteFile(errors.New("cannot create razor report"), "error")
+		return
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	defer bw.Flush()
+
+	fmt.Fprintln(bw, "Peptide\tRazor Protein\tCandidate Proteins\tRationale")
+
+	var peptides []string
+	for k := range p {
+		peptides = append(peptides, k)
+	}
+	sort.Strings(peptides)
+
+	for _, i := range peptides {
+
+		var candidates []string
+		for k := range p[i].MappedProteinsTNP {
+			candidates = append(candidates, k)
+		}
+		sort.Strings(candidates)
+
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\n", i, p[i].MappedProtein, strings.Join(candidates, ", "), p[i].Rationale)
+	}
+}
@@ -0,0 +1,84 @@
+package raz
+
+import (
+	"testing"
+
+	"philosopher/lib/dat"
+)
+
+func TestSelect(t *testing.T) {
+
+	candidates := map[string]Protein{
+		"sp|P1|A": {Peptides: 5, Spectra: 10, Probability: 0.9, Length: 300, Existence: 1, Reviewed: true},
+		"tr|P2|B": {Peptides: 5, Spectra: 20, Probability: 0.99, Length: 900, Existence: 4},
+		"tr|P3|C": {Peptides: 2, Spectra: 30, Probability: 0.8, Length: 100, Existence: 2},
+		"tr|P4|D": {Peptides: 5, Spectra: 20, Probability: 0.95, Length: 900, Existence: 4},
+	}
+
+	tests := []struct {
+		strategy  string
+		protein   string
+		rationale string
+	}{
+		{Peptides, "tr|P2|B", "highest probability"},
+		{Spectra, "tr|P3|C", "most spectra"},
+		{Probability, "tr|P2|B", "highest probability"},
+		{Length, "tr|P2|B", "highest probability"},
+		{Reviewed, "sp|P1|A", "reviewed entry"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+
+			// the map order must not change the choice
+			for i := 0; i < 10; i++ {
+				protein, rationale := Select(candidates, tt.strategy)
+				if protein != tt.protein || rationale != tt.rationale {
+					t.Fatalf("Select() = %s, %s, want %s, %s", protein, rationale, tt.protein, tt.rationale)
+				}
+			}
+		})
+	}
+
+	if protein, rationale := Select(map[string]Protein{"A": {}}, Peptides); protein != "A" || rationale != "single protein" {
+		t.Errorf("Select() = %s, %s for a single candidate", protein, rationale)
+	}
+}
+
+func TestParseStrategy(t *testing.T) {
+
+	if s, e := ParseStrategy(" Reviewed"); e != nil || s != Reviewed {
+		t.Errorf("ParseStrategy() = %s, %v, want %s", s, e, Reviewed)
+	}
+
+	if s, e := ParseStrategy(""); e != nil || s != Default {
+		t.Errorf("ParseStrategy() = %s, %v for the default strategy", s, e)
+	}
+
+	if _, e := ParseStrategy("razor"); e == nil {
+		t.Errorf("ParseStrategy() accepted the unknown strategy razor")
+	}
+}
+
+func TestAnnotate(t *testing.T) {
+
+	records := []dat.Record{
+		{PartHeader: "sp|P1|A", OriginalHeader: "sp|P1|A PE=1", ProteinExistence: "1:Experimental evidence at protein level", Length: 300},
+		{PartHeader: "rev_sp|P1|A", OriginalHeader: "rev_sp|P1|A PE=1", ProteinExistence: "1:Experimental evidence at protein level", Length: 300},
+		{PartHeader: "tr|P2|B", OriginalHeader: "tr|P2|B", Length: 900},
+	}
+
+	proteins := Annotate(records, "rev_")
+
+	if p := proteins["sp|P1|A"]; !p.Reviewed || p.Existence != 1 || p.Length != 300 {
+		t.Errorf("got %+v for sp|P1|A", p)
+	}
+
+	if p := proteins["rev_sp|P1|A"]; !p.Reviewed {
+		t.Errorf("got %+v for the decoy of sp|P1|A, want it reviewed", p)
+	}
+
+	if p := proteins["tr|P2|B"]; p.Reviewed || p.Existence != 6 {
+		t.Errorf("got %+v for tr|P2|B", p)
+	}
+}
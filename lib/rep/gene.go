@@ -0,0 +1,178 @@
+package rep
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"philosopher/lib/dat"
+	"philosopher/lib/msg"
+)
+
+// AssembleGeneReport collapses the proteins into genes. The spectra and peptides are unique when
+// all the proteins they map to come from the same gene, even if there are several isoforms
+func (evi *Evidence) AssembleGeneReport(decoyTag string) {
+
+	var dtb dat.Base
+	dtb.Restore()
+
+	if len(dtb.Records) < 1 {
+		msg.DatabaseNotFound(errors.New(""), "error")
+	}
+
+	proteinGenes := dtb.GeneMap(decoyTag)
+
+	geneOf := func(protein string) string {
+		if g, ok := proteinGenes[protein]; ok {
+			return g
+		}
+		return dat.GeneName("", protein, decoyTag)
+	}
+
+	var index = make(map[string]int)
+	evi.Genes = make(GeneEvidenceList, 0)
+
+	for _, i := range evi.Proteins {
+
+		g := geneOf(i.PartHeader)
+
+		idx, ok := index[g]
+		if !ok {
+			idx = len(evi.Genes)
+			index[g] = idx
+			evi.Genes = append(evi.Genes, GeneEvidence{GeneName: g, QValue: i.QValue, PEP: i.PEP, IsDecoy: i.IsDecoy, IsContaminant: true})
+		}
+
+		gene := &evi.Genes[idx]
+		gene.Proteins = append(gene.Proteins, i.PartHeader)
+		gene.URazorSpC += i.URazorSpC
+		gene.URazorIntensity += i.URazorIntensity
+
+		if i.TopPepProb > gene.TopPepProb {
+			gene.TopPepProb = i.TopPepProb
+		}
+
+		if i.QValue < gene.QValue {
+			gene.QValue = i.QValue
+			gene.PEP = i.PEP
+		}
+
+		if !i.IsContaminant && !strings.HasPrefix(i.OriginalHeader, "contam_") {
+			gene.IsContaminant = false
+		}
+	}
+
+	// genesOf lists the reported genes of the proteins an evidence maps to, and whether other genes
+	// share the evidence
+	genesOf := func(names []string) ([]int, bool) {
+
+		var seen = make(map[string]struct{})
+		var list []int
+
+		for _, i := range names {
+			g := geneOf(i)
+			if _, ok := seen[g]; ok {
+				continue
+			}
+			seen[g] = struct{}{}
+			if j, ok := index[g]; ok {
+				list = append(list, j)
+			}
+		}
+
+		return list, len(seen) == 1
+	}
+
+	for _, i := range evi.PSM {
+
+		var names = []string{i.Protein}
+		for k := range i.MappedProteins {
+			names = append(names, k)
+		}
+
+		list, unique := genesOf(names)
+		for _, j := range list {
+			evi.Genes[j].TotalSpC++
+			if unique {
+				evi.Genes[j].UniqueSpC++
+			}
+		}
+	}
+
+	for _, i := range evi.Peptides {
+
+		var names = []string{i.Protein}
+		for k := range i.MappedProteins {
+			names = append(names, k)
+		}
+
+		list, unique := genesOf(names)
+		for _, j := range list {
+			evi.Genes[j].TotalPeptides++
+			if unique {
+				evi.Genes[j].UniquePeptides++
+			}
+		}
+	}
+
+	for i := range evi.Genes {
+		sort.Strings(evi.Genes[i].Proteins)
+	}
+
+	sort.Sort(evi.Genes)
+}
+
+// GeneReport reports the genes and their proteins
+func (eviGenes GeneEvidenceList) GeneReport(workspace string, hasDecoys, hasPrefix, removeContam bool) {
+
+	var output string
+
+	if hasPrefix {
+		output = fmt.Sprintf("%s%s%s_gene.tsv", workspace, string(filepath.Separator), path.Base(workspace))
+	} else {
+		output = fmt.Sprintf("%s%sgene.tsv", workspace, string(filepath.Separator))
+	}
+
+	// create result file
+	file, e := os.Create(output)
+	if e != nil {
+		msg.WriteFile(errors.New("cannot create gene report"), "fatal")
+	}
+	defer file.Close()
+
+	bw := bufio.NewWriter(file)
+	defer bw.Flush()
+
+	fmt.Fprintln(bw, "Gene\tProteins\tProtein Count\tTop Peptide Probability\tQ-Value\tPEP\tTotal Peptides\tUnique Peptides\tTotal Spectral Count\tUnique Spectral Count\tRazor Spectral Count\tRazor Intensity")
+
+	for _, i := range eviGenes {
+
+		if removeContam && i.IsContaminant {
+			continue
+		}
+
+		if !hasDecoys && i.IsDecoy {
+			continue
+		}
+
+		fmt.Fprintf(bw, "%s\t%s\t%d\t%.4f\t%.6f\t%.6f\t%d\t%d\t%d\t%d\t%d\t%.4f\n",
+			i.GeneName,
+			strings.Join(i.Proteins, ", "),
+			len(i.Proteins),
+			i.TopPepProb,
+			i.QValue,
+			i.PEP,
+			i.TotalPeptides,
+			i.UniquePeptides,
+			i.TotalSpC,
+			i.UniqueSpC,
+			i.URazorSpC,
+			i.URazorIntensity,
+		)
+	}
+}
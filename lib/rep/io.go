@@ -11,7 +11,7 @@ import (
 // SerializeGranular converts the whole structure into sevral small gob files
 func (evi *Evidence) SerializeGranular() {
 	wg := sync.WaitGroup{}
-	wg.Add(4)
+	wg.Add(5)
 	// create PSM Bin
 	go func() { defer wg.Done(); SerializePSM(&evi.PSM) }()
 	// create Ion Bin
@@ -26,6 +26,14 @@ func (evi *Evidence) SerializeGranular() {
 		}
 		SerializeProteins(&evi.Proteins)
 	}()
+	// create Gene Bin
+	go func() {
+		defer wg.Done()
+		if evi.Genes == nil {
+			evi.Genes = make(GeneEvidenceList, 0)
+		}
+		SerializeGenes(&evi.Genes)
+	}()
 	wg.Wait()
 }
 
@@ -49,6 +57,11 @@ func SerializeProteins(evi *ProteinEvidenceList) {
 	sys.Serialize(evi, sys.ProBin())
 }
 
+// SerializeGenes creates an ev serial with Evidence data
+func SerializeGenes(evi *GeneEvidenceList) {
+	sys.Serialize(evi, sys.GeneBin())
+}
+
 // RestoreGranular reads philosopher results files and restore the data sctructure
 func (evi *Evidence) RestoreGranular() {
 
@@ -63,6 +76,9 @@ func (evi *Evidence) RestoreGranular() {
 
 	// Protein
 	RestoreProtein(&evi.Proteins)
+
+	// Gene
+	RestoreGene(&evi.Genes)
 }
 
 // RestorePSM restores PSM data
@@ -85,6 +101,11 @@ func RestoreProtein(evi *ProteinEvidenceList) {
 	sys.Restore(evi, sys.ProBin(), false)
 }
 
+// RestoreGene restores Gene data, the gene report is optional
+func RestoreGene(evi *GeneEvidenceList) {
+	sys.Restore(evi, sys.GeneBin(), true)
+}
+
 // RestoreGranularWithPath reads philosopher results files and restore the data sctructure
 func (evi *Evidence) RestoreGranularWithPath(p string) {
 
@@ -99,6 +120,9 @@ func (evi *Evidence) RestoreGranularWithPath(p string) {
 
 	// Protein
 	RestoreProteinWithPath(&evi.Proteins, p)
+
+	// Gene
+	RestoreGeneWithPath(&evi.Genes, p)
 }
 
 // RestorePSMWithPath restores PSM data
@@ -124,3 +148,9 @@ func RestoreProteinWithPath(evi *ProteinEvidenceList, p string) {
 	path := fmt.Sprintf("%s%s%s", p, string(filepath.Separator), sys.ProBin())
 	sys.Restore(evi, path, false)
 }
+
+// RestoreGeneWithPath restores Gene data, the gene report is optional
+func RestoreGeneWithPath(evi *GeneEvidenceList, p string) {
+	path := fmt.Sprintf("%s%s%s", p, string(filepath.Separator), sys.GeneBin())
+	sys.Restore(evi, path, true)
+}
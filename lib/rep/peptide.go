@@ -28,6 +28,8 @@ func (evi *Evidence) AssemblePeptideReport(pep id.PepIDList, decoyTag string) {
 	var mappedProts = make(map[string][]string)
 	var pepInt = make(map[string]float64)
 	var bestProb = make(map[string]float64)
+	var qvalues = make(map[string]float64)
+	var peps = make(map[string]float64)
 	var prevAA = make(map[string]string)
 	var nextAA = make(map[string]string)
 	var spectra = make(map[string][]id.SpectrumType)
@@ -35,6 +37,8 @@ func (evi *Evidence) AssemblePeptideReport(pep id.PepIDList, decoyTag string) {
 
 	for _, i := range pep {
 		pepSeqMap[i.Peptide] = cla.IsDecoyPSM(i, decoyTag)
+		qvalues[i.Peptide] = i.QValue
+		peps[i.Peptide] = i.PEP
 	}
 
 	for _, i := range evi.PSM {
@@ -86,6 +90,8 @@ func (evi *Evidence) AssemblePeptideReport(pep id.PepIDList, decoyTag string) {
 		pep.Sequence = k
 
 		pep.Probability = bestProb[k]
+		pep.QValue = qvalues[k]
+		pep.PEP = peps[k]
 
 		pep.PrevAA = prevAA[k]
 		pep.NextAA = nextAA[k]
@@ -169,7 +175,7 @@ func (evi PeptideEvidenceList) PeptideReport(workspace, brand, decoyTag string,
 		}
 	}
 
-	header = "Peptide\tPrev AA\tNext AA\tPeptide Length\tCharges\tProbability\tSpectral Count\tIntensity\tAssigned Modifications\tObserved Modifications\tProtein\tProtein ID\tEntry Name\tGene\tProtein Description\tMapped Genes\tMapped Proteins"
+	header = "Peptide\tPrev AA\tNext AA\tPeptide Length\tCharges\tProbability\tQ-Value\tPEP\tSpectral Count\tIntensity\tAssigned Modifications\tObserved Modifications\tProtein\tProtein ID\tEntry Name\tGene\tProtein Description\tMapped Genes\tMapped Proteins"
 
 	var headerIndex int
 	for i := range printSet {
@@ -358,13 +364,15 @@ func (evi PeptideEvidenceList) PeptideReport(workspace, brand, decoyTag string,
 			i.EntryName = decoyTag + i.EntryName
 		}
 
-		line := fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%.4f\t%d\t%f\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s",
+		line := fmt.Sprintf("%s\t%s\t%s\t%d\t%s\t%.4f\t%.6f\t%.6f\t%d\t%f\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s",
 			i.Sequence,
 			string(i.PrevAA),
 			string(i.NextAA),
 			len(i.Sequence),
 			strings.Join(cs, ", "),
 			i.Probability,
+			i.QValue,
+			i.PEP,
 			i.Spc,
 			i.Intensity,
 			strings.Join(assL, ", "),
@@ -46,11 +46,14 @@ func (evi *Evidence) AssembleProteinReport(pro id.ProtIDList, weight float64, de
 		repModificationsIndex := make(map[string]mod.Modification)
 		rep.ProteinGroup = i.GroupNumber
 		rep.ProteinSubGroup = i.GroupSiblingID
+		rep.SubsumingProtein = i.SubsumingProtein
 		rep.Length = i.Length
 		rep.Coverage = i.PercentCoverage
 		rep.UniqueStrippedPeptides = len(i.UniqueStrippedPeptides)
 		rep.Probability = i.Probability
 		rep.TopPepProb = i.TopPepProb
+		rep.QValue = i.QValue
+		rep.PEP = i.PEP
 
 		rep.TotalPeptides = make(map[string]int)
 		rep.UniquePeptides = make(map[string]int)
@@ -278,7 +281,7 @@ func (eviProteins ProteinEvidenceList) ProteinReport(workspace, brand, decoyTag
 		}
 	}
 
-	header = "Protein\tProtein ID\tEntry Name\tGene\tLength\tOrganism\tProtein Description\tProtein Existence\tCoverage\tProtein Probability\tTop Peptide Probability\tTotal Peptides\tUnique Peptides\tRazor Peptides\tTotal Spectral Count\tUnique Spectral Count\tRazor Spectral Count\tTotal Intensity\tUnique Intensity\tRazor Intensity\tRazor Assigned Modifications\tRazor Observed Modifications\tIndistinguishable Proteins"
+	header = "Protein\tProtein ID\tEntry Name\tGene\tLength\tOrganism\tProtein Description\tProtein Existence\tCoverage\tProtein Probability\tTop Peptide Probability\tQ-Value\tPEP\tTotal Peptides\tUnique Peptides\tRazor Peptides\tTotal Spectral Count\tUnique Spectral Count\tRazor Spectral Count\tTotal Intensity\tUnique Intensity\tRazor Intensity\tRazor Assigned Modifications\tRazor Observed Modifications\tProtein Group\tProtein Subgroup\tSubsuming Protein\tIndistinguishable Proteins"
 
 	var headerIndex int
 	for i := range printSet {
@@ -498,7 +501,7 @@ func (eviProteins ProteinEvidenceList) ProteinReport(workspace, brand, decoyTag
 
 		// proteins with almost no evidences, and completely shared with decoys are eliminated from the an	alysis,
 		// in most cases proteins with one small peptide shared with a decoy
-		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%.2f\t%.4f\t%.4f\t%d\t%d\t%d\t%d\t%d\t%d\t%6.f\t%6.f\t%6.f\t%s\t%s\t%s",
+		line := fmt.Sprintf("%s\t%s\t%s\t%s\t%d\t%s\t%s\t%s\t%.2f\t%.4f\t%.4f\t%.6f\t%.6f\t%d\t%d\t%d\t%d\t%d\t%d\t%6.f\t%6.f\t%6.f\t%s\t%s\t%d\t%s\t%s\t%s",
 			i.PartHeader,             // Protein
 			i.ProteinID,              // Protein ID
 			i.EntryName,              // Entry Name
@@ -510,6 +513,8 @@ func (eviProteins ProteinEvidenceList) ProteinReport(workspace, brand, decoyTag
 			i.Coverage,               // Coverage
 			i.Probability,            // Protein Probability
 			i.TopPepProb,             // Top Peptide Probability
+			i.QValue,                 // Q-Value
+			i.PEP,                    // PEP
 			len(i.TotalPeptides),     // Total Peptides
 			len(i.UniquePeptides),    // Unique Peptides
 			len(i.URazorPeptides),    // Razor Peptides
@@ -521,6 +526,9 @@ func (eviProteins ProteinEvidenceList) ProteinReport(workspace, brand, decoyTag
 			i.URazorIntensity,        // Razor Intensity
 			strings.Join(assL, ", "), // Razor Assigned Modifications
 			strings.Join(obs, ", "),  // Razor Observed Modifications
+			i.ProteinGroup,           // Protein Group
+			i.ProteinSubGroup,        // Protein Subgroup
+			i.SubsumingProtein,       // Subsuming Protein
 			strings.Join(ip, ", "),   // Indistinguishable Proteins
 		)
 
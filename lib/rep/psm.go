@@ -60,6 +60,8 @@ func (evi *Evidence) AssemblePSMReport(pep id.PepIDList, decoyTag string) {
 		p.Massdiff = i.Massdiff
 		p.PTM = i.PTM
 		p.Probability = i.Probability
+		p.QValue = i.QValue
+		p.PEP = i.PEP
 		p.Expectation = i.Expectation
 		p.Xcorr = i.Xcorr
 		p.DeltaCN = i.DeltaCN
@@ -238,7 +240,7 @@ func (evi PSMEvidenceList) PSMReport(workspace, brand, decoyTag string, channels
 		header += "\tRTScore"
 	}
 
-	header += "\tExpectation\tHyperscore\tNextscore\tPeptideProphet Probability\tNumber of Enzymatic Termini\tNumber of Missed Cleavages\tProtein Start\tProtein End\tIntensity\tAssigned Modifications\tObserved Modifications"
+	header += "\tExpectation\tHyperscore\tNextscore\tPeptideProphet Probability\tQ-Value\tPEP\tNumber of Enzymatic Termini\tNumber of Missed Cleavages\tProtein Start\tProtein End\tIntensity\tAssigned Modifications\tObserved Modifications"
 
 	if len(modList) > 0 {
 		for _, i := range modList {
@@ -501,12 +503,14 @@ func (evi PSMEvidenceList) PSMReport(workspace, brand, decoyTag string, channels
 			)
 		}
 
-		line = fmt.Sprintf("%s\t%.14f\t%.4f\t%.4f\t%.4f\t%d\t%d\t%d\t%d\t%.4f\t%s\t%s",
+		line = fmt.Sprintf("%s\t%.14f\t%.4f\t%.4f\t%.4f\t%.6f\t%.6f\t%d\t%d\t%d\t%d\t%.4f\t%s\t%s",
 			line,
 			i.Expectation,
 			i.Hyperscore,
 			i.Nextscore,
 			i.Probability,
+			i.QValue,
+			i.PEP,
 			i.NumberOfEnzymaticTermini,
 			i.NumberOfMissedCleavages,
 			i.ProteinStart,
@@ -20,6 +20,7 @@ type Evidence struct {
 	Ions            IonEvidenceList
 	Peptides        PeptideEvidenceList
 	Proteins        ProteinEvidenceList
+	Genes           GeneEvidenceList
 	Mods            mod.Modifications
 	Modifications   ModificationEvidence
 	CombinedProtein CombinedProteinEvidenceList
@@ -140,6 +141,8 @@ type PSMEvidence struct {
 	RawMassdiff                      float64
 	Massdiff                         float64
 	Probability                      float64
+	QValue                           float64
+	PEP                              float64
 	Expectation                      float64
 	Xcorr                            float64
 	DeltaCN                          float64
@@ -215,6 +218,8 @@ type IonEvidence struct {
 	GroupWeight              float64
 	Intensity                float64
 	Probability              float64
+	QValue                   float64
+	PEP                      float64
 	Expectation              float64
 	SummedLabelIntensity     float64
 	IsUnique                 bool
@@ -259,6 +264,8 @@ type PeptideEvidence struct {
 	UnModifiedObservations int
 	Intensity              float64
 	Probability            float64
+	QValue                 float64
+	PEP                    float64
 	IsUnique               bool
 	IsURazor               bool
 	IsDecoy                bool
@@ -290,6 +297,7 @@ type ProteinEvidence struct {
 	PartHeader             string
 	ProteinName            string
 	ProteinSubGroup        string
+	SubsumingProtein       string
 	ProteinID              string
 	EntryName              string
 	Description            string
@@ -309,6 +317,8 @@ type ProteinEvidence struct {
 	URazorIntensity        float64 // Unique + razor
 	Probability            float64
 	TopPepProb             float64
+	QValue                 float64
+	PEP                    float64
 	IsDecoy                bool
 	IsContaminant          bool
 	SupportingSpectra      map[id.SpectrumType]int
@@ -333,6 +343,30 @@ func (a ProteinEvidenceList) Len() int           { return len(a) }
 func (a ProteinEvidenceList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
 func (a ProteinEvidenceList) Less(i, j int) bool { return a[i].ProteinGroup < a[j].ProteinGroup }
 
+// GeneEvidence collapses the proteins of a gene
+type GeneEvidence struct {
+	GeneName        string
+	Proteins        []string
+	TotalPeptides   int
+	UniquePeptides  int
+	TotalSpC        int
+	UniqueSpC       int
+	URazorSpC       int // Unique + razor
+	URazorIntensity float64
+	TopPepProb      float64
+	QValue          float64
+	PEP             float64
+	IsDecoy         bool
+	IsContaminant   bool
+}
+
+// GeneEvidenceList list
+type GeneEvidenceList []GeneEvidence
+
+func (a GeneEvidenceList) Len() int           { return len(a) }
+func (a GeneEvidenceList) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a GeneEvidenceList) Less(i, j int) bool { return a[i].GeneName < a[j].GeneName }
+
 // CombinedProteinEvidence represents all combined proteins detected
 type CombinedProteinEvidence struct {
 	GroupNumber            uint32
@@ -508,6 +542,13 @@ func Run(m met.Data) {
 		repoProteins.ProteinFastaReport(m.Home, m.Report.Decoys)
 	}
 
+	// Gene
+	if m.Filter.Gene && (len(m.Filter.Pox) > 0 || m.Filter.Inference) {
+		var repoGenes GeneEvidenceList
+		RestoreGene(&repoGenes)
+		repoGenes.GeneReport(m.Home, m.Report.Decoys, m.Report.Prefix, m.Report.RemoveContam)
+	}
+
 	// Modifications
 	repo := New()
 	if len(repo.Modifications.MassBins) > 0 {
@@ -528,6 +569,13 @@ func Run(m met.Data) {
 		repo.MetaMSstatsReport(m.Home, isoBrand, isoChannels, m.Report.Decoys, m.Report.Prefix)
 	}
 
+	// mzML
+	if m.Report.MzML {
+		var repoPSM PSMEvidenceList
+		RestorePSM(&repoPSM)
+		repoPSM.SpectraReport(m.Home, m.Report.Spectra, m.Version, m.Report.Decoys, m.Report.MS1, m.Report.Prefix, m.Report.RemoveContam)
+	}
+
 	// MzID
 	if m.Report.MZID {
 		repo.RestoreGranular()
@@ -0,0 +1,132 @@
+package rep
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/msg"
+	"philosopher/lib/mzn"
+	"philosopher/lib/psi"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SpectraReport creates one indexed mzML file per source with the spectra of the reported PSMs,
+// annotated with their identifications, and optionally with their MS1 parent spectra
+func (evi PSMEvidenceList) SpectraReport(workspace, spectraDir, version string, hasDecoys, hasMS1, hasPrefix, removeContam bool) {
+
+	if len(spectraDir) == 0 {
+		spectraDir = "."
+	}
+
+	// the reported PSMs indexed by source and scan number
+	var sources = make(map[string]map[string][]*PSMEvidence)
+
+	for i := range evi {
+
+		if removeContam && (strings.HasPrefix(evi[i].Protein, "contam_") || strings.HasPrefix(evi[i].Protein, "Cont_")) {
+			continue
+		}
+
+		if !hasDecoys && evi[i].IsDecoy {
+			continue
+		}
+
+		split := strings.Split(evi[i].Spectrum, ".")
+		if len(split) < 2 {
+			continue
+		}
+
+		sn, e := strconv.Atoi(split[1])
+		if e != nil {
+			continue
+		}
+
+		if _, ok := sources[split[0]]; !ok {
+			sources[split[0]] = make(map[string][]*PSMEvidence)
+		}
+
+		scan := strconv.Itoa(sn)
+		sources[split[0]][scan] = append(sources[split[0]][scan], &evi[i])
+	}
+
+	var sourceList []string
+	for i := range sources {
+		sourceList = append(sourceList, i)
+	}
+
+	sort.Strings(sourceList)
+
+	for _, s := range sourceList {
+
+		input := fmt.Sprintf("%s%s%s.mzML", spectraDir, string(filepath.Separator), s)
+		if _, e := os.Stat(input); e != nil {
+			msg.InputNotFound(errors.New("cannot find the spectra file "+input), "warning")
+			continue
+		}
+
+		var output string
+		if hasPrefix {
+			output = fmt.Sprintf("%s%s%s_%s_psm.mzML", workspace, string(filepath.Separator), path.Base(workspace), s)
+		} else {
+			output = fmt.Sprintf("%s%s%s_psm.mzML", workspace, string(filepath.Separator), s)
+		}
+
+		idx := mzn.NewIndex(input)
+
+		var scans []string
+		for scan := range sources[s] {
+
+			scans = append(scans, scan)
+
+			if hasMS1 {
+				spec, ok := idx.Scan(scan)
+				if ok && len(spec.Precursor.ParentScan) > 0 {
+					scans = append(scans, spec.Precursor.ParentScan)
+				}
+			}
+		}
+
+		w := mzn.NewMzMLWriter(output, input, version, "64", "32", true, false)
+
+		idx.Walker(scans)(func(spec mzn.Spectrum) {
+
+			var params []psi.UserParam
+			for _, i := range sources[s][spec.Scan] {
+				params = append(params, spectrumParams(i)...)
+			}
+
+			w.Write(spec, params...)
+		})
+
+		w.Close()
+		idx.Close()
+
+		logrus.Info("Wrote ", w.Len(), " spectra to ", filepath.Base(output))
+	}
+
+}
+
+// spectrumParams describes the identification of a spectrum
+func spectrumParams(p *PSMEvidence) []psi.UserParam {
+
+	var params []psi.UserParam
+
+	params = append(params, psi.UserParam{Name: "peptide", Value: p.Peptide, Type: "xsd:string"})
+
+	if len(p.ModifiedPeptide) > 0 {
+		params = append(params, psi.UserParam{Name: "modified peptide", Value: p.ModifiedPeptide, Type: "xsd:string"})
+	}
+
+	params = append(params, psi.UserParam{Name: "charge", Value: strconv.Itoa(int(p.AssumedCharge)), Type: "xsd:int"})
+	params = append(params, psi.UserParam{Name: "probability", Value: strconv.FormatFloat(p.Probability, 'f', -1, 64), Type: "xsd:double"})
+	params = append(params, psi.UserParam{Name: "protein", Value: p.Protein, Type: "xsd:string"})
+
+	return params
+}
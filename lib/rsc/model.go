@@ -0,0 +1,195 @@
+package rsc
+
+import (
+	"math"
+	"math/rand"
+)
+
+// ridge keeps the pooled covariance invertible when the features are correlated
+const ridge = 1e-3
+
+// trainLDA finds the Fisher linear discriminant between the positive and the negative examples,
+// with the bias placing the boundary halfway between the class means
+func trainLDA(x [][]float64, positive []bool) ([]float64, float64) {
+
+	dimension := len(x[0])
+
+	var means = [2][]float64{make([]float64, dimension), make([]float64, dimension)}
+	var counts [2]float64
+
+	for i := range x {
+		c := class(positive[i])
+		counts[c]++
+		for j := range x[i] {
+			means[c][j] += x[i][j]
+		}
+	}
+
+	for c := range means {
+		for j := range means[c] {
+			means[c][j] /= math.Max(counts[c], 1)
+		}
+	}
+
+	var covariance = make([][]float64, dimension)
+	for i := range covariance {
+		covariance[i] = make([]float64, dimension)
+	}
+
+	for i := range x {
+		c := class(positive[i])
+		for j := 0; j < dimension; j++ {
+			for k := 0; k < dimension; k++ {
+				covariance[j][k] += (x[i][j] - means[c][j]) * (x[i][k] - means[c][k])
+			}
+		}
+	}
+
+	var difference = make([]float64, dimension)
+	for j := 0; j < dimension; j++ {
+		for k := 0; k < dimension; k++ {
+			covariance[j][k] /= math.Max(counts[0]+counts[1]-2, 1)
+		}
+		covariance[j][j] += ridge
+		difference[j] = means[1][j] - means[0][j]
+	}
+
+	w := solve(covariance, difference)
+
+	var b float64
+	for j := range w {
+		b -= w[j] * (means[0][j] + means[1][j]) / 2
+	}
+
+	return w, b
+}
+
+// trainSVM fits a linear support vector machine with the L1 loss by dual coordinate descent. The
+// cost of the negative examples is scaled so both classes carry the same weight
+func trainSVM(x [][]float64, positive []bool) ([]float64, float64) {
+
+	const cost = 1.0
+	const epochs = 200
+	const tolerance = 0.01
+
+	dimension := len(x[0])
+
+	var counts [2]float64
+	for i := range positive {
+		counts[class(positive[i])]++
+	}
+
+	var bound = [2]float64{cost * counts[1] / math.Max(counts[0], 1), cost}
+
+	// the bias is learned as the weight of a constant feature
+	var w = make([]float64, dimension+1)
+	var alpha = make([]float64, len(x))
+	var norms = make([]float64, len(x))
+
+	for i := range x {
+		norms[i] = dot(x[i], x[i]) + 1
+	}
+
+	random := rand.New(rand.NewSource(1))
+
+	for epoch := 0; epoch < epochs; epoch++ {
+
+		var violation float64
+
+		for _, i := range random.Perm(len(x)) {
+
+			y := -1.0
+			if positive[i] {
+				y = 1
+			}
+
+			upper := bound[class(positive[i])]
+			gradient := y*(dot(w[:dimension], x[i])+w[dimension]) - 1
+
+			projected := gradient
+			if alpha[i] == 0 {
+				projected = math.Min(gradient, 0)
+			} else if alpha[i] == upper {
+				projected = math.Max(gradient, 0)
+			}
+
+			violation = math.Max(violation, math.Abs(projected))
+
+			if projected == 0 {
+				continue
+			}
+
+			old := alpha[i]
+			alpha[i] = math.Min(math.Max(alpha[i]-gradient/norms[i], 0), upper)
+
+			step := (alpha[i] - old) * y
+			for j := 0; j < dimension; j++ {
+				w[j] += step * x[i][j]
+			}
+			w[dimension] += step
+		}
+
+		if violation < tolerance {
+			break
+		}
+	}
+
+	return w[:dimension], w[dimension]
+}
+
+// solve returns the solution of the linear system by Gaussian elimination with partial pivoting
+func solve(a [][]float64, b []float64) []float64 {
+
+	n := len(b)
+
+	var m = make([][]float64, n)
+	for i := range a {
+		m[i] = append(append([]float64{}, a[i]...), b[i])
+	}
+
+	for i := 0; i < n; i++ {
+
+		pivot := i
+		for j := i + 1; j < n; j++ {
+			if math.Abs(m[j][i]) > math.Abs(m[pivot][i]) {
+				pivot = j
+			}
+		}
+		m[i], m[pivot] = m[pivot], m[i]
+
+		if math.Abs(m[i][i]) < 1e-12 {
+			continue
+		}
+
+		for j := i + 1; j < n; j++ {
+			factor := m[j][i] / m[i][i]
+			for k := i; k <= n; k++ {
+				m[j][k] -= factor * m[i][k]
+			}
+		}
+	}
+
+	var x = make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+
+		if math.Abs(m[i][i]) < 1e-12 {
+			continue
+		}
+
+		v := m[i][n]
+		for j := i + 1; j < n; j++ {
+			v -= m[i][j] * x[j]
+		}
+		x[i] = v / m[i][i]
+	}
+
+	return x
+}
+
+// class is the index of the positive and negative examples
+func class(positive bool) int {
+	if positive {
+		return 1
+	}
+	return 0
+}
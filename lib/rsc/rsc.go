@@ -0,0 +1,626 @@
+// Package rsc is a semi-supervised PSM rescoring engine, it learns a linear discriminant between
+// targets and decoys from the search engine scores on cross validation folds
+package rsc
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"philosopher/lib/cla"
+	"philosopher/lib/id"
+	"philosopher/lib/met"
+	"philosopher/lib/msg"
+	"philosopher/lib/sys"
+
+	"github.com/sirupsen/logrus"
+)
+
+// feature is a score taken from each PSM
+type feature struct {
+	Name  string
+	Value func(p *id.PeptideIdentification) float64
+}
+
+// model trains a linear discriminant on the positive and negative examples and returns the
+// weights and the bias
+type model func(x [][]float64, positive []bool) ([]float64, float64)
+
+var models = map[string]model{
+	"lda": trainLDA,
+	"svm": trainSVM,
+}
+
+// Run is the rescoring main entry point, it rescores the PSMs from the identification files and
+// keeps them in the workspace for the filter command
+func Run(m met.Data, args []string) met.Data {
+
+	if len(args) == 0 {
+		msg.NoParametersFound(errors.New("missing identification files"), "error")
+	}
+
+	// get the database tag from database command
+	if len(m.Rescore.Tag) == 0 {
+		m.Rescore.Tag = m.Database.Tag
+	}
+
+	if len(m.Rescore.Model) == 0 {
+		m.Rescore.Model = "lda"
+	}
+	m.Rescore.Model = strings.ToLower(m.Rescore.Model)
+
+	if _, ok := models[m.Rescore.Model]; !ok {
+		msg.Custom(fmt.Errorf("unknown rescoring model %s, use lda or svm", m.Rescore.Model), "error")
+	}
+
+	if m.Rescore.Folds < 2 {
+		m.Rescore.Folds = 3
+	}
+
+	if m.Rescore.MaxIter < 1 {
+		m.Rescore.MaxIter = 10
+	}
+
+	if m.Rescore.TrainFDR <= 0 {
+		m.Rescore.TrainFDR = 0.01
+	}
+
+	m.Rescore.InputFiles = args
+
+	var files []string
+	for _, i := range args {
+		files = append(files, id.ListInputFiles(i)...)
+	}
+
+	psms := id.ReadInputFiles(files, m.Rescore.Tag, m.Temp, false, m.Rescore.SearchScore)
+
+	Score(psms.PeptideIdentification, m.Rescore)
+
+	sys.Serialize(&psms, sys.RescoreBin())
+
+	return m
+}
+
+// Score trains the discriminant on cross validation folds and assigns the discriminant score, the
+// q-value and the posterior error probability of each PSM. The probability is 1 - PEP so the PSMs
+// can be filtered like the PeptideProphet results
+func Score(psms id.PepIDListPtrs, params met.Rescore) {
+
+	train, ok := models[params.Model]
+	if !ok {
+		msg.Custom(fmt.Errorf("unknown rescoring model %s, use lda or svm", params.Model), "error")
+		return
+	}
+
+	var decoy = make([]bool, len(psms))
+	var decoys int
+	for i, p := range psms {
+		decoy[i] = cla.IsDecoyPSM(*p, params.Tag)
+		if decoy[i] {
+			decoys++
+		}
+	}
+
+	if decoys == 0 || decoys == len(psms) {
+		msg.Custom(errors.New("the rescoring needs both target and decoy PSMs, check the decoy tag"), "error")
+		return
+	}
+
+	features, x := featureMatrix(psms, len(params.SearchScore) > 0)
+	if len(features) == 0 {
+		msg.Custom(errors.New("the PSMs have no scores to learn from"), "error")
+		return
+	}
+
+	var names []string
+	for _, i := range features {
+		names = append(names, i.Name)
+	}
+	logrus.Info("Rescoring ", len(psms), " PSMs with ", strings.ToUpper(params.Model), " on ", strings.Join(names, ", "))
+
+	folds := params.Folds
+	if folds < 2 {
+		folds = 3
+	}
+
+	fold := spectrumFolds(psms, folds)
+
+	// every training set needs targets and decoys, otherwise a single model is trained and
+	// applied on all PSMs
+	for f := 0; f < folds; f++ {
+
+		var targets, decoys int
+		for i := range psms {
+			if fold[i] != f && decoy[i] {
+				decoys++
+			} else if fold[i] != f {
+				targets++
+			}
+		}
+
+		if targets == 0 || decoys == 0 {
+			msg.Custom(fmt.Errorf("the training set of fold %d has %d targets and %d decoys, a single model is trained on all PSMs without cross validation", f+1, targets, decoys), "warning")
+			folds = 1
+			break
+		}
+	}
+
+	var scores = make([]float64, len(psms))
+
+	for f := 0; f < folds; f++ {
+
+		training := func(i int) bool { return folds == 1 || fold[i] != f }
+
+		// the features are standardized with the mean and deviation of the training set only
+		var rows []int
+		for i := range psms {
+			if training(i) {
+				rows = append(rows, i)
+			}
+		}
+		mean, sd := standardization(x, rows)
+
+		var trainX [][]float64
+		var trainDecoy []bool
+		for _, i := range rows {
+			trainX = append(trainX, standardize(x[i], mean, sd))
+			trainDecoy = append(trainDecoy, decoy[i])
+		}
+
+		w, b := iterate(train, trainX, trainDecoy, params.TrainFDR, params.MaxIter)
+
+		// the scores of each fold are put on the same scale before merging them
+		threshold, median := scale(project(trainX, w, b), trainDecoy, params.TrainFDR)
+
+		for i := range psms {
+			if folds == 1 || fold[i] == f {
+				scores[i] = (dot(w, standardize(x[i], mean, sd)) + b - threshold) / (threshold - median)
+			}
+		}
+	}
+
+	qvalues := QValues(scores, decoy)
+	peps := PEPs(scores, decoy)
+
+	var accepted int
+	for i, p := range psms {
+		p.DiscriminantScore = scores[i]
+		p.QValue = qvalues[i]
+		p.PEP = peps[i]
+		p.Probability = 1 - peps[i]
+		p.Rescored = true
+		if !decoy[i] && qvalues[i] <= params.TrainFDR {
+			accepted++
+		}
+	}
+
+	logrus.Info(accepted, " target PSMs at ", params.TrainFDR, " q-value after rescoring")
+}
+
+// featureMatrix collects the scores with some variance across the PSMs. The columns are not
+// standardized here, each training set is standardized on its own
+func featureMatrix(psms id.PepIDListPtrs, searchScore bool) ([]feature, [][]float64) {
+
+	candidates := []feature{
+		{"hyperscore", func(p *id.PeptideIdentification) float64 { return p.Hyperscore }},
+		{"nextscore", func(p *id.PeptideIdentification) float64 { return p.Nextscore }},
+		{"xcorr", func(p *id.PeptideIdentification) float64 { return p.Xcorr }},
+		{"deltaCn", func(p *id.PeptideIdentification) float64 { return p.DeltaCN }},
+		{"log10Expect", func(p *id.PeptideIdentification) float64 {
+			if p.Expectation <= 0 {
+				return 0
+			}
+			return -math.Log10(p.Expectation)
+		}},
+		{"absMassdiff", func(p *id.PeptideIdentification) float64 { return math.Abs(p.Massdiff) }},
+		{"enzN", func(p *id.PeptideIdentification) float64 { return float64(p.NumberOfEnzymaticTermini) }},
+		{"missedCleavages", func(p *id.PeptideIdentification) float64 { return float64(p.NumberofMissedCleavages) }},
+		{"spectralSim", func(p *id.PeptideIdentification) float64 { return p.SpectralSim }},
+		{"rtScore", func(p *id.PeptideIdentification) float64 { return p.Rtscore }},
+		{"ionMobility", func(p *id.PeptideIdentification) float64 { return p.IonMobility }},
+	}
+
+	if searchScore {
+		candidates = append(candidates, feature{"searchScore", func(p *id.PeptideIdentification) float64 { return p.SearchScore }})
+	}
+
+	var features []feature
+	var columns [][]float64
+
+	for _, i := range candidates {
+
+		var column = make([]float64, len(psms))
+		var mean, sd float64
+
+		for j, p := range psms {
+			column[j] = i.Value(p)
+			if math.IsNaN(column[j]) || math.IsInf(column[j], 0) {
+				column[j] = 0
+			}
+			mean += column[j]
+		}
+		mean /= float64(len(psms))
+
+		for _, j := range column {
+			sd += (j - mean) * (j - mean)
+		}
+		sd = math.Sqrt(sd / float64(len(psms)))
+
+		if sd < 1e-9 {
+			continue
+		}
+
+		features = append(features, i)
+		columns = append(columns, column)
+	}
+
+	var x = make([][]float64, len(psms))
+	for i := range psms {
+		x[i] = make([]float64, len(columns))
+		for j := range columns {
+			x[i][j] = columns[j][i]
+		}
+	}
+
+	return features, x
+}
+
+// spectrumFolds splits the PSMs in cross validation folds by spectrum, so the PSMs of the same
+// spectrum with different charges or from different searches are never on both the training and
+// the test sets. The spectra are assigned at random, with a fixed seed so the results are
+// reproducible
+func spectrumFolds(psms id.PepIDListPtrs, folds int) []int {
+
+	var spectra = make(map[string]int)
+	var names []string
+
+	for _, p := range psms {
+		name := spectrumName(p.Spectrum)
+		if _, ok := spectra[name]; !ok {
+			spectra[name] = 0
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	for i, j := range rand.New(rand.NewSource(1)).Perm(len(names)) {
+		spectra[names[j]] = i % folds
+	}
+
+	var fold = make([]int, len(psms))
+	for i, p := range psms {
+		fold[i] = spectra[spectrumName(p.Spectrum)]
+	}
+
+	return fold
+}
+
+// spectrumName removes the charge from the spectrum name, like run.00100.00100.2
+func spectrumName(s string) string {
+
+	if i := strings.LastIndex(s, "."); i > 0 {
+		return s[:i]
+	}
+
+	return s
+}
+
+// standardization returns the mean and the standard deviation of the feature columns on the rows,
+// the constant columns get a unit deviation
+func standardization(x [][]float64, rows []int) ([]float64, []float64) {
+
+	if len(x) == 0 || len(rows) == 0 {
+		return nil, nil
+	}
+
+	var mean = make([]float64, len(x[0]))
+	var sd = make([]float64, len(x[0]))
+
+	for _, i := range rows {
+		for j, v := range x[i] {
+			mean[j] += v
+		}
+	}
+
+	for j := range mean {
+		mean[j] /= float64(len(rows))
+	}
+
+	for _, i := range rows {
+		for j, v := range x[i] {
+			sd[j] += (v - mean[j]) * (v - mean[j])
+		}
+	}
+
+	for j := range sd {
+		sd[j] = math.Sqrt(sd[j] / float64(len(rows)))
+		if sd[j] < 1e-9 {
+			sd[j] = 1
+		}
+	}
+
+	return mean, sd
+}
+
+// standardize centers and scales the features of a PSM
+func standardize(v, mean, sd []float64) []float64 {
+
+	var z = make([]float64, len(v))
+	for i := range v {
+		z[i] = (v[i] - mean[i]) / sd[i]
+	}
+
+	return z
+}
+
+// iterate starts from the single feature that accepts the most targets, and retrains the model on
+// the confident targets and on all decoys until the number of iterations is reached
+func iterate(train model, x [][]float64, decoy []bool, fdr float64, iterations int) ([]float64, float64) {
+
+	if len(x) == 0 {
+		return nil, 0
+	}
+
+	dimension := len(x[0])
+
+	var w = make([]float64, dimension)
+	var b float64
+	var best = -1
+
+	for j := 0; j < dimension; j++ {
+		for _, sign := range []float64{1, -1} {
+
+			var v = make([]float64, dimension)
+			v[j] = sign
+
+			if n := accepted(project(x, v, 0), decoy, fdr); n > best {
+				best = n
+				w = v
+			}
+		}
+	}
+
+	for i := 0; i < iterations; i++ {
+
+		qvalues := QValues(project(x, w, b), decoy)
+
+		var positive []bool
+		var examples [][]float64
+		var positives int
+
+		for j := range x {
+			if decoy[j] {
+				examples = append(examples, x[j])
+				positive = append(positive, false)
+			} else if qvalues[j] <= fdr {
+				examples = append(examples, x[j])
+				positive = append(positive, true)
+				positives++
+			}
+		}
+
+		if positives == 0 {
+			break
+		}
+
+		w, b = train(examples, positive)
+	}
+
+	return w, b
+}
+
+// scale returns the score at the FDR threshold and the median decoy score. Without decoys the
+// scores are left as they are
+func scale(scores []float64, decoy []bool, fdr float64) (float64, float64) {
+
+	qvalues := QValues(scores, decoy)
+
+	var threshold = math.Inf(1)
+	var decoys []float64
+
+	for i := range scores {
+		if decoy[i] {
+			decoys = append(decoys, scores[i])
+		} else if qvalues[i] <= fdr && scores[i] < threshold {
+			threshold = scores[i]
+		}
+	}
+
+	if len(decoys) == 0 {
+		return 1, 0
+	}
+
+	sort.Float64s(decoys)
+	median := decoys[len(decoys)/2]
+
+	// without confident targets the scores are centered on the decoys
+	if math.IsInf(threshold, 1) || threshold-median <= 0 {
+		return median + 1, median
+	}
+
+	return threshold, median
+}
+
+// accepted counts the targets at the FDR threshold
+func accepted(scores []float64, decoy []bool, fdr float64) int {
+
+	var n int
+	for i, j := range QValues(scores, decoy) {
+		if !decoy[i] && j <= fdr {
+			n++
+		}
+	}
+
+	return n
+}
+
+// QValues calculates the target-decoy q-values of a list of scores where higher is better. The FDR
+// at each score is (decoys + 1) / targets, and the q-value is the lowest FDR at which the score is
+// accepted. Tied scores share the same q-value
+func QValues(scores []float64, decoy []bool) []float64 {
+
+	order := descending(scores)
+
+	var fdr = make([]float64, len(scores))
+	var targets, decoys float64
+
+	for i := 0; i < len(order); {
+
+		j := i
+		for j < len(order) && scores[order[j]] == scores[order[i]] {
+			if decoy[order[j]] {
+				decoys++
+			} else {
+				targets++
+			}
+			j++
+		}
+
+		v := 1.0
+		if targets > 0 {
+			v = math.Min((decoys+1)/targets, 1)
+		}
+
+		for k := i; k < j; k++ {
+			fdr[order[k]] = v
+		}
+
+		i = j
+	}
+
+	var qvalues = make([]float64, len(scores))
+	var lowest = 1.0
+
+	for i := len(order) - 1; i >= 0; i-- {
+		lowest = math.Min(lowest, fdr[order[i]])
+		qvalues[order[i]] = lowest
+	}
+
+	return qvalues
+}
+
+// PEPs estimates the posterior error probabilities of a list of scores where higher is better. The
+// fraction of decoys is fitted as a non-increasing function of the score with isotonic regression,
+// and the incorrect targets are assumed to be as many as the decoys at each score
+func PEPs(scores []float64, decoy []bool) []float64 {
+
+	order := descending(scores)
+
+	// the tied scores are pooled before the fit
+	var values, weights []float64
+	var groups [][]int
+
+	for i := 0; i < len(order); {
+
+		var group []int
+		var decoys float64
+
+		j := i
+		for j < len(order) && scores[order[j]] == scores[order[i]] {
+			if decoy[order[j]] {
+				decoys++
+			}
+			group = append(group, order[j])
+			j++
+		}
+
+		values = append(values, decoys/float64(len(group)))
+		weights = append(weights, float64(len(group)))
+		groups = append(groups, group)
+
+		i = j
+	}
+
+	fitted := isotonic(values, weights)
+
+	var peps = make([]float64, len(scores))
+	for i, group := range groups {
+
+		pep := 1.0
+		if fitted[i] < 1 {
+			pep = math.Min(fitted[i]/(1-fitted[i]), 1)
+		}
+
+		for _, j := range group {
+			peps[j] = pep
+		}
+	}
+
+	return peps
+}
+
+// isotonic fits a non-decreasing sequence to the weighted values with the pool adjacent violators
+// algorithm
+func isotonic(values, weights []float64) []float64 {
+
+	type block struct {
+		value  float64
+		weight float64
+		size   int
+	}
+
+	var blocks []block
+
+	for i := range values {
+
+		blocks = append(blocks, block{values[i], weights[i], 1})
+
+		for len(blocks) > 1 && blocks[len(blocks)-2].value > blocks[len(blocks)-1].value {
+
+			last := blocks[len(blocks)-1]
+			prev := blocks[len(blocks)-2]
+
+			weight := prev.weight + last.weight
+			merged := block{(prev.value*prev.weight + last.value*last.weight) / weight, weight, prev.size + last.size}
+
+			blocks = append(blocks[:len(blocks)-2], merged)
+		}
+	}
+
+	var fitted []float64
+	for _, i := range blocks {
+		for j := 0; j < i.size; j++ {
+			fitted = append(fitted, i.value)
+		}
+	}
+
+	return fitted
+}
+
+// descending returns the indexes of the scores from the highest to the lowest
+func descending(scores []float64) []int {
+
+	var order = make([]int, len(scores))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return scores[order[i]] > scores[order[j]]
+	})
+
+	return order
+}
+
+func project(x [][]float64, w []float64, b float64) []float64 {
+
+	var scores = make([]float64, len(x))
+	for i := range x {
+		scores[i] = dot(w, x[i]) + b
+	}
+
+	return scores
+}
+
+func dot(a, b []float64) float64 {
+
+	var v float64
+	for i := range a {
+		v += a[i] * b[i]
+	}
+
+	return v
+}
@@ -0,0 +1,147 @@
+package rsc
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"philosopher/lib/id"
+	"philosopher/lib/met"
+)
+
+// testPSMs simulates a search where half of the targets are correct, with higher hyperscores and
+// smaller mass errors than the incorrect targets and the decoys
+func testPSMs() id.PepIDListPtrs {
+
+	random := rand.New(rand.NewSource(7))
+
+	var psms id.PepIDListPtrs
+	for i := 0; i < 1500; i++ {
+
+		p := &id.PeptideIdentification{Spectrum: fmt.Sprintf("run.%05d.%05d.2", i, i), Protein: "P1"}
+
+		switch i % 3 {
+		case 0:
+			p.Hyperscore = 30 + random.NormFloat64()*3
+			p.Massdiff = random.NormFloat64() * 0.002
+		case 1:
+			p.Hyperscore = 15 + random.NormFloat64()*3
+			p.Massdiff = random.NormFloat64() * 0.02
+		case 2:
+			p.Hyperscore = 15 + random.NormFloat64()*3
+			p.Massdiff = random.NormFloat64() * 0.02
+			p.Protein = "rev_P1"
+		}
+
+		p.Nextscore = 12 + random.NormFloat64()*2
+		p.Expectation = 0.5
+
+		psms = append(psms, p)
+	}
+
+	return psms
+}
+
+func TestScore(t *testing.T) {
+
+	for _, model := range []string{"lda", "svm"} {
+
+		psms := testPSMs()
+		Score(psms, met.Rescore{Model: model, Tag: "rev_", TrainFDR: 0.01, Folds: 3, MaxIter: 10})
+
+		var correct, incorrect int
+		for i, p := range psms {
+			if p.QValue <= 0.01 {
+				if i%3 == 0 {
+					correct++
+				} else {
+					incorrect++
+				}
+			}
+			if p.Probability < 0 || p.Probability > 1 {
+				t.Errorf("%s: got probability %f for %s", model, p.Probability, p.Spectrum)
+			}
+		}
+
+		if correct < 450 || incorrect > 10 {
+			t.Errorf("%s: got %d correct and %d incorrect PSMs at 1%% FDR, want at least 450 and at most 10", model, correct, incorrect)
+		}
+
+		if psms[0].Probability < 0.9 || psms[2].Probability > 0.5 {
+			t.Errorf("%s: got probabilities %f and %f, want a confident target and an unlikely decoy", model, psms[0].Probability, psms[2].Probability)
+		}
+	}
+}
+
+func TestQValuesAndPEPs(t *testing.T) {
+
+	scores := []float64{10, 9, 8, 8, 7, 6, 5, 4}
+	decoy := []bool{false, false, true, false, false, true, true, false}
+
+	qvalues := QValues(scores, decoy)
+	want := []float64{0.5, 0.5, 0.5, 0.5, 0.5, 0.75, 0.8, 0.8}
+
+	for i := range want {
+		if qvalues[i] != want[i] {
+			t.Errorf("got q-value %f for score %.0f, want %f", qvalues[i], scores[i], want[i])
+		}
+	}
+
+	peps := PEPs(scores, decoy)
+	for i := 1; i < len(peps); i++ {
+		if peps[i] < peps[i-1] {
+			t.Errorf("got PEP %f after %f, want the PEPs to grow as the scores drop", peps[i], peps[i-1])
+		}
+	}
+
+	if peps[2] != peps[3] {
+		t.Errorf("got PEPs %f and %f for tied scores", peps[2], peps[3])
+	}
+}
+
+func TestSpectrumFolds(t *testing.T) {
+
+	psms := testPSMs()
+
+	// the same spectra searched again with a different charge
+	for i := 0; i < 300; i++ {
+		psms = append(psms, &id.PeptideIdentification{Spectrum: fmt.Sprintf("run.%05d.%05d.3", i, i)})
+	}
+
+	fold := spectrumFolds(psms, 3)
+
+	var sizes = make([]int, 3)
+	for i := 0; i < 1500; i++ {
+		sizes[fold[i]]++
+	}
+
+	for i := 0; i < 300; i++ {
+		if fold[1500+i] != fold[i] {
+			t.Errorf("got folds %d and %d for the charges of %s", fold[i], fold[1500+i], psms[i].Spectrum)
+		}
+	}
+
+	for i := range sizes {
+		if sizes[i] != 500 {
+			t.Errorf("got %d spectra on fold %d, want 500", sizes[i], i)
+		}
+	}
+}
+
+func TestScoreFoldWithoutDecoys(t *testing.T) {
+
+	// a single decoy leaves the training set of its fold without decoys
+	psms := testPSMs()[:10]
+	for _, p := range psms {
+		p.Protein = "P1"
+	}
+	psms[2].Protein = "rev_P1"
+
+	Score(psms, met.Rescore{Model: "lda", Tag: "rev_", TrainFDR: 0.01, Folds: 3, MaxIter: 10})
+
+	for _, p := range psms {
+		if p.QValue < 0 || p.QValue > 1 || p.Probability < 0 || p.Probability > 1 {
+			t.Errorf("got q-value %f and probability %f for %s", p.QValue, p.Probability, p.Spectrum)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+// Package rwi (RawInfo) reports the metadata, scan events and chromatograms of Thermo raw files
+package rwi
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"philosopher/lib/fin"
+	"philosopher/lib/met"
+	"philosopher/lib/msg"
+	"philosopher/lib/sys"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Info is the summary of a raw file
+type Info struct {
+	File            string         `json:"file"`
+	Manufacturer    string         `json:"manufacturer"`
+	Model           string         `json:"model"`
+	SoftwareName    string         `json:"softwareName"`
+	SoftwareVersion string         `json:"softwareVersion"`
+	Ionization      []string       `json:"ionization"`
+	Analyzer        []string       `json:"analyzer"`
+	Detector        []string       `json:"detector"`
+	StartTime       float64        `json:"startTime"`
+	EndTime         float64        `json:"endTime"`
+	Scans           int            `json:"scans"`
+	ScansPerLevel   map[string]int `json:"scansPerLevel"`
+	Events          []Event        `json:"scanEvents"`
+	Traces          []int          `json:"chromatographyTraces"`
+}
+
+// Event groups the scans acquired with the same settings. The activation type comes from the
+// scan filter or the scan trailer, and it is empty when the instrument records neither
+type Event struct {
+	MSLevel         int     `json:"msLevel"`
+	Analyzer        string  `json:"analyzer"`
+	Polarity        string  `json:"polarity"`
+	ScanType        string  `json:"scanType"`
+	Mode            string  `json:"mode"`
+	Activation      string  `json:"activation"`
+	CollisionEnergy float64 `json:"collisionEnergy"`
+	Scans           int     `json:"scans"`
+}
+
+// Run reports every raw file given, or found inside the given folders
+func Run(m met.Data, args []string) met.Data {
+
+	if len(m.RawInfo.Output) == 0 {
+		m.RawInfo.Output = "."
+	}
+
+	for _, f := range rawFiles(args) {
+
+		info := Read(f, m.RawInfo.Output)
+
+		logrus.WithFields(logrus.Fields{
+			"model":      info.Model,
+			"software":   strings.TrimSpace(info.SoftwareName + " " + info.SoftwareVersion),
+			"ionization": strings.Join(info.Ionization, ","),
+			"analyzer":   strings.Join(info.Analyzer, ","),
+			"detector":   strings.Join(info.Detector, ","),
+			"scans":      info.Scans,
+			"time":       fmt.Sprintf("%.2f-%.2f", info.StartTime, info.EndTime),
+		}).Info(filepath.Base(f))
+
+		for _, i := range info.Events {
+			logrus.WithFields(logrus.Fields{
+				"level":      i.MSLevel,
+				"analyzer":   i.Analyzer,
+				"type":       i.ScanType,
+				"activation": i.Activation,
+				"energy":     i.CollisionEnergy,
+				"scans":      i.Scans,
+			}).Info("Scan event")
+		}
+
+		if m.RawInfo.JSON {
+			info.WriteJSON(outputName(m.RawInfo.Output, f, ".json"))
+		}
+	}
+
+	return m
+}
+
+// Read collects the metadata and the scan events of a raw file. The scan table, the TIC and
+// base peak chromatograms, and the traces of the chromatography controllers are written to the
+// output folder
+func Read(f, output string) Info {
+
+	var rd fin.RawData
+	rd.ProcessRaw(f)
+	defer rd.Close()
+
+	info := Info{
+		File:            filepath.Base(f),
+		Manufacturer:    rd.Manufacturer,
+		Model:           rd.Model,
+		SoftwareName:    rd.SoftwareName,
+		SoftwareVersion: rd.SoftwareVersion,
+		Ionization:      rd.Ionization,
+		Analyzer:        rd.Analyzer,
+		Detector:        rd.Detector,
+		StartTime:       rd.StartTime,
+		EndTime:         rd.EndTime,
+		Scans:           rd.NScans(),
+		ScansPerLevel:   make(map[string]int),
+	}
+
+	scans := newTSV(outputName(output, f, "_scans.tsv"))
+	scans.line("Scan", "Retention Time", "MS Level", "Analyzer", "Polarity", "Scan Type", "Mode", "Precursor m/z", "Isolation Width", "Activation", "Collision Energy", "Low m/z", "High m/z")
+
+	chrom := newTSV(outputName(output, f, "_chromatogram.tsv"))
+	chrom.line("Scan", "Retention Time", "TIC", "Base Peak m/z", "Base Peak Intensity")
+
+	var events = make(map[Event]int)
+
+	for sn := 1; sn <= rd.NScans(); sn++ {
+
+		scan := rd.Scan(sn)
+		if scan.MSLevel < 1 {
+			continue
+		}
+
+		// the last reaction describes the ion isolated for this scan
+		var precursor, width, energy float64
+		if reactions := rd.Scanevents[sn-1].Reaction; len(reactions) > 0 {
+			r := reactions[len(reactions)-1]
+			precursor, width, energy = r.Precursormz, r.IsolationWidth, r.Energy
+		}
+
+		e := Event{
+			MSLevel:         int(scan.MSLevel),
+			Analyzer:        scan.Analyzer.String(),
+			Polarity:        string(scan.Polarity),
+			ScanType:        string(scan.Type),
+			Mode:            string(scan.Mode),
+			Activation:      rd.Activation(sn),
+			CollisionEnergy: energy,
+		}
+		events[e]++
+		info.ScansPerLevel[strconv.Itoa(e.MSLevel)]++
+
+		scans.line(strconv.Itoa(sn), formatFloat(scan.Time), strconv.Itoa(e.MSLevel), e.Analyzer, e.Polarity, e.ScanType, e.Mode,
+			formatFloat(precursor), formatFloat(width), e.Activation, formatFloat(energy), formatFloat(scan.LowMz), formatFloat(scan.HighMz))
+
+		if scan.MSLevel == 1 {
+			chrom.line(strconv.Itoa(sn), formatFloat(scan.Time), formatFloat(scan.TotalCurrent), formatFloat(scan.BaseMz), formatFloat(scan.BaseIntensity))
+		}
+	}
+
+	scans.close()
+	chrom.close()
+
+	// the other controllers, like pumps or UV detectors, record their own traces
+	for i := 0; i < rd.Controllers; i++ {
+
+		trace := rd.Chromatography(i)
+		if len(trace) == 0 {
+			continue
+		}
+
+		t := newTSV(outputName(output, f, fmt.Sprintf("_trace%d.tsv", i)))
+		t.line("Retention Time", "Value")
+		for _, j := range trace {
+			t.line(formatFloat(j.Time), formatFloat(j.Value))
+		}
+		t.close()
+
+		info.Traces = append(info.Traces, i)
+	}
+
+	for k, v := range events {
+		k.Scans = v
+		info.Events = append(info.Events, k)
+	}
+
+	sort.Slice(info.Events, func(i, j int) bool {
+		a, b := info.Events[i], info.Events[j]
+		if a.MSLevel != b.MSLevel {
+			return a.MSLevel < b.MSLevel
+		}
+		if a.Analyzer != b.Analyzer {
+			return a.Analyzer < b.Analyzer
+		}
+		if a.Activation != b.Activation {
+			return a.Activation < b.Activation
+		}
+		if a.CollisionEnergy != b.CollisionEnergy {
+			return a.CollisionEnergy < b.CollisionEnergy
+		}
+		return a.ScanType+a.Polarity+a.Mode < b.ScanType+b.Polarity+b.Mode
+	})
+
+	return info
+}
+
+// WriteJSON saves the summary as a JSON document
+func (i Info) WriteJSON(f string) {
+
+	b, e := json.MarshalIndent(i, "", "  ")
+	if e != nil {
+		msg.MarshalFile(e, "error")
+	}
+
+	if e := ioutil.WriteFile(f, append(b, '\n'), sys.FilePermission()); e != nil {
+		msg.WriteFile(e, "error")
+	}
+}
+
+// rawFiles lists the raw files from the arguments, folders are searched for raw files
+func rawFiles(args []string) []string {
+
+	var files []string
+
+	for _, i := range args {
+
+		info, e := os.Stat(i)
+		if e != nil {
+			msg.InputNotFound(e, "error")
+		}
+
+		if !info.IsDir() {
+			files = append(files, i)
+			continue
+		}
+
+		list, e := ioutil.ReadDir(i)
+		if e != nil {
+			msg.ReadFile(e, "error")
+		}
+
+		for _, j := range list {
+			if !j.IsDir() && strings.EqualFold(filepath.Ext(j.Name()), ".raw") {
+				files = append(files, filepath.Join(i, j.Name()))
+			}
+		}
+	}
+
+	if len(files) == 0 {
+		msg.InputNotFound(fmt.Errorf("no raw files found"), "error")
+	}
+
+	return files
+}
+
+// outputName names an output file after the raw file
+func outputName(dir, f, suffix string) string {
+	return filepath.Join(dir, strings.TrimSuffix(filepath.Base(f), filepath.Ext(f))+suffix)
+}
+
+// formatFloat prints a float with the shortest representation
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// tsv is a tab separated output file
+type tsv struct {
+	file *os.File
+	w    *bufio.Writer
+}
+
+// newTSV creates the output file
+func newTSV(f string) *tsv {
+
+	file, e := os.Create(f)
+	if e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	return &tsv{file: file, w: bufio.NewWriter(file)}
+}
+
+// line writes a row
+func (t *tsv) line(fields ...string) {
+	if _, e := t.w.WriteString(strings.Join(fields, "\t") + "\n"); e != nil {
+		msg.WriteFile(e, "error")
+	}
+}
+
+// close flushes and closes the file
+func (t *tsv) close() {
+
+	if e := t.w.Flush(); e != nil {
+		msg.WriteFile(e, "error")
+	}
+
+	t.file.Close()
+}
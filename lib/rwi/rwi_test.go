@@ -0,0 +1,113 @@
+package rwi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// testdata/synthetic.raw has a MS1 scan, two HCD scans, an EThcD scan and a chromatography
+// controller with two points, written by the synthetic raw writer of the fin tests
+
+func TestRead(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "rwi")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	info := Read(filepath.Join("testdata", "synthetic.raw"), dir)
+
+	if info.Scans != 4 || !reflect.DeepEqual(info.ScansPerLevel, map[string]int{"1": 1, "2": 3}) {
+		t.Errorf("got %d scans %v, want 4 with 1 MS1 and 3 MS2", info.Scans, info.ScansPerLevel)
+	}
+
+	tests := []struct {
+		level      int
+		activation string
+		energy     float64
+		scans      int
+	}{
+		{1, "", 0, 1},
+		{2, "ETHCD", 30, 1},
+		{2, "HCD", 30, 2},
+	}
+
+	if len(info.Events) != len(tests) {
+		t.Fatalf("got scan events %+v, want %d", info.Events, len(tests))
+	}
+
+	for i, tt := range tests {
+		e := info.Events[i]
+		if e.MSLevel != tt.level || e.Activation != tt.activation || e.CollisionEnergy != tt.energy || e.Scans != tt.scans {
+			t.Errorf("got scan event %+v, want level %d %s at %.0f with %d scans", e, tt.level, tt.activation, tt.energy, tt.scans)
+		}
+	}
+
+	if !reflect.DeepEqual(info.Traces, []int{0}) {
+		t.Errorf("got chromatography traces %v, want [0]", info.Traces)
+	}
+
+	files := []struct {
+		name  string
+		lines int
+		text  string
+	}{
+		{"synthetic_scans.tsv", 5, "\tETHCD\t30\t"},
+		{"synthetic_chromatogram.tsv", 2, "1\t0\t"},
+		{"synthetic_trace0.tsv", 3, "0.2\t2.5\n"},
+	}
+
+	for _, tt := range files {
+
+		b, e := ioutil.ReadFile(filepath.Join(dir, tt.name))
+		if e != nil {
+			t.Errorf("%s was not written: %s", tt.name, e)
+			continue
+		}
+
+		if n := strings.Count(string(b), "\n"); n != tt.lines {
+			t.Errorf("got %d lines in %s, want %d", n, tt.name, tt.lines)
+		}
+
+		if !strings.Contains(string(b), tt.text) {
+			t.Errorf("%s does not contain %q:\n%s", tt.name, tt.text, b)
+		}
+	}
+
+	f := filepath.Join(dir, "synthetic.json")
+	info.WriteJSON(f)
+
+	var saved Info
+	b, _ := ioutil.ReadFile(f)
+	if e := json.Unmarshal(b, &saved); e != nil || !reflect.DeepEqual(saved, info) {
+		t.Errorf("got JSON %s, want %+v", b, info)
+	}
+}
+
+func TestRawFiles(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "rwi")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, i := range []string{"a.raw", "b.RAW", "c.mzML"} {
+		if e := ioutil.WriteFile(filepath.Join(dir, i), nil, 0644); e != nil {
+			t.Fatal(e)
+		}
+	}
+
+	got := rawFiles([]string{dir, filepath.Join("testdata", "synthetic.raw")})
+	want := []string{filepath.Join(dir, "a.raw"), filepath.Join(dir, "b.RAW"), filepath.Join("testdata", "synthetic.raw")}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got raw files %v, want %v", got, want)
+	}
+}
@@ -72,6 +72,7 @@ type Protein struct {
 	ProteinName                     []byte                     `xml:"protein_name,attr"`
 	UniqueStrippedPeptides          []byte                     `xml:"unique_stripped_peptides,attr"`
 	GroupSiblingID                  []byte                     `xml:"group_sibling_id,attr"`
+	SubsumingProteinEntry           []byte                     `xml:"subsuming_protein_entry,attr"`
 	NumberIndistinguishableProteins int16                      `xml:"n_indistinguishable_proteins,attr"`
 	TotalNumberPeptides             int                        `xml:"total_number_peptides,attr"`
 	TotalNumberIndPeptides          int                        `xml:"total_number_distinct_peptides,attr"`
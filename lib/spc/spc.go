@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"encoding/xml"
 	"errors"
+	"io"
 	"os"
 	"path/filepath"
 	"philosopher/lib/msg"
@@ -52,6 +53,21 @@ type ModAminoacidMass struct {
 // Parse is the main function for parsing pepxml data
 func (p *PepXML) Parse(f string) {
 
+	var queries []SpectrumQuery
+
+	p.Stream(f, true, func(sq SpectrumQuery) {
+		queries = append(queries, sq)
+	})
+
+	p.MsmsPipelineAnalysis.MsmsRunSummary.SpectrumQuery = queries
+
+}
+
+// Stream reads the pepXML file one spectrum query at a time. The elements preceding the queries,
+// like the analysis and search summaries, are kept in MsmsPipelineAnalysis as soon as they are
+// read. Each query is handed over to fun with only its top ranked search hit, unless allHits is set
+func (p *PepXML) Stream(f string, allHits bool, fun func(sq SpectrumQuery)) {
+
 	if strings.Contains(f, "tmp") {
 		msg.Custom(errors.New("It seems that Philosopher filter command encountered some temporary files generated by PeptideProphet or ProteinProphet. Please remove all temporary xml files and rerun from PepetideProphet. If the crash happens again, change PeptideProphet settings or remove the input MS files that resulted in the crash"), "warning")
 	}
@@ -60,22 +76,128 @@ func (p *PepXML) Parse(f string) {
 	if e != nil {
 		msg.ReadFile(e, "error")
 	}
+	defer xmlFile.Close()
+
 	reader := bufio.NewReader(xmlFile)
 	decoder := xml.NewDecoder(reader)
 	decoder.CharsetReader = charset.NewReader
 
-	var mpa MsmsPipelineAnalysis
-	if e = decoder.Decode(&mpa); e != nil {
-		msg.DecodeMsgPck(e, "error")
+	p.Name = filepath.Base(f)
+	mpa := &p.MsmsPipelineAnalysis
+
+	for {
+
+		t, e := decoder.Token()
+		if e == io.EOF {
+			break
+		} else if e != nil {
+			msg.DecodeMsgPck(e, "error")
+		}
+
+		se, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch se.Name.Local {
+		case "msms_pipeline_analysis":
+
+			mpa.XMLName = se.Name
+			for _, i := range se.Attr {
+				switch i.Name.Local {
+				case "date":
+					mpa.Date = []byte(i.Value)
+				case "summary_xml":
+					mpa.SummaryXML = []byte(i.Value)
+				}
+			}
+
+		case "analysis_summary":
+
+			var as AnalysisSummary
+			if e := decoder.DecodeElement(&as, &se); e != nil {
+				msg.DecodeMsgPck(e, "error")
+			}
+			mpa.AnalysisSummary = append(mpa.AnalysisSummary, as)
+
+		case "msms_run_summary":
+
+			mpa.MsmsRunSummary.XMLName = se.Name
+			mpa.MsmsRunSummary.setAttributes(se.Attr)
+
+		case "sample_enzyme":
+
+			if e := decoder.DecodeElement(&mpa.MsmsRunSummary.SampleEnzyme, &se); e != nil {
+				msg.DecodeMsgPck(e, "error")
+			}
+
+		case "search_summary":
+
+			// the modifications and parameters from all runs are kept, as when the whole file is decoded
+			if e := decoder.DecodeElement(&mpa.MsmsRunSummary.SearchSummary, &se); e != nil {
+				msg.DecodeMsgPck(e, "error")
+			}
+
+		case "spectrum_query":
+
+			var sq SpectrumQuery
+			if e := decoder.DecodeElement(&sq, &se); e != nil {
+				msg.DecodeMsgPck(e, "error")
+			}
+
+			if !allHits {
+				sq.SearchResult.SearchHit = topHit(sq.SearchResult.SearchHit)
+			}
+
+			fun(sq)
+		}
+	}
+
+}
+
+// setAttributes reads the msms_run_summary attributes, the child elements are decoded on their own
+func (m *MsmsRunSummary) setAttributes(attr []xml.Attr) {
+
+	for _, i := range attr {
+		switch i.Name.Local {
+		case "base_name":
+			m.BaseName = []byte(i.Value)
+		case "search_engine":
+			m.SearchEngine = []byte(i.Value)
+		case "msManufacturer":
+			m.MsManufacturer = []byte(i.Value)
+		case "msModel":
+			m.MsModel = []byte(i.Value)
+		case "msIonization":
+			m.MsIonization = []byte(i.Value)
+		case "msMassAnalyzer":
+			m.MsMassAnalyzer = []byte(i.Value)
+		case "msDetector":
+			m.MsDetector = []byte(i.Value)
+		case "raw_data_type":
+			m.RawDataType = []byte(i.Value)
+		case "raw_data":
+			m.RawData = []byte(i.Value)
+		}
 	}
-	err := xmlFile.Close()
-	if err != nil {
-		panic(err)
+
+}
+
+// topHit keeps the search hit with the lowest rank
+func topHit(hits []SearchHit) []SearchHit {
+
+	if len(hits) < 2 {
+		return hits
 	}
 
-	p.MsmsPipelineAnalysis = mpa
-	p.Name = filepath.Base(f)
+	var top int
+	for i := range hits {
+		if hits[i].HitRank < hits[top].HitRank {
+			top = i
+		}
+	}
 
+	return hits[top : top+1]
 }
 
 // Parse is the main function for parsing pepxml data
@@ -1,6 +1,11 @@
 package spc_test
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+
 	. "philosopher/lib/spc"
 	"philosopher/lib/tes"
 	"philosopher/lib/uti"
@@ -257,3 +262,70 @@ func TestProtXML_Parse(t *testing.T) {
 	}
 
 }
+
+const testPepXML = `<?xml version="1.0" encoding="UTF-8"?>
+<msms_pipeline_analysis date="2021-01-01T00:00:00" summary_xml="interact.pep.xml">
+<analysis_summary analysis="peptideprophet" time="2021-01-01T00:00:00"/>
+<msms_run_summary base_name="/data/run1" raw_data=".mzML">
+<sample_enzyme name="trypsin"><specificity cut="KR" no_cut="P" sense="C"/></sample_enzyme>
+<search_summary base_name="/data/run1" search_engine="X! Tandem">
+<aminoacid_modification aminoacid="M" massdiff="15.9949" mass="147.0354" variable="Y"/>
+<parameter name="decoy_tag" value="rev_"/>
+</search_summary>
+<spectrum_query spectrum="run1.00010.00010.2" start_scan="10" end_scan="10" assumed_charge="2" index="1">
+<search_result>
+<search_hit hit_rank="2" peptide="SECONDK" protein="P2"/>
+<search_hit hit_rank="1" peptide="FIRSTK" protein="P1"/>
+</search_result>
+</spectrum_query>
+<spectrum_query spectrum="run1.00011.00011.3" start_scan="11" end_scan="11" assumed_charge="3" index="2">
+<search_result>
+<search_hit hit_rank="1" peptide="THIRDR" protein="P3"/>
+</search_result>
+</spectrum_query>
+</msms_run_summary>
+</msms_pipeline_analysis>
+`
+
+func TestPepXML_Stream(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "spc")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	f := filepath.Join(dir, "interact.pep.xml")
+	if e := ioutil.WriteFile(f, []byte(testPepXML), 0644); e != nil {
+		t.Fatal(e)
+	}
+
+	var p PepXML
+	var peptides []string
+
+	p.Stream(f, false, func(sq SpectrumQuery) {
+
+		if len(p.MsmsPipelineAnalysis.MsmsRunSummary.SearchSummary.AminoAcidModifications) != 1 {
+			t.Errorf("Search summary is missing before spectrum %s", sq.Spectrum)
+		}
+
+		for _, i := range sq.SearchResult.SearchHit {
+			peptides = append(peptides, string(i.Peptide))
+		}
+	})
+
+	if !reflect.DeepEqual(peptides, []string{"FIRSTK", "THIRDR"}) {
+		t.Errorf("Top hits are incorrect, got %v, want %v", peptides, []string{"FIRSTK", "THIRDR"})
+	}
+
+	if string(p.MsmsPipelineAnalysis.MsmsRunSummary.BaseName) != "/data/run1" || string(p.MsmsPipelineAnalysis.AnalysisSummary[0].Analysis) != "peptideprophet" {
+		t.Errorf("Header is incorrect, got base name %s", p.MsmsPipelineAnalysis.MsmsRunSummary.BaseName)
+	}
+
+	var all PepXML
+	all.Parse(f)
+
+	if len(all.MsmsPipelineAnalysis.MsmsRunSummary.SpectrumQuery) != 2 || len(all.MsmsPipelineAnalysis.MsmsRunSummary.SpectrumQuery[0].SearchResult.SearchHit) != 2 {
+		t.Errorf("Parse should keep all spectrum queries and hits")
+	}
+}
@@ -129,12 +129,30 @@ func RawBin() string {
 	return p
 }
 
+// RawCacheBin file with the cached spectra of the source file with the given checksum
+func RawCacheBin(checksum string) string {
+	p := fmt.Sprintf("%s%sraw-%s.bin", MetaDir(), string(filepath.Separator), checksum)
+	return p
+}
+
 // PepxmlBin file
 func PepxmlBin() string {
 	p := fmt.Sprintf("%s%spepxml.bin", MetaDir(), string(filepath.Separator))
 	return p
 }
 
+// PercolatorBin file with the PSMs rescored by Percolator
+func PercolatorBin() string {
+	p := fmt.Sprintf("%s%spercolator.bin", MetaDir(), string(filepath.Separator))
+	return p
+}
+
+// RescoreBin file with the PSMs rescored by the rescore command
+func RescoreBin() string {
+	p := fmt.Sprintf("%s%srescore.bin", MetaDir(), string(filepath.Separator))
+	return p
+}
+
 // PSMBin file
 func PSMBin() string {
 	p := fmt.Sprintf("%s%spsm.bin", MetaDir(), string(filepath.Separator))
@@ -159,6 +177,12 @@ func ProBin() string {
 	return p
 }
 
+// GeneBin file
+func GeneBin() string {
+	p := fmt.Sprintf("%s%sgene.bin", MetaDir(), string(filepath.Separator))
+	return p
+}
+
 // DBBin file
 func DBBin() string {
 	p := fmt.Sprintf("%s%sdb.bin", MetaDir(), string(filepath.Separator))
@@ -0,0 +1,498 @@
+package tdf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+)
+
+// The analysis.tdf metadata is a SQLite database. Only reading full tables is needed,
+// so instead of linking a SQLite engine the b-tree pages are walked directly following
+// the file format described at https://www.sqlite.org/fileformat.html
+
+// sqliteDB is a read-only SQLite database
+type sqliteDB struct {
+	file     *os.File
+	pageSize int
+	usable   int
+	tables   map[string]sqliteTable
+}
+
+// sqliteTable holds the location and the column layout of a table
+type sqliteTable struct {
+	root         int
+	columns      []string
+	rowidAlias   int
+	withoutRowid bool
+}
+
+// b-tree page types
+const (
+	interiorIndex byte = 0x02
+	interiorTable byte = 0x05
+	leafIndex     byte = 0x0a
+	leafTable     byte = 0x0d
+)
+
+// openSQLite opens the database and reads the schema table
+func openSQLite(f string) (*sqliteDB, error) {
+
+	file, e := os.Open(f)
+	if e != nil {
+		return nil, e
+	}
+
+	header := make([]byte, 100)
+	if _, e := file.ReadAt(header, 0); e != nil {
+		file.Close()
+		return nil, e
+	}
+
+	if !bytes.HasPrefix(header, []byte("SQLite format 3\x00")) {
+		file.Close()
+		return nil, errors.New("not a SQLite database")
+	}
+
+	if binary.BigEndian.Uint32(header[56:60]) > 1 {
+		file.Close()
+		return nil, errors.New("only UTF-8 SQLite databases are supported")
+	}
+
+	db := &sqliteDB{file: file, tables: make(map[string]sqliteTable)}
+
+	db.pageSize = int(binary.BigEndian.Uint16(header[16:18]))
+	if db.pageSize == 1 {
+		db.pageSize = 65536
+	}
+	db.usable = db.pageSize - int(header[20])
+
+	// the schema table is always rooted at the first page
+	master := sqliteTable{root: 1, columns: []string{"type", "name", "tbl_name", "rootpage", "sql"}, rowidAlias: -1}
+
+	e = db.walk(master, func(row map[string]interface{}) {
+
+		if asString(row["type"]) != "table" {
+			return
+		}
+
+		t := parseCreateTable(asString(row["sql"]))
+		t.root = int(asInt(row["rootpage"]))
+		db.tables[asString(row["name"])] = t
+	})
+
+	if e != nil {
+		file.Close()
+		return nil, e
+	}
+
+	return db, nil
+}
+
+// Close closes the database file
+func (db *sqliteDB) Close() error {
+	return db.file.Close()
+}
+
+// rows calls fun for every row of the table, with the values indexed by column name
+func (db *sqliteDB) rows(table string, fun func(row map[string]interface{})) error {
+
+	t, ok := db.tables[table]
+	if !ok {
+		return fmt.Errorf("table %s not found", table)
+	}
+
+	return db.walk(t, fun)
+}
+
+// walk visits the table b-tree in key order
+func (db *sqliteDB) walk(t sqliteTable, fun func(row map[string]interface{})) error {
+
+	emit := func(rowid int64, payload []byte) error {
+
+		values, e := decodeRecord(payload)
+		if e != nil {
+			return e
+		}
+
+		var row = make(map[string]interface{})
+		for i, c := range t.columns {
+			if i < len(values) {
+				row[c] = values[i]
+			}
+		}
+
+		if t.rowidAlias >= 0 && !t.withoutRowid {
+			row[t.columns[t.rowidAlias]] = rowid
+		}
+
+		fun(row)
+		return nil
+	}
+
+	return db.walkPage(t.root, emit)
+}
+
+// walkPage visits a b-tree page and its children
+func (db *sqliteDB) walkPage(n int, emit func(rowid int64, payload []byte) error) error {
+
+	page, e := db.page(n)
+	if e != nil {
+		return e
+	}
+
+	// the first page starts with the database header
+	var h int
+	if n == 1 {
+		h = 100
+	}
+
+	kind := page[h]
+	cells := int(binary.BigEndian.Uint16(page[h+3 : h+5]))
+
+	var headerSize = 8
+	if kind == interiorIndex || kind == interiorTable {
+		headerSize = 12
+	}
+
+	for i := 0; i < cells; i++ {
+
+		ptr := int(binary.BigEndian.Uint16(page[h+headerSize+2*i:]))
+
+		switch kind {
+		case leafTable:
+
+			size, k := readVarint(page[ptr:])
+			rowid, l := readVarint(page[ptr+k:])
+
+			payload, e := db.payload(page, ptr+k+l, int(size), db.usable-35)
+			if e != nil {
+				return e
+			}
+
+			if e := emit(rowid, payload); e != nil {
+				return e
+			}
+
+		case interiorTable:
+
+			if e := db.walkPage(int(binary.BigEndian.Uint32(page[ptr:])), emit); e != nil {
+				return e
+			}
+
+		case leafIndex, interiorIndex:
+
+			if kind == interiorIndex {
+				if e := db.walkPage(int(binary.BigEndian.Uint32(page[ptr:])), emit); e != nil {
+					return e
+				}
+				ptr += 4
+			}
+
+			size, k := readVarint(page[ptr:])
+
+			payload, e := db.payload(page, ptr+k, int(size), ((db.usable-12)*64/255)-23)
+			if e != nil {
+				return e
+			}
+
+			if e := emit(0, payload); e != nil {
+				return e
+			}
+
+		default:
+			return fmt.Errorf("unknown b-tree page type %d", kind)
+		}
+	}
+
+	if kind == interiorIndex || kind == interiorTable {
+		return db.walkPage(int(binary.BigEndian.Uint32(page[h+8:h+12])), emit)
+	}
+
+	return nil
+}
+
+// payload collects the cell content, following the overflow pages when it does not fit in the page
+func (db *sqliteDB) payload(page []byte, start, size, maxLocal int) ([]byte, error) {
+
+	if size <= maxLocal {
+		return page[start : start+size], nil
+	}
+
+	minLocal := ((db.usable - 12) * 32 / 255) - 23
+	local := minLocal + ((size - minLocal) % (db.usable - 4))
+	if local > maxLocal {
+		local = minLocal
+	}
+
+	data := make([]byte, 0, size)
+	data = append(data, page[start:start+local]...)
+
+	next := int(binary.BigEndian.Uint32(page[start+local:]))
+
+	for len(data) < size && next > 0 {
+
+		overflow, e := db.page(next)
+		if e != nil {
+			return nil, e
+		}
+
+		chunk := size - len(data)
+		if chunk > db.usable-4 {
+			chunk = db.usable - 4
+		}
+
+		data = append(data, overflow[4:4+chunk]...)
+		next = int(binary.BigEndian.Uint32(overflow[0:4]))
+	}
+
+	if len(data) < size {
+		return nil, errors.New("truncated SQLite overflow chain")
+	}
+
+	return data, nil
+}
+
+// page reads a page, numbered from 1
+func (db *sqliteDB) page(n int) ([]byte, error) {
+
+	page := make([]byte, db.pageSize)
+	if _, e := db.file.ReadAt(page, int64(n-1)*int64(db.pageSize)); e != nil {
+		return nil, e
+	}
+
+	return page, nil
+}
+
+// decodeRecord reads the values of a record
+func decodeRecord(payload []byte) ([]interface{}, error) {
+
+	headerSize, n := readVarint(payload)
+	if int(headerSize) > len(payload) {
+		return nil, errors.New("corrupt SQLite record")
+	}
+
+	var types []int64
+	for i := n; i < int(headerSize); {
+		t, k := readVarint(payload[i:])
+		types = append(types, t)
+		i += k
+	}
+
+	var values []interface{}
+	var pos = int(headerSize)
+
+	for _, t := range types {
+
+		var size int
+
+		switch {
+		case t == 0 || t == 8 || t == 9:
+			size = 0
+		case t >= 1 && t <= 4:
+			size = int(t)
+		case t == 5:
+			size = 6
+		case t == 6 || t == 7:
+			size = 8
+		case t >= 12:
+			size = int(t-12) / 2
+		default:
+			return nil, fmt.Errorf("unknown SQLite serial type %d", t)
+		}
+
+		if pos+size > len(payload) {
+			return nil, errors.New("corrupt SQLite record")
+		}
+
+		b := payload[pos : pos+size]
+		pos += size
+
+		switch {
+		case t == 0:
+			values = append(values, nil)
+		case t == 8:
+			values = append(values, int64(0))
+		case t == 9:
+			values = append(values, int64(1))
+		case t <= 6:
+			// big-endian two's complement integers
+			var v = int64(int8(b[0]))
+			for _, i := range b[1:] {
+				v = v<<8 | int64(i)
+			}
+			values = append(values, v)
+		case t == 7:
+			values = append(values, math.Float64frombits(binary.BigEndian.Uint64(b)))
+		case t%2 == 0:
+			values = append(values, b)
+		default:
+			values = append(values, string(b))
+		}
+	}
+
+	return values, nil
+}
+
+// readVarint reads a SQLite variable length integer and returns the number of bytes used
+func readVarint(b []byte) (int64, int) {
+
+	var v uint64
+
+	for i := 0; i < 8; i++ {
+		v = v<<7 | uint64(b[i]&0x7f)
+		if b[i]&0x80 == 0 {
+			return int64(v), i + 1
+		}
+	}
+
+	v = v<<8 | uint64(b[8])
+
+	return int64(v), 9
+}
+
+// parseCreateTable reads the column names from the table definition
+func parseCreateTable(sql string) sqliteTable {
+
+	var t = sqliteTable{rowidAlias: -1}
+
+	start := strings.Index(sql, "(")
+	end := strings.LastIndex(sql, ")")
+	if start < 0 || end < start {
+		return t
+	}
+
+	t.withoutRowid = strings.Contains(strings.ToUpper(sql[end:]), "WITHOUT ROWID")
+
+	var primaryKey []string
+
+	for _, i := range splitColumns(sql[start+1 : end]) {
+
+		fields := strings.Fields(i)
+		if len(fields) == 0 {
+			continue
+		}
+
+		upper := strings.ToUpper(i)
+		first := strings.ToUpper(fields[0])
+
+		if first == "PRIMARY" {
+			open := strings.Index(i, "(")
+			close := strings.LastIndex(i, ")")
+			if open >= 0 && close > open {
+				for _, j := range strings.Split(i[open+1:close], ",") {
+					primaryKey = append(primaryKey, unquote(strings.Fields(j)[0]))
+				}
+			}
+			continue
+		}
+
+		if first == "CONSTRAINT" || first == "UNIQUE" || first == "FOREIGN" || first == "CHECK" {
+			continue
+		}
+
+		name := unquote(fields[0])
+		t.columns = append(t.columns, name)
+
+		if strings.Contains(upper, "PRIMARY KEY") {
+			primaryKey = append(primaryKey, name)
+			if len(fields) > 1 && strings.ToUpper(fields[1]) == "INTEGER" {
+				t.rowidAlias = len(t.columns) - 1
+			}
+		}
+	}
+
+	// tables without rowid store the primary key columns first
+	if t.withoutRowid && len(primaryKey) > 0 {
+
+		var ordered []string
+		var isKey = make(map[string]bool)
+
+		for _, i := range primaryKey {
+			ordered = append(ordered, i)
+			isKey[i] = true
+		}
+
+		for _, i := range t.columns {
+			if !isKey[i] {
+				ordered = append(ordered, i)
+			}
+		}
+
+		t.columns = ordered
+	}
+
+	return t
+}
+
+// splitColumns splits the table definition on the commas outside parentheses
+func splitColumns(s string) []string {
+
+	var list []string
+	var depth, last int
+
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				list = append(list, s[last:i])
+				last = i + 1
+			}
+		}
+	}
+
+	return append(list, s[last:])
+}
+
+// unquote removes the identifier quotes
+func unquote(s string) string {
+	return strings.Trim(s, "\"'`[]")
+}
+
+// asInt converts a column value to an integer
+func asInt(v interface{}) int64 {
+
+	switch i := v.(type) {
+	case int64:
+		return i
+	case float64:
+		return int64(i)
+	}
+
+	return 0
+}
+
+// asFloat converts a column value to a float
+func asFloat(v interface{}) float64 {
+
+	switch i := v.(type) {
+	case int64:
+		return float64(i)
+	case float64:
+		return i
+	}
+
+	return 0
+}
+
+// asString converts a column value to a string
+func asString(v interface{}) string {
+
+	switch i := v.(type) {
+	case string:
+		return i
+	case []byte:
+		return string(i)
+	case int64, float64:
+		return fmt.Sprint(i)
+	}
+
+	return ""
+}
@@ -0,0 +1,471 @@
+// Package tdf reads Bruker timsTOF .d folders, made of the analysis.tdf SQLite metadata
+// and the analysis.tdf_bin frame data
+package tdf
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Data holds the metadata from a timsTOF acquisition
+type Data struct {
+	Dir        string
+	Metadata   map[string]string
+	Frames     []Frame
+	Precursors map[int]Precursor
+	PasefInfo  map[int][]PasefInfo
+	MaxScans   int
+	bin        *os.File
+	decoder    *zstd.Decoder
+	frames     map[int]int
+	mzCal      map[int]MzCalibration
+	timsCal    map[int]TimsCalibration
+}
+
+// Frame is a TIMS ramp, the MS1 frames hold all ion mobility scans and the PASEF frames
+// hold the fragments of the precursors isolated in each scan range
+type Frame struct {
+	ID       int
+	Time     float64
+	MsMsType int
+	TimsID   int64
+	NumScans int
+	NumPeaks int
+	MzCal    int
+	TimsCal  int
+	T1       float64
+	T2       float64
+}
+
+// MzCalibration converts the TOF indexes of the frames to m/z. The flight time of each TOF index
+// comes from the digitizer delay and time base, and it is a polynomial on the square root of
+// the m/z with the coefficients C0 to C4. The linear coefficient is corrected for the difference
+// between the frame temperatures and the ones from the calibration
+type MzCalibration struct {
+	ID        int
+	ModelType int
+	Timebase  float64
+	Delay     float64
+	T1        float64
+	T2        float64
+	DC1       float64
+	DC2       float64
+	C         [5]float64
+}
+
+// TimsCalibration converts the scan numbers of the frames to inverse reduced ion mobility, as a
+// polynomial on the scan number with the coefficients C0 to C9
+type TimsCalibration struct {
+	ID        int
+	ModelType int
+	C         [10]float64
+}
+
+// Precursor is a feature selected for PASEF fragmentation
+type Precursor struct {
+	ID             int
+	LargestPeakMz  float64
+	AverageMz      float64
+	MonoisotopicMz float64
+	Charge         int
+	ScanNumber     float64
+	Intensity      float64
+	Parent         int
+}
+
+// PasefInfo is the isolation of a precursor on a range of scans from a PASEF frame
+type PasefInfo struct {
+	Frame           int
+	ScanNumStart    int
+	ScanNumEnd      int
+	IsolationMz     float64
+	IsolationWidth  float64
+	CollisionEnergy float64
+	Precursor       int
+}
+
+// Peaks from a single frame, the TOF indexes and intensities of each scan
+type Peaks struct {
+	Tof       [][]uint32
+	Intensity [][]uint32
+}
+
+// frame types from the MsMsType column
+const (
+	MS1   int = 0
+	PASEF int = 8
+)
+
+// the calibration models supported
+const (
+	mzModel   int = 1
+	timsModel int = 2
+)
+
+// calibrationTolerance is the largest relative difference between the calibrated acquisition
+// limits and the ones from the metadata
+const calibrationTolerance = 0.1
+
+// Read loads the metadata from the analysis.tdf file and opens the frame data. The acquisitions
+// without the m/z and mobility calibration tables are refused
+func (d *Data) Read(dir string) error {
+
+	d.Dir = dir
+	d.Metadata = make(map[string]string)
+	d.Precursors = make(map[int]Precursor)
+	d.PasefInfo = make(map[int][]PasefInfo)
+	d.frames = make(map[int]int)
+	d.mzCal = make(map[int]MzCalibration)
+	d.timsCal = make(map[int]TimsCalibration)
+
+	db, e := openSQLite(filepath.Join(dir, "analysis.tdf"))
+	if e != nil {
+		return e
+	}
+	defer db.Close()
+
+	e = db.rows("GlobalMetadata", func(row map[string]interface{}) {
+		d.Metadata[asString(row["Key"])] = asString(row["Value"])
+	})
+	if e != nil {
+		return e
+	}
+
+	if d.Metadata["TimsCompressionType"] != "2" {
+		return fmt.Errorf("TIMS compression type %s is not supported", d.Metadata["TimsCompressionType"])
+	}
+
+	for _, i := range []string{"MzCalibration", "TimsCalibration"} {
+		if _, ok := db.tables[i]; !ok {
+			return fmt.Errorf("the %s table is missing, the spectra cannot be calibrated", i)
+		}
+	}
+
+	e = db.rows("Frames", func(row map[string]interface{}) {
+
+		f := Frame{
+			ID:       int(asInt(row["Id"])),
+			Time:     asFloat(row["Time"]),
+			MsMsType: int(asInt(row["MsMsType"])),
+			TimsID:   asInt(row["TimsId"]),
+			NumScans: int(asInt(row["NumScans"])),
+			NumPeaks: int(asInt(row["NumPeaks"])),
+			MzCal:    int(asInt(row["MzCalibration"])),
+			TimsCal:  int(asInt(row["TimsCalibration"])),
+			T1:       asFloat(row["T1"]),
+			T2:       asFloat(row["T2"]),
+		}
+
+		if f.NumScans > d.MaxScans {
+			d.MaxScans = f.NumScans
+		}
+
+		d.frames[f.ID] = len(d.Frames)
+		d.Frames = append(d.Frames, f)
+	})
+	if e != nil {
+		return e
+	}
+
+	if len(d.Frames) == 0 {
+		return errors.New("no frames found")
+	}
+
+	e = db.rows("MzCalibration", func(row map[string]interface{}) {
+
+		c := MzCalibration{
+			ID:        int(asInt(row["Id"])),
+			ModelType: int(asInt(row["ModelType"])),
+			Timebase:  asFloat(row["DigitizerTimebase"]),
+			Delay:     asFloat(row["DigitizerDelay"]),
+			T1:        asFloat(row["T1"]),
+			T2:        asFloat(row["T2"]),
+			DC1:       asFloat(row["dC1"]),
+			DC2:       asFloat(row["dC2"]),
+		}
+
+		for i := range c.C {
+			c.C[i] = asFloat(row[fmt.Sprintf("C%d", i)])
+		}
+
+		d.mzCal[c.ID] = c
+	})
+	if e != nil {
+		return e
+	}
+
+	e = db.rows("TimsCalibration", func(row map[string]interface{}) {
+
+		c := TimsCalibration{
+			ID:        int(asInt(row["Id"])),
+			ModelType: int(asInt(row["ModelType"])),
+		}
+
+		for i := range c.C {
+			c.C[i] = asFloat(row[fmt.Sprintf("C%d", i)])
+		}
+
+		d.timsCal[c.ID] = c
+	})
+	if e != nil {
+		return e
+	}
+
+	if e := d.checkCalibration(); e != nil {
+		return e
+	}
+
+	// DIA acquisitions have no precursor tables
+	if _, ok := db.tables["Precursors"]; ok {
+
+		e = db.rows("Precursors", func(row map[string]interface{}) {
+			p := Precursor{
+				ID:             int(asInt(row["Id"])),
+				LargestPeakMz:  asFloat(row["LargestPeakMz"]),
+				AverageMz:      asFloat(row["AverageMz"]),
+				MonoisotopicMz: asFloat(row["MonoisotopicMz"]),
+				Charge:         int(asInt(row["Charge"])),
+				ScanNumber:     asFloat(row["ScanNumber"]),
+				Intensity:      asFloat(row["Intensity"]),
+				Parent:         int(asInt(row["Parent"])),
+			}
+			d.Precursors[p.ID] = p
+		})
+		if e != nil {
+			return e
+		}
+
+		e = db.rows("PasefFrameMsMsInfo", func(row map[string]interface{}) {
+			p := PasefInfo{
+				Frame:           int(asInt(row["Frame"])),
+				ScanNumStart:    int(asInt(row["ScanNumBegin"])),
+				ScanNumEnd:      int(asInt(row["ScanNumEnd"])),
+				IsolationMz:     asFloat(row["IsolationMz"]),
+				IsolationWidth:  asFloat(row["IsolationWidth"]),
+				CollisionEnergy: asFloat(row["CollisionEnergy"]),
+				Precursor:       int(asInt(row["Precursor"])),
+			}
+			d.PasefInfo[p.Frame] = append(d.PasefInfo[p.Frame], p)
+		})
+		if e != nil {
+			return e
+		}
+	}
+
+	d.bin, e = os.Open(filepath.Join(dir, "analysis.tdf_bin"))
+	if e != nil {
+		return e
+	}
+
+	d.decoder, e = zstd.NewReader(nil)
+	if e != nil {
+		d.bin.Close()
+		return e
+	}
+
+	return nil
+}
+
+// checkCalibration verifies that every frame has a calibration of a known model, and that the
+// calibrations map the digitizer and scan limits close to the acquisition ranges
+func (d *Data) checkCalibration() error {
+
+	var keys = []string{"MzAcqRangeLower", "MzAcqRangeUpper", "DigitizerNumSamples", "OneOverK0AcqRangeLower", "OneOverK0AcqRangeUpper"}
+	var limits = make(map[string]float64)
+
+	for _, i := range keys {
+		v, e := strconv.ParseFloat(d.Metadata[i], 64)
+		if e != nil {
+			return fmt.Errorf("%s: %s", i, e)
+		}
+		limits[i] = v
+	}
+
+	near := func(got, want float64) bool {
+		return math.Abs(got-want) <= calibrationTolerance*math.Abs(want)
+	}
+
+	for _, f := range d.Frames {
+
+		mz, ok := d.mzCal[f.MzCal]
+		if !ok || mz.ModelType != mzModel {
+			return fmt.Errorf("frame %d has no supported m/z calibration", f.ID)
+		}
+
+		tims, ok := d.timsCal[f.TimsCal]
+		if !ok || tims.ModelType != timsModel {
+			return fmt.Errorf("frame %d has no supported mobility calibration", f.ID)
+		}
+	}
+
+	f := d.Frames[0]
+
+	low, high := d.TofToMz(f, 0), d.TofToMz(f, uint32(limits["DigitizerNumSamples"]))
+	if !near(low, limits["MzAcqRangeLower"]) || !near(high, limits["MzAcqRangeUpper"]) {
+		return fmt.Errorf("the m/z calibration gives %.2f to %.2f, not the acquisition range %.2f to %.2f", low, high, limits["MzAcqRangeLower"], limits["MzAcqRangeUpper"])
+	}
+
+	high, low = d.ScanToOneOverK0(f, 0), d.ScanToOneOverK0(f, float64(d.MaxScans))
+	if !near(low, limits["OneOverK0AcqRangeLower"]) || !near(high, limits["OneOverK0AcqRangeUpper"]) {
+		return fmt.Errorf("the mobility calibration gives %.3f to %.3f, not the acquisition range %.3f to %.3f", low, high, limits["OneOverK0AcqRangeLower"], limits["OneOverK0AcqRangeUpper"])
+	}
+
+	return nil
+}
+
+// Close closes the frame data file
+func (d *Data) Close() error {
+	d.decoder.Close()
+	return d.bin.Close()
+}
+
+// Frame returns the frame with the given ID
+func (d *Data) Frame(id int) (Frame, bool) {
+
+	i, ok := d.frames[id]
+	if !ok {
+		return Frame{}, false
+	}
+
+	return d.Frames[i], true
+}
+
+// ReadFrame decodes the peaks of each scan from a frame
+func (d *Data) ReadFrame(f Frame) (Peaks, error) {
+
+	var peaks Peaks
+
+	if f.NumScans == 0 || f.NumPeaks == 0 {
+		return peaks, nil
+	}
+
+	header := make([]byte, 8)
+	if _, e := d.bin.ReadAt(header, f.TimsID); e != nil {
+		return peaks, fmt.Errorf("frame %d: %s", f.ID, e)
+	}
+
+	size := binary.LittleEndian.Uint32(header[0:4])
+	numScans := int(binary.LittleEndian.Uint32(header[4:8]))
+
+	// the size counts the header too
+	if size < 8 {
+		return peaks, fmt.Errorf("frame %d is corrupted, its size is %d bytes", f.ID, size)
+	}
+
+	compressed := make([]byte, size-8)
+	if _, e := d.bin.ReadAt(compressed, f.TimsID+8); e != nil {
+		return peaks, fmt.Errorf("frame %d: %s", f.ID, e)
+	}
+
+	data, e := d.decoder.DecodeAll(compressed, nil)
+	if e != nil {
+		return peaks, fmt.Errorf("frame %d: %s", f.ID, e)
+	}
+
+	// the 32-bit values are stored as four planes with one byte from each value
+	values := make([]uint32, len(data)/4)
+	n := len(values)
+	for i := range values {
+		values[i] = uint32(data[i]) | uint32(data[n+i])<<8 | uint32(data[2*n+i])<<16 | uint32(data[3*n+i])<<24
+	}
+
+	// the header values count the TOF and intensity pairs of the previous scan, twice,
+	// and the last scan holds the remaining pairs
+	if numScans == 0 || numScans > len(values) {
+		return peaks, fmt.Errorf("frame %d is corrupted", f.ID)
+	}
+
+	counts := make([]int, numScans)
+	var total int
+	for i := 1; i < numScans; i++ {
+		counts[i-1] = int(values[i]) / 2
+		total += counts[i-1]
+	}
+	counts[numScans-1] = (len(values)-numScans)/2 - total
+
+	if counts[numScans-1] < 0 {
+		return peaks, fmt.Errorf("frame %d is corrupted", f.ID)
+	}
+
+	peaks.Tof = make([][]uint32, numScans)
+	peaks.Intensity = make([][]uint32, numScans)
+
+	pos := numScans
+	for i, c := range counts {
+
+		peaks.Tof[i] = make([]uint32, c)
+		peaks.Intensity[i] = make([]uint32, c)
+
+		// TOF indexes are delta encoded within each scan
+		var tof uint32
+		for j := 0; j < c; j++ {
+			tof += values[pos]
+			peaks.Tof[i][j] = tof
+			peaks.Intensity[i][j] = values[pos+1]
+			pos += 2
+		}
+	}
+
+	return peaks, nil
+}
+
+// TofToMz converts a TOF index from the frame to m/z with the frame calibration. The square
+// root of the m/z is found with Newton's method, starting from the linear term alone
+func (d *Data) TofToMz(f Frame, tof uint32) float64 {
+
+	c := d.mzCal[f.MzCal]
+
+	t := c.Delay + float64(tof)*c.Timebase
+
+	k := c.C
+	k[1] += c.DC1*(f.T1-c.T1) + c.DC2*(f.T2-c.T2)
+
+	if k[1] == 0 {
+		return 0
+	}
+
+	u := (t - k[0]) / k[1]
+
+	for i := 0; i < 50; i++ {
+
+		var value, slope float64
+		for j := len(k) - 1; j >= 0; j-- {
+			slope = slope*u + value
+			value = value*u + k[j]
+		}
+
+		if slope == 0 {
+			break
+		}
+
+		step := (value - t) / slope
+		u -= step
+
+		if math.Abs(step) < 1e-12 {
+			break
+		}
+	}
+
+	return u * u
+}
+
+// ScanToOneOverK0 converts a scan number from the frame to inverse reduced ion mobility (1/K0)
+// with the frame calibration, the first scans hold the highest mobilities
+func (d *Data) ScanToOneOverK0(f Frame, scan float64) float64 {
+
+	c := d.timsCal[f.TimsCal]
+
+	var v float64
+	for i := len(c.C) - 1; i >= 0; i-- {
+		v = v*scan + c.C[i]
+	}
+
+	return v
+}
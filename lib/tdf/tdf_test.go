@@ -0,0 +1,337 @@
+package tdf
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// The testdata folders hold synthetic acquisitions. The analysis.tdf files use 1024 byte pages,
+// so the Frames and Properties tables span interior pages and the long metadata and property
+// values spill to overflow pages. The analysis.tdf_bin of calibrated.d holds an MS1 frame at
+// offset 0 and a PASEF frame at offset 4096, encoded as in encodeFrame
+
+// encodeFrame builds a frame block from the TOF and intensity pairs of each scan
+func encodeFrame(t *testing.T, scans [][][2]uint32) []byte {
+
+	var values = make([]uint32, len(scans))
+	for i := 1; i < len(scans); i++ {
+		values[i] = uint32(2 * len(scans[i-1]))
+	}
+
+	for _, i := range scans {
+		var last uint32
+		for _, j := range i {
+			values = append(values, j[0]-last, j[1])
+			last = j[0]
+		}
+	}
+
+	n := len(values)
+	var data = make([]byte, 4*n)
+	for i, v := range values {
+		data[i], data[n+i], data[2*n+i], data[3*n+i] = byte(v), byte(v>>8), byte(v>>16), byte(v>>24)
+	}
+
+	enc, e := zstd.NewWriter(nil)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer enc.Close()
+
+	compressed := enc.EncodeAll(data, nil)
+
+	var frame = make([]byte, 8, 8+len(compressed))
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(8+len(compressed)))
+	binary.LittleEndian.PutUint32(frame[4:8], uint32(len(scans)))
+
+	return append(frame, compressed...)
+}
+
+func TestSQLiteTables(t *testing.T) {
+
+	db, e := openSQLite(filepath.Join("testdata", "calibrated.d", "analysis.tdf"))
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer db.Close()
+
+	tests := []struct {
+		table string
+		rows  int
+		key   string
+		check func(row map[string]interface{}) bool
+	}{
+		{"Frames", 300, "Id", func(row map[string]interface{}) bool {
+			return asInt(row["Id"]) > 0 && asInt(row["NumScans"]) == 4 && asFloat(row["T1"]) == 25.2
+		}},
+		{"GlobalMetadata", 7, "Key", func(row map[string]interface{}) bool {
+			return asString(row["Key"]) != "Description" || asString(row["Value"]) == strings.Repeat("synthetic ", 300)
+		}},
+		{"Properties", 300, "Frame", func(row map[string]interface{}) bool {
+			return asInt(row["Frame"]) != 150 || asString(row["Value"]) == strings.Repeat("long property ", 150)
+		}},
+		{"PasefFrameMsMsInfo", 1, "Frame", func(row map[string]interface{}) bool {
+			return asInt(row["Frame"]) == 2 && asInt(row["ScanNumBegin"]) == 1 && asFloat(row["IsolationMz"]) == 500.5
+		}},
+		{"TimsCalibration", 1, "Id", func(row map[string]interface{}) bool {
+			return asInt(row["Id"]) == 1 && asFloat(row["C1"]) == -0.25
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.table, func(t *testing.T) {
+
+			var keys = make(map[string]bool)
+
+			e := db.rows(tt.table, func(row map[string]interface{}) {
+				keys[asString(row[tt.key])] = true
+				if !tt.check(row) {
+					t.Errorf("got unexpected row %v", row)
+				}
+			})
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			if len(keys) != tt.rows {
+				t.Errorf("got %d rows, want %d", len(keys), tt.rows)
+			}
+		})
+	}
+
+	if e := db.rows("Missing", func(row map[string]interface{}) {}); e == nil {
+		t.Error("reading a missing table did not fail")
+	}
+}
+
+func TestRead(t *testing.T) {
+
+	tests := []struct {
+		name string
+		dir  string
+		err  string
+	}{
+		{"calibrated", "calibrated.d", ""},
+		{"no calibration tables", "uncalibrated.d", "the MzCalibration table is missing"},
+		{"missing folder", "missing.d", "no such file"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			var d Data
+			e := d.Read(filepath.Join("testdata", tt.dir))
+
+			if tt.err != "" {
+				if e == nil || !strings.Contains(e.Error(), tt.err) {
+					t.Fatalf("got error %v, want %s", e, tt.err)
+				}
+				return
+			}
+
+			if e != nil {
+				t.Fatal(e)
+			}
+			defer d.Close()
+
+			if len(d.Frames) != 300 || d.MaxScans != 4 {
+				t.Errorf("got %d frames with %d scans, want 300 with 4", len(d.Frames), d.MaxScans)
+			}
+
+			if p := d.Precursors[1]; p.Charge != 2 || p.Parent != 1 {
+				t.Errorf("got precursor %+v", p)
+			}
+
+			if p := d.PasefInfo[2]; len(p) != 1 || p[0].ScanNumStart != 1 || p[0].ScanNumEnd != 3 {
+				t.Errorf("got PASEF isolations %+v", p)
+			}
+
+			if f, ok := d.Frame(2); !ok || f.MsMsType != PASEF || f.TimsID != 4096 {
+				t.Errorf("got frame %+v", f)
+			}
+		})
+	}
+}
+
+func TestCalibration(t *testing.T) {
+
+	var d Data
+	if e := d.Read(filepath.Join("testdata", "calibrated.d")); e != nil {
+		t.Fatal(e)
+	}
+	defer d.Close()
+
+	f, _ := d.Frame(1)
+	c := d.mzCal[f.MzCal]
+
+	// the flight time of each m/z from the calibration polynomial, corrected for the temperature
+	tof := func(mz float64) float64 {
+		k := c.C
+		k[1] += c.DC1*(f.T1-c.T1) + c.DC2*(f.T2-c.T2)
+		u := math.Sqrt(mz)
+		t := k[0] + k[1]*u + k[2]*u*u + k[3]*u*u*u + k[4]*u*u*u*u
+		return (t - c.Delay) / c.Timebase
+	}
+
+	for _, mz := range []float64{150, 500.25, 1234.5678} {
+
+		// the TOF indexes are integers, the m/z is recovered from the closest one
+		index := uint32(math.Round(tof(mz)))
+
+		got := d.TofToMz(f, index)
+		if math.Abs(tof(got)-float64(index)) > 1e-6 || math.Abs(got-mz) > 0.01 {
+			t.Errorf("got m/z %f for TOF index %d, want %f", got, index, mz)
+		}
+	}
+
+	tests := []struct {
+		scan float64
+		want float64
+	}{
+		{0, 1.6},
+		{2, 1.104},
+		{4, 0.616},
+	}
+
+	for _, tt := range tests {
+		if got := d.ScanToOneOverK0(f, tt.scan); math.Abs(got-tt.want) > 1e-9 {
+			t.Errorf("got 1/K0 %f for scan %.0f, want %f", got, tt.scan, tt.want)
+		}
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+
+	dir, e := ioutil.TempDir("", "tdf")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	scans := [][][2]uint32{{{1000, 10}, {2000, 20}}, {}, {{1500, 5}}, {}}
+
+	valid := encodeFrame(t, scans)
+
+	small := make([]byte, 8)
+	binary.LittleEndian.PutUint32(small[0:4], 4)
+	binary.LittleEndian.PutUint32(small[4:8], 4)
+
+	// the header counts more scans than the frame holds values
+	overflow := encodeFrame(t, [][][2]uint32{{{1000, 10}}})
+	binary.LittleEndian.PutUint32(overflow[4:8], 100)
+
+	tests := []struct {
+		name  string
+		data  []byte
+		peaks int
+		err   string
+	}{
+		{"valid", valid, 3, ""},
+		{"size below the header", small, 3, "its size is 4 bytes"},
+		{"truncated", valid[:len(valid)-4], 3, "EOF"},
+		{"too many scans", overflow, 1, "is corrupted"},
+		{"no peaks", valid, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+
+			f := filepath.Join(dir, "analysis.tdf_bin")
+			if e := ioutil.WriteFile(f, tt.data, 0644); e != nil {
+				t.Fatal(e)
+			}
+
+			bin, e := os.Open(f)
+			if e != nil {
+				t.Fatal(e)
+			}
+			defer bin.Close()
+
+			decoder, e := zstd.NewReader(nil)
+			if e != nil {
+				t.Fatal(e)
+			}
+			defer decoder.Close()
+
+			d := Data{bin: bin, decoder: decoder}
+
+			peaks, e := d.ReadFrame(Frame{ID: 1, NumScans: 4, NumPeaks: tt.peaks})
+
+			if tt.err != "" {
+				if e == nil || !strings.Contains(e.Error(), tt.err) {
+					t.Fatalf("got error %v, want %s", e, tt.err)
+				}
+				return
+			}
+
+			if e != nil {
+				t.Fatal(e)
+			}
+
+			if tt.peaks == 0 {
+				if len(peaks.Tof) != 0 {
+					t.Errorf("got %d scans from an empty frame", len(peaks.Tof))
+				}
+				return
+			}
+
+			if len(peaks.Tof) != len(scans) {
+				t.Fatalf("got %d scans, want %d", len(peaks.Tof), len(scans))
+			}
+
+			for i := range scans {
+				if len(peaks.Tof[i]) != len(scans[i]) {
+					t.Errorf("got %d peaks on scan %d, want %d", len(peaks.Tof[i]), i, len(scans[i]))
+					continue
+				}
+				for j := range scans[i] {
+					if peaks.Tof[i][j] != scans[i][j][0] || peaks.Intensity[i][j] != scans[i][j][1] {
+						t.Errorf("got peak %d %d on scan %d, want %v", peaks.Tof[i][j], peaks.Intensity[i][j], i, scans[i][j])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestFixtureFrames(t *testing.T) {
+
+	var d Data
+	if e := d.Read(filepath.Join("testdata", "calibrated.d")); e != nil {
+		t.Fatal(e)
+	}
+	defer d.Close()
+
+	tests := []struct {
+		frame int
+		want  [][][2]uint32
+	}{
+		{1, [][][2]uint32{{{100000, 10}, {200000, 20}}, {}, {{150000, 5}}, {}}},
+		{2, [][][2]uint32{{}, {{300000, 7}}, {{300000, 3}}, {}}},
+	}
+
+	for _, tt := range tests {
+
+		f, _ := d.Frame(tt.frame)
+
+		peaks, e := d.ReadFrame(f)
+		if e != nil {
+			t.Fatal(e)
+		}
+
+		for i := range tt.want {
+			for j := range tt.want[i] {
+				if j >= len(peaks.Tof[i]) || peaks.Tof[i][j] != tt.want[i][j][0] || peaks.Intensity[i][j] != tt.want[i][j][1] {
+					t.Errorf("frame %d scan %d: got %v %v, want %v", tt.frame, i, peaks.Tof[i], peaks.Intensity[i], tt.want[i])
+				}
+			}
+		}
+	}
+}